@@ -15,6 +15,9 @@ import (
 var (
 	appInstance *cli.App
 
+	// 是否是交互命令行形态
+	consoleMode bool
+
 	saveConfigMutex *sync.Mutex = new(sync.Mutex)
 
 	ReloadConfigFunc = func(c *cli.Context) error {
@@ -44,6 +47,16 @@ func App() *cli.App {
 	return appInstance
 }
 
+// SetConsoleMode 设置是否是交互命令行形态
+func SetConsoleMode(console bool) {
+	consoleMode = console
+}
+
+// IsConsoleMode 是否是交互命令行形态
+func IsConsoleMode() bool {
+	return consoleMode
+}
+
 func DoLoginHelper(refreshToken string) (refreshTokenStr string, webToken aliyunpan.WebLoginToken, error error) {
 	line := cmdliner.NewLiner()
 	defer line.Close()
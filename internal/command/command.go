@@ -35,7 +35,8 @@ type (
 	RapidUploadItem struct {
 		FileSha1 string
 		FileSize int64
-		FilePath string // 绝对路径，包含文件名
+		FilePath string            // 绝对路径，包含文件名
+		Proof    *RapidUploadProof // 秒传证明数据，旧格式的秒传链接为nil
 	}
 )
 
@@ -66,6 +67,14 @@ func GetActiveUser() *config.PanUser {
 	return config.Config.ActiveUser()
 }
 
+// exitCodeError 命令行模式下返回指定的退出码，交互命令行模式下不退出
+func exitCodeError(code int) error {
+	if cmder.IsConsoleMode() {
+		return nil
+	}
+	return cli.NewExitError("", code)
+}
+
 func parseDriveId(c *cli.Context) string {
 	driveId := config.Config.ActiveUser().ActiveDriveId
 	if c.IsSet("driveId") {
@@ -178,10 +187,14 @@ func CmdConfig() cli.Command {
 
 		cache_size 的值支持可选设置单位, 单位不区分大小写, b 和 B 均表示字节的意思, 如 64KB, 1MB, 32kb, 65536b, 65536
 		max_download_rate, max_upload_rate 的值支持可选设置单位, 单位为每秒的传输速率, 后缀'/s' 可省略, 如 2MB/s, 2MB, 2m, 2mb 均为一个意思
+		bandwidth_schedule 按时间段限速, 格式为 开始时间-结束时间=下载速度:上传速度, 多个时间段用逗号分隔. 只指定一个速度则上传和下载使用相同的速度, 0代表不限制.
+		结束时间小于开始时间代表跨越零点, 时间段外使用 max_download_rate 和 max_upload_rate. 设置为空字符串则清除按时间段限速.
+		修改限速配置后, 正在运行的下载、上传、同步备份和WebDAV传输会在10秒内使用新的限速, 不需要重新启动.
 
 	例子:
 		aliyunpan config set -cache_size 64KB
-		aliyunpan config set -cache_size 16384 -max_download_parallel 200 -savedir D:/download`,
+		aliyunpan config set -cache_size 16384 -max_download_parallel 200 -savedir D:/download
+		aliyunpan config set -bandwidth_schedule "01:00-07:00=0,09:00-18:00=2MB:1MB"`,
 				Action: func(c *cli.Context) error {
 					if c.NumFlags() <= 0 || c.NArg() > 0 {
 						cli.ShowCommandHelp(c, c.Command.Name)
@@ -214,6 +227,13 @@ func CmdConfig() cli.Command {
 							return nil
 						}
 					}
+					if c.IsSet("bandwidth_schedule") {
+						err := config.Config.SetBandwidthScheduleByStr(c.String("bandwidth_schedule"))
+						if err != nil {
+							fmt.Printf("设置 bandwidth_schedule 错误: %s\n", err)
+							return nil
+						}
+					}
 					if c.IsSet("transfer_url_type") {
 						config.Config.TransferUrlType = c.Int("transfer_url_type")
 					}
@@ -260,6 +280,10 @@ func CmdConfig() cli.Command {
 						Name:  "max_upload_rate",
 						Usage: "限制最大上传速度, 0代表不限制",
 					},
+					cli.StringFlag{
+						Name:  "bandwidth_schedule",
+						Usage: "按时间段限速, 例如: 01:00-07:00=0,09:00-18:00=2MB:1MB",
+					},
 					cli.IntFlag{
 						Name:  "transfer_url_type",
 						Usage: "上传下载URL类别，1-默认，2-阿里云ECS",
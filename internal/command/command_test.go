@@ -1,8 +1,20 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRapidUploadItem_createRapidUploadLink(t *testing.T) {
@@ -18,4 +30,487 @@ func TestRapidUploadItem_newRapidUploadItem(t *testing.T) {
 	link := "aliyunpan://file我的文件.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|dgsdg%2Frtt5%2F%E6%88%91%E7%9A%84%E6%96%87%E4%BB%B6%E5%A4%B9"
 	item,_ := newRapidUploadItem(link)
 	fmt.Println(item)
-}
\ No newline at end of file
+}
+func TestParseRapidUploadLines(t *testing.T) {
+	fd := &aliyunpan.FileEntity{
+		DriveId:     "1",
+		FileId:      "f_1",
+		FileName:    "1.mp4",
+		FileSize:    7005440,
+		ContentHash: "752fccbfb2436a6ffca3b287831d4faa5654b07e",
+		Path:        "/我的资源/电影/1.mp4",
+	}
+	lines := []string{
+		newRapidUploadHeader().String(),
+		newRapidUploadEntry("u_1", fd, "/", false).String(),
+		newRapidUploadEntry("u_1", fd, "/我的资源", true).String(),
+		"",
+		`{"name":"2.mp4","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":1,"path":"../../etc"}`,
+		`{"name":"3.mp4","sha1":"123","size":1}`,
+		"aliyunpan://file.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|pan_folder",
+		"not a link",
+	}
+	fmt.Println(lines[1])
+	items, lineErrs, err := parseRapidUploadLines(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		fmt.Println(item.FilePath, item.FileSha1, item.FileSize, item.Proof)
+	}
+	fmt.Println(lineErrs)
+	if len(items) != 4 || len(lineErrs) != 2 {
+		t.Fatalf("items = %d, errors = %d", len(items), len(lineErrs))
+	}
+	if items[0].FilePath != "我的资源/电影/1.mp4" || items[0].Proof.FileId != "f_1" || items[1].FilePath != "1.mp4" || items[2].FilePath != "etc/2.mp4" {
+		t.Fail()
+	}
+
+	_, _, err = parseRapidUploadLines([]string{`{"format":"aliyunpan-rapid-upload","version":99}`})
+	if err == nil {
+		t.Fatalf("unsupported version should return error")
+	}
+}
+
+// countReaderAt 统计 ReadAt 调用次数
+type countReaderAt struct {
+	*strings.Reader
+	count int
+}
+
+func (r *countReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.count++
+	return r.Reader.ReadAt(p, off)
+}
+
+func (r *countReaderAt) Len() int64 {
+	return r.Reader.Size()
+}
+
+func TestPanFileStreamReader_ReadAt(t *testing.T) {
+	src := &countReaderAt{Reader: strings.NewReader("0123456789abcdefghij")}
+	r := &panFileStreamReader{reader: src, blockSize: 8}
+	buf := make([]byte, 3)
+	for _, off := range []int64{0, 3, 6, 9} {
+		n, err := r.ReadAt(buf, off)
+		fmt.Println(off, n, err, string(buf[:n]))
+	}
+	// 跨块读取和读取到文件末尾
+	buf = make([]byte, 10)
+	n, err := r.ReadAt(buf, 12)
+	fmt.Println(n, err, string(buf[:n]), src.count)
+	if n != 8 || err != io.EOF || string(buf[:n]) != "cdefghij" || src.count != 3 {
+		t.Fail()
+	}
+}
+
+func TestUniqueCopyName(t *testing.T) {
+	existed := map[string]*aliyunpan.FileEntity{
+		"1.mp4":    {FileName: "1.mp4"},
+		"1(1).mp4": {FileName: "1(1).mp4"},
+		"dir":      {FileName: "dir"},
+	}
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{"1.mp4", "1(2).mp4"},
+		{"dir", "dir(1)"},
+		{"2.mp4", "2.mp4"},
+	}
+	for _, tc := range testCases {
+		if r := uniqueCopyName(tc.name, existed); r != tc.want {
+			t.Errorf("uniqueCopyName(%q) = %q, want %q", tc.name, r, tc.want)
+		}
+	}
+}
+
+func TestPanServerCopier_Copy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/adrive/v2/batch" || r.Header.Get("Authorization") != "Bearer access_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := struct {
+			Requests []struct {
+				Id   string            `json:"id"`
+				Url  string            `json:"url"`
+				Body map[string]string `json:"body"`
+			} `json:"requests"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		responses := []map[string]interface{}{}
+		for _, item := range req.Requests {
+			if item.Url == "/file/copy" && item.Body["file_id"] == "f_a" && item.Body["to_drive_id"] == item.Body["drive_id"] {
+				responses = append(responses, map[string]interface{}{
+					"id": item.Id, "status": 201,
+					"body": map[string]string{"file_id": "new_" + item.Body["new_name"]},
+				})
+				continue
+			}
+			responses = append(responses, map[string]interface{}{
+				"id": item.Id, "status": 404,
+				"body": map[string]string{"code": "NotFound.File", "message": "The resource file cannot be found"},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		accessToken string
+		fileId      string
+		newName     string
+		want        string
+		success     bool
+	}{
+		{"access_token", "f_a", "1(1).mp4", "new_1(1).mp4", true},
+		{"access_token", "f_b", "2.mp4", "", false},
+		{"", "f_a", "1.mp4", "", false},
+	}
+	for _, tc := range testCases {
+		r, err := newPanServerCopier(server.URL, tc.accessToken).Copy("1", tc.fileId, "root", tc.newName)
+		if (err == nil) != tc.success || r != tc.want {
+			t.Errorf("Copy(%s, %s) = %q, %v, want %q", tc.fileId, tc.newName, r, err, tc.want)
+		}
+	}
+}
+
+func TestSearchFilter_Match(t *testing.T) {
+	after, _ := parseSearchTime("2022-01-01")
+	filter := &SearchFilter{
+		NamePattern:  "*.mp4",
+		Exts:         []string{"mp4"},
+		MinSize:      1024,
+		MaxSize:      -1,
+		UpdatedAfter: after,
+		FileType:     "file",
+	}
+	testCases := []struct {
+		file  *aliyunpan.FileEntity
+		match bool
+	}{
+		{&aliyunpan.FileEntity{FileName: "电影.MP4", FileSize: 2048, FileType: "file", UpdatedAt: "2022-05-12 10:21:14"}, true},
+		{&aliyunpan.FileEntity{FileName: "电影.avi", FileSize: 2048, FileType: "file", UpdatedAt: "2022-05-12 10:21:14"}, false},
+		{&aliyunpan.FileEntity{FileName: "电影.mp4", FileSize: 100, FileType: "file", UpdatedAt: "2022-05-12 10:21:14"}, false},
+		{&aliyunpan.FileEntity{FileName: "电影.mp4", FileSize: 2048, FileType: "file", UpdatedAt: "2021-05-12 10:21:14"}, false},
+		{&aliyunpan.FileEntity{FileName: "电影.mp4", FileType: "folder", UpdatedAt: "2022-05-12 10:21:14"}, false},
+		{nil, false},
+	}
+	for i, tc := range testCases {
+		if r := filter.Match(tc.file); r != tc.match {
+			t.Errorf("case %d: Match() = %v, want %v", i, r, tc.match)
+		}
+	}
+}
+
+func TestDuCounter_Add(t *testing.T) {
+	counter := newDuCounter()
+	counter.Add("movie", true, 0)
+	counter.Add("movie/1.mp4", false, 1024)
+	counter.Add("movie/sub", true, 0)
+	counter.Add("movie/sub/2.mp4", false, 2048)
+	counter.Add("readme.txt", false, 100)
+	counter.Add("a.txt", false, 4096)
+	if counter.total.FileCount != 4 || counter.total.FolderCount != 2 || counter.total.Size != 7268 {
+		t.Errorf("total = %+v, want 4 files, 2 folders, 7268 bytes", counter.total)
+	}
+	movie := counter.entries["movie"]
+	if movie == nil || !movie.IsFolder || movie.FileCount != 2 || movie.FolderCount != 1 || movie.Size != 3072 {
+		t.Errorf("movie = %+v, want folder with 2 files, 1 folder, 3072 bytes", movie)
+	}
+
+	testCases := []struct {
+		sortBy string
+		top    int
+		want   []string
+	}{
+		{"size", 0, []string{"a.txt", "movie", "readme.txt"}},
+		{"count", 0, []string{"movie", "a.txt", "readme.txt"}},
+		{"name", 0, []string{"a.txt", "movie", "readme.txt"}},
+		{"size", 2, []string{"a.txt", "movie"}},
+	}
+	for _, tc := range testCases {
+		names := []string{}
+		for _, entry := range counter.List(tc.sortBy, tc.top) {
+			names = append(names, entry.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("List(%s, %d) = %v, want %v", tc.sortBy, tc.top, names, tc.want)
+		}
+	}
+}
+
+func TestSortPanDirFiles(t *testing.T) {
+	files := aliyunpan.FileList{
+		&aliyunpan.FileEntity{FileName: "b.txt", FileType: "file"},
+		nil,
+		&aliyunpan.FileEntity{FileName: "z", FileType: "folder"},
+		&aliyunpan.FileEntity{FileName: "a.txt", FileType: "file"},
+		&aliyunpan.FileEntity{FileName: "c", FileType: "folder"},
+	}
+	names := []string{}
+	for _, f := range sortPanDirFiles(files) {
+		names = append(names, f.FileName)
+	}
+	if want := "c,z,a.txt,b.txt"; strings.Join(names, ",") != want {
+		t.Errorf("sortPanDirFiles() = %v, want %s", names, want)
+	}
+}
+
+func TestVerifyProgress(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_verify")
+	defer os.RemoveAll(dir)
+	progressFilePath := filepath.Join(dir, "progress.jsonl")
+
+	vp, err := openVerifyProgress(progressFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp.Save(&verifyResultItem{Path: "/a.txt", Size: 10, Status: VerifyStatusOk})
+	vp.Save(&verifyResultItem{Path: "/b.txt", Size: 20, Status: VerifyStatusError, Reason: "读取本地文件出错"})
+	vp.Close(false)
+
+	vp, err = openVerifyProgress(progressFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vp.Close(true)
+	fmt.Println(vp.Get("/a.txt", 10), vp.Get("/a.txt", 11), vp.Get("/b.txt", 20))
+	if vp.Get("/a.txt", 10) == nil || vp.Get("/a.txt", 11) != nil || vp.Get("/b.txt", 20) != nil {
+		t.Fail()
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_transfer")
+	defer os.RemoveAll(dir)
+	progressFilePath := filepath.Join(dir, "progress.jsonl")
+
+	tp, err := openTransferProgress(progressFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.Save(&transferResultItem{Path: "/a.txt", Sha1: "ABC", Size: 10, Success: true})
+	tp.Save(&transferResultItem{Path: "/b.txt", Sha1: "DEF", Size: 20, Error: "秒传失败"})
+	tp.Close(false)
+
+	tp, err = openTransferProgress(progressFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close(true)
+	if tp.Get("/a.txt", "ABC", 10) == nil {
+		t.Error("transferred file should be skipped")
+	}
+	if tp.Get("/a.txt", "ABD", 10) != nil {
+		t.Error("changed file should be transferred again")
+	}
+	if tp.Get("/b.txt", "DEF", 20) != nil {
+		t.Error("failed file should be transferred again")
+	}
+}
+
+func TestBuildRenamePlan(t *testing.T) {
+	fmt.Println(renameWithSeq("第{n:03}集.mp4", 7), renameWithSeq("{n}.jpg", 12))
+
+	files := aliyunpan.FileList{
+		&aliyunpan.FileEntity{FileId: "1", FileName: "a", FileType: "file", Path: "/test/a"},
+		&aliyunpan.FileEntity{FileId: "2", FileName: "a_", FileType: "file", Path: "/test/a_"},
+		&aliyunpan.FileEntity{FileId: "3", FileName: "b", FileType: "file", Path: "/test/b"},
+	}
+	// a -> a_, a_ -> a__ 需要先重命名 a_
+	plan, err := buildRenamePlan(files, regexp.MustCompile(`^(a_?)$`), "${1}_", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].OldName != "a_" {
+		t.Fail()
+	}
+	for _, item := range plan {
+		fmt.Println(item.OldName, "->", item.NewName)
+	}
+
+	_, err = buildRenamePlan(files, regexp.MustCompile(`^a$`), "b", "")
+	fmt.Println(err)
+	if err == nil {
+		t.Fail()
+	}
+	_, err = buildRenamePlan(files, regexp.MustCompile(`$`), ".log", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildRenamePlan(files, regexp.MustCompile(`^[ab]$`), "x", "")
+	fmt.Println(err)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestBuildRenamePlan_SeqPerDir(t *testing.T) {
+	files := aliyunpan.FileList{
+		&aliyunpan.FileEntity{FileId: "1", FileName: "x.mp4", FileType: "file", Path: "/剧集/S01/x.mp4"},
+		&aliyunpan.FileEntity{FileId: "2", FileName: "y.mp4", FileType: "file", Path: "/剧集/S01/y.mp4"},
+		&aliyunpan.FileEntity{FileId: "3", FileName: "z.mp4", FileType: "file", Path: "/剧集/S02/z.mp4"},
+	}
+	plan, err := buildRenamePlan(files, regexp.MustCompile(`.*\.mp4$`), "第{n:02}集.mp4", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"1": "第01集.mp4", "2": "第02集.mp4", "3": "第01集.mp4"}
+	if len(plan) != len(want) {
+		t.Fatalf("plan length = %d, want %d", len(plan), len(want))
+	}
+	for _, item := range plan {
+		if item.NewName != want[item.FileId] {
+			t.Errorf("file %s new name = %s, want %s", item.FileId, item.NewName, want[item.FileId])
+		}
+	}
+}
+
+func TestCreateRenameJournal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_rename")
+	defer os.RemoveAll(dir)
+	journalPath := filepath.Join(dir, "journal.jsonl")
+
+	f, p, err := createRenameJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if p != journalPath {
+		t.Errorf("journal path = %s, want %s", p, journalPath)
+	}
+	// 指定的日志文件已经存在，不能追加到旧的日志
+	if f, _, err = createRenameJournal(journalPath); err == nil {
+		f.Close()
+		t.Error("create existed journal should fail")
+	}
+}
+
+func TestDedupeGroup_pickKeeper(t *testing.T) {
+	files := aliyunpan.FileList{
+		&aliyunpan.FileEntity{FileId: "1", FileName: "1.jpg", FileType: "file", FileSize: 100, ContentHash: "ABC", CreatedAt: "2022-03-01 10:00:00", Path: "/下载/照片/1.jpg"},
+		&aliyunpan.FileEntity{FileId: "2", FileName: "1.jpg", FileType: "file", FileSize: 100, ContentHash: "abc", CreatedAt: "2021-03-01 10:00:00", Path: "/备份/2021/照片/1.jpg"},
+		&aliyunpan.FileEntity{FileId: "3", FileName: "1(1).jpg", FileType: "file", FileSize: 100, ContentHash: "ABC", CreatedAt: "2022-05-01 10:00:00", Path: "/1(1).jpg"},
+		&aliyunpan.FileEntity{FileId: "4", FileName: "2.jpg", FileType: "file", FileSize: 200, ContentHash: "DEF", CreatedAt: "2022-05-01 10:00:00", Path: "/2.jpg"},
+		&aliyunpan.FileEntity{FileId: "5", FileName: "empty.txt", FileType: "file", FileSize: 0, ContentHash: "DA39A3EE5E6B4B0D3255BFEF95601890AFD80709", Path: "/empty.txt"},
+		&aliyunpan.FileEntity{FileId: "6", FileName: "empty2.txt", FileType: "file", FileSize: 0, ContentHash: "DA39A3EE5E6B4B0D3255BFEF95601890AFD80709", Path: "/empty2.txt"},
+	}
+	groups := groupDuplicateFiles(files, 1)
+	if len(groups) != 1 || len(groups[0].Files) != 3 || groups[0].WastedSize() != 200 {
+		t.Fatal("group duplicate files error")
+	}
+	group := groups[0]
+	testCases := []struct {
+		policy DedupeKeepPolicy
+		prefix string
+		want   string
+	}{
+		{DedupeKeepOldest, "", "2"},
+		{DedupeKeepNewest, "", "3"},
+		{DedupeKeepShortest, "", "3"},
+		{DedupeKeepPrefix, "/下载", "1"},
+		{DedupeKeepPrefix, "/下载/", "1"},
+		{DedupeKeepPrefix, "/不存在", "2"},
+	}
+	for _, tc := range testCases {
+		group.pickKeeper(tc.policy, tc.prefix)
+		if r := group.Files[group.Keep].FileId; r != tc.want {
+			t.Errorf("pickKeeper(%s, %q) = %s, want %s", tc.policy, tc.prefix, r, tc.want)
+		}
+	}
+}
+
+func TestShareListOptions_Match(t *testing.T) {
+	now := utils.ParseTimeStr("2022-06-01 12:00:00")
+	records := ShareRecordList{
+		{ShareId: "s1", FileIdList: []string{"f1"}, Expiration: "", Status: shareRecordStatus("", false, now)},
+		{ShareId: "s2", FileIdList: []string{"f2"}, Expiration: "2022-06-03 12:00:00", Status: shareRecordStatus("2022-06-03 12:00:00", false, now)},
+		{ShareId: "s3", FileIdList: []string{"f3"}, Expiration: "2022-05-01 12:00:00", Status: shareRecordStatus("2022-05-01 12:00:00", false, now)},
+		{ShareId: "s4", FileIdList: []string{"f4"}, Expiration: "", Status: shareRecordStatus("", true, now)},
+	}
+	testCases := []struct {
+		options *ShareListOptions
+		want    string
+	}{
+		{&ShareListOptions{ExpiringDays: -1}, "s1,s2,s3,s4"},
+		{&ShareListOptions{Status: ShareStatusActive, ExpiringDays: -1}, "s1,s2"},
+		{&ShareListOptions{Status: ShareStatusExpired, ExpiringDays: -1}, "s3"},
+		{&ShareListOptions{Status: ShareStatusDeleted, ExpiringDays: -1}, "s4"},
+		{&ShareListOptions{FileIds: map[string]bool{"f1": true, "f3": true}, ExpiringDays: -1}, "s1,s3"},
+		{&ShareListOptions{ExpiringDays: 7}, "s2"},
+		{&ShareListOptions{ExpiringDays: 1}, ""},
+	}
+	for i, tc := range testCases {
+		ids := []string{}
+		for _, r := range records {
+			if tc.options.Match(r, now) {
+				ids = append(ids, r.ShareId)
+			}
+		}
+		if r := strings.Join(ids, ","); r != tc.want {
+			t.Errorf("case %d: Match() = %q, want %q", i, r, tc.want)
+		}
+	}
+}
+
+func TestRecycleFilter_Match(t *testing.T) {
+	durations := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30d", 30 * 24 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"1x", 0, false},
+		{"-1d", 0, false},
+	}
+	for _, tc := range durations {
+		d, err := parseRecycleDuration(tc.value)
+		if (err == nil) != tc.ok || d != tc.want {
+			t.Errorf("parseRecycleDuration(%q) = %v, %v, want %v", tc.value, d, err, tc.want)
+		}
+	}
+
+	items := []*recycleItem{
+		{File: &aliyunpan.FileEntity{FileName: "a.pdf", UpdatedAt: "2022-05-01 12:00:00"}, OriginPath: "/docs/a.pdf"},
+		{File: &aliyunpan.FileEntity{FileName: "b.pdf", UpdatedAt: "2022-05-30 12:00:00"}, OriginPath: "/docs/2022/b.pdf"},
+		{File: &aliyunpan.FileEntity{FileName: "c.mp4", UpdatedAt: "2022-05-31 12:00:00"}, OriginPath: "/docs/c.mp4"},
+		{File: &aliyunpan.FileEntity{FileName: "d.pdf", UpdatedAt: "2022-05-31 12:00:00"}, OriginPath: ""},
+	}
+	weekAgo := utils.ParseTimeStr("2022-06-01 12:00:00").Add(-7 * 24 * time.Hour)
+	testCases := []struct {
+		pattern       string
+		deletedBefore time.Time
+		deletedAfter  time.Time
+		want          string
+	}{
+		{"/docs/**.pdf", time.Time{}, time.Time{}, "a.pdf b.pdf"},
+		{"/docs/*.pdf", time.Time{}, time.Time{}, "a.pdf"},
+		{"/docs/**/*.pdf", time.Time{}, time.Time{}, "a.pdf b.pdf"},
+		{"/docs/2022", time.Time{}, time.Time{}, "b.pdf"},
+		{"*.pdf", time.Time{}, time.Time{}, "a.pdf b.pdf d.pdf"}, // 只匹配文件名，不需要原路径
+		{"**", time.Time{}, time.Time{}, "a.pdf b.pdf c.mp4 d.pdf"},
+		{"", time.Time{}, weekAgo, "b.pdf c.mp4 d.pdf"},
+		{"", weekAgo, time.Time{}, "a.pdf"},
+		{"*.pdf", time.Time{}, weekAgo, "b.pdf d.pdf"},
+	}
+	for _, tc := range testCases {
+		filter := &RecycleFilter{DeletedBefore: tc.deletedBefore, DeletedAfter: tc.deletedAfter}
+		if tc.pattern != "" {
+			filter.PathPattern, _ = recycleGlobRegexp(tc.pattern)
+			filter.NameOnly = !strings.Contains(tc.pattern, "/")
+		}
+		matched := []string{}
+		for _, item := range items {
+			if filter.Match(item) {
+				matched = append(matched, item.File.FileName)
+			}
+		}
+		if r := strings.Join(matched, " "); r != tc.want {
+			t.Errorf("pattern %q matched %q, want %q", tc.pattern, r, tc.want)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/functions/panshare"
+	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/library-go/requester"
+	"github.com/urfave/cli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// CopyConflictPolicy 复制时目标位置已经存在同名文件的处理策略
+	CopyConflictPolicy string
+
+	// panServerCopier 在云盘服务端复制文件或者文件夹，不需要下载和上传文件内容。
+	// aliyunpan-api 没有提供复制接口，这里通过批量接口发送 /file/copy 请求
+	panServerCopier struct {
+		apiHost     string
+		accessToken string
+		client      *requester.HTTPClient
+	}
+
+	// panFileRangeReader 通过HTTP Range请求读取云盘文件指定位置的数据，
+	// 用于计算秒传需要的 proof_code，不需要下载整个文件
+	panFileRangeReader struct {
+		panClient   *aliyunpan.PanClient
+		file        *aliyunpan.FileEntity
+		downloadUrl string
+	}
+
+	// panCopyTask 复制任务
+	panCopyTask struct {
+		panClient *aliyunpan.PanClient
+		copier    *panServerCopier
+		driveId   string
+		policy    CopyConflictPolicy
+
+		copiedCount  int
+		skippedCount int
+		failedPaths  []string
+	}
+)
+
+const (
+	// CopyConflictSkip 跳过已经存在的文件，已经存在的文件夹会合并
+	CopyConflictSkip CopyConflictPolicy = "skip"
+	// CopyConflictOverwrite 覆盖已经存在的文件，已经存在的文件夹会合并
+	CopyConflictOverwrite CopyConflictPolicy = "overwrite"
+	// CopyConflictRename 自动重命名，例如 1.mp4 复制为 1(1).mp4
+	CopyConflictRename CopyConflictPolicy = "rename"
+)
+
+func CmdCp() cli.Command {
+	return cli.Command{
+		Name:  "cp",
+		Usage: "拷贝文件/目录",
+		UsageText: `拷贝:
+	aliyunpan cp <文件/目录1> <文件/目录2> <文件/目录3> ... <目标目录>`,
+		Description: `
+	拷贝文件和目录到目标目录, 目录会递归拷贝. 拷贝优先使用云盘服务端复制, 服务端复制失败或者覆盖已经存在的文件时使用秒传, 不会下载和上传文件内容.
+	注意: 拷贝多个文件和目录时, 不存在的文件和目录会被跳过.
+
+	目标目录已经存在同名文件时的处理策略, 通过 -conflict 指定:
+	skip: 跳过已经存在的文件, 默认策略
+	overwrite: 覆盖已经存在的文件
+	rename: 自动重命名, 例如 1.mp4 拷贝为 1(1).mp4
+	已经存在的同名目录在 skip 和 overwrite 策略下会合并.
+
+	示例:
+
+	将 /我的资源/1.mp4 拷贝到 根目录 /
+	aliyunpan cp /我的资源/1.mp4 /
+
+	将 /我的资源 整个目录和 /我的文档/1.txt 拷贝到 /备份 目录, 覆盖已经存在的文件
+	aliyunpan cp -conflict overwrite /我的资源 /我的文档/1.txt /备份
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() <= 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			policy := CopyConflictPolicy(strings.ToLower(c.String("conflict")))
+			if policy != CopyConflictSkip && policy != CopyConflictOverwrite && policy != CopyConflictRename {
+				fmt.Println("不支持的冲突处理策略: ", c.String("conflict"))
+				return nil
+			}
+
+			RunCopy(parseDriveId(c), policy, c.Args()...)
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "conflict",
+				Usage: "目标目录已经存在同名文件时的处理策略, 支持: skip,overwrite,rename",
+				Value: string(CopyConflictSkip),
+			},
+		},
+	}
+}
+
+// RunCopy 执行拷贝文件/目录
+func RunCopy(driveId string, policy CopyConflictPolicy, paths ...string) {
+	activeUser := GetActiveUser()
+	opFileList, targetFile, failedPaths, err := getFileInfo(driveId, paths...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, p := range failedPaths {
+		fmt.Println("文件不存在, 跳过: ", p)
+	}
+	if len(opFileList) == 0 {
+		fmt.Println("没有有效的文件可拷贝")
+		return
+	}
+	targetFile.Path = path.Clean(activeUser.PathJoin(driveId, paths[len(paths)-1]))
+
+	task := &panCopyTask{
+		panClient:   activeUser.PanClient(),
+		copier:      newPanServerCopier(panshare.DefaultApiHost, activeUser.PanClient().GetAccessToken()),
+		driveId:     driveId,
+		policy:      policy,
+		failedPaths: []string{},
+	}
+	existed, err := task.listFolder(targetFile)
+	if err != nil {
+		fmt.Println("获取目标目录文件列表失败: ", err)
+		return
+	}
+	for _, fe := range opFileList {
+		if fe.IsFolder() && (targetFile.Path == fe.Path || strings.HasPrefix(targetFile.Path, fe.Path+"/")) {
+			fmt.Println("不能将目录拷贝到自身或者子目录中: ", fe.Path)
+			task.failedPaths = append(task.failedPaths, fe.Path)
+			continue
+		}
+		task.copyEntity(fe, targetFile, existed)
+	}
+
+	fmt.Printf("\n拷贝结束, 已拷贝: %d, 已跳过: %d, 失败: %d\n", task.copiedCount, task.skippedCount, len(task.failedPaths))
+	if len(task.failedPaths) > 0 {
+		fmt.Println("以下文件拷贝失败：")
+		for _, p := range task.failedPaths {
+			fmt.Println(p)
+		}
+	}
+	activeUser.DeleteCache([]string{targetFile.Path})
+}
+
+// uniqueCopyName 获取不和已经存在的文件重名的名称，例如 1.mp4 -> 1(1).mp4
+func uniqueCopyName(name string, existed map[string]*aliyunpan.FileEntity) string {
+	if _, ok := existed[name]; !ok {
+		return name
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		newName := fmt.Sprintf("%s(%d)%s", base, i, ext)
+		if _, ok := existed[newName]; !ok {
+			return newName
+		}
+	}
+}
+
+// listFolder 获取文件夹下的文件，key为文件名
+func (t *panCopyTask) listFolder(folder *aliyunpan.FileEntity) (map[string]*aliyunpan.FileEntity, error) {
+	fileList, apierr := t.panClient.FileListGetAll(&aliyunpan.FileListParam{
+		DriveId:      t.driveId,
+		ParentFileId: folder.FileId,
+	}, 500)
+	if apierr != nil {
+		return nil, apierr
+	}
+	files := map[string]*aliyunpan.FileEntity{}
+	for _, fe := range fileList {
+		fe.Path = path.Join(folder.Path, fe.FileName)
+		files[fe.FileName] = fe
+	}
+	return files, nil
+}
+
+// copyEntity 拷贝文件或者文件夹到目标文件夹，existed 为目标文件夹下已经存在的文件
+func (t *panCopyTask) copyEntity(src, targetFolder *aliyunpan.FileEntity, existed map[string]*aliyunpan.FileEntity) {
+	name := src.FileName
+	checkNameMode := "refuse"
+	if dst, ok := existed[name]; ok {
+		switch t.policy {
+		case CopyConflictRename:
+			name = uniqueCopyName(name, existed)
+		default:
+			if src.IsFolder() != dst.IsFolder() {
+				fmt.Println("目标位置已经存在不同类型的同名文件, 跳过: ", dst.Path)
+				t.skippedCount++
+				return
+			}
+			if src.IsFolder() {
+				// 合并文件夹
+				t.copyFolder(src, dst)
+				return
+			}
+			if t.policy == CopyConflictSkip {
+				fmt.Println("文件已经存在, 跳过: ", dst.Path)
+				t.skippedCount++
+				return
+			}
+			checkNameMode = "overwrite"
+		}
+	}
+
+	targetPath := path.Join(targetFolder.Path, name)
+	if src.IsFolder() {
+		// 优先在服务端复制整个文件夹，失败时再创建文件夹逐个拷贝文件
+		newFileId, err := t.copier.Copy(t.driveId, src.FileId, targetFolder.FileId, name)
+		if err == nil {
+			existed[name] = &aliyunpan.FileEntity{
+				DriveId:  t.driveId,
+				FileId:   newFileId,
+				FileName: name,
+				FileType: "folder",
+				Path:     targetPath,
+			}
+			t.copiedCount++
+			fmt.Printf("已拷贝: %s -> %s\n", src.Path, targetPath)
+			return
+		}
+		logger.Verboseln("server copy folder error, copy files one by one: ", src.Path, err)
+		rs, apierr := t.panClient.MkdirByFullPath(t.driveId, targetPath)
+		if apierr != nil || rs == nil || rs.FileId == "" {
+			fmt.Println("创建文件夹失败: ", targetPath, apierr)
+			t.failedPaths = append(t.failedPaths, src.Path)
+			return
+		}
+		dst := &aliyunpan.FileEntity{
+			DriveId:  t.driveId,
+			FileId:   rs.FileId,
+			FileName: name,
+			Path:     targetPath,
+		}
+		existed[name] = dst
+		t.copyFolder(src, dst)
+		return
+	}
+
+	if err := t.copyFile(src, targetFolder, name, checkNameMode); err != nil {
+		fmt.Printf("拷贝文件失败: %s, %s\n", src.Path, err)
+		t.failedPaths = append(t.failedPaths, src.Path)
+		return
+	}
+	existed[name] = &aliyunpan.FileEntity{FileName: name, Path: targetPath}
+	t.copiedCount++
+	fmt.Printf("已拷贝: %s -> %s\n", src.Path, targetPath)
+}
+
+// copyFolder 递归拷贝文件夹下的文件到目标文件夹
+func (t *panCopyTask) copyFolder(src, dst *aliyunpan.FileEntity) {
+	srcFiles, err := t.listFolder(src)
+	if err != nil {
+		fmt.Println("获取文件夹文件列表失败: ", src.Path, err)
+		t.failedPaths = append(t.failedPaths, src.Path)
+		return
+	}
+	existed, err := t.listFolder(dst)
+	if err != nil {
+		fmt.Println("获取文件夹文件列表失败: ", dst.Path, err)
+		t.failedPaths = append(t.failedPaths, src.Path)
+		return
+	}
+	time.Sleep(500 * time.Millisecond)
+	for _, fe := range srcFiles {
+		t.copyEntity(fe, dst, existed)
+	}
+}
+
+// copyFile 拷贝文件，优先使用服务端复制，服务端复制失败或者需要覆盖已经存在的文件时使用秒传
+func (t *panCopyTask) copyFile(src, targetFolder *aliyunpan.FileEntity, name, checkNameMode string) error {
+	if checkNameMode != "overwrite" {
+		_, err := t.copier.Copy(t.driveId, src.FileId, targetFolder.FileId, name)
+		if err == nil {
+			return nil
+		}
+		logger.Verboseln("server copy file error, try rapid upload: ", src.Path, err)
+		if src.ContentHash == "" {
+			return err
+		}
+	}
+	if src.ContentHash == "" {
+		return fmt.Errorf("文件没有SHA1信息，无法秒传拷贝")
+	}
+	proofCode := ""
+	if src.FileSize > 0 {
+		proofCode = aliyunpan.CalcProofCode(t.panClient.GetAccessToken(), &panFileRangeReader{
+			panClient: t.panClient,
+			file:      src,
+		}, src.FileSize)
+	}
+	uploadOpEntity, apierr := t.panClient.CreateUploadFile(&aliyunpan.CreateFileUploadParam{
+		DriveId:         t.driveId,
+		Name:            name,
+		Size:            src.FileSize,
+		ContentHash:     src.ContentHash,
+		ContentHashName: "sha1",
+		CheckNameMode:   checkNameMode,
+		ParentFileId:    targetFolder.FileId,
+		BlockSize:       aliyunpan.DefaultChunkSize,
+		ProofCode:       proofCode,
+		ProofVersion:    "v1",
+	})
+	if apierr != nil {
+		return apierr
+	}
+	if !uploadOpEntity.RapidUpload {
+		return fmt.Errorf("秒传失败")
+	}
+	logger.Verboseln("rapid upload copy file: ", src.Path, " -> ", path.Join(targetFolder.Path, name))
+	return nil
+}
+
+// newPanServerCopier 创建服务端复制工具，accessToken 为当前登录用户的令牌
+func newPanServerCopier(apiHost, accessToken string) *panServerCopier {
+	return &panServerCopier{
+		apiHost:     strings.TrimSuffix(apiHost, "/"),
+		accessToken: accessToken,
+		client:      requester.NewHTTPClient(),
+	}
+}
+
+// Copy 在同一个网盘内复制文件或者文件夹到目标文件夹，newName 为复制后的名称，返回新文件的ID
+func (c *panServerCopier) Copy(driveId, fileId, toParentFileId, newName string) (string, error) {
+	type batchResponse struct {
+		Responses []struct {
+			Id     string `json:"id"`
+			Status int    `json:"status"`
+			Body   struct {
+				FileId  string `json:"file_id"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"body"`
+		} `json:"responses"`
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"requests": []map[string]interface{}{
+			{
+				"body": map[string]interface{}{
+					"drive_id":          driveId,
+					"file_id":           fileId,
+					"to_drive_id":       driveId,
+					"to_parent_file_id": toParentFileId,
+					"new_name":          newName,
+				},
+				"headers": map[string]string{"Content-Type": "application/json"},
+				"id":      fileId,
+				"method":  "POST",
+				"url":     "/file/copy",
+			},
+		},
+		"resource": "file",
+	})
+	if err != nil {
+		return "", err
+	}
+	header := map[string]string{
+		"Content-Type":  "application/json;charset=UTF-8",
+		"Accept":        "application/json, text/plain, */*",
+		"Authorization": "Bearer " + c.accessToken,
+	}
+	resp, err := c.client.Req(http.MethodPost, c.apiHost+"/adrive/v2/batch", bytes.NewReader(data), header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("服务端复制请求失败: %s", resp.Status)
+	}
+	r := &batchResponse{}
+	if err = json.Unmarshal(body, r); err != nil {
+		return "", err
+	}
+	for _, item := range r.Responses {
+		if item.Id != fileId {
+			continue
+		}
+		if item.Status < 200 || item.Status >= 300 {
+			return "", fmt.Errorf("服务端复制失败: %s: %s", item.Body.Code, item.Body.Message)
+		}
+		return item.Body.FileId, nil
+	}
+	return "", fmt.Errorf("服务端复制失败: 没有返回结果")
+}
+
+// Len 文件大小
+func (r *panFileRangeReader) Len() int64 {
+	return r.file.FileSize
+}
+
+// ReadAt 读取云盘文件指定位置的数据
+func (r *panFileRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.file.FileSize {
+		return 0, io.EOF
+	}
+	if r.downloadUrl == "" {
+		durl, apierr := r.panClient.GetFileDownloadUrl(&aliyunpan.GetFileDownloadUrlParam{
+			DriveId: r.file.DriveId,
+			FileId:  r.file.FileId,
+		})
+		if apierr != nil {
+			return 0, apierr
+		}
+		r.downloadUrl = durl.Url
+	}
+
+	end := off + int64(len(p))
+	if end > r.file.FileSize {
+		end = r.file.FileSize
+	}
+	var resp *http.Response
+	var err error
+	client := requester.NewHTTPClient()
+	apierr := r.panClient.DownloadFileData(r.downloadUrl, aliyunpan.FileDownloadRange{
+		Offset: off,
+		End:    end - 1,
+	}, func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+		resp, err = client.Req(httpMethod, fullUrl, nil, headers)
+		return resp, err
+	})
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if apierr != nil {
+		return 0, apierr
+	}
+	if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && off == 0) {
+		return 0, fmt.Errorf("read pan file range error: %s", resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p[:end-off])
+	if err == nil && end == r.file.FileSize && int(end-off) < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
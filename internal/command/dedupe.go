@@ -0,0 +1,359 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/logger"
+	"github.com/urfave/cli"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// DedupeKeepPolicy 重复文件保留策略
+	DedupeKeepPolicy string
+
+	// DedupeOptions 查找重复文件可选项
+	DedupeOptions struct {
+		KeepPolicy   DedupeKeepPolicy // 保留策略
+		PreferPrefix string           // 优先保留的路径前缀，用于 prefix 策略
+		MinSize      int64            // 最小文件大小，小于该大小的文件不处理
+		Confirm      bool             // 将多余的文件移动到回收站，否则只显示结果
+	}
+
+	// dedupeGroup 大小和SHA1相同的一组重复文件
+	dedupeGroup struct {
+		Size  int64
+		Sha1  string
+		Files aliyunpan.FileList
+		Keep  int // 保留的文件在 Files 中的位置
+	}
+)
+
+const (
+	// DedupeKeepOldest 保留创建时间最早的文件
+	DedupeKeepOldest DedupeKeepPolicy = "oldest"
+	// DedupeKeepNewest 保留创建时间最晚的文件
+	DedupeKeepNewest DedupeKeepPolicy = "newest"
+	// DedupeKeepShortest 保留路径最短的文件
+	DedupeKeepShortest DedupeKeepPolicy = "shortest"
+	// DedupeKeepPrefix 优先保留指定路径前缀下的文件
+	DedupeKeepPrefix DedupeKeepPolicy = "prefix"
+
+	// dedupeDeleteBatchSize 每次批量删除的文件数量
+	dedupeDeleteBatchSize = 100
+)
+
+func CmdDedupe() cli.Command {
+	return cli.Command{
+		Name:      "dedupe",
+		Usage:     "查找和清理重复文件",
+		UsageText: cmder.App().Name + " dedupe [-keep oldest|newest|shortest|prefix] [-y] <目录>",
+		Description: `
+	递归查找指定目录(默认为当前工作目录)内大小和SHA1都相同的重复文件, 显示每组重复文件和浪费的空间.
+	指定目录为 / 则查找整个网盘.
+
+	默认只显示结果, 使用 -y 会根据保留策略每组保留一个文件, 其他文件移动到回收站.
+
+	保留策略, 通过 -keep 指定:
+	oldest: 保留创建时间最早的文件, 默认策略
+	newest: 保留创建时间最晚的文件
+	shortest: 保留路径最短的文件
+	prefix: 优先保留 -prefer 指定目录下的文件, 有多个时保留创建时间最早的文件
+
+	示例:
+
+	查找 /我的资源 内的重复文件
+	aliyunpan dedupe /我的资源
+
+	查找整个网盘大于 1MB 的重复文件
+	aliyunpan dedupe -minsize 1MB /
+
+	优先保留 /整理好的照片 内的文件, 其他重复文件移动到回收站
+	aliyunpan dedupe -keep prefix -prefer /整理好的照片 -y /
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			policy := DedupeKeepPolicy(strings.ToLower(c.String("keep")))
+			switch policy {
+			case DedupeKeepOldest, DedupeKeepNewest, DedupeKeepShortest:
+			case DedupeKeepPrefix:
+				if c.String("prefer") == "" {
+					fmt.Println("prefix 策略需要使用 -prefer 指定优先保留的目录")
+					return nil
+				}
+			default:
+				fmt.Println("不支持的保留策略: ", c.String("keep"))
+				return nil
+			}
+			minSize := int64(1)
+			if c.String("minsize") != "" {
+				size, err := converter.ParseFileSizeStr(c.String("minsize"))
+				if err != nil {
+					fmt.Println("文件大小格式错误: ", c.String("minsize"))
+					return nil
+				}
+				minSize = size
+			}
+
+			driveId := parseDriveId(c)
+			preferPrefix := ""
+			if c.String("prefer") != "" {
+				preferPrefix = path.Clean(GetActiveUser().PathJoin(driveId, c.String("prefer")))
+			}
+			RunDedupe(driveId, c.Args().Get(0), &DedupeOptions{
+				KeepPolicy:   policy,
+				PreferPrefix: preferPrefix,
+				MinSize:      minSize,
+				Confirm:      c.Bool("y"),
+			})
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "keep",
+				Usage: "保留策略, 支持: oldest,newest,shortest,prefix",
+				Value: string(DedupeKeepOldest),
+			},
+			cli.StringFlag{
+				Name:  "prefer",
+				Usage: "prefix 策略优先保留的目录",
+			},
+			cli.StringFlag{
+				Name:  "minsize",
+				Usage: "最小文件大小, 小于该大小的文件不处理, 默认忽略空文件",
+			},
+			cli.BoolFlag{
+				Name:  "y",
+				Usage: "将多余的重复文件移动到回收站, 否则只显示结果",
+			},
+		},
+	}
+}
+
+// groupDuplicateFiles 按照大小和SHA1对文件分组，只返回有重复的分组，按照浪费的空间降序排序
+func groupDuplicateFiles(files aliyunpan.FileList, minSize int64) []*dedupeGroup {
+	groupMap := map[string]*dedupeGroup{}
+	for _, f := range files {
+		if f.IsFolder() || f.ContentHash == "" || f.FileSize < minSize {
+			continue
+		}
+		sha1 := strings.ToUpper(f.ContentHash)
+		key := fmt.Sprintf("%d_%s", f.FileSize, sha1)
+		group, ok := groupMap[key]
+		if !ok {
+			group = &dedupeGroup{Size: f.FileSize, Sha1: sha1}
+			groupMap[key] = group
+		}
+		group.Files = append(group.Files, f)
+	}
+
+	groups := []*dedupeGroup{}
+	for _, group := range groupMap {
+		if len(group.Files) < 2 {
+			continue
+		}
+		sort.Slice(group.Files, func(i, j int) bool {
+			return group.Files[i].Path < group.Files[j].Path
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].WastedSize() != groups[j].WastedSize() {
+			return groups[i].WastedSize() > groups[j].WastedSize()
+		}
+		return groups[i].Files[0].Path < groups[j].Files[0].Path
+	})
+	return groups
+}
+
+// WastedSize 重复文件浪费的空间
+func (g *dedupeGroup) WastedSize() int64 {
+	return g.Size * int64(len(g.Files)-1)
+}
+
+// pickKeeper 根据保留策略选择需要保留的文件
+func (g *dedupeGroup) pickKeeper(policy DedupeKeepPolicy, preferPrefix string) {
+	preferred := func(f *aliyunpan.FileEntity) bool {
+		return f.Path == preferPrefix || strings.HasPrefix(f.Path, strings.TrimSuffix(preferPrefix, "/")+"/")
+	}
+	// better 判断文件 i 是否比文件 j 更应该保留，相同时保留路径排序靠前的文件
+	better := func(i, j int) bool {
+		fi, fj := g.Files[i], g.Files[j]
+		switch policy {
+		case DedupeKeepNewest:
+			ti, tj := utils.ParseTimeStr(fi.CreatedAt), utils.ParseTimeStr(fj.CreatedAt)
+			if !ti.Equal(tj) {
+				return ti.After(tj)
+			}
+		case DedupeKeepShortest:
+			li, lj := utf8.RuneCountInString(fi.Path), utf8.RuneCountInString(fj.Path)
+			if li != lj {
+				return li < lj
+			}
+		default:
+			if policy == DedupeKeepPrefix && preferred(fi) != preferred(fj) {
+				return preferred(fi)
+			}
+			ti, tj := utils.ParseTimeStr(fi.CreatedAt), utils.ParseTimeStr(fj.CreatedAt)
+			if !ti.Equal(tj) {
+				return ti.Before(tj)
+			}
+		}
+		return fi.Path < fj.Path
+	}
+
+	g.Keep = 0
+	for k := 1; k < len(g.Files); k++ {
+		if better(k, g.Keep) {
+			g.Keep = k
+		}
+	}
+}
+
+// RunDedupe 执行查找和清理重复文件
+func RunDedupe(driveId, targetPath string, options *DedupeOptions) {
+	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
+	targetPath = path.Clean(activeUser.PathJoin(driveId, targetPath))
+
+	files := aliyunpan.FileList{}
+	// 读取失败的目录，出现后扫描会中断，查找结果是不完整的
+	failedPaths := []string{}
+	panClient.FilesDirectoriesRecurseList(driveId, targetPath, func(depth int, fdPath string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		if apiError != nil {
+			logger.Verbosef("%s\n", apiError)
+			failedPaths = append(failedPaths, fmt.Sprintf("%s: %s", fdPath, apiError))
+			return false
+		}
+		if fd != nil && !fd.IsFolder() {
+			files = append(files, fd)
+			if len(files)%100 == 0 {
+				fmt.Printf("\r已扫描文件: %d", len(files))
+			}
+		}
+		return true
+	})
+	fmt.Printf("\r已扫描文件: %d\n", len(files))
+	if len(files) == 0 && len(failedPaths) > 0 {
+		fmt.Printf("扫描失败, %s\n", failedPaths[0])
+		return
+	}
+	if len(failedPaths) > 0 {
+		fmt.Println("读取目录失败, 扫描已中断, 以下结果不完整:")
+		for _, failedPath := range failedPaths {
+			fmt.Printf("  %s\n", failedPath)
+		}
+	}
+
+	groups := groupDuplicateFiles(files, options.MinSize)
+	if len(groups) == 0 {
+		if len(failedPaths) > 0 {
+			fmt.Println("已扫描的文件中没有重复的文件")
+		} else {
+			fmt.Println("没有重复的文件")
+		}
+		return
+	}
+
+	var duplicateCount, wastedSize int64
+	deleteFiles := aliyunpan.FileList{}
+	for k, group := range groups {
+		group.pickKeeper(options.KeepPolicy, options.PreferPrefix)
+		duplicateCount += int64(len(group.Files) - 1)
+		wastedSize += group.WastedSize()
+
+		fmt.Printf("\n[%d] 文件大小: %s, SHA1: %s, 重复数量: %d, 浪费空间: %s\n", k+1,
+			converter.ConvertFileSize(group.Size, 2), group.Sha1, len(group.Files), converter.ConvertFileSize(group.WastedSize(), 2))
+		for i, f := range group.Files {
+			if i == group.Keep {
+				fmt.Printf("  保留  %s  %s\n", f.CreatedAt, f.Path)
+				continue
+			}
+			fmt.Printf("  删除  %s  %s\n", f.CreatedAt, f.Path)
+			deleteFiles = append(deleteFiles, f)
+		}
+	}
+	fmt.Printf("\n重复文件组: %d, 多余文件: %d, 浪费空间: %s\n", len(groups), duplicateCount, converter.ConvertFileSize(wastedSize, 2))
+
+	if len(failedPaths) > 0 {
+		// 扫描不完整时可能没有找到应该保留的文件，不允许删除
+		if options.Confirm {
+			fmt.Println("扫描没有完成, 以上结果不完整, 已取消删除, 请稍后重试")
+		} else {
+			fmt.Println("扫描没有完成, 以上为不完整的预览结果, 扫描完成后才能使用 -y 删除文件")
+		}
+		return
+	}
+	if !options.Confirm {
+		fmt.Println("以上为预览结果, 确认无误后请使用 -y 将标记为删除的文件移动到回收站")
+		return
+	}
+
+	successCount := 0
+	cacheCleanDirs := []string{}
+	for start := 0; start < len(deleteFiles); start += dedupeDeleteBatchSize {
+		end := start + dedupeDeleteBatchSize
+		if end > len(deleteFiles) {
+			end = len(deleteFiles)
+		}
+		fileId2FileEntity := map[string]*aliyunpan.FileEntity{}
+		delFileInfos := []*aliyunpan.FileBatchActionParam{}
+		for _, f := range deleteFiles[start:end] {
+			delFileInfos = append(delFileInfos, &aliyunpan.FileBatchActionParam{
+				DriveId: driveId,
+				FileId:  f.FileId,
+			})
+			fileId2FileEntity[f.FileId] = f
+		}
+		fdr, err := panClient.FileDelete(delFileInfos)
+		if err != nil && fdr == nil {
+			fmt.Println("删除文件失败: ", err)
+			continue
+		}
+		for _, item := range fdr {
+			f := fileId2FileEntity[item.FileId]
+			if f == nil {
+				continue
+			}
+			if !item.Success {
+				fmt.Println("删除文件失败: ", f.Path)
+				continue
+			}
+			successCount++
+			cacheCleanDirs = append(cacheCleanDirs, path.Dir(f.Path))
+		}
+	}
+	activeUser.DeleteCache(cacheCleanDirs)
+	fmt.Printf("已将 %d 个多余的重复文件移动到回收站, 可在云盘文件回收站找回\n", successCount)
+}
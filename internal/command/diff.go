@@ -0,0 +1,260 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/localfile"
+	"github.com/tickstep/aliyunpan/internal/syncdrive"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"github.com/urfave/cli"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type (
+	// DiffOptions 对比本地目录和云盘目录可选项
+	DiffOptions struct {
+		NoHash        bool // 不计算SHA1，只对比文件大小
+		ShowIdentical bool // 显示相同的文件
+		JsonOutput    bool // 以JSON格式输出
+	}
+)
+
+var (
+	diffStatusNames = map[syncdrive.DiffStatus]string{
+		syncdrive.DiffStatusAdded:     "本地新增",
+		syncdrive.DiffStatusRemoved:   "云盘独有",
+		syncdrive.DiffStatusChanged:   "不同",
+		syncdrive.DiffStatusIdentical: "相同",
+	}
+)
+
+func CmdDiff() cli.Command {
+	return cli.Command{
+		Name:      "diff",
+		Usage:     "对比本地目录和云盘目录",
+		UsageText: cmder.App().Name + " diff [-nohash] <本地目录> <云盘目录>",
+		Description: `
+	按照相对路径对比本地目录和云盘目录内的文件, 用于上传或者同步之前确认两边的差异.
+	默认对比文件大小和SHA1, 使用 -nohash 则只对比文件大小, 不需要读取本地文件内容.
+
+	对比结果:
+	本地新增: 只存在于本地目录
+	云盘独有: 只存在于云盘目录
+	不同: 两边都存在, 但是类型、大小或者内容不同
+	相同: 两边都存在, 并且内容相同
+
+	两边存在差异时退出码为1, 出错时退出码为2, 方便在脚本中使用.
+
+	示例:
+
+	对比本地目录 D:\tickstep\Documents 和云盘目录 /我的文档
+	aliyunpan diff "D:\tickstep\Documents" /我的文档
+
+	只对比文件大小
+	aliyunpan diff -nohash "D:\tickstep\Documents" /我的文档
+
+	以JSON格式输出所有文件的对比结果
+	aliyunpan diff -a -json "D:\tickstep\Documents" /我的文档
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			result, err := RunDiff(parseDriveId(c), c.Args().Get(0), c.Args().Get(1), &DiffOptions{
+				NoHash:        c.Bool("nohash"),
+				ShowIdentical: c.Bool("a"),
+				JsonOutput:    c.Bool("json"),
+			})
+			if err != nil {
+				fmt.Println(err)
+				return exitCodeError(2)
+			}
+			if result.HasDifference() {
+				return exitCodeError(1)
+			}
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.BoolFlag{
+				Name:  "nohash",
+				Usage: "不计算SHA1, 只对比文件大小",
+			},
+			cli.BoolFlag{
+				Name:  "a",
+				Usage: "显示相同的文件",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "以JSON格式输出",
+			},
+		},
+	}
+}
+
+// scanLocalFolder 递归获取本地目录内的所有文件，不包含目录本身
+func scanLocalFolder(localFolderPath string) (syncdrive.LocalFileList, error) {
+	files := syncdrive.LocalFileList{}
+	err := filepath.Walk(localFolderPath, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == localFolderPath {
+			return nil
+		}
+		ft := "file"
+		if info.IsDir() {
+			ft = "folder"
+		}
+		files = append(files, &syncdrive.LocalFileItem{
+			FileName:      info.Name(),
+			FileSize:      info.Size(),
+			FileType:      ft,
+			CreatedAt:     info.ModTime().Format("2006-01-02 15:04:05"),
+			UpdatedAt:     info.ModTime().Format("2006-01-02 15:04:05"),
+			FileExtension: path.Ext(info.Name()),
+			Path:          fullPath,
+			ScanTimeAt:    utils.NowTimeStr(),
+			ScanStatus:    syncdrive.ScanStatusNormal,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// scanPanFolder 递归获取云盘目录内的所有文件，不包含目录本身。
+// 获取任意一个目录的文件列表出错都会返回错误，避免不完整的文件列表被当成云盘缺少文件
+func scanPanFolder(panClient *aliyunpan.PanClient, driveId, panFolderPath string) (syncdrive.PanFileList, error) {
+	files := syncdrive.PanFileList{}
+	var scanErr error
+	panClient.FilesDirectoriesRecurseList(driveId, panFolderPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		if apiError != nil {
+			logger.Verbosef("%s\n", apiError)
+			scanErr = apiError
+			return false
+		}
+		if fd == nil || depth == 0 {
+			return true
+		}
+		files = append(files, syncdrive.NewPanFileItem(fd))
+		return true
+	})
+	return files, scanErr
+}
+
+// RunDiff 对比本地目录和云盘目录
+func RunDiff(driveId, localFolderPath, panFolderPath string, options *DiffOptions) (syncdrive.DiffItemList, error) {
+	activeUser := config.Config.ActiveUser()
+	panFolderPath = path.Clean(activeUser.PathJoin(driveId, panFolderPath))
+	localFolderPath, err := filepath.Abs(localFolderPath)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(localFolderPath); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("本地目录不存在: %s", localFolderPath)
+	}
+	panFolder, apierr := activeUser.PanClient().FileInfoByPath(driveId, panFolderPath)
+	if apierr != nil || !panFolder.IsFolder() {
+		return nil, fmt.Errorf("云盘目录不存在: %s", panFolderPath)
+	}
+
+	localFiles, err := scanLocalFolder(localFolderPath)
+	if err != nil {
+		return nil, fmt.Errorf("扫描本地目录出错: %s", err)
+	}
+	panFiles, err := scanPanFolder(activeUser.PanClient(), driveId, panFolderPath)
+	if err != nil {
+		return nil, fmt.Errorf("扫描云盘目录出错: %s", err)
+	}
+
+	// 云盘文件的修改时间是上传时间，和本地文件的修改时间没有可比性，不计算SHA1时只对比文件大小
+	var compareFunc syncdrive.DiffCompareFunc
+	if !options.NoHash {
+		compareFunc = func(localFile *syncdrive.LocalFileItem, panFile *syncdrive.PanFileItem) (bool, string) {
+			if !options.JsonOutput {
+				fmt.Printf("\r正在计算SHA1: %s", localFile.Path)
+			}
+			lfs, err := localfile.GetFileSum(localFile.Path, localfile.CHECKSUM_SHA1)
+			if !options.JsonOutput {
+				fmt.Printf("\r%s\r", strings.Repeat(" ", len("正在计算SHA1: ")+len(localFile.Path)))
+			}
+			if err != nil {
+				return false, "读取本地文件出错: " + err.Error()
+			}
+			localFile.Sha1Hash = lfs.SHA1
+			if !strings.EqualFold(lfs.SHA1, panFile.Sha1Hash) {
+				return false, "SHA1不同"
+			}
+			return true, ""
+		}
+	}
+	result := syncdrive.DiffFileList(localFolderPath, localFiles, panFolderPath, panFiles, compareFunc)
+
+	if options.JsonOutput {
+		items := syncdrive.DiffItemList{}
+		for _, item := range result {
+			if options.ShowIdentical || item.Status != syncdrive.DiffStatusIdentical {
+				items = append(items, item)
+			}
+		}
+		fmt.Println(utils.ObjectToJsonStr(items, true))
+		return result, nil
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "对比结果", "文件(目录)", "说明"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	idx := 0
+	for _, item := range result {
+		if !options.ShowIdentical && item.Status == syncdrive.DiffStatusIdentical {
+			continue
+		}
+		idx++
+		name := strings.TrimPrefix(item.RelativePath, "/")
+		if (item.LocalFile != nil && item.LocalFile.IsFolder()) || (item.LocalFile == nil && item.PanFile.IsFolder()) {
+			name += "/"
+		}
+		tb.Append([]string{strconv.Itoa(idx), diffStatusNames[item.Status], name, item.Reason})
+	}
+	if idx > 0 {
+		tb.Render()
+	}
+	count := result.Count()
+	fmt.Printf("本地新增: %d, 云盘独有: %d, 不同: %d, 相同: %d\n",
+		count[syncdrive.DiffStatusAdded], count[syncdrive.DiffStatusRemoved], count[syncdrive.DiffStatusChanged], count[syncdrive.DiffStatusIdentical])
+	return result, nil
+}
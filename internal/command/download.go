@@ -15,6 +15,7 @@ package command
 
 import (
 	"fmt"
+	"github.com/olekukonko/tablewriter"
 	"github.com/tickstep/aliyunpan/cmder"
 	"github.com/tickstep/aliyunpan/cmder/cmdtable"
 	"github.com/tickstep/aliyunpan/internal/config"
@@ -30,6 +31,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -91,11 +94,35 @@ func CmdDownload() cli.Command {
 
     下载 /我的资源/1.mp4 并保存下载的文件到本地的 d:/panfile
 	aliyunpan d --saveto d:/panfile /我的资源/1.mp4
+
+	下载进程中断后, 恢复所有未完成的下载任务
+	aliyunpan d --resume
+
+	恢复指定的下载任务
+	aliyunpan d --resume 3f2a9c1b
+
+	查看未完成的下载任务
+	aliyunpan d --jobs
+
+	丢弃未完成的下载任务, 已经下载的文件以及未下载完成的文件不会被删除
+	aliyunpan d --discard 3f2a9c1b
 `,
 		Category: "阿里云盘",
 		Before:   cmder.ReloadConfigFunc,
 		Action: func(c *cli.Context) error {
-			if c.NArg() == 0 {
+			if c.Bool("jobs") {
+				RunDownloadJobList()
+				return nil
+			}
+			if c.Bool("discard") {
+				if c.NArg() == 0 {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					return nil
+				}
+				RunDownloadJobRemove(c.Args())
+				return nil
+			}
+			if c.NArg() == 0 && !c.Bool("resume") {
 				cli.ShowCommandHelp(c, c.Command.Name)
 				return nil
 			}
@@ -123,10 +150,26 @@ func CmdDownload() cli.Command {
 				DriveId:             parseDriveId(c),
 			}
 
+			if c.Bool("resume") {
+				RunDownloadResume(c.Args(), do)
+				return nil
+			}
 			RunDownload(c.Args(), do)
 			return nil
 		},
 		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "resume",
+				Usage: "恢复中断的下载任务, 可以指定任务ID, 不指定则恢复当前账号所有未完成的下载任务",
+			},
+			cli.BoolFlag{
+				Name:  "jobs",
+				Usage: "列出未完成的下载任务, 下载任务全部完成后会被自动删除",
+			},
+			cli.BoolFlag{
+				Name:  "discard",
+				Usage: "丢弃指定ID的未完成下载任务, 已经下载的文件不会被删除",
+			},
 			cli.BoolFlag{
 				Name:  "ow",
 				Usage: "overwrite, 覆盖已存在的文件",
@@ -180,10 +223,8 @@ func downloadPrintFormat(load int) string {
 	return "\r[%s] ↓ %s/%s %s/s in %s, left %s ..."
 }
 
-// RunDownload 执行下载网盘内文件
-func RunDownload(paths []string, options *DownloadOptions) {
-	activeUser := GetActiveUser()
-	// pan token expired checker
+// startDownloadTokenChecker 定时检查并刷新下载使用的token
+func startDownloadTokenChecker(activeUser *config.PanUser) {
 	go func() {
 		for {
 			time.Sleep(time.Duration(1) * time.Minute)
@@ -192,7 +233,10 @@ func RunDownload(paths []string, options *DownloadOptions) {
 			}
 		}
 	}()
+}
 
+// fixDownloadOptions 修正下载可选参数
+func fixDownloadOptions(options *DownloadOptions) *DownloadOptions {
 	if options == nil {
 		options = &DownloadOptions{}
 	}
@@ -205,13 +249,116 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		// windows下不加执行权限
 		options.IsExecutedPermission = false
 	}
+	return options
+}
+
+// RunDownload 执行下载网盘内文件
+func RunDownload(paths []string, options *DownloadOptions) {
+	activeUser := GetActiveUser()
+	// pan token expired checker
+	startDownloadTokenChecker(activeUser)
+	options = fixDownloadOptions(options)
+
+	paths, err := matchPathByShellPattern(options.DriveId, paths...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// 记录下载任务, 进程中断后可以恢复下载
+	job := pandownload.NewDownloadJob(activeUser.UserId, options.DriveId, paths)
+	job.IsExecutedPermission = options.IsExecutedPermission
+	job.IsOverwrite = options.IsOverwrite
+	job.NoCheck = options.NoCheck
+	job.Parallel = options.Parallel
+	job.MaxRetry = options.MaxRetry
+	for k := range paths {
+		item := &pandownload.DownloadJobItem{
+			FilePanPath: paths[k],
+		}
+		// 设置储存的路径
+		if options.SaveTo != "" {
+			item.OriginSaveRootPath = options.SaveTo
+			item.SavePath = filepath.Join(options.SaveTo, filepath.Base(paths[k]))
+		} else {
+			// 使用默认的保存路径
+			item.OriginSaveRootPath = activeUser.GetSavePath("")
+			item.SavePath = activeUser.GetSavePath(paths[k])
+		}
+		job.Items = append(job.Items, item)
+	}
+	jobDb, err := pandownload.NewDownloadingDatabase()
+	if err == nil {
+		err = jobDb.AddJob(job)
+	}
+	if err != nil {
+		fmt.Printf("保存下载任务失败, 下载中断后将无法恢复: %s\n", err)
+		jobDb = nil
+	}
+
+	runDownloadJob(job, options, jobDb)
+}
+
+// RunDownloadResume 恢复中断的下载任务, 没有指定任务ID则恢复当前账号所有未完成的下载任务
+func RunDownloadResume(jobIds []string, options *DownloadOptions) {
+	activeUser := GetActiveUser()
+	jobDb, err := pandownload.NewDownloadingDatabase()
+	if err != nil {
+		fmt.Printf("读取下载任务失败: %s\n", err)
+		return
+	}
+
+	jobs := []*pandownload.DownloadJob{}
+	if len(jobIds) == 0 {
+		if jobs, err = jobDb.JobList(activeUser.UserId); err != nil {
+			fmt.Printf("读取下载任务失败: %s\n", err)
+			return
+		}
+	}
+	for _, jobId := range jobIds {
+		job, er := jobDb.GetJob(jobId)
+		if er != nil {
+			fmt.Printf("%s: %s\n", er, jobId)
+			continue
+		}
+		if job.UserId != activeUser.UserId {
+			fmt.Printf("下载任务不属于当前登录的账号: %s\n", jobId)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("没有需要恢复的下载任务")
+		return
+	}
+
+	// pan token expired checker
+	startDownloadTokenChecker(activeUser)
+	for _, job := range jobs {
+		// 使用下载任务保存的下载选项
+		jobOptions := *options
+		jobOptions.DriveId = job.DriveId
+		jobOptions.IsExecutedPermission = job.IsExecutedPermission
+		jobOptions.IsOverwrite = job.IsOverwrite
+		jobOptions.NoCheck = job.NoCheck
+		jobOptions.MaxRetry = job.MaxRetry
+		if jobOptions.Parallel < 1 {
+			jobOptions.Parallel = job.Parallel
+		}
+		fmt.Printf("\n恢复下载任务 %s, 未完成的文件(目录)数量: %d\n", job.Id, len(job.Items))
+		runDownloadJob(job, fixDownloadOptions(&jobOptions), jobDb)
+	}
+}
 
+// newDownloadConfig 根据下载可选参数创建下载配置, 并修正下载最大并发量
+func newDownloadConfig(options *DownloadOptions) *downloader.Config {
 	// 设置下载配置
 	cfg := &downloader.Config{
 		Mode:                       transfer.RangeGenMode_BlockSize,
 		CacheSize:                  config.Config.CacheSize,
 		BlockSize:                  MaxDownloadRangeSize,
 		MaxRate:                    config.Config.MaxDownloadRate,
+		MaxRateFunc:                config.Config.MaxDownloadRateNow,
 		InstanceStateStorageFormat: downloader.InstanceStateStorageFormatJSON,
 		ShowProgress: options.ShowProgress,
 		UseInternalUrl: config.Config.TransferUrlType == 2,
@@ -231,18 +378,17 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		options.Parallel = config.MaxFileDownloadParallelNum
 	}
 
-	paths, err := matchPathByShellPattern(options.DriveId, paths...)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
 	fmt.Printf("\n[0] 当前文件下载最大并发量为: %d, 下载缓存为: %s\n\n", options.Parallel, converter.ConvertFileSize(int64(cfg.CacheSize), 2))
+	cfg.MaxParallel = options.Parallel
+	return cfg
+}
 
+// runDownloadJob 执行下载任务中未完成的文件, jobDb 为nil则不记录下载进度
+func runDownloadJob(job *pandownload.DownloadJob, options *DownloadOptions, jobDb *pandownload.DownloadingDatabase) {
+	cfg := newDownloadConfig(options)
 	var (
-		panClient = activeUser.PanClient()
+		panClient = GetActiveUser().PanClient()
 	)
-	cfg.MaxParallel = options.Parallel
 
 	var (
 		executor = taskframework.TaskExecutor{
@@ -257,7 +403,7 @@ func RunDownload(paths []string, options *DownloadOptions) {
 	globalSpeedsStat := &speeds.Speeds{}
 
 	// 处理队列
-	for k := range paths {
+	for _, item := range job.Items {
 		newCfg := *cfg
 		unit := pandownload.DownloadTaskUnit{
 			Cfg:                  &newCfg, // 复制一份新的cfg
@@ -270,22 +416,16 @@ func RunDownload(paths []string, options *DownloadOptions) {
 			IsExecutedPermission: options.IsExecutedPermission,
 			IsOverwrite:          options.IsOverwrite,
 			NoCheck:              options.NoCheck,
-			FilePanPath:          paths[k],
+			FilePanPath:          item.FilePanPath,
+			SavePath:             item.SavePath,
+			OriginSaveRootPath:   item.OriginSaveRootPath,
 			DriveId:              options.DriveId,
 			GlobalSpeedsStat:     globalSpeedsStat,
-		}
-
-		// 设置储存的路径
-		if options.SaveTo != "" {
-			unit.OriginSaveRootPath = options.SaveTo
-			unit.SavePath = filepath.Join(options.SaveTo, filepath.Base(paths[k]))
-		} else {
-			// 使用默认的保存路径
-			unit.OriginSaveRootPath = GetActiveUser().GetSavePath("")
-			unit.SavePath = GetActiveUser().GetSavePath(paths[k])
+			JobDatabase:          jobDb,
+			JobId:                job.Id,
 		}
 		info := executor.Append(&unit, options.MaxRetry)
-		fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), paths[k])
+		fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), item.FilePanPath)
 	}
 
 	// 开始计时
@@ -307,4 +447,75 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		}
 		tb.Render()
 	}
+
+	// 全部完成则删除下载任务, 否则提示恢复下载
+	if jobDb == nil {
+		return
+	}
+	if err := jobDb.Flush(); err != nil {
+		logger.Verboseln("save download job error: ", err)
+	}
+	if savedJob, err := jobDb.GetJob(job.Id); err == nil {
+		if len(savedJob.Items) == 0 {
+			jobDb.DeleteJob(job.Id)
+		} else {
+			fmt.Printf("下载任务 %s 还有 %d 个文件(目录)未完成, 可以使用以下命令继续下载:\n%s download --resume %s\n",
+				job.Id, len(savedJob.Items), cmder.App().Name, job.Id)
+		}
+	}
+}
+
+// RunDownloadJobList 列出未完成的下载任务
+func RunDownloadJobList() {
+	jobDb, err := pandownload.NewDownloadingDatabase()
+	if err != nil {
+		fmt.Printf("读取下载任务失败: %s\n", err)
+		return
+	}
+	jobs, err := jobDb.JobList(GetActiveUser().UserId)
+	if err != nil {
+		fmt.Printf("读取下载任务失败: %s\n", err)
+		return
+	}
+	if len(jobs) == 0 {
+		fmt.Println("没有未完成的下载任务")
+		return
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "任务ID", "下载路径", "未完成数量", "保存目录", "创建时间", "更新时间"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for k, job := range jobs {
+		saveDir := ""
+		if len(job.Items) > 0 {
+			saveDir = job.Items[0].OriginSaveRootPath
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), job.Id, strings.Join(job.Paths, ", "), strconv.Itoa(len(job.Items)), saveDir, job.CreateTime, job.UpdateTime})
+	}
+	tb.Render()
+}
+
+// RunDownloadJobRemove 丢弃未完成的下载任务, 已经下载的文件不会被删除
+func RunDownloadJobRemove(jobIds []string) {
+	jobDb, err := pandownload.NewDownloadingDatabase()
+	if err != nil {
+		fmt.Printf("读取下载任务失败: %s\n", err)
+		return
+	}
+	activeUser := GetActiveUser()
+	for _, jobId := range jobIds {
+		job, er := jobDb.GetJob(jobId)
+		if er != nil {
+			fmt.Printf("%s: %s\n", er, jobId)
+			continue
+		}
+		if job.UserId != activeUser.UserId {
+			fmt.Printf("下载任务不属于当前登录的账号: %s\n", jobId)
+			continue
+		}
+		if _, er = jobDb.DeleteJob(job.Id); er != nil {
+			fmt.Printf("删除下载任务失败: %s, %s\n", jobId, er)
+			continue
+		}
+		fmt.Printf("已删除下载任务: %s\n", job.Id)
+	}
 }
@@ -0,0 +1,235 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/logger"
+	"github.com/urfave/cli"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// DuOptions 统计目录占用空间可选项
+	DuOptions struct {
+		SortBy string // 排序方式，size, count, name
+		Top    int    // 只显示前N个，0代表不限制
+	}
+
+	// duEntry 目录下一级文件或者目录的统计
+	duEntry struct {
+		Name        string
+		IsFolder    bool
+		FileCount   int64
+		FolderCount int64
+		Size        int64
+	}
+
+	// duCounter 按照下一级文件或者目录汇总统计
+	duCounter struct {
+		entries map[string]*duEntry
+		total   *duEntry
+	}
+)
+
+func CmdDu() cli.Command {
+	return cli.Command{
+		Name:      "du",
+		Usage:     "统计目录占用空间",
+		UsageText: cmder.App().Name + " du [-sort size|count|name] [-top N] <目录>",
+		Description: `
+	递归统计指定目录(默认为当前工作目录)内每个子目录的文件数量和占用空间
+
+	示例:
+
+	统计 /我的资源 内每个子目录的占用空间, 按大小降序排序
+	aliyunpan du /我的资源
+
+	只显示占用空间最大的 10 个
+	aliyunpan du -top 10 /
+
+	按文件数量降序排序
+	aliyunpan du -sort count /我的资源
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			sortBy := strings.ToLower(c.String("sort"))
+			if sortBy != "size" && sortBy != "count" && sortBy != "name" {
+				fmt.Println("不支持的排序方式: ", c.String("sort"))
+				return nil
+			}
+			RunDu(parseDriveId(c), c.Args().Get(0), &DuOptions{
+				SortBy: sortBy,
+				Top:    c.Int("top"),
+			})
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "sort",
+				Usage: "排序方式, 支持: size(大小降序), count(文件数量降序), name(名称升序)",
+				Value: "size",
+			},
+			cli.IntFlag{
+				Name:  "top",
+				Usage: "只显示前N个, 0代表不限制",
+				Value: 0,
+			},
+		},
+	}
+}
+
+func newDuCounter() *duCounter {
+	return &duCounter{
+		entries: map[string]*duEntry{},
+		total:   &duEntry{},
+	}
+}
+
+// Add 添加文件，relativePath 为相对统计目录的路径
+func (dc *duCounter) Add(relativePath string, isFolder bool, size int64) {
+	relativePath = strings.Trim(relativePath, "/")
+	if relativePath == "" {
+		return
+	}
+	name := relativePath
+	if idx := strings.Index(relativePath, "/"); idx >= 0 {
+		name = relativePath[:idx]
+	}
+	entry, ok := dc.entries[name]
+	if !ok {
+		entry = &duEntry{
+			Name:     name,
+			IsFolder: isFolder || name != relativePath,
+		}
+		dc.entries[name] = entry
+	}
+
+	if isFolder {
+		// 下一级目录本身不计入该目录的目录数量
+		if name != relativePath {
+			entry.FolderCount++
+		}
+		dc.total.FolderCount++
+		return
+	}
+	entry.FileCount++
+	entry.Size += size
+	dc.total.FileCount++
+	dc.total.Size += size
+}
+
+// List 获取排序后的统计结果
+func (dc *duCounter) List(sortBy string, top int) []*duEntry {
+	list := make([]*duEntry, 0, len(dc.entries))
+	for _, entry := range dc.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		switch sortBy {
+		case "count":
+			if list[i].FileCount != list[j].FileCount {
+				return list[i].FileCount > list[j].FileCount
+			}
+		case "size":
+			if list[i].Size != list[j].Size {
+				return list[i].Size > list[j].Size
+			}
+		}
+		return list[i].Name < list[j].Name
+	})
+	if top > 0 && len(list) > top {
+		list = list[:top]
+	}
+	return list
+}
+
+// RunDu 执行统计目录占用空间
+func RunDu(driveId, targetPath string, options *DuOptions) {
+	activeUser := config.Config.ActiveUser()
+	targetPath = path.Clean(activeUser.PathJoin(driveId, targetPath))
+	rootPrefix := strings.TrimSuffix(targetPath, "/") + "/"
+
+	counter := newDuCounter()
+	scanned := 0
+	// 读取失败的目录，出现后统计结果是不完整的
+	failedPaths := []string{}
+	activeUser.PanClient().FilesDirectoriesRecurseList(driveId, targetPath, func(depth int, fdPath string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		if apiError != nil {
+			logger.Verbosef("%s\n", apiError)
+			failedPaths = append(failedPaths, fmt.Sprintf("%s: %s", fdPath, apiError))
+			return false
+		}
+		if fd == nil || !strings.HasPrefix(fd.Path, rootPrefix) {
+			// 统计目录本身
+			return true
+		}
+		counter.Add(strings.TrimPrefix(fd.Path, rootPrefix), fd.IsFolder(), fd.FileSize)
+		scanned++
+		if scanned%100 == 0 {
+			fmt.Printf("\r已扫描: %d", scanned)
+		}
+		return true
+	})
+	fmt.Printf("\r")
+	if scanned == 0 && len(failedPaths) > 0 {
+		fmt.Printf("统计失败, %s\n", failedPaths[0])
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "占用空间", "文件数量", "目录数量", "文件(目录)"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
+	for k, entry := range counter.List(options.SortBy, options.Top) {
+		name := entry.Name
+		if entry.IsFolder {
+			name += aliyunpan.PathSeparator
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), converter.ConvertFileSize(entry.Size, 2), strconv.FormatInt(entry.FileCount, 10), strconv.FormatInt(entry.FolderCount, 10), name})
+	}
+	totalLabel := "总: "
+	if len(failedPaths) > 0 {
+		totalLabel = "总(不完整): "
+	}
+	tb.Append([]string{"", totalLabel + converter.ConvertFileSize(counter.total.Size, 2), strconv.FormatInt(counter.total.FileCount, 10), strconv.FormatInt(counter.total.FolderCount, 10), targetPath})
+	tb.Render()
+
+	if len(failedPaths) > 0 {
+		fmt.Println("读取目录失败, 统计已中断, 以上结果不完整:")
+		for _, failedPath := range failedPaths {
+			fmt.Printf("  %s\n", failedPath)
+		}
+	}
+}
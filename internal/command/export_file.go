@@ -15,11 +15,7 @@ package command
 
 import (
 	"fmt"
-	"github.com/tickstep/aliyunpan-api/aliyunpan"
-	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
 	"github.com/tickstep/aliyunpan/cmder"
-	"github.com/tickstep/aliyunpan/internal/config"
-	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"log"
 	"os"
@@ -36,7 +32,13 @@ func CmdExport() cli.Command {
 		UsageText: cmder.App().Name + " export <网盘文件/目录的路径1> <文件/目录2> <文件/目录3> ... <本地保存文件路径>",
 		Description: `
 	导出指定文件/目录下面的所有文件的元数据信息，并保存到指定的本地文件里面。导出的文件元信息可以使用 import 命令（秒传文件功能）导入到网盘中。
-	支持多个文件或目录的导出.
+	支持多个文件或目录的导出，导出的文件会保留从指定文件/目录开始的文件夹结构.
+
+	导出文件为 JSON Lines 格式，第一行是文件头，之后每一行是一个文件，样例如下：
+	{"format":"aliyunpan-rapid-upload","version":1,"createdAt":"2022-05-01 10:00:00"}
+	{"name":"1.mp4","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":7005440,"path":"我的资源","proof":{"userId":"...","driveId":"...","fileId":"..."}}
+
+	proof 是秒传需要的证明数据，导入时需要使用导出文件的账号读取源文件，所以该账号需要在本机登录过，并且源文件没有被删除.
 
 	示例:
 
@@ -46,6 +48,9 @@ func CmdExport() cli.Command {
 	导出 /我的资源 整个目录 元数据到文件 /Users/tickstep/Downloads/export_files.txt
 	aliyunpan export /我的资源 /Users/tickstep/Downloads/export_files.txt
 
+	导出 /我的资源 整个目录 元数据到文件 /Users/tickstep/Downloads/export_files.txt，不导出相对目录
+	aliyunpan export -hide-path /我的资源 /Users/tickstep/Downloads/export_files.txt
+
     导出 网盘 整个目录 元数据到文件 /Users/tickstep/Downloads/export_files.txt
 	aliyunpan export / /Users/tickstep/Downloads/export_files.txt
 `,
@@ -58,7 +63,7 @@ func CmdExport() cli.Command {
 			}
 
 			subArgs := c.Args()
-			RunExportFiles(parseDriveId(c), c.Bool("ow"), subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1])
+			RunExportFiles(parseDriveId(c), c.Bool("ow"), c.Bool("hide-path"), subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1])
 			return nil
 		},
 		Flags: []cli.Flag{
@@ -66,6 +71,10 @@ func CmdExport() cli.Command {
 				Name:  "ow",
 				Usage: "overwrite, 覆盖已存在的导出文件",
 			},
+			cli.BoolFlag{
+				Name:  "hide-path, hp",
+				Usage: "hide path, 不导出文件的相对目录，导入时所有文件都保存到同一个目录",
+			},
 			cli.StringFlag{
 				Name:  "driveId",
 				Usage: "网盘ID",
@@ -76,15 +85,13 @@ func CmdExport() cli.Command {
 }
 
 
-func RunExportFiles(driveId string, overwrite bool, panPaths []string, saveLocalFilePath string) {
-	activeUser := config.Config.ActiveUser()
-	panClient := activeUser.PanClient()
-
+// RunExportFiles 导出文件的秒传元数据
+func RunExportFiles(driveId string, overwrite, hidePath bool, panPaths []string, saveLocalFilePath string) {
 	lfi,_ := os.Stat(saveLocalFilePath)
 	realSaveFilePath := saveLocalFilePath
 	if lfi != nil {
 		if lfi.IsDir() {
-			realSaveFilePath = path.Join(saveLocalFilePath, "export_file_") + strconv.FormatInt(time.Now().Unix(), 10) + ".txt"
+			realSaveFilePath = path.Join(saveLocalFilePath, "export_file_") + strconv.FormatInt(time.Now().Unix(), 10) + ".jsonl"
 		} else {
 			if !overwrite {
 				fmt.Println("导出文件已存在")
@@ -110,37 +117,17 @@ func RunExportFiles(driveId string, overwrite bool, panPaths []string, saveLocal
 		realSaveFilePath = saveLocalFilePath
 	}
 
-	totalCount := 0
 	saveFile, err := os.OpenFile(realSaveFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	for _,panPath := range panPaths {
-		panPath = activeUser.PathJoin(driveId, panPath)
-		panClient.FilesDirectoriesRecurseList(driveId, panPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
-			if apiError != nil {
-				logger.Verbosef("%s\n", apiError)
-				return true
-			}
-
-			// 只需要存储文件即可
-			if !fd.IsFolder() {
-				item := newRapidUploadItemFromFileEntity(fd)
-				jstr := item.createRapidUploadLink(false)
-				if len(jstr) <= 0 {
-					logger.Verboseln("create rapid upload link err")
-					return false
-				}
-				saveFile.WriteString(jstr + "\n")
-				totalCount += 1
-				time.Sleep(time.Duration(100) * time.Millisecond)
-				fmt.Printf("\r导出文件数量: %d", totalCount)
-			}
-			return true
-		})
-	}
+	exportedCount := 0
+	totalCount := writeRapidUploadEntries(saveFile, driveId, panPaths, hidePath, func(entry *RapidUploadEntry) {
+		exportedCount += 1
+		fmt.Printf("\r导出文件数量: %d", exportedCount)
+	})
 
 	// close and save
 	if err := saveFile.Close(); err != nil {
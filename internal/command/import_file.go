@@ -18,7 +18,9 @@ import (
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
 	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
 	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/utils"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"io/ioutil"
@@ -26,6 +28,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,6 +38,20 @@ type (
 		Dir      *aliyunpan.MkdirResult
 		FileList aliyunpan.FileList
 	}
+
+	// importResultItem 单个文件的导入结果
+	importResultItem struct {
+		Path    string `json:"path"`
+		Sha1    string `json:"sha1"`
+		Size    int64  `json:"size"`
+		Success bool   `json:"success"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// importProgress 导入进度文件，每导入一个文件追加一行，中断或者失败后可以继续导入
+	importProgress struct {
+		*jsonLinesProgress
+	}
 )
 
 const (
@@ -45,13 +62,18 @@ func CmdImport() cli.Command {
 	return cli.Command{
 		Name:      "import",
 		Usage:     "导入文件",
-		UsageText: cmder.App().Name + " export <本地元数据文件路径>",
+		UsageText: cmder.App().Name + " import <本地元数据文件路径>",
 		Description: `
     导入文件中记录的元数据文件到网盘。保存到网盘的文件会使用文件元数据记录的路径位置，如果没有指定云盘目录(saveto)则默认导入到目录 aliyunpan 中。
     导入的文件可以使用 export 命令获得。
     
-    导入文件每一行是一个文件元数据，样例如下：
-    aliyunpan://file.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|pan_folder
+    导入文件为 JSON Lines 格式，第一行是文件头，之后每一行是一个文件元数据，样例如下：
+    {"format":"aliyunpan-rapid-upload","version":1,"createdAt":"2022-05-01 10:00:00"}
+    {"name":"file.dmg","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":7005440,"path":"pan_folder","proof":{"userId":"...","driveId":"...","fileId":"..."}}
+    同时兼容旧的格式：aliyunpan://file.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|pan_folder
+
+    导入时会使用 proof 记录的账号读取源文件计算秒传证明，所以导出文件的账号需要在本机登录过，并且源文件没有被删除。
+    每个文件的导入结果都会记录下来，如果有文件导入失败，重新执行相同的导入命令会跳过已经导入成功的文件。
 
 	示例:
     导入文件 /Users/tickstep/Downloads/export_files.txt 存储的所有文件元数据项
@@ -62,6 +84,9 @@ func CmdImport() cli.Command {
 
     导入文件 /Users/tickstep/Downloads/export_files.txt 存储的所有文件元数据项并保存到网盘根目录 / 中
     aliyunpan import -saveto=/ /Users/tickstep/Downloads/export_files.txt
+
+    忽略上次的导入进度，重新导入文件 /Users/tickstep/Downloads/export_files.txt 存储的所有文件元数据项
+    aliyunpan import -restart /Users/tickstep/Downloads/export_files.txt
 `,
 		Category: "阿里云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -77,7 +102,7 @@ func CmdImport() cli.Command {
 			}
 
 			subArgs := c.Args()
-			RunImportFiles(parseDriveId(c), c.Bool("ow"), saveTo, subArgs[0])
+			RunImportFiles(parseDriveId(c), c.Bool("ow"), c.Bool("restart"), saveTo, subArgs[0])
 			return nil
 		},
 		Flags: []cli.Flag{
@@ -94,11 +119,17 @@ func CmdImport() cli.Command {
 				Name:  "saveto",
 				Usage: "将文件保存到指定的目录",
 			},
+			cli.BoolFlag{
+				Name:  "restart",
+				Usage: "忽略上次的导入进度，重新导入全部文件",
+			},
 		},
 	}
 }
 
-func RunImportFiles(driveId string, overwrite bool, panSavePath, localFilePath string) {
+// RunImportFiles 导入秒传文件，每个文件的导入结果会记录到进度文件，重新执行时跳过已经导入成功的文件
+func RunImportFiles(driveId string, overwrite, restart bool, panSavePath, localFilePath string) {
+	activeUser := GetActiveUser()
 	lfi, _ := os.Stat(localFilePath)
 	if lfi != nil {
 		if lfi.IsDir() {
@@ -135,58 +166,96 @@ func RunImportFiles(driveId string, overwrite bool, panSavePath, localFilePath s
 		fmt.Println("文件为空")
 		return
 	}
-	fileText = strings.TrimSpace(fileText)
-	fileLines := strings.Split(fileText, "\n")
-	importFileItems := []RapidUploadItem{}
-	for _, line := range fileLines {
-		line = strings.TrimSpace(line)
-		if item, e := newRapidUploadItem(line); e != nil {
-			fmt.Println(e)
-			continue
-		} else {
-			item.FilePath = strings.ReplaceAll(path.Join(panSavePath, item.FilePath), "\\", "/")
-			importFileItems = append(importFileItems, *item)
-		}
+	importFileItems, lineErrs, err := parseRapidUploadLines(strings.Split(fileText, "\n"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, e := range lineErrs {
+		fmt.Println(e)
+	}
+	for _, item := range importFileItems {
+		item.FilePath = strings.ReplaceAll(path.Join(panSavePath, item.FilePath), "\\", "/")
 	}
 	if len(importFileItems) == 0 {
 		fmt.Println("没有可以导入的文件项目")
 		return
 	}
 
+	// 导入进度
+	absLocalFilePath, _ := filepath.Abs(localFilePath)
+	progressFilePath := filepath.Join(config.GetConfigDir(), "import", utils.Md5Str(activeUser.UserId+"|"+driveId+"|"+absLocalFilePath+"|"+panSavePath)+".jsonl")
+	progress, err := openImportProgress(progressFilePath, restart)
+	if err != nil {
+		fmt.Printf("打开导入进度文件出错: %s\n", err)
+		return
+	}
+	pendingItems := []*RapidUploadItem{}
+	for _, item := range importFileItems {
+		if progress.Get(item) == nil {
+			pendingItems = append(pendingItems, item)
+		}
+	}
+	skippedCount := len(importFileItems) - len(pendingItems)
+	if skippedCount > 0 {
+		fmt.Printf("跳过上次已经导入成功的文件: %d\n", skippedCount)
+	}
+
 	fmt.Println("正在准备导入...")
-	dirMap := prepareMkdir(driveId, importFileItems)
+	dirMap := prepareMkdir(driveId, pendingItems)
 
 	fmt.Println("正在导入...")
-	successImportFiles := []RapidUploadItem{}
-	failedImportFiles := []RapidUploadItem{}
-	for _, item := range importFileItems {
-		fmt.Printf("正在处理导入: %s\n", item.FilePath)
-		result, abort := processOneImport(driveId, overwrite, dirMap, item)
-		if abort {
-			fmt.Println("导入任务终止了")
-			break
+	successCount := 0
+	failedImportFiles := []*importResultItem{}
+	for k, item := range pendingItems {
+		result := &importResultItem{
+			Path: item.FilePath,
+			Sha1: item.FileSha1,
+			Size: item.FileSize,
 		}
-		if result {
-			successImportFiles = append(successImportFiles, item)
+		if err := processOneImport(driveId, overwrite, dirMap, item); err != nil {
+			result.Error = err.Error()
+			failedImportFiles = append(failedImportFiles, result)
+			fmt.Printf("[%d/%d] 导入失败: %s, %s\n", k+1, len(pendingItems), item.FilePath, err)
 		} else {
-			failedImportFiles = append(failedImportFiles, item)
+			result.Success = true
+			successCount += 1
+			fmt.Printf("[%d/%d] 导入成功: %s\n", k+1, len(pendingItems), item.FilePath)
+		}
+		if err := progress.Save(result); err != nil {
+			logger.Verboseln("save import progress error: ", err)
 		}
 		time.Sleep(time.Duration(200) * time.Millisecond)
 	}
 	if len(failedImportFiles) > 0 {
 		fmt.Println("\n以下文件导入失败")
-		for _, f := range failedImportFiles {
-			fmt.Printf("%s %s\n", f.FileSha1, f.FilePath)
+		tb := cmdtable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"#", "SHA1", "文件", "失败原因"})
+		for k, f := range failedImportFiles {
+			tb.Append([]string{strconv.Itoa(k + 1), f.Sha1, f.Path, f.Error})
 		}
+		tb.Render()
 		fmt.Println("")
 	}
-	fmt.Printf("导入结果, 成功 %d, 失败 %d\n", len(successImportFiles), len(failedImportFiles))
+	fmt.Printf("导入结果, 成功 %d, 失败 %d, 跳过 %d\n", successCount, len(failedImportFiles), skippedCount)
+
+	if len(failedImportFiles) > 0 {
+		progress.Close(false)
+		fmt.Println("重新执行相同的导入命令可以只导入失败的文件，使用 -restart 重新导入全部文件")
+	} else {
+		progress.Close(true)
+	}
+	activeUser.DeleteCache(GetAllPathFolderByPath(panSavePath))
 }
 
-func processOneImport(driveId string, isOverwrite bool, dirMap map[string]*dirFileListData, item RapidUploadItem) (result, abort bool) {
+// processOneImport 导入单个文件
+func processOneImport(driveId string, isOverwrite bool, dirMap map[string]*dirFileListData, item *RapidUploadItem) error {
 	panClient := config.Config.ActiveUser().PanClient()
 	panDir, fileName := path.Split(item.FilePath)
 	dataItem := dirMap[path.Dir(panDir)]
+	if dataItem == nil {
+		return fmt.Errorf("创建云盘文件夹失败")
+	}
 	if isOverwrite {
 		// 标记覆盖旧同名文件
 		// 检查同名文件是否存在
@@ -206,37 +275,22 @@ func processOneImport(driveId string, isOverwrite bool, dirMap map[string]*dirFi
 				},
 			})
 			if err != nil || fdr == nil || !fdr[0].Success {
-				fmt.Println("无法删除文件，请稍后重试")
-				return false, false
+				return fmt.Errorf("无法删除同名文件，请稍后重试")
 			}
 			time.Sleep(time.Duration(500) * time.Millisecond)
-			fmt.Println("检测到同名文件，已移动到回收站")
+			logger.Verboseln("检测到同名文件，已移动到回收站: ", item.FilePath)
 		}
 	}
 
-	appCreateUploadFileParam := &aliyunpan.CreateFileUploadParam{
-		DriveId:      driveId,
-		Name:         fileName,
-		Size:         item.FileSize,
-		ContentHash:  item.FileSha1,
-		ParentFileId: dataItem.Dir.FileId,
-	}
-	uploadOpEntity, apierr := panClient.CreateUploadFile(appCreateUploadFileParam)
-	if apierr != nil {
-		fmt.Println("创建秒传任务失败：" + apierr.Error())
-		return false, true
-	}
-
-	if uploadOpEntity.RapidUpload {
-		logger.Verboseln("秒传成功, 保存到网盘路径: ", path.Join(panDir, uploadOpEntity.FileName))
-	} else {
-		fmt.Println("失败，文件未曾上传，无法秒传")
-		return false, false
+	uploadOpEntity, err := createRapidUploadFile(panClient, driveId, dataItem.Dir.FileId, fileName, item)
+	if err != nil {
+		return err
 	}
-	return true, false
+	logger.Verboseln("秒传成功, 保存到网盘路径: ", path.Join(panDir, uploadOpEntity.FileName))
+	return nil
 }
 
-func prepareMkdir(driveId string, importFileItems []RapidUploadItem) map[string]*dirFileListData {
+func prepareMkdir(driveId string, importFileItems []*RapidUploadItem) map[string]*dirFileListData {
 	panClient := config.Config.ActiveUser().PanClient()
 	resultMap := map[string]*dirFileListData{}
 	for _, item := range importFileItems {
@@ -275,3 +329,27 @@ func prepareMkdir(driveId string, importFileItems []RapidUploadItem) map[string]
 	}
 	return resultMap
 }
+
+// openImportProgress 打开导入进度文件，restart 为 true 时清空之前的进度
+func openImportProgress(progressFilePath string, restart bool) (*importProgress, error) {
+	p, err := openJsonLinesProgress(progressFilePath, restart, func() progressItem {
+		return &importResultItem{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &importProgress{p}, nil
+}
+
+func (item *importResultItem) progressKey() string {
+	return item.Path
+}
+
+// Get 获取已经导入成功的文件结果，没有导入或者导入失败返回nil
+func (ip *importProgress) Get(item *RapidUploadItem) *importResultItem {
+	r, ok := ip.get(item.FilePath).(*importResultItem)
+	if !ok || !r.Success || r.Sha1 != item.FileSha1 || r.Size != item.FileSize {
+		return nil
+	}
+	return r
+}
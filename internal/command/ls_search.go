@@ -167,6 +167,14 @@ func RunLs(driveId, targetPath string, lsOptions *LsOptions,
 	renderTable(opLs, lsOptions.Total, targetPath, fileList)
 }
 
+// folderShowName 文件夹显示的名称，搜索结果显示完整路径
+func folderShowName(op int, file *aliyunpan.FileEntity) string {
+	if op == opSearch {
+		return file.Path + aliyunpan.PathSeparator
+	}
+	return file.FileName + aliyunpan.PathSeparator
+}
+
 func renderTable(op int, isTotal bool, path string, files aliyunpan.FileList) {
 	tb := cmdtable.NewTable(os.Stdout)
 	var (
@@ -186,7 +194,7 @@ func renderTable(op int, isTotal bool, path string, files aliyunpan.FileList) {
 		tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
 		for k, file := range files {
 			if file.IsFolder() {
-				tb.Append([]string{strconv.Itoa(k), file.FileId, "-", "-", "-", file.CreatedAt, file.UpdatedAt, file.FileName + aliyunpan.PathSeparator})
+				tb.Append([]string{strconv.Itoa(k), file.FileId, "-", "-", "-", file.CreatedAt, file.UpdatedAt, folderShowName(op, file)})
 				continue
 			}
 
@@ -204,7 +212,7 @@ func renderTable(op int, isTotal bool, path string, files aliyunpan.FileList) {
 		tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
 		for k, file := range files {
 			if file.IsFolder() {
-				tb.Append([]string{strconv.Itoa(k), "-", file.UpdatedAt, file.FileName + aliyunpan.PathSeparator})
+				tb.Append([]string{strconv.Itoa(k), "-", file.UpdatedAt, folderShowName(op, file)})
 				continue
 			}
 
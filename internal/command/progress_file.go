@@ -0,0 +1,104 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bufio"
+	jsoniter "github.com/json-iterator/go"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type (
+	// progressItem 进度文件的一行记录
+	progressItem interface {
+		// progressKey 记录的唯一标识，相同标识的记录以最后写入的为准
+		progressKey() string
+	}
+
+	// jsonLinesProgress JSON Lines 格式的进度文件，每完成一项追加一行，中断或者失败后可以继续执行
+	jsonLinesProgress struct {
+		filePath string
+		file     *os.File
+		items    map[string]progressItem
+		mutex    sync.Mutex
+	}
+)
+
+// openJsonLinesProgress 打开进度文件，newItem 用于创建解析每一行的空记录，restart 为 true 时清空之前的进度
+func openJsonLinesProgress(progressFilePath string, restart bool, newItem func() progressItem) (*jsonLinesProgress, error) {
+	p := &jsonLinesProgress{
+		filePath: progressFilePath,
+		items:    map[string]progressItem{},
+	}
+	if err := os.MkdirAll(filepath.Dir(progressFilePath), 0755); err != nil {
+		return nil, err
+	}
+	if !restart {
+		if f, err := os.Open(progressFilePath); err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				item := newItem()
+				if jsoniter.Unmarshal(scanner.Bytes(), item) != nil || item.progressKey() == "" {
+					// 中断时写入了不完整的行
+					continue
+				}
+				p.items[item.progressKey()] = item
+			}
+			f.Close()
+		}
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if restart {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(progressFilePath, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p.file = f
+	return p, nil
+}
+
+// get 获取指定标识的记录，没有则返回nil
+func (p *jsonLinesProgress) get(key string) progressItem {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.items[key]
+}
+
+// Save 保存一条记录
+func (p *jsonLinesProgress) Save(item progressItem) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.items[item.progressKey()] = item
+	data, err := jsoniter.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = p.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close 关闭进度文件，remove 为 true 时删除进度文件
+func (p *jsonLinesProgress) Close(remove bool) error {
+	err := p.file.Close()
+	if remove {
+		return os.Remove(p.filePath)
+	}
+	return err
+}
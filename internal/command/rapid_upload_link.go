@@ -0,0 +1,288 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 秒传文件格式 (JSON Lines)，每一行是一个JSON对象：
+//
+// 第一行是文件头，标识格式名称和版本：
+// {"format":"aliyunpan-rapid-upload","version":1,"createdAt":"2022-05-01 10:00:00"}
+//
+// 之后每一行是一个文件：
+// {"name":"file.dmg","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":7005440,"path":"pan_folder","proof":{"userId":"...","driveId":"...","fileId":"..."}}
+//
+// name: 文件名
+// sha1: 文件SHA1
+// size: 文件大小
+// path: 文件所在的相对目录，为空代表保存到目标目录本身
+// proof: 秒传证明数据，即导出文件的账号和源文件。阿里云盘秒传需要源文件的片段数据(proof_code)，
+// 导入时使用该账号读取源文件计算，所以该账号需要在本机登录过，并且源文件没有被删除
+//
+// 导入时同时兼容旧的秒传链接格式：aliyunpan://文件名|sha1|文件大小|<相对路径>
+
+type (
+	// RapidUploadHeader 秒传文件头
+	RapidUploadHeader struct {
+		Format    string `json:"format"`
+		Version   int    `json:"version"`
+		CreatedAt string `json:"createdAt"`
+	}
+
+	// RapidUploadProof 秒传证明数据
+	RapidUploadProof struct {
+		UserId  string `json:"userId"`
+		DriveId string `json:"driveId"`
+		FileId  string `json:"fileId"`
+	}
+
+	// RapidUploadEntry 秒传文件项
+	RapidUploadEntry struct {
+		Name  string            `json:"name"`
+		Sha1  string            `json:"sha1"`
+		Size  int64             `json:"size"`
+		Path  string            `json:"path"`
+		Proof *RapidUploadProof `json:"proof,omitempty"`
+	}
+)
+
+const (
+	// RapidUploadFormatName 秒传文件格式名称
+	RapidUploadFormatName = "aliyunpan-rapid-upload"
+	// RapidUploadFormatVersion 秒传文件格式版本
+	RapidUploadFormatVersion = 1
+)
+
+var (
+	sha1Regexp = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+)
+
+func newRapidUploadHeader() *RapidUploadHeader {
+	return &RapidUploadHeader{
+		Format:    RapidUploadFormatName,
+		Version:   RapidUploadFormatVersion,
+		CreatedAt: utils.NowTimeStr(),
+	}
+}
+
+func (h *RapidUploadHeader) String() string {
+	data, _ := jsoniter.Marshal(h)
+	return string(data)
+}
+
+// newRapidUploadEntry 创建秒传文件项，相对目录从 rootDir 开始计算
+func newRapidUploadEntry(userId string, fd *aliyunpan.FileEntity, rootDir string, hidePath bool) *RapidUploadEntry {
+	entry := &RapidUploadEntry{
+		Name: fd.FileName,
+		Sha1: strings.ToUpper(fd.ContentHash),
+		Size: fd.FileSize,
+		Proof: &RapidUploadProof{
+			UserId:  userId,
+			DriveId: fd.DriveId,
+			FileId:  fd.FileId,
+		},
+	}
+	if !hidePath {
+		entry.Path = strings.Trim(strings.TrimPrefix(path.Dir(fd.Path), rootDir), "/")
+	}
+	return entry
+}
+
+func (e *RapidUploadEntry) String() string {
+	data, _ := jsoniter.Marshal(e)
+	return string(data)
+}
+
+// toRapidUploadItem 校验文件项并转换为秒传数据项，FilePath 为相对路径
+func (e *RapidUploadEntry) toRapidUploadItem() (*RapidUploadItem, error) {
+	name := strings.TrimSpace(e.Name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return nil, fmt.Errorf("文件名错误: %s", e.Name)
+	}
+	if !sha1Regexp.MatchString(e.Sha1) {
+		return nil, fmt.Errorf("文件sha1错误: %s", e.Sha1)
+	}
+	if e.Size < 0 {
+		return nil, fmt.Errorf("文件大小错误: %d", e.Size)
+	}
+	// 相对目录不能跳出保存目录
+	dir := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(e.Path, "\\", "/")), "/")
+	return &RapidUploadItem{
+		FileSha1: strings.ToUpper(e.Sha1),
+		FileSize: e.Size,
+		FilePath: path.Join(dir, name),
+		Proof:    e.Proof,
+	}, nil
+}
+
+// parseRapidUploadLines 解析秒传文件的所有行，返回可以导入的文件项和每一行的解析错误。
+// 文件头的格式或者版本不支持时返回 error
+func parseRapidUploadLines(lines []string) ([]*RapidUploadItem, []error, error) {
+	items := []*RapidUploadItem{}
+	lineErrs := []error{}
+	headerChecked := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "aliyunpan://") {
+			item, err := newRapidUploadItem(line)
+			if err != nil {
+				lineErrs = append(lineErrs, fmt.Errorf("第 %d 行: %s", i+1, err))
+				continue
+			}
+			items = append(items, item)
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			lineErrs = append(lineErrs, fmt.Errorf("第 %d 行: 无法识别的秒传链接: %s", i+1, line))
+			continue
+		}
+
+		if !headerChecked {
+			// 文件头只能是第一个JSON行
+			headerChecked = true
+			header := &RapidUploadHeader{}
+			if jsoniter.Unmarshal([]byte(line), header) == nil && header.Format != "" {
+				if header.Format != RapidUploadFormatName {
+					return nil, nil, fmt.Errorf("不支持的秒传文件格式: %s", header.Format)
+				}
+				if header.Version < 1 || header.Version > RapidUploadFormatVersion {
+					return nil, nil, fmt.Errorf("不支持的秒传文件版本: %d, 请升级到最新版本", header.Version)
+				}
+				continue
+			}
+		}
+		entry := &RapidUploadEntry{}
+		if err := jsoniter.Unmarshal([]byte(line), entry); err != nil {
+			lineErrs = append(lineErrs, fmt.Errorf("第 %d 行: 秒传链接格式错误: %s", i+1, line))
+			continue
+		}
+		item, err := entry.toRapidUploadItem()
+		if err != nil {
+			lineErrs = append(lineErrs, fmt.Errorf("第 %d 行: %s", i+1, err))
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, lineErrs, nil
+}
+
+// writeRapidUploadEntries 遍历网盘文件/目录，输出文件头和所有文件的秒传文件项，返回文件数量。
+// 文件项的相对目录从指定文件/目录的上一级目录开始计算，保留文件夹的结构
+func writeRapidUploadEntries(w io.Writer, driveId string, panPaths []string, hidePath bool, onEntry func(entry *RapidUploadEntry)) int {
+	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
+
+	fmt.Fprintln(w, newRapidUploadHeader().String())
+	totalCount := 0
+	for _, panPath := range panPaths {
+		panPath = activeUser.PathJoin(driveId, panPath)
+		rootDir := path.Dir(panPath)
+		panClient.FilesDirectoriesRecurseList(driveId, panPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+			if apiError != nil {
+				logger.Verbosef("%s\n", apiError)
+				if depth == 0 {
+					fmt.Fprintf(os.Stderr, "文件不存在: %s\n", panPath)
+					return false
+				}
+				return true
+			}
+
+			// 只需要文件即可
+			if fd.IsFolder() {
+				return true
+			}
+			if fd.ContentHash == "" {
+				fmt.Fprintf(os.Stderr, "文件没有SHA1信息, 跳过: %s\n", fd.Path)
+				return true
+			}
+			entry := newRapidUploadEntry(activeUser.UserId, fd, rootDir, hidePath)
+			fmt.Fprintln(w, entry.String())
+			totalCount += 1
+			if onEntry != nil {
+				onEntry(entry)
+			}
+			time.Sleep(time.Duration(100) * time.Millisecond)
+			return true
+		})
+	}
+	return totalCount
+}
+
+// rapidUploadProofCode 使用导出文件的账号读取源文件片段，计算秒传需要的 proof_code
+func rapidUploadProofCode(accessToken string, item *RapidUploadItem) (string, error) {
+	if item.FileSize == 0 {
+		return "", nil
+	}
+	if item.Proof == nil || item.Proof.FileId == "" {
+		return "", fmt.Errorf("秒传链接没有证明数据")
+	}
+	sourceUser, err := config.Config.GetLoginUser(item.Proof.UserId, "")
+	if err != nil {
+		return "", fmt.Errorf("无法读取源文件, %s", err)
+	}
+	return aliyunpan.CalcProofCode(accessToken, &panFileRangeReader{
+		panClient: sourceUser.PanClient(),
+		file: &aliyunpan.FileEntity{
+			DriveId:  item.Proof.DriveId,
+			FileId:   item.Proof.FileId,
+			FileSize: item.FileSize,
+		},
+	}, item.FileSize), nil
+}
+
+// createRapidUploadFile 秒传文件到指定的网盘目录
+func createRapidUploadFile(panClient *aliyunpan.PanClient, driveId, parentFileId, fileName string, item *RapidUploadItem) (*aliyunpan.CreateFileUploadResult, error) {
+	proofCode, proofErr := rapidUploadProofCode(panClient.GetAccessToken(), item)
+	if proofErr != nil {
+		logger.Verboseln("calc proof code error: ", proofErr)
+	}
+	uploadOpEntity, apierr := panClient.CreateUploadFile(&aliyunpan.CreateFileUploadParam{
+		DriveId:         driveId,
+		Name:            fileName,
+		Size:            item.FileSize,
+		ContentHash:     item.FileSha1,
+		ContentHashName: "sha1",
+		ParentFileId:    parentFileId,
+		BlockSize:       aliyunpan.DefaultChunkSize,
+		ProofCode:       proofCode,
+		ProofVersion:    "v1",
+	})
+	if apierr != nil {
+		return nil, fmt.Errorf("创建秒传任务失败：%s", apierr)
+	}
+	if !uploadOpEntity.RapidUpload {
+		if proofErr != nil {
+			return nil, fmt.Errorf("秒传失败, %s", proofErr)
+		}
+		return nil, fmt.Errorf("失败，文件未曾上传，无法秒传")
+	}
+	return uploadOpEntity, nil
+}
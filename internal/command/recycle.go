@@ -18,12 +18,20 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdliner"
 	"github.com/tickstep/aliyunpan/cmder/cmdtable"
 	"github.com/tickstep/library-go/converter"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"os"
+	"path"
 	"strconv"
+	"strings"
+)
+
+const (
+	// recycleBatchSize 回收站批量操作每次的文件数量
+	recycleBatchSize = 100
 )
 
 func CmdRecycle() cli.Command {
@@ -32,16 +40,29 @@ func CmdRecycle() cli.Command {
 		Usage: "回收站",
 		Description: `
 	回收站操作.
+	回收站文件可以通过 file_id 指定, 也可以通过删除前的路径, 文件名通配符和删除时间筛选.
+	通配符中 * 匹配除 / 以外的任意字符, ** 匹配包括 / 在内的任意字符, ? 匹配除 / 以外的一个字符,
+	不包含 / 的通配符只匹配文件名, 不包含通配符的路径匹配该路径本身以及下面的所有文件.
+	删除时间支持 30d(天), 2w(周), 12h(小时), 30m(分钟) 等格式.
 
 	示例:
 
 	1. 从回收站还原两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
 	aliyunpan recycle restore 1013792297798440 643596340463870
 
-	2. 从回收站删除两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
+	2. 从回收站还原删除前在 /docs 目录下的所有pdf文件
+	aliyunpan recycle restore -path "/docs/**.pdf"
+
+	3. 从回收站还原最近1天删除的所有 mp4 文件, 原位置已经存在同名文件时自动重命名
+	aliyunpan recycle restore -path "*.mp4" -newer-than 1d -conflict rename
+
+	4. 从回收站删除两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
 	aliyunpan recycle delete 1013792297798440 643596340463870
 
-	3. 清空回收站, 程序不会进行二次确认, 谨慎操作!!!
+	5. 从回收站删除30天前删除的文件
+	aliyunpan recycle delete -older-than 30d
+
+	6. 清空回收站, 程序不会进行二次确认, 谨慎操作!!!
 	aliyunpan recycle delete -all
 `,
 		Category: "阿里云盘",
@@ -57,47 +78,67 @@ func CmdRecycle() cli.Command {
 				Name:      "list",
 				Aliases:   []string{"ls", "l"},
 				Usage:     "列出回收站文件列表",
-				UsageText: cmder.App().Name + " recycle list",
+				UsageText: cmder.App().Name + " recycle list [-path <通配符>] [-older-than <时长>] [-newer-than <时长>]",
 				Action: func(c *cli.Context) error {
-					RunRecycleList(parseDriveId(c))
+					filter, err := newRecycleFilter(c)
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+					RunRecycleList(parseDriveId(c), filter)
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append(recycleFilterFlags(),
 					cli.StringFlag{
 						Name:  "driveId",
 						Usage: "网盘ID",
 						Value: "",
 					},
-				},
+				),
 			},
 			{
 				Name:        "restore",
 				Aliases:     []string{"r"},
 				Usage:       "还原回收站文件或目录",
-				UsageText:   cmder.App().Name + " recycle restore <file_id 1> <file_id 2> <file_id 3> ...",
-				Description: `根据文件/目录的 fs_id, 还原回收站指定的文件或目录`,
+				UsageText:   cmder.App().Name + " recycle restore [-path <通配符>] [-older-than <时长>] [-newer-than <时长>] [-conflict <策略>] <file_id 1> <file_id 2> <file_id 3> ...",
+				Description: `根据文件/目录的 file_id 或者筛选条件, 还原回收站指定的文件或目录`,
 				Action: func(c *cli.Context) error {
-					if c.NArg() <= 0 {
+					filter, err := newRecycleFilter(c)
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+					if c.NArg() <= 0 && filter == nil {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
 					}
-					RunRecycleRestore(parseDriveId(c), c.Args()...)
+					policy := CopyConflictPolicy(strings.ToLower(c.String("conflict")))
+					if policy != CopyConflictSkip && policy != CopyConflictOverwrite && policy != CopyConflictRename {
+						fmt.Println("不支持的冲突处理策略: ", c.String("conflict"))
+						return nil
+					}
+					RunRecycleRestore(parseDriveId(c), filter, policy, c.Args()...)
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append(recycleFilterFlags(),
+					cli.StringFlag{
+						Name:  "conflict",
+						Usage: "原位置已经存在同名文件时的处理策略, 支持: skip(跳过),overwrite(将已存在的文件移动到回收站),rename(还原后自动重命名)",
+						Value: string(CopyConflictSkip),
+					},
 					cli.StringFlag{
 						Name:  "driveId",
 						Usage: "网盘ID",
 						Value: "",
 					},
-				},
+				),
 			},
 			{
 				Name:        "delete",
 				Aliases:     []string{"d"},
 				Usage:       "删除回收站文件或目录 / 清空回收站",
-				UsageText:   cmder.App().Name + " recycle delete [-all] <file_id 1> <file_id 2> <file_id 3> ...",
-				Description: `根据文件/目录的 file_id 或 -all 参数, 删除回收站指定的文件或目录或清空回收站`,
+				UsageText:   cmder.App().Name + " recycle delete [-all] [-path <通配符>] [-older-than <时长>] [-newer-than <时长>] <file_id 1> <file_id 2> <file_id 3> ...",
+				Description: `根据文件/目录的 file_id, 筛选条件或 -all 参数, 删除回收站指定的文件或目录或清空回收站`,
 				Action: func(c *cli.Context) error {
 					if c.Bool("all") {
 						// 清空回收站
@@ -105,113 +146,286 @@ func CmdRecycle() cli.Command {
 						return nil
 					}
 
-					if c.NArg() <= 0 {
+					filter, err := newRecycleFilter(c)
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+					if c.NArg() <= 0 && filter == nil {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
 					}
-					RunRecycleDelete(parseDriveId(c), c.Args()...)
+					RunRecycleDelete(parseDriveId(c), filter, c.Bool("y"), c.Args()...)
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append(recycleFilterFlags(),
 					cli.BoolFlag{
 						Name:  "all",
 						Usage: "清空回收站, 程序不会进行二次确认, 谨慎操作!!!",
 					},
+					cli.BoolFlag{
+						Name:  "y",
+						Usage: "使用筛选条件删除时不进行二次确认",
+					},
 					cli.StringFlag{
 						Name:  "driveId",
 						Usage: "网盘ID",
 						Value: "",
 					},
-				},
+				),
 			},
 		},
 	}
 }
 
-// RunRecycleList 执行列出回收站文件列表
-func RunRecycleList(driveId string) {
-	panClient := GetActivePanClient()
-	fdl, err := panClient.RecycleBinFileListGetAll(&aliyunpan.RecycleBinFileListParam{
-		DriveId: driveId,
-		Limit:   100,
-	})
-	if err != nil {
-		fmt.Println(err)
-		return
+// recycleFilterFlags 回收站文件筛选参数
+func recycleFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "删除前的路径或文件名通配符, 例如: /docs/**.pdf, *.mp4",
+		},
+		cli.StringFlag{
+			Name:  "older-than",
+			Usage: "只处理删除时间早于该时长之前的文件, 例如: 30d",
+		},
+		cli.StringFlag{
+			Name:  "newer-than",
+			Usage: "只处理最近该时长内删除的文件, 例如: 12h",
+		},
 	}
+}
 
+// recycleItemsTable 输出回收站文件表格
+func recycleItemsTable(items []*recycleItem) {
 	tb := cmdtable.NewTable(os.Stdout)
-	tb.SetHeader([]string{"#", "file_id", "文件/目录名", "文件大小", "创建日期", "修改日期"})
-	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
-	for k, file := range fdl {
+	tb.SetHeader([]string{"#", "file_id", "文件/目录名", "文件大小", "创建日期", "删除日期", "原路径"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for k, item := range items {
+		file := item.File
 		fn := file.FileName
 		fs := converter.ConvertFileSize(file.FileSize, 2)
 		if file.IsFolder() {
 			fn = fn + "/"
 			fs = "-"
 		}
-		tb.Append([]string{strconv.Itoa(k), file.FileId, fn, fs, file.CreatedAt, file.UpdatedAt})
+		originPath := item.OriginPath
+		if originPath == "" {
+			originPath = "-"
+		}
+		tb.Append([]string{strconv.Itoa(k), file.FileId, fn, fs, file.CreatedAt, file.UpdatedAt, originPath})
 	}
-
 	tb.Render()
 }
 
-// RunRecycleRestore 执行还原回收站文件或目录
-func RunRecycleRestore(driveId string, fidStrList ...string) {
-	panClient := GetActivePanClient()
-	restoreFileList := []*aliyunpan.FileBatchActionParam{}
-
-	for _,fid := range fidStrList {
-		restoreFileList = append(restoreFileList, &aliyunpan.FileBatchActionParam{
-			DriveId: driveId,
-			FileId:  fid,
-		})
+// RunRecycleList 执行列出回收站文件列表
+func RunRecycleList(driveId string, filter *RecycleFilter) {
+	items, err := selectRecycleItems(driveId, filter, nil, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	recycleItemsTable(items)
+	if filter != nil {
+		fmt.Printf("符合条件的文件: %d\n", len(items))
 	}
+}
 
-	if len(restoreFileList) == 0 {
-		fmt.Printf("没有需要还原的文件")
+// RunRecycleRestore 执行还原回收站文件或目录，policy 为原位置已经存在同名文件时的处理策略
+func RunRecycleRestore(driveId string, filter *RecycleFilter, policy CopyConflictPolicy, fidStrList ...string) {
+	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
+	items, err := selectRecycleItems(driveId, filter, fidStrList, false)
+	if err != nil {
+		fmt.Printf("获取回收站文件失败：%s\n", err)
 		return
 	}
-
-	rbfr, err := panClient.RecycleBinFileRestore(restoreFileList)
-	if rbfr != nil && len(rbfr) > 0 {
-		fmt.Printf("还原文件成功\n")
+	if len(items) == 0 {
+		fmt.Println("没有需要还原的文件")
 		return
 	}
 
-	if len(rbfr) == 0 && err != nil {
-		fmt.Printf("还原文件失败：%s\n", err)
-		return
+	// 检查原位置的同名文件，key为父文件夹ID
+	folders := map[string]map[string]*aliyunpan.FileEntity{}
+	renames := map[string]string{}
+	restoreItems := []*recycleItem{}
+	skippedCount, failedCount := 0, 0
+	for _, item := range items {
+		parentId := item.File.ParentFileId
+		existed, ok := folders[parentId]
+		if !ok {
+			existed = map[string]*aliyunpan.FileEntity{}
+			fileList, apierr := panClient.FileListGetAll(&aliyunpan.FileListParam{
+				DriveId:      driveId,
+				ParentFileId: parentId,
+			}, 500)
+			if apierr != nil {
+				// 原文件夹已经不存在
+				logger.Verboseln("list origin folder error: ", parentId, apierr)
+			}
+			for _, fe := range fileList {
+				existed[fe.FileName] = fe
+			}
+			folders[parentId] = existed
+		}
+
+		displayPath := item.OriginPath
+		if displayPath == "" {
+			displayPath = item.File.FileName
+		}
+		name := item.File.FileName
+		if dst, ok := existed[name]; ok {
+			switch policy {
+			case CopyConflictSkip:
+				fmt.Println("原位置已经存在同名文件, 跳过: ", displayPath)
+				skippedCount++
+				continue
+			case CopyConflictOverwrite:
+				if dst == nil || dst.IsFolder() {
+					fmt.Println("原位置已经存在同名文件夹或者待还原的同名文件, 跳过: ", displayPath)
+					skippedCount++
+					continue
+				}
+				fdr, apierr := panClient.FileDelete([]*aliyunpan.FileBatchActionParam{
+					{
+						DriveId: driveId,
+						FileId:  dst.FileId,
+					},
+				})
+				if apierr != nil || len(fdr) == 0 || !fdr[0].Success {
+					fmt.Println("移动原位置的同名文件到回收站失败, 跳过: ", displayPath)
+					failedCount++
+					continue
+				}
+			case CopyConflictRename:
+				name = uniqueCopyName(name, existed)
+				renames[item.File.FileId] = name
+			}
+		}
+		// 待还原的文件也会占用文件名，value 为nil
+		existed[name] = nil
+		restoreItems = append(restoreItems, item)
 	}
+
+	successCount := 0
+	cacheCleanDirs := []string{}
+	// 只获取还原成功的文件的原路径，用于显示和清理缓存
+	resolver := newRecyclePathResolver(panClient, driveId)
+	for start := 0; start < len(restoreItems); start += recycleBatchSize {
+		end := start + recycleBatchSize
+		if end > len(restoreItems) {
+			end = len(restoreItems)
+		}
+		fileId2Item := map[string]*recycleItem{}
+		restoreFileList := []*aliyunpan.FileBatchActionParam{}
+		for _, item := range restoreItems[start:end] {
+			restoreFileList = append(restoreFileList, &aliyunpan.FileBatchActionParam{
+				DriveId: driveId,
+				FileId:  item.File.FileId,
+			})
+			fileId2Item[item.File.FileId] = item
+		}
+		rbfr, err := panClient.RecycleBinFileRestore(restoreFileList)
+		if err != nil && rbfr == nil {
+			fmt.Printf("还原文件失败：%s\n", err)
+			failedCount += len(restoreFileList)
+			continue
+		}
+		for _, r := range rbfr {
+			item := fileId2Item[r.FileId]
+			if item == nil {
+				continue
+			}
+			if r.Success {
+				resolver.resolve(item)
+			}
+			displayPath := item.OriginPath
+			if displayPath == "" {
+				displayPath = item.File.FileName
+			}
+			if !r.Success {
+				fmt.Println("还原文件失败: ", displayPath)
+				failedCount++
+				continue
+			}
+			if newName, ok := renames[item.File.FileId]; ok {
+				if _, apierr := panClient.FileRename(driveId, item.File.FileId, newName); apierr != nil {
+					fmt.Printf("还原文件成功, 但是重命名为 %s 失败: %s, %s\n", newName, displayPath, apierr)
+				} else {
+					displayPath = path.Join(path.Dir(displayPath), newName)
+				}
+			}
+			fmt.Println("还原文件成功: ", displayPath)
+			successCount++
+			if item.OriginPath != "" {
+				cacheCleanDirs = append(cacheCleanDirs, path.Dir(item.OriginPath))
+			}
+		}
+	}
+	activeUser.DeleteCache(cacheCleanDirs)
+	fmt.Printf("还原文件完成, 成功: %d, 跳过: %d, 失败: %d\n", successCount, skippedCount, failedCount)
 }
 
-// RunRecycleDelete 执行删除回收站文件或目录
-func RunRecycleDelete(driveId string, fidStrList ...string) {
+// RunRecycleDelete 执行删除回收站文件或目录，使用筛选条件时需要二次确认
+func RunRecycleDelete(driveId string, filter *RecycleFilter, yes bool, fidStrList ...string) {
 	panClient := GetActivePanClient()
-	deleteFileList := []*aliyunpan.FileBatchActionParam{}
-
-	for _,fid := range fidStrList {
-		deleteFileList = append(deleteFileList, &aliyunpan.FileBatchActionParam{
-			DriveId: driveId,
-			FileId:  fid,
-		})
+	// 使用筛选条件时需要输出文件表格确认
+	items, err := selectRecycleItems(driveId, filter, fidStrList, filter != nil && !yes)
+	if err != nil {
+		fmt.Printf("获取回收站文件失败：%s\n", err)
+		return
 	}
-
-	if len(deleteFileList) == 0 {
-		fmt.Printf("没有需要删除的文件")
+	if len(items) == 0 {
+		fmt.Println("没有需要删除的文件")
 		return
 	}
 
-	rbfr, err := panClient.RecycleBinFileDelete(deleteFileList)
-	if rbfr != nil && len(rbfr) > 0 {
-		fmt.Printf("彻底删除文件成功\n")
-		return
+	if filter != nil && !yes {
+		recycleItemsTable(items)
+		line := cmdliner.NewLiner()
+		input, err := line.State.Prompt(fmt.Sprintf("确认彻底删除以上 %d 个文件? 删除后无法找回 [y/N] ", len(items)))
+		line.Close()
+		if err != nil || strings.ToLower(strings.TrimSpace(input)) != "y" {
+			fmt.Println("已放弃删除文件")
+			return
+		}
 	}
 
-	if len(rbfr) == 0 && err != nil {
-		fmt.Printf("彻底删除文件失败：%s\n", err)
-		return
+	successCount, failedCount := 0, 0
+	for start := 0; start < len(items); start += recycleBatchSize {
+		end := start + recycleBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		fileId2Item := map[string]*recycleItem{}
+		deleteFileList := []*aliyunpan.FileBatchActionParam{}
+		for _, item := range items[start:end] {
+			deleteFileList = append(deleteFileList, &aliyunpan.FileBatchActionParam{
+				DriveId: driveId,
+				FileId:  item.File.FileId,
+			})
+			fileId2Item[item.File.FileId] = item
+		}
+		rbfr, err := panClient.RecycleBinFileDelete(deleteFileList)
+		if err != nil && rbfr == nil {
+			fmt.Printf("彻底删除文件失败：%s\n", err)
+			failedCount += len(deleteFileList)
+			continue
+		}
+		for _, r := range rbfr {
+			item := fileId2Item[r.FileId]
+			if item == nil {
+				continue
+			}
+			if !r.Success {
+				fmt.Println("彻底删除文件失败: ", item.File.FileName)
+				failedCount++
+				continue
+			}
+			successCount++
+		}
 	}
+	fmt.Printf("彻底删除文件完成, 成功: %d, 失败: %d\n", successCount, failedCount)
 }
 
 // RunRecycleClear 清空回收站
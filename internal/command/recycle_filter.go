@@ -0,0 +1,287 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"github.com/urfave/cli"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// RecycleFilter 回收站文件筛选条件，所有设置的条件都满足才会匹配
+	RecycleFilter struct {
+		PathPattern   *regexp.Regexp // 原路径通配符
+		NameOnly      bool           // 通配符不包含目录，只匹配文件名
+		DeletedBefore time.Time      // 删除时间早于该时间
+		DeletedAfter  time.Time      // 删除时间不早于该时间
+	}
+
+	// recycleItem 回收站文件
+	recycleItem struct {
+		File       *aliyunpan.FileEntity
+		OriginPath string // 删除前的路径，为空代表无法获取
+	}
+
+	// recyclePathResolver 通过父文件夹ID获取回收站文件删除前的路径
+	recyclePathResolver struct {
+		panClient *aliyunpan.PanClient
+		driveId   string
+		paths     map[string]string // key为文件夹ID
+	}
+)
+
+// parseRecycleDuration 解析时长，支持 30d, 2w 以及 12h, 30m 等格式
+func parseRecycleDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(value[:len(value)-1]))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("时长格式错误, 例如: 30d, 2w, 12h: %s", value)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("时长格式错误, 例如: 30d, 2w, 12h: %s", value)
+	}
+	return d, nil
+}
+
+// recycleGlobRegexp 将路径通配符转换为正则表达式。
+// * 匹配除 / 以外的任意字符，** 匹配包括 / 在内的任意字符，? 匹配除 / 以外的一个字符。
+// 不包含通配符的路径匹配该路径本身以及下面的所有文件
+func recycleGlobRegexp(pattern string) (*regexp.Regexp, error) {
+	if !strings.ContainsAny(pattern, "*?") {
+		p := path.Clean(pattern)
+		if p == "/" {
+			return regexp.Compile(`^/.*$`)
+		}
+		return regexp.Compile("^" + regexp.QuoteMeta(p) + "(/.*)?$")
+	}
+	sb := &strings.Builder{}
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ 可以匹配零个或多个目录
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			// 按字节处理，多字节字符原样写入
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// newRecycleFilter 根据命令行参数创建筛选条件，没有设置任何条件时返回nil
+func newRecycleFilter(c *cli.Context) (*RecycleFilter, error) {
+	if c.String("path") == "" && c.String("older-than") == "" && c.String("newer-than") == "" {
+		return nil, nil
+	}
+	filter := &RecycleFilter{}
+	now := time.Now()
+	if pattern := c.String("path"); pattern != "" {
+		// 不包含目录的通配符只匹配文件名，否则匹配删除前的完整路径
+		filter.NameOnly = !strings.Contains(pattern, "/")
+		if !filter.NameOnly && !strings.HasPrefix(pattern, "/") {
+			activeUser := GetActiveUser()
+			pattern = activeUser.PathJoin(parseDriveId(c), pattern)
+		}
+		re, err := recycleGlobRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("路径通配符格式错误: %s", c.String("path"))
+		}
+		filter.PathPattern = re
+	}
+	if c.String("older-than") != "" {
+		d, err := parseRecycleDuration(c.String("older-than"))
+		if err != nil {
+			return nil, err
+		}
+		filter.DeletedBefore = now.Add(-d)
+	}
+	if c.String("newer-than") != "" {
+		d, err := parseRecycleDuration(c.String("newer-than"))
+		if err != nil {
+			return nil, err
+		}
+		filter.DeletedAfter = now.Add(-d)
+	}
+	return filter, nil
+}
+
+// Match 回收站文件是否符合筛选条件，删除时间使用文件的修改时间，文件移动到回收站时会更新
+func (f *RecycleFilter) Match(item *recycleItem) bool {
+	if f.PathPattern != nil {
+		if f.NameOnly {
+			if !f.PathPattern.MatchString(item.File.FileName) {
+				return false
+			}
+		} else if item.OriginPath == "" || !f.PathPattern.MatchString(item.OriginPath) {
+			return false
+		}
+	}
+	if !f.DeletedBefore.IsZero() || !f.DeletedAfter.IsZero() {
+		deletedAt := utils.ParseTimeStr(item.File.UpdatedAt)
+		if !f.DeletedBefore.IsZero() && !deletedAt.Before(f.DeletedBefore) {
+			return false
+		}
+		if !f.DeletedAfter.IsZero() && deletedAt.Before(f.DeletedAfter) {
+			return false
+		}
+	}
+	return true
+}
+
+// needOriginPath 筛选条件是否需要文件删除前的路径
+func (f *RecycleFilter) needOriginPath() bool {
+	return f != nil && f.PathPattern != nil && !f.NameOnly
+}
+
+func newRecyclePathResolver(panClient *aliyunpan.PanClient, driveId string) *recyclePathResolver {
+	return &recyclePathResolver{
+		panClient: panClient,
+		driveId:   driveId,
+		paths:     map[string]string{},
+	}
+}
+
+// folderPath 获取文件夹的路径
+func (r *recyclePathResolver) folderPath(fileId string) (string, error) {
+	if fileId == "" || fileId == aliyunpan.DefaultRootParentFileId {
+		return "/", nil
+	}
+	if p, ok := r.paths[fileId]; ok {
+		return p, nil
+	}
+	fe, apierr := r.panClient.FileInfoById(r.driveId, fileId)
+	if apierr != nil {
+		return "", apierr
+	}
+	parentPath, err := r.folderPath(fe.ParentFileId)
+	if err != nil {
+		return "", err
+	}
+	p := path.Join(parentPath, fe.FileName)
+	r.paths[fileId] = p
+	return p, nil
+}
+
+// resolve 获取回收站文件删除前的路径，已经获取过则跳过
+func (r *recyclePathResolver) resolve(item *recycleItem) {
+	if item.OriginPath != "" {
+		return
+	}
+	if parentPath, err := r.folderPath(item.File.ParentFileId); err == nil {
+		item.OriginPath = path.Join(parentPath, item.File.FileName)
+	} else {
+		logger.Verboseln("get recycle file origin path error: ", item.File.FileName, err)
+	}
+}
+
+// getRecycleItemsById 获取回收站中指定ID的文件，不需要获取整个回收站的文件列表
+func getRecycleItemsById(driveId string, fileIds []string) []*recycleItem {
+	panClient := GetActivePanClient()
+	items := []*recycleItem{}
+	for _, fid := range fileIds {
+		fe, apierr := panClient.FileInfoById(driveId, fid)
+		if apierr != nil {
+			fmt.Println("回收站中不存在该文件, 跳过: ", fid)
+			continue
+		}
+		items = append(items, &recycleItem{File: fe})
+	}
+	return items
+}
+
+// selectRecycleItems 获取回收站中指定ID并且符合筛选条件的文件，fileIds 为空代表不限制ID。
+// 删除前的路径只在筛选条件或者 resolvePath 需要时获取，每个文件夹都需要请求一次接口
+func selectRecycleItems(driveId string, filter *RecycleFilter, fileIds []string, resolvePath bool) ([]*recycleItem, error) {
+	resolver := newRecyclePathResolver(GetActivePanClient(), driveId)
+	if len(fileIds) > 0 && filter == nil {
+		items := getRecycleItemsById(driveId, fileIds)
+		if resolvePath {
+			for _, item := range items {
+				resolver.resolve(item)
+			}
+		}
+		return items, nil
+	}
+
+	fdl, err := GetActivePanClient().RecycleBinFileListGetAll(&aliyunpan.RecycleBinFileListParam{
+		DriveId: driveId,
+		Limit:   100,
+	})
+	if err != nil {
+		return nil, err
+	}
+	idSet := map[string]bool{}
+	for _, fid := range fileIds {
+		idSet[fid] = true
+	}
+	selected := []*recycleItem{}
+	for _, f := range fdl {
+		item := &recycleItem{File: f}
+		if len(idSet) > 0 {
+			if !idSet[f.FileId] {
+				continue
+			}
+			delete(idSet, f.FileId)
+		}
+		if filter.needOriginPath() {
+			resolver.resolve(item)
+		}
+		if filter != nil && !filter.Match(item) {
+			continue
+		}
+		if resolvePath {
+			resolver.resolve(item)
+		}
+		selected = append(selected, item)
+	}
+	for _, fid := range fileIds {
+		if idSet[fid] {
+			fmt.Println("回收站中不存在该文件, 跳过: ", fid)
+		}
+	}
+	return selected, nil
+}
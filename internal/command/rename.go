@@ -28,7 +28,13 @@ func CmdRename() cli.Command {
 		Name:  "rename",
 		Usage: "重命名文件",
 		UsageText: `重命名文件:
-	aliyunpan rename <旧文件/目录名> <新文件/目录名>`,
+	aliyunpan rename <旧文件/目录名> <新文件/目录名>
+
+	批量正则重命名:
+	aliyunpan rename -regex [-r] [-type file|folder] [-y] <正则表达式> <替换内容> <目录>
+
+	撤销批量重命名:
+	aliyunpan rename -undo <重命名日志文件>`,
 		Description: `
 	示例:
 
@@ -38,18 +44,54 @@ func CmdRename() cli.Command {
 	将文件 /test/1.mp4 重命名为 /test/2.mp4
 	要求必须是同一个文件目录内
 	aliyunpan rename /test/1.mp4 /test/2.mp4
+
+	批量正则重命名:
+	正则表达式匹配文件名, 替换内容支持使用 $1 或者 ${1} 引用捕获分组, 使用 {n} 或者 {n:03} 插入序号(每个目录内按照路径排序从1开始, {n:03} 代表不足3位补0).
+	默认只预览重命名的结果, 确认无误后使用 -y 执行重命名. 执行重命名之前会检查重名冲突,
+	执行后会保存重命名日志, 使用 -undo 可以撤销这一批重命名.
+
+	预览将 /我的资源 内的 IMG_1234.JPG 重命名为 照片_1234.jpg
+	aliyunpan rename -regex "^IMG_(\d+)\.JPG$" "照片_$1.jpg" /我的资源
+
+	递归重命名 /剧集 内所有的 mp4 文件为 第001集.mp4, 第002集.mp4 ...
+	aliyunpan rename -regex -r -type file -y ".*\.mp4$" "第{n:03}集.mp4" /剧集
+
+	撤销批量重命名
+	aliyunpan rename -undo "/root/.config/aliyunpan/rename_journal/20220101120000123.jsonl"
 `,
 		Category: "阿里云盘",
 		Before:   cmder.ReloadConfigFunc,
 		Action: func(c *cli.Context) error {
-			if c.NArg() != 2 {
-				cli.ShowCommandHelp(c, c.Command.Name)
-				return nil
-			}
 			if config.Config.ActiveUser() == nil {
 				fmt.Println("未登录账号")
 				return nil
 			}
+			if c.IsSet("undo") {
+				RunRenameUndo(c.String("undo"))
+				return nil
+			}
+			if c.Bool("regex") {
+				if c.NArg() != 2 && c.NArg() != 3 {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					return nil
+				}
+				fileType := strings.ToLower(c.String("type"))
+				if fileType != "" && fileType != "file" && fileType != "folder" {
+					fmt.Println("不支持的类型: ", c.String("type"))
+					return nil
+				}
+				RunRenameRegex(parseDriveId(c), c.Args().Get(0), c.Args().Get(1), c.Args().Get(2), &RenameRegexOptions{
+					Recursive:   c.Bool("r"),
+					FileType:    fileType,
+					Confirm:     c.Bool("y"),
+					JournalPath: c.String("journal"),
+				})
+				return nil
+			}
+			if c.NArg() != 2 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
 			RunRename(parseDriveId(c), c.Args().Get(0), c.Args().Get(1))
 			return nil
 		},
@@ -59,6 +101,30 @@ func CmdRename() cli.Command {
 				Usage: "网盘ID",
 				Value: "",
 			},
+			cli.BoolFlag{
+				Name:  "regex",
+				Usage: "批量正则重命名",
+			},
+			cli.BoolFlag{
+				Name:  "r",
+				Usage: "批量重命名时递归处理子目录",
+			},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "批量重命名时只处理指定类型, 支持: file,folder",
+			},
+			cli.BoolFlag{
+				Name:  "y",
+				Usage: "确认执行批量重命名, 否则只预览重命名结果",
+			},
+			cli.StringFlag{
+				Name:  "journal",
+				Usage: "批量重命名日志的保存路径, 文件不能已经存在, 默认保存在配置目录的 rename_journal 文件夹",
+			},
+			cli.StringFlag{
+				Name:  "undo",
+				Usage: "根据重命名日志撤销批量重命名",
+			},
 		},
 	}
 }
@@ -0,0 +1,346 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bufio"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apiutil"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/library-go/logger"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// RenameRegexOptions 批量正则重命名可选项
+	RenameRegexOptions struct {
+		Recursive   bool   // 递归处理子目录
+		FileType    string // 只处理指定类型，file 或者 folder，为空代表不限制
+		Confirm     bool   // 执行重命名，否则只预览
+		JournalPath string // 重命名日志保存路径
+	}
+
+	// RenameJournalItem 重命名日志的一行，记录一个文件的重命名
+	RenameJournalItem struct {
+		DriveId string `json:"driveId"`
+		FileId  string `json:"fileId"`
+		Dir     string `json:"dir"`
+		OldName string `json:"oldName"`
+		NewName string `json:"newName"`
+	}
+)
+
+const (
+	// RenameJournalDirName 重命名日志默认保存的文件夹名称
+	RenameJournalDirName = "rename_journal"
+)
+
+var (
+	// renameSeqPattern 序号占位符，例如 {n} 或者 {n:03}
+	renameSeqPattern = regexp.MustCompile(`\{n(?::(\d+))?\}`)
+)
+
+// renameWithSeq 替换名称中的序号占位符
+func renameWithSeq(name string, seq int) string {
+	return renameSeqPattern.ReplaceAllStringFunc(name, func(s string) string {
+		m := renameSeqPattern.FindStringSubmatch(s)
+		width, _ := strconv.Atoi(m[1])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// buildRenamePlan 根据正则表达式生成重命名计划，files 需要包含目录内的所有文件，用于检查重名冲突。
+// 返回的计划已经按照执行顺序排序，新名称被其他文件占用需要先重命名的文件会排在前面
+func buildRenamePlan(files aliyunpan.FileList, re *regexp.Regexp, replacement, fileType string) ([]*RenameJournalItem, error) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	// 每个目录已经存在的名称
+	dirNames := map[string]map[string]bool{}
+	for _, f := range files {
+		dir := path.Dir(f.Path)
+		if dirNames[dir] == nil {
+			dirNames[dir] = map[string]bool{}
+		}
+		dirNames[dir][f.FileName] = true
+	}
+
+	plan := []*RenameJournalItem{}
+	// 序号在每个目录内单独从1开始
+	dirSeq := map[string]int{}
+	for _, f := range files {
+		if (fileType == "file" && f.IsFolder()) || (fileType == "folder" && !f.IsFolder()) {
+			continue
+		}
+		if !re.MatchString(f.FileName) {
+			continue
+		}
+		dirSeq[path.Dir(f.Path)]++
+		newName := renameWithSeq(re.ReplaceAllString(f.FileName, replacement), dirSeq[path.Dir(f.Path)])
+		if newName == f.FileName {
+			continue
+		}
+		if newName == "" || strings.Contains(newName, "/") || !apiutil.CheckFileNameValid(newName) {
+			return nil, fmt.Errorf("新文件名不合法: %s -> %s, 文件名不能为空, 不能包含特殊字符：%s", f.Path, newName, apiutil.FileNameSpecialChars)
+		}
+		plan = append(plan, &RenameJournalItem{
+			DriveId: f.DriveId,
+			FileId:  f.FileId,
+			Dir:     path.Dir(f.Path),
+			OldName: f.FileName,
+			NewName: newName,
+		})
+	}
+
+	// 检查重名冲突
+	renamedFrom := map[string]*RenameJournalItem{}
+	renamedTo := map[string]*RenameJournalItem{}
+	for _, item := range plan {
+		renamedFrom[path.Join(item.Dir, item.OldName)] = item
+	}
+	for _, item := range plan {
+		target := path.Join(item.Dir, item.NewName)
+		if other, ok := renamedTo[target]; ok {
+			return nil, fmt.Errorf("重名冲突: %s 和 %s 都会被重命名为 %s", path.Join(other.Dir, other.OldName), path.Join(item.Dir, item.OldName), target)
+		}
+		renamedTo[target] = item
+		if dirNames[item.Dir][item.NewName] && renamedFrom[target] == nil {
+			return nil, fmt.Errorf("重名冲突: %s 已经存在", target)
+		}
+	}
+
+	// 新名称被其他待重命名的文件占用时，需要等待该文件先重命名
+	ordered := make([]*RenameJournalItem, 0, len(plan))
+	pending := plan
+	for len(pending) > 0 {
+		next := []*RenameJournalItem{}
+		for _, item := range pending {
+			if blocker, ok := renamedFrom[path.Join(item.Dir, item.NewName)]; ok && blocker != item {
+				next = append(next, item)
+				continue
+			}
+			ordered = append(ordered, item)
+			delete(renamedFrom, path.Join(item.Dir, item.OldName))
+		}
+		if len(next) == len(pending) {
+			return nil, fmt.Errorf("重名冲突: 存在循环重命名, 例如 %s -> %s", path.Join(next[0].Dir, next[0].OldName), next[0].NewName)
+		}
+		pending = next
+	}
+	return ordered, nil
+}
+
+// listRenameFiles 获取目录内的文件，recursive 为 true 时包含所有子目录的文件
+func listRenameFiles(panClient *aliyunpan.PanClient, driveId string, dir *aliyunpan.FileEntity, recursive bool) (aliyunpan.FileList, error) {
+	if !recursive {
+		files, apierr := panClient.FileListGetAll(&aliyunpan.FileListParam{
+			DriveId:      driveId,
+			ParentFileId: dir.FileId,
+		}, 500)
+		if apierr != nil {
+			return nil, apierr
+		}
+		for _, f := range files {
+			f.Path = path.Join(dir.Path, f.FileName)
+		}
+		return files, nil
+	}
+
+	files := aliyunpan.FileList{}
+	var scanErr error
+	panClient.FilesDirectoriesRecurseList(driveId, dir.Path, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		if apiError != nil {
+			// 不完整的文件列表无法检查重名冲突
+			scanErr = apiError
+			return false
+		}
+		if fd == nil || depth == 0 {
+			return true
+		}
+		files = append(files, fd)
+		return true
+	})
+	return files, scanErr
+}
+
+// RunRenameRegex 执行批量正则重命名
+func RunRenameRegex(driveId, pattern, replacement, dirPath string, options *RenameRegexOptions) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Println("正则表达式格式错误: ", err)
+		return
+	}
+	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
+	dirPath = path.Clean(activeUser.PathJoin(driveId, dirPath))
+	dir, apierr := panClient.FileInfoByPath(driveId, dirPath)
+	if apierr != nil || !dir.IsFolder() {
+		fmt.Println("目录不存在: ", dirPath)
+		return
+	}
+	dir.Path = dirPath
+
+	files, err := listRenameFiles(panClient, driveId, dir, options.Recursive)
+	if err != nil {
+		fmt.Println("获取文件列表失败: ", err)
+		return
+	}
+	plan, err := buildRenamePlan(files, re, replacement, options.FileType)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(plan) == 0 {
+		fmt.Println("没有需要重命名的文件")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "目录", "原名称", "新名称"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for k, item := range plan {
+		tb.Append([]string{strconv.Itoa(k + 1), item.Dir, item.OldName, item.NewName})
+	}
+	tb.Render()
+	if !options.Confirm {
+		fmt.Printf("共 %d 个文件需要重命名, 以上为预览结果, 确认无误后请使用 -y 执行重命名\n", len(plan))
+		return
+	}
+
+	journal, journalPath, err := createRenameJournal(options.JournalPath)
+	if err != nil {
+		fmt.Println("创建重命名日志失败: ", err)
+		return
+	}
+	defer journal.Close()
+
+	successCount := 0
+	changedDirs := map[string]bool{}
+	for _, item := range plan {
+		b, e := panClient.FileRename(driveId, item.FileId, item.NewName)
+		if e != nil || !b {
+			fmt.Printf("重命名文件失败: %s -> %s, %v\n", path.Join(item.Dir, item.OldName), item.NewName, e)
+			continue
+		}
+		successCount++
+		changedDirs[item.Dir] = true
+		item.DriveId = driveId
+		data, _ := jsoniter.Marshal(item)
+		if _, err := journal.Write(append(data, '\n')); err != nil {
+			logger.Verboseln("write rename journal error: ", err)
+		}
+		fmt.Printf("重命名文件成功：%s -> %s\n", path.Join(item.Dir, item.OldName), item.NewName)
+	}
+
+	dirs := []string{}
+	for d := range changedDirs {
+		dirs = append(dirs, d)
+	}
+	activeUser.DeleteCache(dirs)
+	fmt.Printf("\n重命名完成, 成功: %d, 失败: %d\n", successCount, len(plan)-successCount)
+	if successCount > 0 {
+		fmt.Printf("重命名日志: %s\n撤销本次重命名: aliyunpan rename -undo \"%s\"\n", journalPath, journalPath)
+	}
+}
+
+// createRenameJournal 创建新的重命名日志文件，不会追加到已经存在的日志。
+// 没有指定路径则在配置目录的 rename_journal 文件夹生成不重复的文件名
+func createRenameJournal(journalPath string) (*os.File, string, error) {
+	autoName := journalPath == ""
+	if autoName {
+		journalPath = filepath.Join(config.GetConfigDir(), RenameJournalDirName, strings.Replace(time.Now().Format("20060102150405.000"), ".", "", 1)+".jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		return nil, "", err
+	}
+	basePath := strings.TrimSuffix(journalPath, ".jsonl")
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(journalPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err == nil {
+			return f, journalPath, nil
+		}
+		if !autoName || !os.IsExist(err) {
+			return nil, "", err
+		}
+		// 同一时间有其他的重命名任务，使用新的文件名
+		journalPath = fmt.Sprintf("%s_%d.jsonl", basePath, i)
+	}
+}
+
+// loadRenameJournal 读取重命名日志
+func loadRenameJournal(journalPath string) ([]*RenameJournalItem, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := []*RenameJournalItem{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		item := &RenameJournalItem{}
+		if err := jsoniter.Unmarshal([]byte(line), item); err != nil {
+			return nil, fmt.Errorf("重命名日志格式错误: %s", line)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// RunRenameUndo 根据重命名日志撤销批量重命名，按照重命名的相反顺序恢复原名称
+func RunRenameUndo(journalPath string) {
+	items, err := loadRenameJournal(journalPath)
+	if err != nil {
+		fmt.Println("读取重命名日志失败: ", err)
+		return
+	}
+	if len(items) == 0 {
+		fmt.Println("重命名日志中没有记录")
+		return
+	}
+
+	activeUser := GetActiveUser()
+	successCount := 0
+	dirs := []string{}
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		b, e := activeUser.PanClient().FileRename(item.DriveId, item.FileId, item.OldName)
+		if e != nil || !b {
+			fmt.Printf("撤销重命名失败: %s -> %s, %v\n", path.Join(item.Dir, item.NewName), item.OldName, e)
+			continue
+		}
+		successCount++
+		dirs = append(dirs, item.Dir)
+		fmt.Printf("撤销重命名成功：%s -> %s\n", path.Join(item.Dir, item.NewName), item.OldName)
+	}
+	activeUser.DeleteCache(dirs)
+	fmt.Printf("\n撤销完成, 成功: %d, 失败: %d\n", successCount, len(items)-successCount)
+}
@@ -0,0 +1,418 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// SearchFilter 搜索条件，所有设置的条件都满足才会匹配
+	SearchFilter struct {
+		NamePattern   string         // 文件名通配符，不区分大小写
+		NameRegexp    *regexp.Regexp // 文件名正则表达式
+		Exts          []string       // 文件扩展名，不包含点，小写
+		Categories    []string       // 文件分类，例如 video, image, audio, doc, zip, others
+		MinSize       int64          // 最小文件大小，-1代表不限制
+		MaxSize       int64          // 最大文件大小，-1代表不限制
+		UpdatedAfter  time.Time      // 修改时间不早于该时间
+		UpdatedBefore time.Time      // 修改时间早于该时间
+		FileType      string         // 类型，file 或者 folder，为空代表不限制
+	}
+
+	// searchResultItem 搜索结果，用于 JSON 输出
+	searchResultItem struct {
+		FileId      string `json:"fileId"`
+		Path        string `json:"path"`
+		Type        string `json:"type"`
+		Size        int64  `json:"size"`
+		ContentHash string `json:"contentHash"`
+		Category    string `json:"category"`
+		CreatedAt   string `json:"createdAt"`
+		UpdatedAt   string `json:"updatedAt"`
+	}
+)
+
+const (
+	searchOutputTable = "table"
+	searchOutputJson  = "json"
+	searchOutputCsv   = "csv"
+)
+
+func CmdSearch() cli.Command {
+	return cli.Command{
+		Name:      "search",
+		Usage:     "搜索文件",
+		UsageText: cmder.App().Name + " search [条件] <目录>",
+		Description: `
+	递归搜索指定目录(默认为当前工作目录)内符合条件的文件和目录, 多个条件需要同时满足
+
+	示例:
+
+	搜索 /我的资源 内所有的 mp4 和 mkv 文件
+	aliyunpan search -ext mp4,mkv /我的资源
+
+	搜索文件名包含 合同 的文档
+	aliyunpan search -name "*合同*" -category doc /
+
+	使用正则表达式搜索文件名
+	aliyunpan search -regex "^IMG_\d+\.jpg$" /相册
+
+	搜索大于 1GB 且在 2022-01-01 之后修改的文件, 最多显示 100 个
+	aliyunpan search -type file -minsize 1GB -after 2022-01-01 -limit 100 /
+
+	以 JSON 或者 CSV 格式输出结果, 结果会边搜索边输出, 适合文件数量很多的目录
+	aliyunpan search -ext pdf -json / > result.json
+	aliyunpan search -ext pdf -csv / > result.csv
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+
+			filter, err := newSearchFilter(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			output := searchOutputTable
+			if c.Bool("json") {
+				output = searchOutputJson
+			} else if c.Bool("csv") {
+				output = searchOutputCsv
+			}
+			RunSearch(parseDriveId(c), c.Args().Get(0), filter, &SearchOptions{
+				Total:   c.Bool("l"),
+				Recurse: true,
+			}, output, c.Int("limit"))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "文件名通配符, 不区分大小写, 例如: *.mp4",
+			},
+			cli.StringFlag{
+				Name:  "regex",
+				Usage: "文件名正则表达式",
+			},
+			cli.StringFlag{
+				Name:  "ext",
+				Usage: "文件扩展名, 多个使用逗号分隔, 例如: mp4,mkv",
+			},
+			cli.StringFlag{
+				Name:  "category",
+				Usage: "文件分类, 多个使用逗号分隔, 支持: video,image,audio,doc,zip,app,others",
+			},
+			cli.StringFlag{
+				Name:  "minsize",
+				Usage: "最小文件大小, 例如: 100MB",
+			},
+			cli.StringFlag{
+				Name:  "maxsize",
+				Usage: "最大文件大小, 例如: 1GB",
+			},
+			cli.StringFlag{
+				Name:  "after",
+				Usage: "修改时间不早于, 格式: 2006-01-02 或者 2006-01-02 15:04:05",
+			},
+			cli.StringFlag{
+				Name:  "before",
+				Usage: "修改时间早于, 格式: 2006-01-02 或者 2006-01-02 15:04:05",
+			},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "类型, 支持: file,folder",
+			},
+			cli.IntFlag{
+				Name:  "limit",
+				Usage: "最多输出的结果数量, 0代表不限制",
+				Value: 0,
+			},
+			cli.BoolFlag{
+				Name:  "l",
+				Usage: "详细显示",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "以 JSON 格式输出",
+			},
+			cli.BoolFlag{
+				Name:  "csv",
+				Usage: "以 CSV 格式输出",
+			},
+		},
+	}
+}
+
+// splitSearchValues 分割逗号分隔的值，转为小写
+func splitSearchValues(value string, trimDot bool) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if trimDot {
+			v = strings.TrimPrefix(v, ".")
+		}
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseSearchTime 解析时间，支持 2006-01-02 和 2006-01-02 15:04:05 格式
+func parseSearchTime(value string) (time.Time, error) {
+	cz := time.FixedZone("CST", 8*3600) // 东8区，和云盘文件时间一致
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, cz); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("时间格式错误, 请使用 2006-01-02 或者 2006-01-02 15:04:05 格式: %s", value)
+}
+
+// newSearchFilter 根据命令行参数创建搜索条件
+func newSearchFilter(c *cli.Context) (*SearchFilter, error) {
+	var err error
+	filter := &SearchFilter{
+		NamePattern: strings.ToLower(c.String("name")),
+		Exts:        splitSearchValues(c.String("ext"), true),
+		Categories:  splitSearchValues(c.String("category"), false),
+		MinSize:     -1,
+		MaxSize:     -1,
+		FileType:    strings.ToLower(c.String("type")),
+	}
+	if filter.NamePattern != "" {
+		if _, err = path.Match(filter.NamePattern, ""); err != nil {
+			return nil, fmt.Errorf("文件名通配符格式错误: %s", c.String("name"))
+		}
+	}
+	if c.String("regex") != "" {
+		if filter.NameRegexp, err = regexp.Compile(c.String("regex")); err != nil {
+			return nil, fmt.Errorf("正则表达式格式错误: %s", err)
+		}
+	}
+	if c.String("minsize") != "" {
+		if filter.MinSize, err = converter.ParseFileSizeStr(c.String("minsize")); err != nil {
+			return nil, fmt.Errorf("文件大小格式错误: %s", c.String("minsize"))
+		}
+	}
+	if c.String("maxsize") != "" {
+		if filter.MaxSize, err = converter.ParseFileSizeStr(c.String("maxsize")); err != nil {
+			return nil, fmt.Errorf("文件大小格式错误: %s", c.String("maxsize"))
+		}
+	}
+	if c.String("after") != "" {
+		if filter.UpdatedAfter, err = parseSearchTime(c.String("after")); err != nil {
+			return nil, err
+		}
+	}
+	if c.String("before") != "" {
+		if filter.UpdatedBefore, err = parseSearchTime(c.String("before")); err != nil {
+			return nil, err
+		}
+	}
+	if filter.FileType != "" && filter.FileType != "file" && filter.FileType != "folder" {
+		return nil, fmt.Errorf("不支持的类型: %s", c.String("type"))
+	}
+	return filter, nil
+}
+
+// Match 文件是否符合搜索条件
+func (f *SearchFilter) Match(fe *aliyunpan.FileEntity) bool {
+	if fe == nil {
+		return false
+	}
+	switch f.FileType {
+	case "file":
+		if fe.IsFolder() {
+			return false
+		}
+	case "folder":
+		if !fe.IsFolder() {
+			return false
+		}
+	}
+
+	name := strings.ToLower(fe.FileName)
+	if f.NamePattern != "" {
+		if matched, _ := path.Match(f.NamePattern, name); !matched {
+			return false
+		}
+	}
+	if f.NameRegexp != nil && !f.NameRegexp.MatchString(fe.FileName) {
+		return false
+	}
+	if len(f.Exts) > 0 {
+		if fe.IsFolder() || !containsSearchValue(f.Exts, strings.TrimPrefix(path.Ext(name), ".")) {
+			return false
+		}
+	}
+	if len(f.Categories) > 0 {
+		if fe.IsFolder() || !containsSearchValue(f.Categories, strings.ToLower(fe.Category)) {
+			return false
+		}
+	}
+	if f.MinSize >= 0 || f.MaxSize >= 0 {
+		// 文件夹没有大小
+		if fe.IsFolder() {
+			return false
+		}
+		if f.MinSize >= 0 && fe.FileSize < f.MinSize {
+			return false
+		}
+		if f.MaxSize >= 0 && fe.FileSize > f.MaxSize {
+			return false
+		}
+	}
+	if !f.UpdatedAfter.IsZero() || !f.UpdatedBefore.IsZero() {
+		updatedAt := utils.ParseTimeStr(fe.UpdatedAt)
+		if !f.UpdatedAfter.IsZero() && updatedAt.Before(f.UpdatedAfter) {
+			return false
+		}
+		if !f.UpdatedBefore.IsZero() && !updatedAt.Before(f.UpdatedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsSearchValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newSearchResultItem(fe *aliyunpan.FileEntity) *searchResultItem {
+	item := &searchResultItem{
+		FileId:      fe.FileId,
+		Path:        fe.Path,
+		Type:        "file",
+		Size:        fe.FileSize,
+		ContentHash: fe.ContentHash,
+		Category:    fe.Category,
+		CreatedAt:   fe.CreatedAt,
+		UpdatedAt:   fe.UpdatedAt,
+	}
+	if fe.IsFolder() {
+		item.Type = "folder"
+		item.Size = 0
+	}
+	return item
+}
+
+// RunSearch 执行搜索。JSON 和 CSV 格式边搜索边输出，表格格式搜索完成后输出
+func RunSearch(driveId, targetPath string, filter *SearchFilter, searchOptions *SearchOptions, output string, limit int) {
+	activeUser := config.Config.ActiveUser()
+	targetPath = path.Clean(activeUser.PathJoin(driveId, targetPath))
+
+	var csvWriter *csv.Writer
+	switch output {
+	case searchOutputJson:
+		fmt.Println("[")
+	case searchOutputCsv:
+		csvWriter = csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"file_id", "path", "type", "size", "content_hash", "category", "created_at", "updated_at"})
+		csvWriter.Flush()
+	}
+
+	count := 0
+	fileList := aliyunpan.FileList{}
+	var searchErr error
+	activeUser.PanClient().FilesDirectoriesRecurseList(driveId, targetPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		// 已出错或者已达到数量上限则停止搜索. 目录的返回值会被忽略, 所以需要在这里检查
+		if searchErr != nil || (limit > 0 && count >= limit) {
+			return false
+		}
+		if apiError != nil {
+			searchErr = apiError
+			return false
+		}
+		if fd == nil || (depth == 0 && fd.IsFolder()) {
+			// 跳过搜索目录本身
+			return true
+		}
+		if !filter.Match(fd) {
+			return true
+		}
+
+		count++
+		switch output {
+		case searchOutputJson:
+			if count > 1 {
+				fmt.Println(",")
+			}
+			fmt.Print(utils.ObjectToJsonStr(newSearchResultItem(fd), false))
+		case searchOutputCsv:
+			item := newSearchResultItem(fd)
+			csvWriter.Write([]string{item.FileId, item.Path, item.Type, strconv.FormatInt(item.Size, 10), item.ContentHash, item.Category, item.CreatedAt, item.UpdatedAt})
+			csvWriter.Flush()
+		default:
+			fileList = append(fileList, fd)
+			fmt.Printf("\r已找到: %d", count)
+		}
+		return true
+	})
+
+	switch output {
+	case searchOutputJson:
+		if count > 0 {
+			fmt.Println()
+		}
+		fmt.Println("]")
+		if searchErr != nil {
+			fmt.Fprintf(os.Stderr, "搜索出错, 结果不完整: %s\n", searchErr)
+		}
+	case searchOutputCsv:
+		if searchErr != nil {
+			fmt.Fprintf(os.Stderr, "搜索出错, 结果不完整: %s\n", searchErr)
+		}
+	default:
+		fmt.Printf("\r")
+		if searchErr != nil {
+			fmt.Printf("搜索出错, 结果不完整: %s\n", searchErr)
+		}
+		if len(fileList) == 0 {
+			fmt.Println("没有符合条件的文件")
+			return
+		}
+		renderTable(opSearch, searchOptions.Total, targetPath, fileList)
+	}
+}
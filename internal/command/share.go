@@ -18,12 +18,11 @@ import (
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
 	"github.com/tickstep/aliyunpan/cmder"
-	"github.com/tickstep/aliyunpan/cmder/cmdtable"
 	"github.com/tickstep/aliyunpan/internal/config"
-	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/aliyunpan/internal/functions/pandownload"
 	"github.com/urfave/cli"
 	"os"
-	"strconv"
+	"path/filepath"
 	"time"
 )
 
@@ -125,21 +124,122 @@ func CmdShare() cli.Command {
 				Name:      "list",
 				Aliases:   []string{"l"},
 				Usage:     "列出已分享文件/目录",
-				UsageText: cmder.App().Name + " share list",
+				UsageText: cmder.App().Name + " share list [-expired|-active] [-path <文件/目录>] [-expiring <天数>] [-json|-csv]",
+				Description: `
+	列出当前账号的分享, 支持按照状态和分享的文件筛选, 以及导出为JSON或者CSV格式.
+	使用 -expiring 列出指定天数内即将过期的有效分享, 按照过期时间排序, 方便在分享失效前重新分享.
+
+示例:
+
+    列出已过期的分享
+	aliyunpan share list -expired
+
+    列出分享了 /我的资源 目录或者目录内文件的分享
+	aliyunpan share list -path /我的资源
+
+    列出7天内即将过期的分享
+	aliyunpan share list -expiring 7
+
+    导出所有分享为CSV文件
+	aliyunpan share list -csv > shares.csv
+`,
 				Action: func(c *cli.Context) error {
-					RunShareList()
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					status, err := shareListStatusFlag(c.Bool("expired"), c.Bool("active"))
+					if err != nil {
+						fmt.Println(err)
+						return nil
+					}
+					options := &ShareListOptions{
+						Status:       status,
+						ExpiringDays: -1,
+					}
+					if c.IsSet("expiring") {
+						if c.Int("expiring") < 0 {
+							fmt.Println("天数不能小于0")
+							return nil
+						}
+						options.ExpiringDays = c.Int("expiring")
+					}
+					if c.String("path") != "" {
+						if options.FileIds, err = shareFileIdsByPath(parseDriveId(c), c.String("path")); err != nil {
+							fmt.Println(err)
+							return nil
+						}
+					}
+					if c.Bool("json") {
+						options.Output = "json"
+					} else if c.Bool("csv") {
+						options.Output = "csv"
+					}
+					RunShareList(options)
 					return nil
 				},
 				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "driveId",
+						Usage: "网盘ID",
+						Value: "",
+					},
+					cli.BoolFlag{
+						Name:  "expired",
+						Usage: "只列出已过期的分享",
+					},
+					cli.BoolFlag{
+						Name:  "active",
+						Usage: "只列出有效的分享",
+					},
+					cli.StringFlag{
+						Name:  "path",
+						Usage: "只列出分享了该文件/目录, 或者目录内文件的分享",
+					},
+					cli.IntFlag{
+						Name:  "expiring",
+						Usage: "只列出指定天数内即将过期的有效分享",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "以JSON格式输出",
+					},
+					cli.BoolFlag{
+						Name:  "csv",
+						Usage: "以CSV格式输出",
+					},
 				},
 			},
 			{
-				Name:        "cancel",
-				Aliases:     []string{"c"},
-				Usage:       "取消分享文件/目录",
-				UsageText:   cmder.App().Name + " share cancel <shareid_1> <shareid_2> ...",
-				Description: `目前只支持通过分享id (shareid) 来取消分享.`,
+				Name:      "cancel",
+				Aliases:   []string{"c"},
+				Usage:     "取消分享文件/目录",
+				UsageText: cmder.App().Name + " share cancel [-expired|-all] [-y] <shareid_1> <shareid_2> ...",
+				Description: `
+	通过分享id (shareid) 来取消分享, 或者使用 -expired 取消所有已过期以及文件已删除的分享, 使用 -all 取消所有分享.
+	批量取消分享前会列出将要取消的分享并要求确认, 使用 -y 跳过确认.
+
+示例:
+
+    取消指定的分享
+	aliyunpan share cancel 6hbM1aS1Kdb
+
+    取消所有已过期的分享
+	aliyunpan share cancel -expired
+`,
 				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					if c.Bool("expired") || c.Bool("all") {
+						if c.NArg() > 0 {
+							fmt.Println("批量取消分享时不能指定分享id")
+							return nil
+						}
+						RunShareCancelByStatus(!c.Bool("all"), c.Bool("y"))
+						return nil
+					}
 					if c.NArg() < 1 {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
@@ -147,52 +247,226 @@ func CmdShare() cli.Command {
 					RunShareCancel(c.Args())
 					return nil
 				},
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "expired",
+						Usage: "取消所有已过期以及文件已删除的分享",
+					},
+					cli.BoolFlag{
+						Name:  "all",
+						Usage: "取消所有分享",
+					},
+					cli.BoolFlag{
+						Name:  "y",
+						Usage: "跳过确认",
+					},
+				},
+			},
+			{
+				Name:      "save",
+				Usage:     "保存他人分享的文件/目录到网盘",
+				UsageText: cmder.App().Name + " share save [-pwd <提取码>] [-path <分享内的路径>] <分享链接> [网盘目录]",
+				Description: `
+	将他人分享链接中的文件/目录保存到自己网盘的指定目录, 没有指定网盘目录则保存到当前工作目录.
+	默认保存分享的全部内容, 可以使用 -path 指定只保存分享内的部分文件/目录, 可以多次指定.
+	网盘目录存在同名文件时会自动重命名. 保存目录时由服务端在后台执行, 可能需要稍等片刻才能在网盘中看到全部文件.
+
+示例:
+
+    保存分享链接的全部内容到当前工作目录
+	aliyunpan share save https://www.aliyundrive.com/s/abcXYZ123
+
+    保存私密分享的全部内容到 /我的资源 目录
+	aliyunpan share save -pwd 2333 https://www.aliyundrive.com/s/abcXYZ123 /我的资源
+
+    只保存分享内的 docs/a.txt 文件和 pics 目录
+	aliyunpan share save -path docs/a.txt -path pics https://www.aliyundrive.com/s/abcXYZ123 /我的资源
+`,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunShareSave(parseDriveId(c), c.Args().Get(0), c.String("pwd"), c.StringSlice("path"), c.Args().Get(1))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "driveId",
+						Usage: "网盘ID",
+						Value: "",
+					},
+					cli.StringFlag{
+						Name:  "pwd",
+						Usage: "分享提取码",
+						Value: "",
+					},
+					cli.StringSliceFlag{
+						Name:  "path",
+						Usage: "只保存分享内指定的文件/目录, 可以多次指定",
+					},
+				},
+			},
+			{
+				Name:      "ls",
+				Usage:     "列出他人分享链接内的文件/目录",
+				UsageText: cmder.App().Name + " share ls [-pwd <提取码>] <分享链接> [分享内的目录]",
+				Description: `
+	浏览他人分享链接内的文件/目录, 不需要保存到自己的网盘.
+
+示例:
+
+    列出分享链接根目录的文件
+	aliyunpan share ls https://www.aliyundrive.com/s/abcXYZ123
+
+    列出私密分享内 docs 目录的文件
+	aliyunpan share ls -pwd 2333 https://www.aliyundrive.com/s/abcXYZ123 docs
+`,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 || c.NArg() > 2 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					RunShareLs(c.Args().Get(0), c.String("pwd"), c.Args().Get(1))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "pwd",
+						Usage: "分享提取码",
+						Value: "",
+					},
+				},
+			},
+			{
+				Name:      "download",
+				Aliases:   []string{"d"},
+				Usage:     "下载他人分享链接内的文件/目录",
+				UsageText: cmder.App().Name + " share download [-pwd <提取码>] [-saveto <本地目录>] <分享链接> [分享内的文件/目录1] [分享内的文件/目录2] ...",
+				Description: `
+	直接下载他人分享链接内的文件/目录到本地, 不需要保存到自己的网盘. 没有指定分享内的文件/目录则下载分享的全部内容.
+	下载的文件默认保存到下载保存目录, 和 download 命令一样支持多线程下载和断点续传, 下载中断后重新执行相同的命令即可继续下载.
+
+示例:
+
+    下载分享链接的全部内容
+	aliyunpan share download https://www.aliyundrive.com/s/abcXYZ123
+
+    下载私密分享内的 docs 目录, 并保存到 D:\Downloads
+	aliyunpan share download -pwd 2333 -saveto D:\Downloads https://www.aliyundrive.com/s/abcXYZ123 docs
+`,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					saveTo := ""
+					if c.String("saveto") != "" {
+						saveTo = filepath.Clean(c.String("saveto"))
+					}
+					RunShareDownload(c.Args().Get(0), c.String("pwd"), c.Args().Tail(), &DownloadOptions{
+						IsPrintStatus:        c.Bool("status"),
+						IsExecutedPermission: c.Bool("x"),
+						IsOverwrite:          c.Bool("ow"),
+						SaveTo:               saveTo,
+						Parallel:             c.Int("p"),
+						MaxRetry:             c.Int("retry"),
+						ShowProgress:         !c.Bool("np"),
+					})
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "pwd",
+						Usage: "分享提取码",
+						Value: "",
+					},
+					cli.StringFlag{
+						Name:  "saveto",
+						Usage: "将下载的文件直接保存到指定的目录",
+					},
+					cli.BoolFlag{
+						Name:  "ow",
+						Usage: "overwrite, 覆盖已存在的文件",
+					},
+					cli.BoolFlag{
+						Name:  "status",
+						Usage: "输出所有线程的工作状态",
+					},
+					cli.BoolFlag{
+						Name:  "x",
+						Usage: "为文件加上执行权限, (windows系统无效)",
+					},
+					cli.IntFlag{
+						Name:  "p",
+						Usage: "指定同时进行下载文件的数量（取值范围:1 ~ 20）",
+					},
+					cli.IntFlag{
+						Name:  "retry",
+						Usage: "下载失败最大重试次数",
+						Value: pandownload.DefaultDownloadMaxRetry,
+					},
+					cli.BoolFlag{
+						Name:  "np",
+						Usage: "no progress 不展示下载进度条",
+					},
+				},
+			},
+			{
+				Name:      "mc",
+				Aliases:   []string{},
+				Usage:     "创建秒传链接",
+				UsageText: cmder.App().Name + " share mc <文件/目录1> <文件/目录2> ...",
+				Description: `
+创建文件秒传链接，秒传链接只能是文件，如果是文件夹则会创建文件夹包含的所有文件的秒传链接。秒传链接可以通过RapidUpload命令或者Import命令进行导入到自己的网盘。
+秒传链接为 JSON Lines 格式，第一行是文件头，之后每一行是一个文件的秒传链接，格式和 export 命令导出的文件相同。
+可以将输出重定向保存到文件，然后使用 import 命令导入。
+
+示例:
+    创建文件 1.mp4 的秒传链接
+	aliyunpan share mc 1.mp4
+
+    创建文件 1.mp4 的秒传链接，但链接隐藏相对路径
+	aliyunpan share mc -hp 1.mp4
+
+    创建文件夹 share_folder 下面所有文件的秒传链接
+	aliyunpan share mc share_folder/
+
+    创建文件夹 share_folder 下面所有文件的秒传链接，并保存到文件 mc.jsonl
+	aliyunpan share mc share_folder/ > mc.jsonl
+`,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunShareMc(parseDriveId(c), c.Bool("hide-path"), c.Args())
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "driveId",
+						Usage: "网盘ID",
+						Value: "",
+					},
+					cli.BoolFlag{
+						Name:  "hide-path, hp",
+						Usage: "hide path, 隐藏相对目录",
+					},
+				},
 			},
-//			{
-//				Name:      "mc",
-//				Aliases:   []string{},
-//				Usage:     "创建秒传链接",
-//				UsageText: cmder.App().Name + " share mc <文件/目录1> <文件/目录2> ...",
-//				Description: `
-//创建文件秒传链接，秒传链接只能是文件，如果是文件夹则会创建文件夹包含的所有文件的秒传链接。秒传链接可以通过RapidUpload命令或者Import命令进行导入到自己的网盘。
-//示例:
-//    创建文件 1.mp4 的秒传链接
-//	aliyunpan share mc 1.mp4
-//
-//    创建文件 1.mp4 的秒传链接，但链接隐藏相对路径
-//	aliyunpan share mc -hp 1.mp4
-//
-//    创建文件夹 share_folder 下面所有文件的秒传链接
-//	aliyunpan share mc share_folder/
-//`,
-//				Action: func(c *cli.Context) error {
-//					if c.NArg() < 1 {
-//						cli.ShowCommandHelp(c, c.Command.Name)
-//						return nil
-//					}
-//					if config.Config.ActiveUser() == nil {
-//						fmt.Println("未登录账号")
-//						return nil
-//					}
-//					hp := false
-//					if c.IsSet("hp") {
-//						hp = c.Bool("hp")
-//					}
-//					RunShareMc(parseDriveId(c), hp, c.Args())
-//					return nil
-//				},
-//				Flags: []cli.Flag{
-//					cli.StringFlag{
-//						Name:  "driveId",
-//						Usage: "网盘ID",
-//						Value: "",
-//					},
-//					cli.BoolFlag{
-//						Name:  "hp",
-//						Usage: "hide path, 隐藏相对目录",
-//					},
-//				},
-//			},
 		},
 	}
 }
@@ -241,44 +515,6 @@ func RunShareSet(driveId string, paths []string, expiredTime string, sharePwd st
 
 }
 
-// RunShareList 执行列出分享列表
-func RunShareList() {
-	activeUser := GetActiveUser()
-	records, err := activeUser.PanClient().ShareLinkList(activeUser.UserId)
-	if err != nil {
-		fmt.Printf("获取分享列表失败: %s\n", err)
-		return
-	}
-
-	tb := cmdtable.NewTable(os.Stdout)
-	tb.SetHeader([]string{"#", "ShARE_ID", "分享链接", "提取码", "文件名", "FILE_ID", "过期时间", "状态"})
-	now := time.Now()
-	for k, record := range records {
-		et := "永久有效"
-		if len(record.Expiration) > 0 {
-			et = record.Expiration
-		}
-		status := "有效"
-		if record.FirstFile == nil {
-			status = "已删除"
-		} else {
-			cz := time.FixedZone("CST", 8*3600)
-			if len(record.Expiration) > 0 {
-				expiredTime, _ := time.ParseInLocation("2006-01-02 15:04:05", record.Expiration, cz)
-				if expiredTime.Unix() < now.Unix() {
-					status = "已过期"
-				}
-			}
-		}
-		tb.Append([]string{strconv.Itoa(k), record.ShareId, record.ShareUrl, record.SharePwd,
-			record.ShareName,
-			record.FileIdList[0],
-			et,
-			status})
-	}
-	tb.Render()
-}
-
 // RunShareCancel 执行取消分享
 func RunShareCancel(shareIdList []string) {
 	if len(shareIdList) == 0 {
@@ -302,33 +538,7 @@ func RunShareCancel(shareIdList []string) {
 
 // 创建秒传链接
 func RunShareMc(driveId string, hideRelativePath bool, panPaths []string) {
-	activeUser := config.Config.ActiveUser()
-	panClient := activeUser.PanClient()
-
-	totalCount := 0
-	for _,panPath := range panPaths {
-		panPath = activeUser.PathJoin(driveId, panPath)
-		panClient.FilesDirectoriesRecurseList(driveId, panPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
-			if apiError != nil {
-				logger.Verbosef("%s\n", apiError)
-				return true
-			}
-
-			// 只需要文件即可
-			if !fd.IsFolder() {
-				item := newRapidUploadItemFromFileEntity(fd)
-				jstr := item.createRapidUploadLink(hideRelativePath)
-				if len(jstr) <= 0 {
-					logger.Verboseln("create rapid upload link err")
-					return false
-				}
-				// print
-				fmt.Println(jstr)
-				totalCount += 1
-				time.Sleep(time.Duration(100) * time.Millisecond)
-			}
-			return true
-		})
-	}
-	fmt.Printf("\n秒传链接总数量: %d\n", totalCount)
+	// 秒传链接输出到 stdout，统计信息输出到 stderr，方便重定向保存
+	totalCount := writeRapidUploadEntries(os.Stdout, driveId, panPaths, hideRelativePath, nil)
+	fmt.Fprintf(os.Stderr, "\n秒传链接总数量: %d\n", totalCount)
 }
\ No newline at end of file
@@ -0,0 +1,351 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/functions/pandownload"
+	"github.com/tickstep/aliyunpan/internal/functions/panshare"
+	"github.com/tickstep/aliyunpan/internal/taskframework"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/requester/rio/speeds"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// shareLinkSession 打开的他人分享链接
+	shareLinkSession struct {
+		helper     *panshare.ShareHelper
+		info       *panshare.ShareInfo
+		token      *panshare.ShareToken
+		sharePwd   string
+		rootFileId string // 分享链接指向的文件夹ID
+		tokenMutex sync.Mutex
+	}
+)
+
+// openShareLink 解析分享链接并获取分享令牌
+func openShareLink(shareUrl, sharePwd string) (*shareLinkSession, error) {
+	shareId, folderId, err := panshare.ParseShareUrl(shareUrl)
+	if err != nil {
+		return nil, err
+	}
+	accessToken := ""
+	if activeUser := config.Config.ActiveUser(); activeUser != nil {
+		accessToken = activeUser.PanClient().GetAccessToken()
+	}
+	helper := panshare.NewShareHelper(panshare.DefaultApiHost, accessToken)
+	info, err := helper.GetShareInfo(shareId)
+	if err != nil {
+		return nil, fmt.Errorf("获取分享信息失败: %s", err)
+	}
+	token, err := helper.GetShareToken(shareId, sharePwd)
+	if err != nil {
+		if sharePwd == "" {
+			return nil, fmt.Errorf("获取分享令牌失败, 私密分享请使用 -pwd 指定提取码: %s", err)
+		}
+		return nil, fmt.Errorf("获取分享令牌失败: %s", err)
+	}
+	return &shareLinkSession{
+		helper:     helper,
+		info:       info,
+		token:      token,
+		sharePwd:   sharePwd,
+		rootFileId: folderId,
+	}, nil
+}
+
+// downloadUrl 获取分享内文件的下载链接, 分享令牌过期则重新获取令牌
+func (s *shareLinkSession) downloadUrl(fileId string) (string, error) {
+	s.tokenMutex.Lock()
+	token := s.token
+	s.tokenMutex.Unlock()
+	durl, err := s.helper.GetDownloadUrl(token, fileId)
+	if err == nil {
+		return durl, nil
+	}
+
+	s.tokenMutex.Lock()
+	if s.token == token {
+		newToken, er := s.helper.GetShareToken(token.ShareId, s.sharePwd)
+		if er != nil {
+			s.tokenMutex.Unlock()
+			return "", err
+		}
+		s.token = newToken
+	}
+	token = s.token
+	s.tokenMutex.Unlock()
+	return s.helper.GetDownloadUrl(token, fileId)
+}
+
+// selectFiles 获取分享内指定路径的文件，没有指定路径则返回分享链接指向文件夹下的全部文件
+func (s *shareLinkSession) selectFiles(sharePaths []string) (panshare.ShareFileList, error) {
+	if len(sharePaths) == 0 {
+		files, err := s.helper.FileList(s.token, s.rootFileId)
+		if err != nil {
+			return nil, fmt.Errorf("获取分享文件列表失败: %s", err)
+		}
+		for _, f := range files {
+			f.Path = path.Join("/", f.FileName)
+		}
+		return files, nil
+	}
+	files := panshare.ShareFileList{}
+	for _, p := range sharePaths {
+		f, err := s.helper.FileInfoByPath(s.token, s.rootFileId, p)
+		if err != nil {
+			return nil, err
+		}
+		if f.FileId == s.rootFileId {
+			// 选择了分享根目录，等同于全部文件
+			return s.selectFiles(nil)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// RunShareSave 保存他人分享的文件到网盘
+func RunShareSave(driveId, shareUrl, sharePwd string, sharePaths []string, targetDir string) {
+	activeUser := config.Config.ActiveUser()
+	targetPath := activeUser.PathJoin(driveId, targetDir)
+	targetFolder, apierr := activeUser.PanClient().FileInfoByPath(driveId, targetPath)
+	if apierr != nil || !targetFolder.IsFolder() {
+		fmt.Printf("网盘目录不存在: %s\n", targetPath)
+		return
+	}
+
+	session, err := openShareLink(shareUrl, sharePwd)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("分享: %s, 分享者: %s\n", session.info.ShareName, session.info.CreatorName)
+
+	files, err := session.selectFiles(sharePaths)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Println("没有需要保存的文件")
+		return
+	}
+
+	fileIds := []string{}
+	for _, f := range files {
+		fileIds = append(fileIds, f.FileId)
+	}
+	results, err := session.helper.SaveToDrive(session.token, fileIds, driveId, targetFolder.FileId)
+	if len(results) > 0 {
+		activeUser.DeleteCache([]string{targetPath})
+	}
+
+	resultMap := map[string]*panshare.SaveResult{}
+	for _, r := range results {
+		resultMap[r.FileId] = r
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "文件(目录)", "结果"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	successCount := 0
+	for k, f := range files {
+		name := f.Path
+		if f.IsFolder() {
+			name += "/"
+		}
+		status := "未保存"
+		if r, ok := resultMap[f.FileId]; ok {
+			if r.Success {
+				successCount++
+				status = "成功"
+				if r.AsyncTaskId != "" {
+					status = "成功(后台保存中)"
+				}
+			} else {
+				status = "失败: " + r.Error
+			}
+		}
+		tb.Append([]string{strconv.Itoa(k + 1), name, status})
+	}
+	tb.Render()
+	if err != nil {
+		fmt.Printf("保存分享文件出错: %s\n", err)
+	}
+	fmt.Printf("已保存 %d 个文件(目录)到: %s\n", successCount, targetPath)
+}
+
+// shareFileEntity 转换分享内的文件为网盘文件结构, 用于下载
+func shareFileEntity(f *panshare.ShareFileEntity) *aliyunpan.FileEntity {
+	return &aliyunpan.FileEntity{
+		DriveId:       f.DriveId,
+		FileId:        f.FileId,
+		ParentFileId:  f.ParentFileId,
+		FileName:      f.FileName,
+		FileType:      f.FileType,
+		FileSize:      f.FileSize,
+		FileExtension: f.FileExtension,
+		Category:      f.Category,
+		CreatedAt:     f.CreatedAt,
+		UpdatedAt:     f.UpdatedAt,
+		Path:          f.Path,
+	}
+}
+
+// RunShareLs 列出他人分享链接内的文件
+func RunShareLs(shareUrl, sharePwd, sharePath string) {
+	session, err := openShareLink(shareUrl, sharePwd)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	folder, err := session.helper.FileInfoByPath(session.token, session.rootFileId, sharePath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	files := panshare.ShareFileList{folder}
+	if folder.IsFolder() {
+		if files, err = session.helper.FileList(session.token, folder.FileId); err != nil {
+			fmt.Printf("获取分享文件列表失败: %s\n", err)
+			return
+		}
+	}
+
+	fmt.Printf("分享: %s, 分享者: %s, 当前目录: %s\n", session.info.ShareName, session.info.CreatorName, path.Clean("/"+sharePath))
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "文件大小", "修改日期", "文件(目录)"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	var totalSize int64
+	fN, dN := 0, 0
+	for k, f := range files {
+		if f.IsFolder() {
+			dN++
+			tb.Append([]string{strconv.Itoa(k), "-", f.UpdatedAt, f.FileName + "/"})
+			continue
+		}
+		fN++
+		totalSize += f.FileSize
+		tb.Append([]string{strconv.Itoa(k), converter.ConvertFileSize(f.FileSize, 2), f.UpdatedAt, f.FileName})
+	}
+	tb.Append([]string{"", "总: " + converter.ConvertFileSize(totalSize, 2), "", fmt.Sprintf("文件总数: %d, 目录总数: %d", fN, dN)})
+	tb.Render()
+}
+
+// RunShareDownload 下载他人分享链接内的文件到本地
+func RunShareDownload(shareUrl, sharePwd string, sharePaths []string, options *DownloadOptions) {
+	activeUser := GetActiveUser()
+	startDownloadTokenChecker(activeUser)
+	options = fixDownloadOptions(options)
+
+	session, err := openShareLink(shareUrl, sharePwd)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("分享: %s, 分享者: %s\n", session.info.ShareName, session.info.CreatorName)
+	selected, err := session.selectFiles(sharePaths)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	saveRoot := options.SaveTo
+	if saveRoot == "" {
+		saveRoot = activeUser.GetSavePath("")
+	}
+	cfg := newDownloadConfig(options)
+	var (
+		executor = taskframework.TaskExecutor{
+			IsFailedDeque: true, // 统计失败的列表
+		}
+		statistic        = &pandownload.DownloadStatistic{}
+		globalSpeedsStat = &speeds.Speeds{}
+	)
+	executor.SetParallel(cfg.MaxParallel)
+	downloadUrlFunc := func(_, fileId string) (string, string, error) {
+		durl, err := session.downloadUrl(fileId)
+		return durl, durl, err
+	}
+
+	for _, item := range selected {
+		// 选中的文件(目录)保存在本地保存目录下, 目录内的文件保持相对路径
+		files := panshare.ShareFileList{item}
+		if item.IsFolder() {
+			subFiles, err := session.helper.RecurseList(session.token, item)
+			if err != nil {
+				fmt.Printf("获取分享目录 %s 的文件列表失败: %s\n", item.Path, err)
+				continue
+			}
+			files = append(files, subFiles...)
+		}
+		for _, f := range files {
+			savePath := filepath.Join(saveRoot, strings.TrimPrefix(f.Path, path.Dir(item.Path)))
+			if f.IsFolder() {
+				// 首先在本地创建目录, 保证空目录也能被保存
+				os.MkdirAll(savePath, 0777)
+				continue
+			}
+			newCfg := *cfg
+			unit := &pandownload.DownloadTaskUnit{
+				Cfg:                  &newCfg, // 复制一份新的cfg
+				PanClient:            activeUser.PanClient(),
+				VerbosePrinter:       panCommandVerbose,
+				PrintFormat:          downloadPrintFormat(options.Load),
+				ParentTaskExecutor:   &executor,
+				DownloadStatistic:    statistic,
+				IsPrintStatus:        options.IsPrintStatus,
+				IsExecutedPermission: options.IsExecutedPermission,
+				IsOverwrite:          options.IsOverwrite,
+				NoCheck:              options.NoCheck,
+				DownloadUrlFunc:      downloadUrlFunc,
+				FilePanPath:          f.Path,
+				SavePath:             savePath,
+				OriginSaveRootPath:   saveRoot,
+				DriveId:              f.DriveId,
+				GlobalSpeedsStat:     globalSpeedsStat,
+			}
+			unit.SetFileInfo(shareFileEntity(f))
+			info := executor.Append(unit, options.MaxRetry)
+			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), f.Path)
+		}
+	}
+
+	statistic.StartTimer()
+	executor.Execute()
+	fmt.Printf("\n下载结束, 时间: %s, 数据总量: %s\n", utils.ConvertTime(statistic.Elapsed()), converter.ConvertFileSize(statistic.TotalSize(), 2))
+
+	// 输出失败的文件列表
+	failedList := executor.FailedDeque()
+	if failedList.Size() != 0 {
+		fmt.Printf("以下文件下载失败, 重新执行相同的命令可以继续下载: \n")
+		tb := cmdtable.NewTable(os.Stdout)
+		for e := failedList.Shift(); e != nil; e = failedList.Shift() {
+			item := e.(*taskframework.TaskInfoItem)
+			tb.Append([]string{item.Info.Id(), item.Unit.(*pandownload.DownloadTaskUnit).FilePanPath})
+		}
+		tb.Render()
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/cmder/cmdliner"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ShareRecord 分享记录
+	ShareRecord struct {
+		ShareId    string   `json:"shareId"`
+		ShareName  string   `json:"shareName"`
+		ShareUrl   string   `json:"shareUrl"`
+		SharePwd   string   `json:"sharePwd"`
+		FileIdList []string `json:"fileIdList"`
+		Expiration string   `json:"expiration"` // 过期时间，为空表示永久有效
+		Status     string   `json:"status"`
+	}
+	ShareRecordList []*ShareRecord
+
+	// ShareListOptions 列出分享可选项
+	ShareListOptions struct {
+		Status       string          // 只列出指定状态的分享，为空则列出全部
+		FileIds      map[string]bool // 只列出分享了这些文件的分享，为nil则不限制
+		ExpiringDays int             // 只列出指定天数内即将过期的分享，小于0则不限制
+		Output       string          // 输出格式：json, csv，为空则输出表格
+	}
+)
+
+const (
+	// ShareStatusActive 有效
+	ShareStatusActive = "active"
+	// ShareStatusExpired 已过期
+	ShareStatusExpired = "expired"
+	// ShareStatusDeleted 分享的文件已删除
+	ShareStatusDeleted = "deleted"
+)
+
+var (
+	shareStatusNames = map[string]string{
+		ShareStatusActive:  "有效",
+		ShareStatusExpired: "已过期",
+		ShareStatusDeleted: "已删除",
+	}
+)
+
+// shareRecordStatus 计算分享的状态
+func shareRecordStatus(expiration string, fileDeleted bool, now time.Time) string {
+	if fileDeleted {
+		return ShareStatusDeleted
+	}
+	if expiration != "" && utils.ParseTimeStr(expiration).Unix() < now.Unix() {
+		return ShareStatusExpired
+	}
+	return ShareStatusActive
+}
+
+// ExpiresIn 距离过期的剩余时间，永久有效的分享返回false
+func (r *ShareRecord) ExpiresIn(now time.Time) (time.Duration, bool) {
+	if r.Expiration == "" {
+		return 0, false
+	}
+	return utils.ParseTimeStr(r.Expiration).Sub(now), true
+}
+
+// Match 分享是否符合筛选条件
+func (o *ShareListOptions) Match(r *ShareRecord, now time.Time) bool {
+	if o.Status != "" && r.Status != o.Status {
+		return false
+	}
+	if o.FileIds != nil {
+		found := false
+		for _, fileId := range r.FileIdList {
+			if o.FileIds[fileId] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.ExpiringDays >= 0 {
+		left, ok := r.ExpiresIn(now)
+		if !ok || r.Status != ShareStatusActive || left > time.Duration(o.ExpiringDays)*24*time.Hour {
+			return false
+		}
+	}
+	return true
+}
+
+// getShareRecords 获取当前账号的所有分享记录
+func getShareRecords() (ShareRecordList, error) {
+	activeUser := GetActiveUser()
+	records, err := activeUser.PanClient().ShareLinkList(activeUser.UserId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := ShareRecordList{}
+	for _, record := range records {
+		result = append(result, &ShareRecord{
+			ShareId:    record.ShareId,
+			ShareName:  record.ShareName,
+			ShareUrl:   record.ShareUrl,
+			SharePwd:   record.SharePwd,
+			FileIdList: record.FileIdList,
+			Expiration: record.Expiration,
+			Status:     shareRecordStatus(record.Expiration, record.FirstFile == nil, now),
+		})
+	}
+	return result, nil
+}
+
+// shareFileIdsByPath 获取网盘路径下的所有文件ID，包含路径本身，用于按路径筛选分享
+func shareFileIdsByPath(driveId, panPath string) (map[string]bool, error) {
+	activeUser := GetActiveUser()
+	panPath = activeUser.PathJoin(driveId, panPath)
+	fileIds := map[string]bool{}
+	var walkErr error
+	activeUser.PanClient().FilesDirectoriesRecurseList(driveId, panPath, func(depth int, _ string, fd *aliyunpan.FileEntity, apiError *apierror.ApiError) bool {
+		if apiError != nil {
+			logger.Verbosef("%s\n", apiError)
+			if depth == 0 {
+				walkErr = fmt.Errorf("文件不存在: %s", panPath)
+				return false
+			}
+			return true
+		}
+		if fd != nil {
+			fileIds[fd.FileId] = true
+		}
+		return true
+	})
+	return fileIds, walkErr
+}
+
+// RunShareList 执行列出分享列表
+func RunShareList(options *ShareListOptions) {
+	records, err := getShareRecords()
+	if err != nil {
+		fmt.Printf("获取分享列表失败: %s\n", err)
+		return
+	}
+
+	now := time.Now()
+	filtered := ShareRecordList{}
+	for _, r := range records {
+		if options.Match(r, now) {
+			filtered = append(filtered, r)
+		}
+	}
+	if options.ExpiringDays >= 0 {
+		// 即将过期的排在前面
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Expiration < filtered[j].Expiration
+		})
+	}
+
+	switch options.Output {
+	case "json":
+		fmt.Println(utils.ObjectToJsonStr(filtered, true))
+		return
+	case "csv":
+		csvWriter := csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"share_id", "share_name", "share_url", "share_pwd", "file_ids", "expiration", "status"})
+		for _, r := range filtered {
+			csvWriter.Write([]string{r.ShareId, r.ShareName, r.ShareUrl, r.SharePwd, strings.Join(r.FileIdList, ";"), r.Expiration, r.Status})
+		}
+		csvWriter.Flush()
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	if options.ExpiringDays >= 0 {
+		tb.SetHeader([]string{"#", "SHARE_ID", "分享链接", "提取码", "文件名", "过期时间", "剩余时间"})
+		for k, r := range filtered {
+			left, _ := r.ExpiresIn(now)
+			tb.Append([]string{strconv.Itoa(k), r.ShareId, r.ShareUrl, r.SharePwd, r.ShareName, r.Expiration, utils.ConvertTime(left)})
+		}
+		tb.Render()
+		fmt.Printf("%d 天内即将过期的分享: %d\n", options.ExpiringDays, len(filtered))
+		return
+	}
+
+	tb.SetHeader([]string{"#", "SHARE_ID", "分享链接", "提取码", "文件名", "FILE_ID", "过期时间", "状态"})
+	for k, r := range filtered {
+		et := "永久有效"
+		if len(r.Expiration) > 0 {
+			et = r.Expiration
+		}
+		fileId := ""
+		if len(r.FileIdList) > 0 {
+			fileId = r.FileIdList[0]
+		}
+		tb.Append([]string{strconv.Itoa(k), r.ShareId, r.ShareUrl, r.SharePwd, r.ShareName, fileId, et, shareStatusNames[r.Status]})
+	}
+	tb.Render()
+}
+
+// RunShareCancelByStatus 批量取消分享，onlyExpired 为true则只取消已过期和文件已删除的分享
+func RunShareCancelByStatus(onlyExpired bool, yes bool) {
+	records, err := getShareRecords()
+	if err != nil {
+		fmt.Printf("获取分享列表失败: %s\n", err)
+		return
+	}
+	cancelList := ShareRecordList{}
+	for _, r := range records {
+		if !onlyExpired || r.Status != ShareStatusActive {
+			cancelList = append(cancelList, r)
+		}
+	}
+	if len(cancelList) == 0 {
+		fmt.Println("没有需要取消的分享")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "SHARE_ID", "分享链接", "文件名", "过期时间", "状态"})
+	shareIdList := []string{}
+	for k, r := range cancelList {
+		tb.Append([]string{strconv.Itoa(k), r.ShareId, r.ShareUrl, r.ShareName, r.Expiration, shareStatusNames[r.Status]})
+		shareIdList = append(shareIdList, r.ShareId)
+	}
+	tb.Render()
+
+	if !yes {
+		line := cmdliner.NewLiner()
+		input, err := line.State.Prompt(fmt.Sprintf("确认取消以上 %d 个分享? [y/N] ", len(cancelList)))
+		line.Close()
+		if err != nil || strings.ToLower(strings.TrimSpace(input)) != "y" {
+			fmt.Println("已放弃取消分享")
+			return
+		}
+	}
+	RunShareCancel(shareIdList)
+}
+
+// shareListStatusFlag 解析分享状态筛选参数
+func shareListStatusFlag(expired, active bool) (string, error) {
+	if expired && active {
+		return "", fmt.Errorf("不能同时指定 -expired 和 -active")
+	}
+	if expired {
+		return ShareStatusExpired, nil
+	}
+	if active {
+		return ShareStatusActive, nil
+	}
+	return "", nil
+}
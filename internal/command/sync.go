@@ -15,16 +15,20 @@ package command
 
 import (
 	"fmt"
+	"github.com/olekukonko/tablewriter"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
 	"github.com/tickstep/aliyunpan/internal/config"
 	"github.com/tickstep/aliyunpan/internal/syncdrive"
 	"github.com/tickstep/aliyunpan/internal/utils"
 	"github.com/tickstep/library-go/converter"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -48,6 +52,9 @@ func CmdSync() cli.Command {
 
 	请输入以下命令查看如何配置和启动：
     aliyunpan sync start -h
+
+	请输入以下命令查看如何管理同步任务：
+    aliyunpan sync task -h
 `,
 		Category: "阿里云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -80,6 +87,26 @@ name - 任务名称
 localFolderPath - 本地目录
 panFolderPath - 网盘目录
 mode - 模式，支持三种: upload(备份本地文件到云盘),download(备份云盘文件到本地),sync(双向同步备份)
+conflictPolicy - 双向同步(sync)模式下的冲突处理策略，可选，默认为 newer-wins，支持以下几种:
+    newer-wins(修改时间较新的文件覆盖另一方), keep-both(保留双方，本地文件重命名为冲突副本),
+    local-wins(以本地文件为准), pan-wins(以云盘文件为准), ask(不处理，只记录冲突，等待手动处理)
+localScanMode - 本地文件扫描模式，可选，默认为 scan，支持以下两种:
+    scan(定时全量扫描本地目录), watch(监听文件系统变更事件，只处理有变更的文件，适合文件数量巨大的目录。系统不支持时自动退回到 scan 模式)
+localFullScanInterval - watch模式下全量扫描的间隔，作为遗漏变更的兜底，单位分钟，可选，默认为60
+deleteThresholdCount - 大量删除保护，一轮同步最多允许删除的文件数量，可选，默认为1000，-1代表不限制
+deleteThresholdPercent - 大量删除保护，一轮同步最多允许删除的文件百分比，可选，默认为50，-1代表不限制
+    超过阈值时所有删除操作都会被阻止，需要使用 sync approve 命令确认后才会执行
+versioning - 历史版本，只对upload模式有效，可选，默认不开启。开启后被覆盖或者删除的云盘文件会移动到云盘同步目录下的
+    .versions/<相对路径>/<时间戳> 而不是回收站，可以使用 sync versions 命令查看和恢复。包括以下字段:
+    enable(是否开启), keepLast(保留最新的N个版本), keepDays(最近D天每天保留一个版本)，都为0则保留全部版本
+    例如: "versioning": {"enable": true, "keepLast": 10, "keepDays": 30}
+exclude - 排除文件的规则列表，可选，语法和 .gitignore 一致，规则中的路径相对于同步目录。支持 * ? [abc] 通配符、
+    ** 匹配任意层级的文件夹、! 开头重新包含之前被排除的文件、以 / 结尾只匹配文件夹。本地和云盘文件都会被过滤
+    例如: "exclude": ["*.tmp", "node_modules/", "/build/**", "!important.tmp"]
+include - 重新包含文件的规则列表，可选，优先级高于 exclude 规则以及 .aliyunpanignore 文件。
+    注意和 .gitignore 一样，被排除的文件夹中的文件无法被重新包含
+    本地同步目录以及子目录中的 .aliyunpanignore 文件同样作为排除规则，规则相对于文件所在的目录，子目录中的规则优先级更高
+disabled - 是否禁用该任务，可选，默认为false。可以使用 sync task 命令管理同步任务
     
 	例子:
 	1. 查看帮助
@@ -101,6 +128,15 @@ mode - 模式，支持三种: upload(备份本地文件到云盘),download(备
 	6. 使用配置文件启动同步备份服务，并配置下载并发为2，上传并发为1，下载分片大小为256KB，上传分片大小为1MB
 	aliyunpan sync start -dp 2 -up 1 -dbs 256 -ubs 1024
 
+	7. 使用配置文件进行单次同步，所有任务同步完成后退出，适合在定时任务(cron)中使用
+	aliyunpan sync start -once
+
+	8. 使用命令行配置启动双向同步备份服务，文件冲突时保留双方文件
+	aliyunpan sync start -ldir "D:\tickstep\Documents\设计文档" -pdir "/sync_drive/我的文档" -mode "sync" -conflict "keep-both"
+
+	9. 使用命令行配置启动同步备份服务，排除临时文件以及 node_modules 文件夹
+	aliyunpan sync start -ldir "D:\tickstep\Documents\设计文档" -pdir "/sync_drive/我的文档" -exclude "*.tmp" -exclude "node_modules/"
+
 `,
 				Action: func(c *cli.Context) error {
 					if config.Config.ActiveUser() == nil {
@@ -136,38 +172,15 @@ mode - 模式，支持三种: upload(备份本地文件到云盘),download(备
 						uploadBlockSize = aliyunpan.DefaultChunkSize
 					}
 
-					var task *syncdrive.SyncTask
-					localDir := c.String("ldir")
-					panDir := c.String("pdir")
-					mode := c.String("mode")
-					if localDir != "" && panDir != "" {
-						//if b, e := utils.PathExists(localDir); e == nil {
-						//	if !b {
-						//		fmt.Println("本地文件夹不存在：", localDir)
-						//		return nil
-						//	}
-						//} else {
-						//	fmt.Println("本地文件夹不存在：", localDir)
-						//	return nil
-						//}
-						task = &syncdrive.SyncTask{}
-						task.LocalFolderPath = path.Clean(strings.ReplaceAll(localDir, "\\", "/"))
-						task.PanFolderPath = panDir
-						task.Mode = syncdrive.UploadOnly
-						if mode == string(syncdrive.UploadOnly) {
-							task.Mode = syncdrive.UploadOnly
-						} else if mode == string(syncdrive.DownloadOnly) {
-							task.Mode = syncdrive.DownloadOnly
-						} else if mode == string(syncdrive.SyncTwoWay) {
-							task.Mode = syncdrive.SyncTwoWay
-						} else {
-							task.Mode = syncdrive.UploadOnly
-						}
-						task.Name = path.Base(task.LocalFolderPath)
-						task.Id = utils.Md5Str(task.LocalFolderPath)
+					task, e := newSyncTaskFromContext(c)
+					if e != nil {
+						fmt.Println(e)
+						return nil
 					}
 
-					RunSync(task, dp, up, downloadBlockSize, uploadBlockSize)
+					if !RunSync(task, dp, up, downloadBlockSize, uploadBlockSize, c.Bool("once")) {
+						return exitCodeError(1)
+					}
 					return nil
 				},
 				Flags: []cli.Flag{
@@ -184,6 +197,19 @@ mode - 模式，支持三种: upload(备份本地文件到云盘),download(备
 						Usage: "备份模式, 支持三种: upload(备份本地文件到云盘),download(备份云盘文件到本地),sync(双向同步备份)",
 						Value: "upload",
 					},
+					cli.StringFlag{
+						Name:  "conflict",
+						Usage: "双向同步冲突处理策略, 支持: newer-wins,keep-both,local-wins,pan-wins,ask",
+						Value: string(syncdrive.ConflictPolicyNewerWins),
+					},
+					cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "排除文件的规则，语法和 .gitignore 一致，可以指定多次",
+					},
+					cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "重新包含文件的规则，优先级高于排除规则，语法和 .gitignore 一致，可以指定多次",
+					},
 					cli.IntFlag{
 						Name:  "dp",
 						Usage: "download parallel, 下载并发数量，即可以同时并发下载多少个文件。0代表跟从配置文件设置（取值范围:1 ~ 10）",
@@ -204,16 +230,460 @@ mode - 模式，支持三种: upload(备份本地文件到云盘),download(备
 						Usage: "upload block size，上传分片大小，单位KB。推荐值：1024 ~ 10240",
 						Value: 10240,
 					},
+					cli.BoolFlag{
+						Name:  "once",
+						Usage: "单次同步，每个任务只进行一次扫描和同步，完成后退出。有文件同步失败时退出码为1",
+					},
+				},
+			},
+			{
+				Name:      "plan",
+				Usage:     "预览sync同步备份任务将要执行的文件操作",
+				UsageText: cmder.App().Name + " sync plan [arguments...]",
+				Description: `
+扫描本地和云盘文件并进行对比，列出同步备份任务将要执行的上传、下载、删除操作，但不会执行这些操作。
+参数和 sync start 一致，支持命令行配置或者使用备份配置文件。首次启动双向同步(sync)之前，建议先使用本命令确认将要删除的文件。
+
+	例子:
+	1. 预览使用配置文件的所有同步备份任务
+	aliyunpan sync plan
+
+	2. 预览双向同步本地目录 D:\tickstep\Documents\设计文档 和云盘目录 /sync_drive/我的文档，并显示每一个文件的操作
+	aliyunpan sync plan -ldir "D:\tickstep\Documents\设计文档" -pdir "/sync_drive/我的文档" -mode "sync" -detail
+
+	3. 预览同步备份任务，并将计划保存为JSON文件
+	aliyunpan sync plan -json "D:\tickstep\Documents\sync_plan.json"
+`,
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					task, e := newSyncTaskFromContext(c)
+					if e != nil {
+						fmt.Println(e)
+						return nil
+					}
+					RunSyncPlan(task, c.String("json"), c.Bool("detail"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "ldir",
+						Usage: "local dir, 本地文件夹完整路径",
+					},
+					cli.StringFlag{
+						Name:  "pdir",
+						Usage: "pan dir, 云盘文件夹完整路径",
+					},
+					cli.StringFlag{
+						Name:  "mode",
+						Usage: "备份模式, 支持三种: upload(备份本地文件到云盘),download(备份云盘文件到本地),sync(双向同步备份)",
+						Value: "upload",
+					},
+					cli.StringFlag{
+						Name:  "conflict",
+						Usage: "双向同步冲突处理策略, 支持: newer-wins,keep-both,local-wins,pan-wins,ask",
+						Value: string(syncdrive.ConflictPolicyNewerWins),
+					},
+					cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "排除文件的规则，语法和 .gitignore 一致，可以指定多次",
+					},
+					cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "重新包含文件的规则，优先级高于排除规则，语法和 .gitignore 一致，可以指定多次",
+					},
+					cli.BoolFlag{
+						Name:  "detail",
+						Usage: "显示每一个文件的操作",
+					},
+					cli.StringFlag{
+						Name:  "json",
+						Usage: "将同步计划保存为JSON文件的路径",
+					},
+				},
+			},
+			{
+				Name:      "approve",
+				Usage:     "确认被大量删除保护阻止的删除操作",
+				UsageText: cmder.App().Name + " sync approve <任务ID或任务名称>",
+				Description: `
+一轮同步中将要删除的文件数量或者比例超过阈值时，同步任务的所有删除操作都会被阻止，以防止本地目录挂载失败等情况导致误删文件。
+请先确认将要删除的文件无误，然后使用本命令允许删除，正在运行的同步进程会继续执行这些删除操作。
+
+	例子:
+	1. 确认指定ID的同步任务的删除操作
+	aliyunpan sync approve 5b2d7c10-e927-4e72-8f9d-5abb3bb04814
+
+	2. 确认配置文件中名称为"设计文档备份"的同步任务的删除操作
+	aliyunpan sync approve 设计文档备份
+
+	3. 确认使用命令行启动的同步任务的删除操作
+	aliyunpan sync approve -ldir "D:\tickstep\Documents\设计文档"
+`,
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					taskId := c.Args().First()
+					if c.String("ldir") != "" {
+						taskId = utils.Md5Str(path.Clean(strings.ReplaceAll(c.String("ldir"), "\\", "/")))
+					}
+					if taskId == "" {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					RunSyncApprove(taskId)
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "ldir",
+						Usage: "local dir, 使用命令行启动的同步任务的本地文件夹完整路径",
+					},
+				},
+			},
+			{
+				Name:      "conflicts",
+				Usage:     "查看双向同步的文件冲突记录",
+				UsageText: cmder.App().Name + " sync conflicts [arguments...]",
+				Description: `
+查看双向同步备份任务的文件冲突记录，以及每个冲突的处理结果。处理结果为 pending 的冲突需要手动处理。
+
+	例子:
+	1. 查看所有同步任务的冲突记录
+	aliyunpan sync conflicts
+
+	2. 查看指定本地目录对应的同步任务的冲突记录
+	aliyunpan sync conflicts -ldir "D:\tickstep\Documents\设计文档"
+
+	3. 查看指定ID的同步任务最近10条冲突记录
+	aliyunpan sync conflicts -id "5b2d7c10-e927-4e72-8f9d-5abb3bb04814" -count 10
+`,
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					taskId := c.String("id")
+					if taskId == "" && c.String("ldir") != "" {
+						taskId = utils.Md5Str(path.Clean(strings.ReplaceAll(c.String("ldir"), "\\", "/")))
+					}
+					RunSyncConflicts(taskId, c.Int("count"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "同步任务ID",
+					},
+					cli.StringFlag{
+						Name:  "ldir",
+						Usage: "local dir, 使用命令行启动的同步任务的本地文件夹完整路径",
+					},
+					cli.IntFlag{
+						Name:  "count",
+						Usage: "显示最近的冲突记录数量，0代表全部显示",
+						Value: 0,
+					},
+				},
+			},
+			{
+				Name:      "versions",
+				Usage:     "查看和恢复文件的历史版本",
+				UsageText: cmder.App().Name + " sync versions <任务ID或任务名称> <文件路径> [arguments...]",
+				Description: `
+查看和恢复同步任务中文件（夹）的历史版本，只对开启了历史版本(versioning)的upload模式同步任务有效。
+文件路径可以是相对于云盘同步目录的路径，也可以是云盘完整路径。
+恢复历史版本时，目标路径已经存在的文件会先作为新的历史版本保留。
+
+	例子:
+	1. 查看 /sync_drive/我的文档/方案.docx 的历史版本
+	aliyunpan sync versions 设计文档备份 方案.docx
+
+	2. 恢复 /sync_drive/我的文档/方案.docx 的历史版本 20220601-123045 到原路径
+	aliyunpan sync versions 设计文档备份 /sync_drive/我的文档/方案.docx -restore 20220601-123045
+
+	3. 恢复历史版本 20220601-123045 到云盘路径 /恢复/方案.docx
+	aliyunpan sync versions 设计文档备份 方案.docx -restore 20220601-123045 -to /恢复/方案.docx
+`,
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					if c.NArg() < 2 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					RunSyncVersions(c.Args().Get(0), c.Args().Get(1), c.String("restore"), c.String("to"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "restore",
+						Usage: "需要恢复的历史版本名称",
+					},
+					cli.StringFlag{
+						Name:  "to",
+						Usage: "恢复到指定的云盘路径，默认恢复到原路径",
+					},
 				},
 			},
+			{
+				Name:      "status",
+				Usage:     "查看同步任务的运行状态",
+				UsageText: cmder.App().Name + " sync status [arguments...]",
+				Description: `
+查看同步任务的运行状态，包括各个状态的文件数量、正在执行的文件操作、最近一次扫描的时间以及执行失败的文件和错误信息。
+可以在同步进程运行时，在另外一个命令行窗口中使用本命令查看。
+
+	例子:
+	1. 查看所有同步任务的状态
+	aliyunpan sync status
+
+	2. 查看配置文件中名称为"设计文档备份"的同步任务的状态
+	aliyunpan sync status -id 设计文档备份
+
+	3. 查看指定本地目录对应的同步任务的状态
+	aliyunpan sync status -ldir "D:\tickstep\Documents\设计文档"
+
+	4. 以JSON格式输出所有同步任务的状态，用于监控
+	aliyunpan sync status -json
+`,
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					taskId := c.String("id")
+					if taskId == "" && c.String("ldir") != "" {
+						taskId = utils.Md5Str(path.Clean(strings.ReplaceAll(c.String("ldir"), "\\", "/")))
+					}
+					RunSyncStatus(taskId, c.Bool("json"))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "同步任务ID或者任务名称",
+					},
+					cli.StringFlag{
+						Name:  "ldir",
+						Usage: "local dir, 使用命令行启动的同步任务的本地文件夹完整路径",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "以JSON格式输出",
+					},
+				},
+			},
+			cmdSyncTask(),
 		},
 	}
 }
 
-func RunSync(defaultTask *syncdrive.SyncTask, fileDownloadParallel, fileUploadParallel int, downloadBlockSize, uploadBlockSize int64) {
+// newSyncTaskFromContext 使用命令行参数创建同步任务，没有指定本地目录和云盘目录则返回nil，代表使用配置文件
+func newSyncTaskFromContext(c *cli.Context) (*syncdrive.SyncTask, error) {
+	var task *syncdrive.SyncTask
+	localDir := c.String("ldir")
+	panDir := c.String("pdir")
+	mode := c.String("mode")
+	if localDir != "" && panDir != "" {
+		//if b, e := utils.PathExists(localDir); e == nil {
+		//	if !b {
+		//		fmt.Println("本地文件夹不存在：", localDir)
+		//		return nil
+		//	}
+		//} else {
+		//	fmt.Println("本地文件夹不存在：", localDir)
+		//	return nil
+		//}
+		task = &syncdrive.SyncTask{}
+		task.LocalFolderPath = path.Clean(strings.ReplaceAll(localDir, "\\", "/"))
+		task.PanFolderPath = panDir
+		task.Mode = syncdrive.UploadOnly
+		if mode == string(syncdrive.UploadOnly) {
+			task.Mode = syncdrive.UploadOnly
+		} else if mode == string(syncdrive.DownloadOnly) {
+			task.Mode = syncdrive.DownloadOnly
+		} else if mode == string(syncdrive.SyncTwoWay) {
+			task.Mode = syncdrive.SyncTwoWay
+		} else {
+			task.Mode = syncdrive.UploadOnly
+		}
+		policy, e := syncdrive.ParseConflictPolicy(c.String("conflict"))
+		if e != nil {
+			return nil, e
+		}
+		task.ConflictPolicy = policy
+		task.Exclude = c.StringSlice("exclude")
+		task.Include = c.StringSlice("include")
+		task.Name = path.Base(task.LocalFolderPath)
+		task.Id = utils.Md5Str(task.LocalFolderPath)
+	}
+	return task, nil
+}
+
+// newSyncTaskManager 创建只用于查询同步任务信息的管理器
+func newSyncTaskManager() *syncdrive.SyncTaskManager {
+	activeUser := GetActiveUser()
+	return syncdrive.NewSyncTaskManager(activeUser, activeUser.DriveList.GetFileDriveId(), activeUser.PanClient(), config.GetSyncDriveDir(),
+		0, 0, 0, 0, false, nil, nil)
+}
+
+// RunSyncApprove 确认同步任务被阻止的删除操作
+func RunSyncApprove(task string) {
+	syncMgr := newSyncTaskManager()
+	taskId := task
+	if tasks, e := syncMgr.ConfigSyncTaskList(); e == nil {
+		for _, t := range tasks {
+			if t.Name == task && t.Id != "" {
+				taskId = t.Id
+				break
+			}
+		}
+	}
+
+	guard, e := syncMgr.ApproveDelete(taskId)
+	if e != nil {
+		fmt.Println("确认删除操作失败：", e)
+		return
+	}
+	fmt.Printf("已确认删除操作，同步进程将继续删除 %d 个文件\n阻止原因：%s\n", guard.DeleteCount, guard.Reason)
+}
+
+// RunSyncConflicts 显示同步冲突记录
+func RunSyncConflicts(taskId string, count int) {
+	records, e := newSyncTaskManager().ConflictRecords(taskId)
+	if e != nil {
+		fmt.Println("读取冲突记录失败：", e)
+		return
+	}
+	if len(records) == 0 {
+		fmt.Println("没有冲突记录")
+		return
+	}
+	if count > 0 && len(records) > count {
+		records = records[len(records)-count:]
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "时间", "任务ID", "本地文件", "冲突策略", "处理结果", "冲突副本"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for i, r := range records {
+		tb.Append([]string{strconv.Itoa(i + 1), r.Time, r.TaskId, r.LocalFilePath, string(r.Policy), string(r.Resolution), r.ConflictFilePath})
+	}
+	tb.Render()
+}
+
+// RunSyncVersions 查看或者恢复文件的历史版本
+func RunSyncVersions(taskIdOrName, filePath, restoreVersion, targetPath string) {
+	task, e := newSyncTaskManager().ConfigSyncTask(taskIdOrName)
+	if e != nil {
+		fmt.Println("获取同步任务失败：", e)
+		return
+	}
+
+	if restoreVersion != "" {
+		if e = task.RestoreFileVersion(filePath, restoreVersion, targetPath); e != nil {
+			fmt.Println("恢复历史版本失败：", e)
+			return
+		}
+		fmt.Println("恢复历史版本成功：", restoreVersion)
+		return
+	}
+
+	versions, e := task.ListFileVersions(filePath)
+	if e != nil {
+		fmt.Println("获取历史版本失败：", e)
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Println("没有历史版本")
+		return
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "版本", "时间", "文件大小", "类型"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
+	for i, v := range versions {
+		fileType, size := "文件", converter.ConvertFileSize(v.FileSize, 2)
+		if v.IsFolder {
+			fileType, size = "文件夹", "-"
+		}
+		tb.Append([]string{strconv.Itoa(i + 1), v.Name, v.Time.Format("2006-01-02 15:04:05"), size, fileType})
+	}
+	tb.Render()
+}
+
+// RunSyncStatus 显示同步任务的运行状态
+func RunSyncStatus(taskId string, jsonOutput bool) {
+	statusList, e := newSyncTaskManager().TaskStatusList(taskId)
+	if e != nil {
+		fmt.Println("读取同步任务状态失败：", e)
+		return
+	}
+	if jsonOutput {
+		fmt.Println(utils.ObjectToJsonStr(statusList, true))
+		return
+	}
+	if len(statusList) == 0 {
+		fmt.Println("没有同步任务")
+		return
+	}
+
+	for _, status := range statusList {
+		running := "未运行"
+		if status.Running {
+			running = fmt.Sprintf("运行中(PID: %d)", status.Pid)
+		}
+		fmt.Printf("\n任务: %s(%s)\n本地目录: %s\n云盘目录: %s\n运行状态: %s\n", status.TaskName, status.TaskId, status.LocalFolderPath, status.PanFolderPath, running)
+		if status.LocalScan != nil {
+			fmt.Printf("本地扫描: 开始 %s，完成 %s，文件数量 %d\n", status.LocalScan.LastStartTime, status.LocalScan.LastEndTime, status.LocalScan.FileCount)
+		}
+		if status.PanScan != nil {
+			fmt.Printf("云盘扫描: 开始 %s，完成 %s，文件数量 %d\n", status.PanScan.LastStartTime, status.PanScan.LastEndTime, status.PanScan.FileCount)
+		}
+
+		tb := cmdtable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"状态", "文件数量"})
+		tb.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
+		for _, fileStatus := range syncdrive.SyncFileStatusList {
+			tb.Append([]string{string(fileStatus), strconv.Itoa(status.StatusCount[fileStatus])})
+		}
+		tb.Render()
+
+		if len(status.InProcessItems) > 0 {
+			fmt.Println("正在执行:")
+			tb = cmdtable.NewTable(os.Stdout)
+			tb.SetHeader([]string{"#", "操作", "状态", "文件", "文件大小", "开始时间"})
+			tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
+			for i, item := range status.InProcessItems {
+				tb.Append([]string{strconv.Itoa(i + 1), syncFileActionLabel(item.Action), string(item.Status), item.Path, converter.ConvertFileSize(item.FileSize, 2), item.StatusUpdateTime})
+			}
+			tb.Render()
+		}
+		if len(status.FailedItems) > 0 {
+			fmt.Println("执行失败:")
+			tb = cmdtable.NewTable(os.Stdout)
+			tb.SetHeader([]string{"#", "操作", "状态", "文件", "时间", "错误信息"})
+			tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+			for i, item := range status.FailedItems {
+				tb.Append([]string{strconv.Itoa(i + 1), syncFileActionLabel(item.Action), string(item.Status), item.Path, item.StatusUpdateTime, item.Error})
+			}
+			tb.Render()
+		}
+	}
+}
+
+// RunSync 启动同步备份，返回是否全部成功
+func RunSync(defaultTask *syncdrive.SyncTask, fileDownloadParallel, fileUploadParallel int, downloadBlockSize, uploadBlockSize int64, runOnce bool) bool {
 	useInternalUrl := config.Config.TransferUrlType == 2
-	maxDownloadRate := config.Config.MaxDownloadRate
-	maxUploadRate := config.Config.MaxUploadRate
+	// 限速配置在运行期间修改后立即生效
+	maxDownloadRate := config.Config.MaxDownloadRateNow
+	maxUploadRate := config.Config.MaxUploadRateNow
 	activeUser := GetActiveUser()
 	panClient := activeUser.PanClient()
 
@@ -256,9 +726,26 @@ func RunSync(defaultTask *syncdrive.SyncTask, fileDownloadParallel, fileUploadPa
 	fmt.Printf("备份配置文件：%s\n链接类型：%s\n下载并发：%d\n上传并发：%d\n下载分片大小：%s\n上传分片大小：%s\n",
 		syncConfigFile, typeUrlStr, fileDownloadParallel, fileUploadParallel, converter.ConvertFileSize(downloadBlockSize, 2),
 		converter.ConvertFileSize(uploadBlockSize, 2))
+	if runOnce {
+		reports, e := syncMgr.RunOnce(tasks)
+		if e != nil && e != syncdrive.ErrSyncTaskRunFailed {
+			fmt.Println("启动任务失败：", e)
+			return false
+		}
+		printSyncReports(reports)
+		if e != nil {
+			return false
+		}
+		for _, report := range reports {
+			if report.Failed() > 0 || report.DeleteBlockedReason != "" {
+				return false
+			}
+		}
+		return true
+	}
 	if _, e := syncMgr.Start(tasks); e != nil {
 		fmt.Println("启动任务失败：", e)
-		return
+		return false
 	}
 
 	_, ok := os.LookupEnv("ALIYUNPAN_DOCKER")
@@ -279,4 +766,117 @@ func RunSync(defaultTask *syncdrive.SyncTask, fileDownloadParallel, fileUploadPa
 
 	fmt.Println("正在停止同步备份任务，请稍等...")
 	syncMgr.Stop()
+	return true
+}
+
+// printSyncReports 打印单次同步的结果报告
+func printSyncReports(reports []*syncdrive.SyncReport) {
+	for _, report := range reports {
+		fmt.Printf("\n同步报告: %s(%s)\n开始时间: %s\n结束时间: %s\n", report.TaskName, report.TaskId, report.StartTime, report.EndTime)
+		tb := cmdtable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"操作", "文件数量", "文件大小"})
+		tb.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+		tb.Append([]string{"上传", strconv.Itoa(report.Uploaded), converter.ConvertFileSize(report.UploadedSize, 2)})
+		tb.Append([]string{"下载", strconv.Itoa(report.Downloaded), converter.ConvertFileSize(report.DownloadedSize, 2)})
+		tb.Append([]string{"删除本地文件", strconv.Itoa(report.DeletedLocal), "-"})
+		tb.Append([]string{"删除云盘文件", strconv.Itoa(report.DeletedPan), "-"})
+		tb.Append([]string{"移动本地文件", strconv.Itoa(report.MovedLocal), "-"})
+		tb.Append([]string{"移动云盘文件", strconv.Itoa(report.MovedPan), "-"})
+		tb.Append([]string{"跳过(文件已不存在)", strconv.Itoa(report.Skipped), "-"})
+		tb.Append([]string{"失败", strconv.Itoa(report.Failed()), "-"})
+		tb.Render()
+		if report.DeleteBlockedReason != "" {
+			fmt.Printf("删除操作已被大量删除保护阻止，%s\n请确认无误后使用以下命令允许删除: aliyunpan sync approve %s\n", report.DeleteBlockedReason, report.TaskId)
+		}
+
+		if report.Failed() > 0 {
+			tb = cmdtable.NewTable(os.Stdout)
+			tb.SetHeader([]string{"#", "操作", "状态", "文件", "错误信息"})
+			tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+			for i, item := range report.FailedItems {
+				tb.Append([]string{strconv.Itoa(i + 1), syncFileActionLabel(item.Action), string(item.Status), item.Path, item.Error})
+			}
+			tb.Render()
+		}
+	}
+}
+
+// syncFileActionLabel 文件动作的显示名称
+func syncFileActionLabel(action syncdrive.SyncFileAction) string {
+	switch action {
+	case syncdrive.SyncFileActionUpload:
+		return "上传"
+	case syncdrive.SyncFileActionDownload:
+		return "下载"
+	case syncdrive.SyncFileActionDeleteLocal:
+		return "删除本地文件"
+	case syncdrive.SyncFileActionDeletePan:
+		return "删除云盘文件"
+	case syncdrive.SyncFileActionMoveLocal:
+		return "移动本地文件"
+	case syncdrive.SyncFileActionMovePan:
+		return "移动云盘文件"
+	}
+	return string(action)
+}
+
+// RunSyncPlan 预览同步备份任务将要执行的文件操作
+func RunSyncPlan(defaultTask *syncdrive.SyncTask, jsonFilePath string, showDetail bool) {
+	var tasks []*syncdrive.SyncTask
+	if defaultTask != nil {
+		tasks = []*syncdrive.SyncTask{defaultTask}
+	}
+
+	plans, e := newSyncTaskManager().Plan(tasks)
+	if e != nil {
+		fmt.Println("生成同步计划失败：", e)
+		return
+	}
+
+	actions := []syncdrive.SyncFileAction{syncdrive.SyncFileActionUpload, syncdrive.SyncFileActionDownload,
+		syncdrive.SyncFileActionDeleteLocal, syncdrive.SyncFileActionDeletePan,
+		syncdrive.SyncFileActionMoveLocal, syncdrive.SyncFileActionMovePan}
+	for _, plan := range plans {
+		fmt.Printf("\n任务: %s(%s)\n本地目录: %s\n云盘目录: %s\n", plan.TaskName, plan.TaskId, plan.LocalFolderPath, plan.PanFolderPath)
+		tb := cmdtable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"操作", "文件数量", "文件大小"})
+		tb.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+		for _, action := range actions {
+			tb.Append([]string{syncFileActionLabel(action), strconv.Itoa(plan.Count(action)), converter.ConvertFileSize(plan.TotalSize(action), 2)})
+		}
+		tb.Render()
+		if len(plan.Conflicts) > 0 {
+			fmt.Printf("检测到 %d 个文件冲突，详情请使用 -json 参数导出查看\n", len(plan.Conflicts))
+		}
+		if plan.DeleteBlockedReason != "" {
+			fmt.Printf("警告：删除操作将会被大量删除保护阻止，%s\n", plan.DeleteBlockedReason)
+		}
+
+		if showDetail && len(plan.Items) > 0 {
+			tb = cmdtable.NewTable(os.Stdout)
+			tb.SetHeader([]string{"#", "操作", "本地文件", "云盘文件", "文件大小"})
+			tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
+			for i, item := range plan.Items {
+				localPath, panPath, size := "", "", int64(0)
+				if item.LocalFile != nil {
+					localPath = item.LocalFile.Path
+					size = item.LocalFile.FileSize
+				}
+				if item.PanFile != nil {
+					panPath = item.PanFile.Path
+					size = item.PanFile.FileSize
+				}
+				tb.Append([]string{strconv.Itoa(i + 1), syncFileActionLabel(item.Action), localPath, panPath, converter.ConvertFileSize(size, 2)})
+			}
+			tb.Render()
+		}
+	}
+
+	if jsonFilePath != "" {
+		if e := ioutil.WriteFile(jsonFilePath, []byte(utils.ObjectToJsonStr(plans, true)), 0755); e != nil {
+			fmt.Println("保存同步计划失败：", e)
+			return
+		}
+		fmt.Println("同步计划已保存到：", jsonFilePath)
+	}
 }
@@ -0,0 +1,283 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/syncdrive"
+	"github.com/urfave/cli"
+	"os"
+	"strconv"
+)
+
+// syncTaskFlags 添加和修改同步任务的参数
+func syncTaskFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "任务名称，默认使用本地文件夹名称",
+		},
+		cli.StringFlag{
+			Name:  "ldir",
+			Usage: "local dir, 本地文件夹完整路径",
+		},
+		cli.StringFlag{
+			Name:  "pdir",
+			Usage: "pan dir, 云盘文件夹完整路径",
+		},
+		cli.StringFlag{
+			Name:  "mode",
+			Usage: "备份模式, 支持三种: upload(备份本地文件到云盘),download(备份云盘文件到本地),sync(双向同步备份)",
+		},
+		cli.StringFlag{
+			Name:  "conflict",
+			Usage: "双向同步冲突处理策略, 支持: newer-wins,keep-both,local-wins,pan-wins,ask",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "排除文件的规则，语法和 .gitignore 一致，可以指定多次。修改任务时会替换原有的规则",
+		},
+		cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "重新包含文件的规则，优先级高于排除规则，语法和 .gitignore 一致，可以指定多次。修改任务时会替换原有的规则",
+		},
+	}
+}
+
+// syncTaskLoginAction 检查登录状态并且需要指定任务ID或者名称的命令
+func syncTaskLoginAction(action func(c *cli.Context, idOrName string)) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		if config.Config.ActiveUser() == nil {
+			fmt.Println("未登录账号")
+			return nil
+		}
+		if c.NArg() < 1 {
+			cli.ShowCommandHelp(c, c.Command.Name)
+			return nil
+		}
+		action(c, c.Args().First())
+		return nil
+	}
+}
+
+func cmdSyncTask() cli.Command {
+	return cli.Command{
+		Name:      "task",
+		Usage:     "管理配置文件中的同步任务",
+		UsageText: cmder.App().Name + " sync task <add|list|rm|edit|enable|disable>",
+		Description: `
+管理同步配置文件 sync_drive_config.json 中的同步任务，不需要手动编辑配置文件。
+添加和修改任务时会检查本地目录和云盘目录是否有效，以及是否和其他任务的目录重叠。
+正在运行的同步进程需要重新启动才会使用修改后的配置。
+
+	例子:
+	1. 添加同步任务
+	aliyunpan sync task add -name 设计文档备份 -ldir "D:\tickstep\Documents\设计文档" -pdir "/sync_drive/我的文档" -mode upload
+
+	2. 查看所有同步任务
+	aliyunpan sync task list
+
+	3. 修改同步任务的同步模式
+	aliyunpan sync task edit 设计文档备份 -mode sync -conflict keep-both
+
+	4. 禁用和启用同步任务
+	aliyunpan sync task disable 设计文档备份
+	aliyunpan sync task enable 设计文档备份
+
+	5. 删除同步任务
+	aliyunpan sync task rm 设计文档备份
+`,
+		Action: func(c *cli.Context) error {
+			cli.ShowCommandHelp(c, c.Command.Name)
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "add",
+				Usage:     "添加同步任务",
+				UsageText: cmder.App().Name + " sync task add -ldir <本地目录> -pdir <云盘目录> [arguments...]",
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					if c.String("ldir") == "" || c.String("pdir") == "" {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					RunSyncTaskAdd(&syncdrive.SyncTask{
+						Name:            c.String("name"),
+						LocalFolderPath: c.String("ldir"),
+						PanFolderPath:   c.String("pdir"),
+						Mode:            syncdrive.SyncMode(c.String("mode")),
+						ConflictPolicy:  syncdrive.ConflictPolicy(c.String("conflict")),
+						Exclude:         c.StringSlice("exclude"),
+						Include:         c.StringSlice("include"),
+					})
+					return nil
+				},
+				Flags: syncTaskFlags(),
+			},
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "列出同步任务",
+				UsageText: cmder.App().Name + " sync task list",
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunSyncTaskList()
+					return nil
+				},
+			},
+			{
+				Name:      "rm",
+				Usage:     "删除同步任务",
+				UsageText: cmder.App().Name + " sync task rm <任务ID或任务名称>",
+				Description: `
+从配置文件中删除同步任务，不会删除本地和云盘的文件，以及该任务的同步数据库。
+`,
+				Action: syncTaskLoginAction(func(c *cli.Context, idOrName string) {
+					RunSyncTaskRemove(idOrName)
+				}),
+			},
+			{
+				Name:      "edit",
+				Usage:     "修改同步任务",
+				UsageText: cmder.App().Name + " sync task edit <任务ID或任务名称> [arguments...]",
+				Description: `
+修改同步任务的配置，只修改指定的参数，任务ID和上一次同步时间保持不变。
+`,
+				Action: syncTaskLoginAction(func(c *cli.Context, idOrName string) {
+					RunSyncTaskEdit(idOrName, func(task *syncdrive.SyncTask) {
+						if c.IsSet("name") {
+							task.Name = c.String("name")
+						}
+						if c.IsSet("ldir") {
+							task.LocalFolderPath = c.String("ldir")
+						}
+						if c.IsSet("pdir") {
+							task.PanFolderPath = c.String("pdir")
+						}
+						if c.IsSet("mode") {
+							task.Mode = syncdrive.SyncMode(c.String("mode"))
+						}
+						if c.IsSet("conflict") {
+							task.ConflictPolicy = syncdrive.ConflictPolicy(c.String("conflict"))
+						}
+						if c.IsSet("exclude") {
+							task.Exclude = c.StringSlice("exclude")
+						}
+						if c.IsSet("include") {
+							task.Include = c.StringSlice("include")
+						}
+					})
+				}),
+				Flags: syncTaskFlags(),
+			},
+			{
+				Name:      "enable",
+				Usage:     "启用同步任务",
+				UsageText: cmder.App().Name + " sync task enable <任务ID或任务名称>",
+				Action: syncTaskLoginAction(func(c *cli.Context, idOrName string) {
+					RunSyncTaskSetDisabled(idOrName, false)
+				}),
+			},
+			{
+				Name:      "disable",
+				Usage:     "禁用同步任务，禁用的任务不会被启动",
+				UsageText: cmder.App().Name + " sync task disable <任务ID或任务名称>",
+				Action: syncTaskLoginAction(func(c *cli.Context, idOrName string) {
+					RunSyncTaskSetDisabled(idOrName, true)
+				}),
+			},
+		},
+	}
+}
+
+// RunSyncTaskAdd 添加同步任务
+func RunSyncTaskAdd(task *syncdrive.SyncTask) {
+	task, e := newSyncTaskManager().AddSyncTask(task)
+	if e != nil {
+		fmt.Println("添加同步任务失败：", e)
+		return
+	}
+	fmt.Println("已添加同步任务")
+	fmt.Println(task)
+}
+
+// RunSyncTaskEdit 修改同步任务
+func RunSyncTaskEdit(idOrName string, editFunc func(task *syncdrive.SyncTask)) {
+	task, e := newSyncTaskManager().EditSyncTask(idOrName, editFunc)
+	if e != nil {
+		fmt.Println("修改同步任务失败：", e)
+		return
+	}
+	fmt.Println("已修改同步任务")
+	fmt.Println(task)
+}
+
+// RunSyncTaskRemove 删除同步任务
+func RunSyncTaskRemove(idOrName string) {
+	task, e := newSyncTaskManager().RemoveSyncTask(idOrName)
+	if e != nil {
+		fmt.Println("删除同步任务失败：", e)
+		return
+	}
+	fmt.Println("已删除同步任务：", task.NameLabel())
+}
+
+// RunSyncTaskList 列出配置文件中的同步任务
+func RunSyncTaskList() {
+	tasks, e := newSyncTaskManager().ConfigSyncTaskList()
+	if e != nil {
+		fmt.Println("读取同步任务失败：", e)
+		return
+	}
+	if len(tasks) == 0 {
+		fmt.Println("没有同步任务")
+		return
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "任务ID", "名称", "同步模式", "状态", "本地目录", "云盘目录", "上一次同步时间"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for i, task := range tasks {
+		state := "启用"
+		if task.Disabled {
+			state = "禁用"
+		}
+		tb.Append([]string{strconv.Itoa(i + 1), task.Id, task.Name, string(task.Mode), state, task.LocalFolderPath, task.PanFolderPath, task.LastSyncTime})
+	}
+	tb.Render()
+}
+
+// RunSyncTaskSetDisabled 启用或者禁用同步任务
+func RunSyncTaskSetDisabled(idOrName string, disabled bool) {
+	task, e := newSyncTaskManager().SetSyncTaskDisabled(idOrName, disabled)
+	if e != nil {
+		fmt.Println("修改同步任务失败：", e)
+		return
+	}
+	if disabled {
+		fmt.Println("已禁用同步任务：", task.NameLabel())
+	} else {
+		fmt.Println("已启用同步任务：", task.NameLabel())
+	}
+}
@@ -0,0 +1,536 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/file/uploader"
+	"github.com/tickstep/aliyunpan/internal/functions/panupload"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/library-go/requester/rio"
+	"github.com/tickstep/library-go/requester/rio/speeds"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// TransferOptions 跨账号传输可选项
+	TransferOptions struct {
+		From      string // 源账号，UID或者用户名
+		To        string // 目标账号，UID或者用户名
+		FromDrive string // 源网盘ID，为空使用源账号当前的网盘
+		ToDrive   string // 目标网盘ID，为空使用目标账号当前的网盘
+		Policy    CopyConflictPolicy
+		Restart   bool
+	}
+
+	// panFileStreamReader 按块读取云盘文件的数据并缓存当前块，
+	// 用于将源账号的文件数据直接上传到目标账号，不需要保存到本地
+	panFileStreamReader struct {
+		reader    rio.ReaderAtLen64
+		blockSize int64
+		buf       []byte
+		bufOffset int64
+		mutex     sync.Mutex
+	}
+
+	// transferResultItem 单个文件的传输结果，同时也是传输进度文件的一行
+	transferResultItem struct {
+		Path    string `json:"path"`
+		Sha1    string `json:"sha1,omitempty"`
+		Size    int64  `json:"size"`
+		Success bool   `json:"success"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// transferProgress 传输进度文件，每传输一个文件追加一行，中断或者失败后可以继续传输
+	transferProgress struct {
+		*jsonLinesProgress
+	}
+
+	// transferFolder 目标账号的文件夹
+	transferFolder struct {
+		fileId  string
+		existed map[string]*aliyunpan.FileEntity
+	}
+
+	// panTransferTask 跨账号传输任务
+	panTransferTask struct {
+		srcUser    *config.PanUser
+		dstUser    *config.PanUser
+		srcDriveId string
+		dstDriveId string
+		policy     CopyConflictPolicy
+		progress   *transferProgress
+		folders    map[string]*transferFolder
+
+		globalSpeedsStat *speeds.Speeds
+		rapidCount       int
+		streamCount      int
+		streamSize       int64
+		skippedCount     int
+		failedList       []*transferResultItem
+	}
+)
+
+func CmdTransfer() cli.Command {
+	return cli.Command{
+		Name:      "transfer",
+		Usage:     "在已登录的账号之间传输文件/目录",
+		UsageText: cmder.App().Name + " transfer -from <源账号> -to <目标账号> <源文件/目录1> <源文件/目录2> ... <目标目录>",
+		Description: `
+	将源账号的文件和目录传输到目标账号的目标目录中, 目录会递归传输. 源账号和目标账号都需要在本机登录过, 账号可以使用UID或者用户名指定.
+	传输时优先使用源文件的SHA1秒传到目标账号, 秒传失败时从源账号读取文件数据直接上传到目标账号, 不会保存到本地.
+
+	每个文件的传输结果都会记录下来, 如果有文件传输失败, 重新执行相同的命令会跳过已经传输成功的文件.
+	目标目录中已经存在内容相同(SHA1一致)的文件也会直接跳过.
+
+	目标目录已经存在同名但内容不同的文件时的处理策略, 通过 -conflict 指定:
+	skip: 跳过已经存在的文件, 默认策略
+	overwrite: 覆盖已经存在的文件
+	rename: 自动重命名, 例如 1.mp4 保存为 1(1).mp4
+
+	示例:
+
+	将账号 tickstep 的 /我的资源 整个目录传输到账号 tickstep2 的 /备份 目录
+	aliyunpan transfer -from tickstep -to tickstep2 /我的资源 /备份
+
+	将账号 tickstep 的 /我的资源/1.mp4 和 /我的文档 传输到账号 tickstep2 的根目录, 覆盖已经存在的文件
+	aliyunpan transfer -from tickstep -to tickstep2 -conflict overwrite /我的资源/1.mp4 /我的文档 /
+`,
+		Category: "阿里云盘账号",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() <= 1 || c.String("from") == "" || c.String("to") == "" {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			policy := CopyConflictPolicy(strings.ToLower(c.String("conflict")))
+			if policy != CopyConflictSkip && policy != CopyConflictOverwrite && policy != CopyConflictRename {
+				fmt.Println("不支持的冲突处理策略: ", c.String("conflict"))
+				return nil
+			}
+			subArgs := c.Args()
+			RunTransfer(&TransferOptions{
+				From:      c.String("from"),
+				To:        c.String("to"),
+				FromDrive: c.String("fromDriveId"),
+				ToDrive:   c.String("toDriveId"),
+				Policy:    policy,
+				Restart:   c.Bool("restart"),
+			}, subArgs[:len(subArgs)-1], subArgs[len(subArgs)-1])
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "源账号, UID或者用户名",
+			},
+			cli.StringFlag{
+				Name:  "to",
+				Usage: "目标账号, UID或者用户名",
+			},
+			cli.StringFlag{
+				Name:  "fromDriveId",
+				Usage: "源网盘ID, 默认使用源账号当前的网盘",
+			},
+			cli.StringFlag{
+				Name:  "toDriveId",
+				Usage: "目标网盘ID, 默认使用目标账号当前的网盘",
+			},
+			cli.StringFlag{
+				Name:  "conflict",
+				Usage: "目标目录已经存在同名文件时的处理策略, 支持: skip,overwrite,rename",
+				Value: string(CopyConflictSkip),
+			},
+			cli.BoolFlag{
+				Name:  "restart",
+				Usage: "忽略上次的传输进度，重新传输全部文件",
+			},
+		},
+	}
+}
+
+// RunTransfer 执行跨账号传输
+func RunTransfer(options *TransferOptions, srcPaths []string, dstDir string) {
+	srcUser, err := config.Config.GetLoginUser(options.From, options.From)
+	if err != nil {
+		fmt.Printf("源账号 %s: %s\n", options.From, err)
+		return
+	}
+	dstUser, err := config.Config.GetLoginUser(options.To, options.To)
+	if err != nil {
+		fmt.Printf("目标账号 %s: %s\n", options.To, err)
+		return
+	}
+	if srcUser.UserId == dstUser.UserId {
+		fmt.Println("源账号和目标账号相同, 请使用 cp 命令拷贝文件")
+		return
+	}
+
+	task := &panTransferTask{
+		srcUser:          srcUser,
+		dstUser:          dstUser,
+		srcDriveId:       options.FromDrive,
+		dstDriveId:       options.ToDrive,
+		policy:           options.Policy,
+		folders:          map[string]*transferFolder{},
+		globalSpeedsStat: &speeds.Speeds{},
+		failedList:       []*transferResultItem{},
+	}
+	if task.srcDriveId == "" {
+		task.srcDriveId = srcUser.ActiveDriveId
+	}
+	if task.dstDriveId == "" {
+		task.dstDriveId = dstUser.ActiveDriveId
+	}
+	dstDir = path.Clean(dstUser.PathJoin(task.dstDriveId, dstDir))
+	for k := range srcPaths {
+		srcPaths[k] = path.Clean(srcUser.PathJoin(task.srcDriveId, srcPaths[k]))
+	}
+
+	// 传输进度
+	progressFilePath := filepath.Join(config.GetConfigDir(), "transfer", utils.Md5Str(srcUser.UserId+"|"+task.srcDriveId+"|"+
+		strings.Join(srcPaths, "|")+"|"+dstUser.UserId+"|"+task.dstDriveId+"|"+dstDir)+".jsonl")
+	task.progress, err = openTransferProgress(progressFilePath, options.Restart)
+	if err != nil {
+		fmt.Printf("打开传输进度文件出错: %s\n", err)
+		return
+	}
+
+	// 传输时间可能很长，需要刷新两个账号的token
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				RefreshTokenInNeed(srcUser)
+				RefreshTokenInNeed(dstUser)
+			}
+		}
+	}()
+
+	fmt.Printf("从账号 %s 传输到账号 %s 的目录: %s\n", srcUser.Nickname, dstUser.Nickname, dstDir)
+	timeStart := time.Now()
+	for _, srcPath := range srcPaths {
+		task.transferPath(srcPath, dstDir)
+	}
+
+	fmt.Printf("\n传输结束, 耗时: %s, 秒传: %d, 上传: %d(%s), 跳过: %d, 失败: %d\n", utils.ConvertTime(time.Now().Sub(timeStart)),
+		task.rapidCount, task.streamCount, converter.ConvertFileSize(task.streamSize, 2), task.skippedCount, len(task.failedList))
+	if len(task.failedList) > 0 {
+		fmt.Println("以下文件传输失败：")
+		tb := cmdtable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"#", "文件", "失败原因"})
+		for k, f := range task.failedList {
+			tb.Append([]string{strconv.Itoa(k + 1), f.Path, f.Error})
+		}
+		tb.Render()
+		task.progress.Close(false)
+		fmt.Println("重新执行相同的命令可以只传输失败的文件，使用 -restart 重新传输全部文件")
+	} else {
+		task.progress.Close(true)
+	}
+	dstUser.DeleteCache([]string{dstDir})
+}
+
+// openTransferProgress 打开传输进度文件，restart 为 true 时清空之前的进度
+func openTransferProgress(progressFilePath string, restart bool) (*transferProgress, error) {
+	p, err := openJsonLinesProgress(progressFilePath, restart, func() progressItem {
+		return &transferResultItem{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &transferProgress{p}, nil
+}
+
+func (item *transferResultItem) progressKey() string {
+	return item.Path
+}
+
+// Get 获取已经传输成功的文件结果，没有传输或者传输失败返回nil
+func (tp *transferProgress) Get(targetPath, sha1 string, size int64) *transferResultItem {
+	r, ok := tp.get(targetPath).(*transferResultItem)
+	if !ok || !r.Success || r.Sha1 != sha1 || r.Size != size {
+		return nil
+	}
+	return r
+}
+
+// transferPath 递归传输源文件/目录到目标目录
+func (t *panTransferTask) transferPath(srcPath, dstDir string) {
+	fd, apierr := t.srcUser.PanClient().FileInfoByPath(t.srcDriveId, srcPath)
+	if apierr != nil {
+		logger.Verbosef("%s\n", apierr)
+		t.fail(&transferResultItem{Path: srcPath}, fmt.Errorf("源文件不存在"))
+		return
+	}
+	fd.Path = srcPath
+	t.transferEntity(fd, path.Join(dstDir, path.Base(srcPath)))
+}
+
+// transferEntity 传输文件或者递归传输文件夹，子文件夹出错只记录失败，继续传输其他文件
+func (t *panTransferTask) transferEntity(fd *aliyunpan.FileEntity, targetPath string) {
+	if !fd.IsFolder() {
+		t.transferFile(fd, targetPath)
+		return
+	}
+	// 文件夹在目标账号直接创建，空文件夹也会保留
+	if _, err := t.getFolder(targetPath); err != nil {
+		t.fail(&transferResultItem{Path: targetPath}, err)
+		return
+	}
+	files, apierr := t.srcUser.PanClient().FileListGetAll(&aliyunpan.FileListParam{
+		DriveId:      t.srcDriveId,
+		ParentFileId: fd.FileId,
+	}, 500)
+	if apierr != nil {
+		t.fail(&transferResultItem{Path: fd.Path}, fmt.Errorf("获取源文件夹文件列表失败: %s", apierr))
+		return
+	}
+	for _, f := range files {
+		f.Path = path.Join(fd.Path, f.FileName)
+		t.transferEntity(f, path.Join(targetPath, f.FileName))
+	}
+}
+
+// getFolder 获取目标账号的文件夹，不存在则创建
+func (t *panTransferTask) getFolder(folderPath string) (*transferFolder, error) {
+	if folder, ok := t.folders[folderPath]; ok {
+		return folder, nil
+	}
+	dstClient := t.dstUser.PanClient()
+	folder := &transferFolder{
+		fileId:  aliyunpan.DefaultRootParentFileId,
+		existed: map[string]*aliyunpan.FileEntity{},
+	}
+	if folderPath != "/" {
+		rs, apierr := dstClient.MkdirByFullPath(t.dstDriveId, folderPath)
+		if apierr != nil || rs == nil || rs.FileId == "" {
+			return nil, fmt.Errorf("创建目标文件夹失败: %s", folderPath)
+		}
+		folder.fileId = rs.FileId
+	}
+	fileList, apierr := dstClient.FileListGetAll(&aliyunpan.FileListParam{
+		DriveId:      t.dstDriveId,
+		ParentFileId: folder.fileId,
+	}, 500)
+	if apierr != nil {
+		return nil, fmt.Errorf("获取目标文件夹文件列表失败: %s", apierr)
+	}
+	for _, fe := range fileList {
+		folder.existed[fe.FileName] = fe
+	}
+	t.folders[folderPath] = folder
+	return folder, nil
+}
+
+// transferFile 传输单个文件，优先秒传，秒传失败则读取源文件数据上传
+func (t *panTransferTask) transferFile(src *aliyunpan.FileEntity, targetPath string) {
+	result := &transferResultItem{
+		Path: targetPath,
+		Sha1: strings.ToUpper(src.ContentHash),
+		Size: src.FileSize,
+	}
+	if t.progress.Get(targetPath, result.Sha1, result.Size) != nil {
+		t.skippedCount++
+		return
+	}
+
+	dir, name := path.Split(targetPath)
+	folder, err := t.getFolder(path.Clean(dir))
+	if err != nil {
+		t.fail(result, err)
+		return
+	}
+	checkNameMode := "refuse"
+	if existed := folder.existed[name]; existed != nil {
+		if !existed.IsFolder() && src.ContentHash != "" && strings.EqualFold(existed.ContentHash, src.ContentHash) {
+			fmt.Println("目标文件已经存在, 跳过: ", targetPath)
+			t.skippedCount++
+			result.Success = true
+			t.save(result)
+			return
+		}
+		switch t.policy {
+		case CopyConflictSkip:
+			fmt.Println("目标位置已经存在同名文件, 跳过: ", targetPath)
+			t.skippedCount++
+			return
+		case CopyConflictOverwrite:
+			if existed.IsFolder() {
+				t.fail(result, fmt.Errorf("目标位置已经存在同名文件夹"))
+				return
+			}
+			checkNameMode = "overwrite"
+		case CopyConflictRename:
+			name = uniqueCopyName(name, folder.existed)
+		}
+	}
+	// 进度按原始路径记录，重命名后的路径只用于显示
+	savePath := path.Join(dir, name)
+
+	// 秒传，proof_code 使用目标账号的token和源文件的数据计算
+	srcReader := &panFileRangeReader{
+		panClient: t.srcUser.PanClient(),
+		file:      src,
+	}
+	dstClient := t.dstUser.PanClient()
+	param := &aliyunpan.CreateFileUploadParam{
+		DriveId:       t.dstDriveId,
+		Name:          name,
+		Size:          src.FileSize,
+		CheckNameMode: checkNameMode,
+		ParentFileId:  folder.fileId,
+		BlockSize:     aliyunpan.DefaultChunkSize,
+	}
+	if src.ContentHash != "" {
+		param.ContentHash = src.ContentHash
+		param.ContentHashName = "sha1"
+		if src.FileSize > 0 {
+			param.ProofCode = aliyunpan.CalcProofCode(dstClient.GetAccessToken(), srcReader, src.FileSize)
+			param.ProofVersion = "v1"
+		}
+	}
+	uploadOpEntity, apierr := dstClient.CreateUploadFile(param)
+	if apierr != nil {
+		t.fail(result, apierr)
+		return
+	}
+	if uploadOpEntity.RapidUpload {
+		fmt.Println("秒传成功: ", savePath)
+		t.rapidCount++
+	} else {
+		fmt.Println("秒传失败, 开始传输文件数据: ", savePath)
+		if err := t.streamFile(srcReader, uploadOpEntity, savePath); err != nil {
+			t.fail(result, err)
+			return
+		}
+		fmt.Println("传输成功: ", savePath)
+		t.streamCount++
+		t.streamSize += src.FileSize
+	}
+	folder.existed[name] = &aliyunpan.FileEntity{FileName: name, ContentHash: src.ContentHash}
+	result.Success = true
+	t.save(result)
+}
+
+// streamFile 从源账号读取文件数据上传到目标账号
+func (t *panTransferTask) streamFile(srcReader *panFileRangeReader, uploadOpEntity *aliyunpan.CreateFileUploadResult, targetPath string) error {
+	muer := uploader.NewMultiUploader(
+		panupload.NewPanUpload(t.dstUser.PanClient(), targetPath, t.dstDriveId, uploadOpEntity, false),
+		&panFileStreamReader{
+			reader:    srcReader,
+			blockSize: aliyunpan.DefaultChunkSize,
+		}, &uploader.MultiUploaderConfig{
+			Parallel:    1,
+			BlockSize:   aliyunpan.DefaultChunkSize,
+			MaxRate:     config.Config.MaxUploadRate,
+			MaxRateFunc: config.Config.MaxUploadRateNow,
+		}, uploadOpEntity, t.globalSpeedsStat)
+	muer.OnUploadStatusEvent(func(status uploader.Status, updateChan <-chan struct{}) {
+		fmt.Printf("\r↑ %s/%s %s/s in %s ............",
+			converter.ConvertFileSize(status.Uploaded(), 2),
+			converter.ConvertFileSize(status.TotalSize(), 2),
+			converter.ConvertFileSize(status.SpeedsPerSecond(), 2),
+			status.TimeElapsed(),
+		)
+	})
+	err := muer.Execute()
+	fmt.Printf("\n")
+	return err
+}
+
+func (t *panTransferTask) save(result *transferResultItem) {
+	if err := t.progress.Save(result); err != nil {
+		logger.Verboseln("save transfer progress error: ", err)
+	}
+}
+
+func (t *panTransferTask) fail(result *transferResultItem, err error) {
+	fmt.Printf("传输失败: %s, %s\n", result.Path, err)
+	result.Error = err.Error()
+	t.failedList = append(t.failedList, result)
+	if result.Sha1 != "" {
+		t.save(result)
+	}
+}
+
+// Len 文件大小
+func (r *panFileStreamReader) Len() int64 {
+	return r.reader.Len()
+}
+
+// ReadAt 读取文件数据，不在缓存中的数据按块下载
+func (r *panFileStreamReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n := 0
+	for n < len(p) && off+int64(n) < r.Len() {
+		cur := off + int64(n)
+		if r.buf == nil || cur < r.bufOffset || cur >= r.bufOffset+int64(len(r.buf)) {
+			if err := r.fill(cur / r.blockSize * r.blockSize); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.buf[cur-r.bufOffset:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fill 下载指定位置的一块数据，下载链接过期时重新获取一次
+func (r *panFileStreamReader) fill(offset int64) error {
+	size := r.blockSize
+	if offset+size > r.Len() {
+		size = r.Len() - offset
+	}
+	buf := make([]byte, size)
+	_, err := r.reader.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		if rr, ok := r.reader.(*panFileRangeReader); ok {
+			logger.Verboseln("read source file error, retry with new download url: ", err)
+			rr.downloadUrl = ""
+			_, err = r.reader.ReadAt(buf, offset)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	r.buf = buf
+	r.bufOffset = offset
+	return nil
+}
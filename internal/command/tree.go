@@ -0,0 +1,196 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+	"path"
+	"sort"
+)
+
+type (
+	// TreeOptions 树形显示目录可选项
+	TreeOptions struct {
+		MaxDepth   int  // 最大深度，0代表不限制
+		FolderOnly bool // 只显示目录
+		ShowSize   bool // 显示文件大小
+	}
+
+	// treeStat 树形显示的统计
+	treeStat struct {
+		fileCount   int64
+		folderCount int64
+		totalSize   int64
+	}
+)
+
+func CmdTree() cli.Command {
+	return cli.Command{
+		Name:      "tree",
+		Usage:     "树形显示目录",
+		UsageText: cmder.App().Name + " tree [-depth <深度>] <目录>",
+		Description: `
+	以树形结构显示指定目录(默认为当前工作目录)内的文件和目录
+
+	示例:
+
+	显示 /我的资源 的目录结构
+	aliyunpan tree /我的资源
+
+	只显示 2 层目录, 不显示文件
+	aliyunpan tree -depth 2 -d /我的资源
+
+	显示文件大小
+	aliyunpan tree -size /我的资源
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			if c.Int("depth") < 0 {
+				fmt.Println("深度不能小于0")
+				return nil
+			}
+			RunTree(parseDriveId(c), c.Args().Get(0), &TreeOptions{
+				MaxDepth:   c.Int("depth"),
+				FolderOnly: c.Bool("d"),
+				ShowSize:   c.Bool("size"),
+			})
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.IntFlag{
+				Name:  "depth",
+				Usage: "显示的最大目录深度, 0代表不限制",
+				Value: 0,
+			},
+			cli.BoolFlag{
+				Name:  "d",
+				Usage: "只显示目录",
+			},
+			cli.BoolFlag{
+				Name:  "size",
+				Usage: "显示文件大小",
+			},
+		},
+	}
+}
+
+// listPanDirSorted 通过目录缓存获取目录内的文件，目录在前，按照文件名排序
+func listPanDirSorted(activeUser *config.PanUser, driveId, dirPath string) (aliyunpan.FileList, error) {
+	files, apierr := activeUser.CacheFilesDirectoriesListByDriveId(driveId, dirPath)
+	if apierr != nil {
+		return nil, apierr
+	}
+	return sortPanDirFiles(files), nil
+}
+
+// sortPanDirFiles 目录在前，按照文件名排序，忽略空项
+func sortPanDirFiles(files aliyunpan.FileList) aliyunpan.FileList {
+	sorted := make(aliyunpan.FileList, 0, len(files))
+	for _, f := range files {
+		if f != nil {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].IsFolder() != sorted[j].IsFolder() {
+			return sorted[i].IsFolder()
+		}
+		return sorted[i].FileName < sorted[j].FileName
+	})
+	return sorted
+}
+
+// RunTree 执行树形显示目录
+func RunTree(driveId, targetPath string, options *TreeOptions) {
+	activeUser := config.Config.ActiveUser()
+	targetPath = path.Clean(activeUser.PathJoin(driveId, targetPath))
+	targetPathInfo, err := activeUser.PanClient().FileInfoByPath(driveId, targetPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !targetPathInfo.IsFolder() {
+		fmt.Println("指定的路径不是目录: ", targetPath)
+		return
+	}
+
+	stat := &treeStat{}
+	fmt.Println(targetPath)
+	printPanTree(activeUser, driveId, targetPath, "", 1, options, stat)
+
+	if options.FolderOnly {
+		fmt.Printf("\n%d 个目录\n", stat.folderCount)
+	} else if options.ShowSize {
+		fmt.Printf("\n%d 个目录, %d 个文件, 总大小: %s\n", stat.folderCount, stat.fileCount, converter.ConvertFileSize(stat.totalSize, 2))
+	} else {
+		fmt.Printf("\n%d 个目录, %d 个文件\n", stat.folderCount, stat.fileCount)
+	}
+}
+
+// printPanTree 递归输出目录树，prefix 为当前层级的缩进前缀
+func printPanTree(activeUser *config.PanUser, driveId, dirPath, prefix string, depth int, options *TreeOptions, stat *treeStat) {
+	files, err := listPanDirSorted(activeUser, driveId, dirPath)
+	if err != nil {
+		fmt.Printf("%s└── [读取目录失败: %s]\n", prefix, err)
+		return
+	}
+	if options.FolderOnly {
+		folders := aliyunpan.FileList{}
+		for _, f := range files {
+			if f.IsFolder() {
+				folders = append(folders, f)
+			}
+		}
+		files = folders
+	}
+
+	for k, f := range files {
+		connector, childPrefix := "├── ", prefix+"│   "
+		if k == len(files)-1 {
+			connector, childPrefix = "└── ", prefix+"    "
+		}
+
+		name := f.FileName
+		if f.IsFolder() {
+			stat.folderCount++
+			name += aliyunpan.PathSeparator
+		} else {
+			stat.fileCount++
+			stat.totalSize += f.FileSize
+			if options.ShowSize {
+				name = fmt.Sprintf("[%s]  %s", converter.ConvertFileSize(f.FileSize, 2), name)
+			}
+		}
+		fmt.Println(prefix + connector + name)
+
+		if f.IsFolder() && (options.MaxDepth <= 0 || depth < options.MaxDepth) {
+			printPanTree(activeUser, driveId, path.Join(dirPath, f.FileName), childPrefix, depth+1, options, stat)
+		}
+	}
+}
@@ -186,8 +186,11 @@ func CmdRapidUpload() cli.Command {
 	使用此功能秒传文件, 前提是知道文件的大小, sha1, 且网盘中存在一模一样的文件.
 	上传的文件将会保存到网盘的目标目录。文件的秒传链接可以通过share或者export命令获取。
 
-	链接格式说明：aliyunpan://文件名|sha1|文件大小|<相对路径>
-    "相对路径" 可以为空，为空代表存储到网盘根目录
+	秒传链接为 JSON 格式，和 export 命令导出的文件中每一行的格式相同：
+	{"name":"file.dmg","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":7005440,"path":"pan_folder","proof":{"userId":"...","driveId":"...","fileId":"..."}}
+	"path" 为相对路径，可以为空，为空代表存储到网盘根目录。"proof" 为秒传证明数据，需要创建秒传链接的账号在本机登录过。
+
+	同时兼容旧的链接格式：aliyunpan://文件名|sha1|文件大小|<相对路径>
 
 	示例:
 	1. 如果秒传成功, 则保存到网盘路径 /pan_folder/file.dmg
@@ -196,7 +199,10 @@ func CmdRapidUpload() cli.Command {
 	2. 如果秒传成功, 则保存到网盘路径 /file.dmg
 	aliyunpan rapidupload "aliyunpan://file.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|"
 
-	3. 同时秒传多个文件，如果秒传成功, 则保存到网盘路径 /pan_folder/file.dmg, /pan_folder/file1.dmg
+	3. 使用 JSON 格式的秒传链接, 如果秒传成功, 则保存到网盘路径 /myfolder/pan_folder/file.dmg
+	aliyunpan rapidupload -path=/myfolder '{"name":"file.dmg","sha1":"752FCCBFB2436A6FFCA3B287831D4FAA5654B07E","size":7005440,"path":"pan_folder","proof":{"userId":"...","driveId":"...","fileId":"..."}}'
+
+	4. 同时秒传多个文件，如果秒传成功, 则保存到网盘路径 /pan_folder/file.dmg, /pan_folder/file1.dmg
 	aliyunpan rapidupload "aliyunpan://file.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|pan_folder" "aliyunpan://file1.dmg|752FCCBFB2436A6FFCA3B287831D4FAA5654B07E|7005440|pan_folder"
 `,
 		Category: "阿里云盘",
@@ -461,24 +467,17 @@ func RunRapidUpload(driveId string, isOverwrite bool, fileMetaList []string, sav
 		return
 	}
 
-	items := []*RapidUploadItem{}
-	// parse file meta strings
-	for _, fileMeta := range fileMetaList {
-		item, e := newRapidUploadItem(fileMeta)
-		if e != nil {
-			fmt.Println(e)
-			continue
-		}
-		if item == nil {
-			fmt.Println("秒传链接格式错误: ", fileMeta)
-			continue
-		}
-
+	items, lineErrs, err := parseRapidUploadLines(fileMetaList)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, e := range lineErrs {
+		fmt.Println(e)
+	}
+	for _, item := range items {
 		// pan path
 		item.FilePath = path.Join(savePanPath, item.FilePath)
-
-		// append
-		items = append(items, item)
 	}
 
 	// upload one by one
@@ -492,13 +491,13 @@ func RunRapidUpload(driveId string, isOverwrite bool, fileMetaList []string, sav
 	}
 }
 
+// doRapidUpload 秒传单个文件，FilePath 为网盘绝对路径
 func doRapidUpload(driveId string, isOverwrite bool, item *RapidUploadItem) error {
 	activeUser := GetActiveUser()
 	panClient := activeUser.PanClient()
 
 	var apierr *apierror.ApiError
 	var rs *aliyunpan.MkdirResult
-	var appCreateUploadFileParam *aliyunpan.CreateFileUploadParam
 	var saveFilePath string
 
 	panDir, panFileName := path.Split(item.FilePath)
@@ -536,22 +535,10 @@ func doRapidUpload(driveId string, isOverwrite bool, item *RapidUploadItem) erro
 		}
 	}
 
-	appCreateUploadFileParam = &aliyunpan.CreateFileUploadParam{
-		DriveId:      driveId,
-		Name:         panFileName,
-		Size:         item.FileSize,
-		ContentHash:  item.FileSha1,
-		ParentFileId: rs.FileId,
-	}
-	uploadOpEntity, apierr := panClient.CreateUploadFile(appCreateUploadFileParam)
-	if apierr != nil {
-		return fmt.Errorf("创建秒传任务失败：" + apierr.Error())
-	}
-
-	if uploadOpEntity.RapidUpload {
-		logger.Verboseln("秒传成功, 保存到网盘路径: ", path.Join(panDir, uploadOpEntity.FileName))
-	} else {
-		return fmt.Errorf("失败，文件未曾上传，无法秒传")
+	uploadOpEntity, err := createRapidUploadFile(panClient, driveId, rs.FileId, panFileName, item)
+	if err != nil {
+		return err
 	}
+	logger.Verboseln("秒传成功, 保存到网盘路径: ", path.Join(panDir, uploadOpEntity.FileName))
 	return nil
 }
@@ -0,0 +1,328 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/tickstep/aliyunpan/cmder"
+	"github.com/tickstep/aliyunpan/cmder/cmdtable"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/localfile"
+	"github.com/tickstep/aliyunpan/internal/syncdrive"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/internal/waitgroup"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// VerifyStatus 校验结果
+	VerifyStatus string
+
+	// VerifyOptions 校验可选项
+	VerifyOptions struct {
+		Parallel int  // 计算哈希的并发数
+		Restart  bool // 忽略上次的校验进度，重新校验
+	}
+
+	// verifyResultItem 一个文件的校验结果，同时也是校验进度文件的一行
+	verifyResultItem struct {
+		Path   string       `json:"path"`
+		Size   int64        `json:"size"`
+		Status VerifyStatus `json:"status"`
+		Reason string       `json:"reason,omitempty"`
+	}
+
+	// verifyProgress 校验进度文件，每校验完成一个文件追加一行，中断后可以继续校验
+	verifyProgress struct {
+		*jsonLinesProgress
+	}
+)
+
+const (
+	// VerifyStatusOk 校验通过
+	VerifyStatusOk VerifyStatus = "ok"
+	// VerifyStatusMismatch 校验值不一致
+	VerifyStatusMismatch VerifyStatus = "mismatch"
+	// VerifyStatusMissing 云盘存在，本地缺失
+	VerifyStatusMissing VerifyStatus = "missing"
+	// VerifyStatusExtra 本地存在，云盘没有
+	VerifyStatusExtra VerifyStatus = "extra"
+	// VerifyStatusError 校验出错
+	VerifyStatusError VerifyStatus = "error"
+)
+
+var (
+	verifyStatusNames = map[VerifyStatus]string{
+		VerifyStatusOk:       "通过",
+		VerifyStatusMismatch: "不一致",
+		VerifyStatusMissing:  "本地缺失",
+		VerifyStatusExtra:    "本地多余",
+		VerifyStatusError:    "出错",
+	}
+)
+
+func CmdVerify() cli.Command {
+	return cli.Command{
+		Name:      "verify",
+		Usage:     "校验本地目录和云盘目录的文件完整性",
+		UsageText: cmder.App().Name + " verify [-p <并发数>] <本地目录> <云盘目录>",
+		Description: `
+	计算本地目录内每个文件的SHA1(云盘文件有CRC64时同时计算CRC64), 和云盘目录内对应文件的校验值对比,
+	用于确认下载或者上传的备份是完整的. 会报告校验值不一致、本地缺失和本地多余的文件.
+
+	校验进度会保存到配置目录的 verify 文件夹中, 校验中断后再次执行相同的命令会跳过已经校验过的文件,
+	使用 -restart 可以忽略上次的进度重新校验. 全部校验完成后会删除进度文件.
+
+	存在问题时退出码为1, 出错时退出码为2, 方便在脚本中使用.
+
+	示例:
+
+	校验下载到本地的 /我的资源 目录
+	aliyunpan verify "D:\Downloads\我的资源" /我的资源
+
+	使用8个并发计算哈希
+	aliyunpan verify -p 8 "D:\Downloads\我的资源" /我的资源
+`,
+		Category: "阿里云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			parallel := c.Int("p")
+			if parallel <= 0 {
+				parallel = 1
+			}
+			ok, err := RunVerify(parseDriveId(c), c.Args().Get(0), c.Args().Get(1), &VerifyOptions{
+				Parallel: parallel,
+				Restart:  c.Bool("restart"),
+			})
+			if err != nil {
+				fmt.Println(err)
+				return exitCodeError(2)
+			}
+			if !ok {
+				return exitCodeError(1)
+			}
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "driveId",
+				Usage: "网盘ID",
+				Value: "",
+			},
+			cli.IntFlag{
+				Name:  "p",
+				Usage: "计算哈希的并发数",
+				Value: 4,
+			},
+			cli.BoolFlag{
+				Name:  "restart",
+				Usage: "忽略上次的校验进度, 重新校验",
+			},
+		},
+	}
+}
+
+// openVerifyProgress 打开校验进度文件，restart 为 true 时清空之前的进度
+func openVerifyProgress(progressFilePath string, restart bool) (*verifyProgress, error) {
+	p, err := openJsonLinesProgress(progressFilePath, restart, func() progressItem {
+		return &verifyResultItem{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &verifyProgress{p}, nil
+}
+
+func (item *verifyResultItem) progressKey() string {
+	return item.Path
+}
+
+// Get 获取已经校验过的文件的结果，文件大小改变则需要重新校验
+func (vp *verifyProgress) Get(relativePath string, size int64) *verifyResultItem {
+	item, ok := vp.get(relativePath).(*verifyResultItem)
+	if !ok || item.Size != size || item.Status == VerifyStatusError {
+		return nil
+	}
+	return item
+}
+
+// verifyFileHash 计算本地文件的哈希并和云盘文件对比
+func verifyFileHash(localFile *syncdrive.LocalFileItem, panFile *syncdrive.PanFileItem) (VerifyStatus, string) {
+	if panFile.Sha1Hash == "" && panFile.Crc64Hash == "" {
+		return VerifyStatusError, "云盘文件没有校验值"
+	}
+	flag := 0
+	if panFile.Sha1Hash != "" {
+		flag |= localfile.CHECKSUM_SHA1
+	}
+	if panFile.Crc64Hash != "" {
+		flag |= localfile.CHECKSUM_CRC64
+	}
+	lfs, err := localfile.GetFileSum(localFile.Path, flag)
+	if err != nil {
+		return VerifyStatusError, "读取本地文件出错: " + err.Error()
+	}
+	if lfs.Length != panFile.FileSize {
+		return VerifyStatusMismatch, "文件大小不同"
+	}
+	if panFile.Sha1Hash != "" && !strings.EqualFold(lfs.SHA1, panFile.Sha1Hash) {
+		return VerifyStatusMismatch, "SHA1不同"
+	}
+	if panFile.Crc64Hash != "" && strconv.FormatUint(lfs.CRC64, 10) != panFile.Crc64Hash {
+		return VerifyStatusMismatch, "CRC64不同"
+	}
+	return VerifyStatusOk, ""
+}
+
+// RunVerify 校验本地目录和云盘目录的文件完整性，返回是否全部校验通过
+func RunVerify(driveId, localFolderPath, panFolderPath string, options *VerifyOptions) (bool, error) {
+	activeUser := config.Config.ActiveUser()
+	panFolderPath = path.Clean(activeUser.PathJoin(driveId, panFolderPath))
+	localFolderPath, err := filepath.Abs(localFolderPath)
+	if err != nil {
+		return false, err
+	}
+	if fi, err := os.Stat(localFolderPath); err != nil || !fi.IsDir() {
+		return false, fmt.Errorf("本地目录不存在: %s", localFolderPath)
+	}
+	panFolder, apierr := activeUser.PanClient().FileInfoByPath(driveId, panFolderPath)
+	if apierr != nil || !panFolder.IsFolder() {
+		return false, fmt.Errorf("云盘目录不存在: %s", panFolderPath)
+	}
+
+	fmt.Println("正在扫描本地目录和云盘目录...")
+	localFiles, err := scanLocalFolder(localFolderPath)
+	if err != nil {
+		return false, fmt.Errorf("扫描本地目录出错: %s", err)
+	}
+	panFiles, err := scanPanFolder(activeUser.PanClient(), driveId, panFolderPath)
+	if err != nil {
+		return false, fmt.Errorf("扫描云盘目录出错: %s", err)
+	}
+
+	progressFilePath := filepath.Join(config.GetConfigDir(), "verify", utils.Md5Str(activeUser.UserId+"|"+driveId+"|"+localFolderPath+"|"+panFolderPath)+".jsonl")
+	progress, err := openVerifyProgress(progressFilePath, options.Restart)
+	if err != nil {
+		return false, fmt.Errorf("打开校验进度文件出错: %s", err)
+	}
+
+	results := []*verifyResultItem{}
+	resultMutex := sync.Mutex{}
+	addResult := func(item *verifyResultItem) {
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		results = append(results, item)
+	}
+
+	// 只校验文件，大小不同的文件不需要计算哈希
+	var totalSize, resumedCount int64
+	needToHash := []*syncdrive.DiffItem{}
+	for _, item := range syncdrive.DiffFileList(localFolderPath, localFiles, panFolderPath, panFiles, nil) {
+		switch item.Status {
+		case syncdrive.DiffStatusAdded:
+			if !item.LocalFile.IsFolder() {
+				addResult(&verifyResultItem{Path: item.RelativePath, Size: item.LocalFile.FileSize, Status: VerifyStatusExtra})
+			}
+		case syncdrive.DiffStatusRemoved:
+			if !item.PanFile.IsFolder() {
+				addResult(&verifyResultItem{Path: item.RelativePath, Size: item.PanFile.FileSize, Status: VerifyStatusMissing})
+			}
+		case syncdrive.DiffStatusChanged:
+			addResult(&verifyResultItem{Path: item.RelativePath, Size: item.PanFile.FileSize, Status: VerifyStatusMismatch, Reason: item.Reason})
+		case syncdrive.DiffStatusIdentical:
+			if item.PanFile.IsFolder() {
+				continue
+			}
+			if done := progress.Get(item.RelativePath, item.PanFile.FileSize); done != nil {
+				addResult(done)
+				resumedCount++
+				continue
+			}
+			needToHash = append(needToHash, item)
+			totalSize += item.PanFile.FileSize
+		}
+	}
+	if resumedCount > 0 {
+		fmt.Printf("继续上次的校验进度, 跳过已经校验的文件 %d 个\n", resumedCount)
+	}
+
+	var doneCount, doneSize int64
+	wg := waitgroup.NewWaitGroup(options.Parallel)
+	for _, item := range needToHash {
+		wg.AddDelta()
+		go func(item *syncdrive.DiffItem) {
+			defer wg.Done()
+			status, reason := verifyFileHash(item.LocalFile, item.PanFile)
+			result := &verifyResultItem{Path: item.RelativePath, Size: item.PanFile.FileSize, Status: status, Reason: reason}
+			if err := progress.Save(result); err != nil {
+				fmt.Printf("\n保存校验进度出错: %s\n", err)
+			}
+			addResult(result)
+
+			resultMutex.Lock()
+			doneCount++
+			doneSize += item.PanFile.FileSize
+			fmt.Printf("\r已校验: %d/%d, %s/%s  ", doneCount, len(needToHash),
+				converter.ConvertFileSize(doneSize, 2), converter.ConvertFileSize(totalSize, 2))
+			resultMutex.Unlock()
+		}(item)
+	}
+	wg.Wait()
+	if len(needToHash) > 0 {
+		fmt.Println()
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	count := map[VerifyStatus]int{}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "校验结果", "文件", "说明"})
+	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	problemCount := 0
+	for _, item := range results {
+		count[item.Status]++
+		if item.Status == VerifyStatusOk {
+			continue
+		}
+		problemCount++
+		tb.Append([]string{strconv.Itoa(problemCount), verifyStatusNames[item.Status], strings.TrimPrefix(item.Path, "/"), item.Reason})
+	}
+	if problemCount > 0 {
+		tb.Render()
+	}
+	fmt.Printf("通过: %d, 不一致: %d, 本地缺失: %d, 本地多余: %d, 出错: %d\n",
+		count[VerifyStatusOk], count[VerifyStatusMismatch], count[VerifyStatusMissing], count[VerifyStatusExtra], count[VerifyStatusError])
+
+	// 有出错的文件时保留进度文件，再次校验时只需要重新校验出错的文件
+	progress.Close(count[VerifyStatusError] == 0)
+	return problemCount == 0, nil
+}
@@ -75,6 +75,8 @@ aliyunpan webdav start -h
 						PanUser: nil,
 						UploadChunkSize: c.Int("bs") * 1024,
 						TransferUrlType: config.Config.TransferUrlType,
+						MaxDownloadRate: config.Config.MaxDownloadRateNow,
+						MaxUploadRate:   config.Config.MaxUploadRateNow,
 						Address:   "0.0.0.0",
 						Port:      23077,
 						Prefix:    "/",
@@ -0,0 +1,237 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// BandwidthSchedule 按时间段限速
+	BandwidthSchedule struct {
+		Start           string `json:"start"`           // 开始时间，格式 HH:MM
+		End             string `json:"end"`             // 结束时间，格式 HH:MM，小于开始时间代表跨越零点，等于开始时间代表全天
+		MaxDownloadRate int64  `json:"maxDownloadRate"` // 该时间段最大下载速度，单位 B/s, 0代表不限制
+		MaxUploadRate   int64  `json:"maxUploadRate"`   // 该时间段最大上传速度，单位 B/s, 0代表不限制
+	}
+	BandwidthScheduleList []*BandwidthSchedule
+
+	// bandwidthConfig 配置文件中的限速配置，用于运行期间重新读取
+	bandwidthConfig struct {
+		MaxDownloadRate    int64                 `json:"maxDownloadRate"`
+		MaxUploadRate      int64                 `json:"maxUploadRate"`
+		BandwidthSchedules BandwidthScheduleList `json:"bandwidthSchedules"`
+	}
+)
+
+const (
+	// bandwidthReloadInterval 检查配置文件限速配置是否修改的间隔
+	bandwidthReloadInterval = 10 * time.Second
+)
+
+// parseClock 解析 HH:MM 格式的时间，返回从零点开始的分钟数
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误, 请使用 HH:MM 格式: %s", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseRate 解析速度，支持 2MB 或者 2MB/s 格式
+func parseRate(rateStr string) (int64, error) {
+	rate, err := converter.ParseFileSizeStr(stripPerSecond(strings.TrimSpace(rateStr)))
+	if err != nil {
+		return 0, fmt.Errorf("速度格式错误: %s", rateStr)
+	}
+	return rate, nil
+}
+
+// Contains 时间是否在该时间段内
+func (s *BandwidthSchedule) Contains(t time.Time) bool {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start == end {
+		return true
+	}
+	if start < end {
+		return now >= start && now < end
+	}
+	// 跨越零点
+	return now >= start || now < end
+}
+
+func (s *BandwidthSchedule) String() string {
+	return fmt.Sprintf("%s-%s=%s:%s", s.Start, s.End, showMaxRate(s.MaxDownloadRate), showMaxRate(s.MaxUploadRate))
+}
+
+// Find 查找时间所在的时间段，多个时间段重叠时以第一个为准，没有则返回nil
+func (sl BandwidthScheduleList) Find(t time.Time) *BandwidthSchedule {
+	for _, s := range sl {
+		if s != nil && s.Contains(t) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (sl BandwidthScheduleList) String() string {
+	if len(sl) == 0 {
+		return ""
+	}
+	items := []string{}
+	for _, s := range sl {
+		items = append(items, s.String())
+	}
+	return strings.Join(items, ",")
+}
+
+// ParseBandwidthSchedule 解析按时间段限速的配置，多个时间段使用逗号分隔，例如：
+// 01:00-07:00=0,09:00-18:00=2MB:1MB
+// 等号后面为 下载速度:上传速度，只指定一个速度则上传和下载使用相同的速度，0代表不限制
+func ParseBandwidthSchedule(scheduleStr string) (BandwidthScheduleList, error) {
+	sl := BandwidthScheduleList{}
+	for _, item := range strings.Split(scheduleStr, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.Index(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("时间段格式错误, 缺少速度: %s", item)
+		}
+		timeRange := strings.Split(item[:idx], "-")
+		if len(timeRange) != 2 {
+			return nil, fmt.Errorf("时间段格式错误, 请使用 HH:MM-HH:MM 格式: %s", item[:idx])
+		}
+		s := &BandwidthSchedule{}
+		for i, clock := range timeRange {
+			minutes, err := parseClock(clock)
+			if err != nil {
+				return nil, err
+			}
+			clock = fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+			if i == 0 {
+				s.Start = clock
+			} else {
+				s.End = clock
+			}
+		}
+
+		rates := strings.Split(item[idx+1:], ":")
+		if len(rates) > 2 {
+			return nil, fmt.Errorf("速度格式错误, 请使用 下载速度:上传速度 格式: %s", item[idx+1:])
+		}
+		rate, err := parseRate(rates[0])
+		if err != nil {
+			return nil, err
+		}
+		s.MaxDownloadRate, s.MaxUploadRate = rate, rate
+		if len(rates) == 2 {
+			if s.MaxUploadRate, err = parseRate(rates[1]); err != nil {
+				return nil, err
+			}
+		}
+		sl = append(sl, s)
+	}
+	return sl, nil
+}
+
+// SetBandwidthScheduleByStr 设置 bandwidth_schedule，为空则清除按时间段限速
+func (c *PanConfig) SetBandwidthScheduleByStr(scheduleStr string) error {
+	sl, err := ParseBandwidthSchedule(scheduleStr)
+	if err != nil {
+		return err
+	}
+	c.bandwidthMu.Lock()
+	defer c.bandwidthMu.Unlock()
+	c.BandwidthSchedules = sl
+	return nil
+}
+
+// reloadBandwidthConfig 配置文件修改后重新读取限速配置，使其他进程修改的限速配置对正在运行的传输生效
+func (c *PanConfig) reloadBandwidthConfig(now time.Time) {
+	if c.configFilePath == "" || now.Sub(c.bandwidthCheckTime) < bandwidthReloadInterval {
+		return
+	}
+	c.bandwidthCheckTime = now
+	info, err := os.Stat(c.configFilePath)
+	if err != nil {
+		return
+	}
+	if c.bandwidthModTime.IsZero() {
+		// 第一次检查，配置已经在初始化时载入
+		c.bandwidthModTime = info.ModTime()
+		return
+	}
+	if info.ModTime().Equal(c.bandwidthModTime) {
+		return
+	}
+	data, err := ioutil.ReadFile(c.configFilePath)
+	if err != nil {
+		return
+	}
+	bc := &bandwidthConfig{}
+	if err = jsoniter.Unmarshal(data, bc); err != nil {
+		// 文件可能正在写入，下次再读取
+		logger.Verboseln("reload bandwidth config error: ", err)
+		return
+	}
+	c.bandwidthModTime = info.ModTime()
+	c.MaxDownloadRate = bc.MaxDownloadRate
+	c.MaxUploadRate = bc.MaxUploadRate
+	c.BandwidthSchedules = bc.BandwidthSchedules
+}
+
+// currentRate 获取当前生效的最大速度，在限速时间段内使用时间段的速度，否则使用 max_download_rate / max_upload_rate
+func (c *PanConfig) currentRate(upload bool) int64 {
+	c.bandwidthMu.Lock()
+	defer c.bandwidthMu.Unlock()
+	now := time.Now()
+	c.reloadBandwidthConfig(now)
+	if s := c.BandwidthSchedules.Find(now); s != nil {
+		if upload {
+			return s.MaxUploadRate
+		}
+		return s.MaxDownloadRate
+	}
+	if upload {
+		return c.MaxUploadRate
+	}
+	return c.MaxDownloadRate
+}
+
+// MaxDownloadRateNow 当前生效的最大下载速度，单位 B/s, 0代表不限制
+func (c *PanConfig) MaxDownloadRateNow() int64 {
+	return c.currentRate(false)
+}
+
+// MaxUploadRateNow 当前生效的最大上传速度，单位 B/s, 0代表不限制
+func (c *PanConfig) MaxUploadRateNow() int64 {
+	return c.currentRate(true)
+}
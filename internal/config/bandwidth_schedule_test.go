@@ -0,0 +1,63 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	sl, err := ParseBandwidthSchedule("01:00-07:00=0, 9:00-18:00=2MB/s:1MB, 22:00-01:00=512KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(sl)
+	if len(sl) != 3 || sl[1].Start != "09:00" || sl[1].MaxDownloadRate != 2*1024*1024 || sl[1].MaxUploadRate != 1024*1024 {
+		t.Fatal("parse bandwidth schedule error")
+	}
+	if sl[2].MaxDownloadRate != 512*1024 || sl[2].MaxUploadRate != 512*1024 {
+		t.Fatal("parse single rate error")
+	}
+
+	for _, str := range []string{"01:00-07:00", "01:00=1MB", "25:00-07:00=1MB", "01:00-07:00=1MB:1MB:1MB"} {
+		if _, err = ParseBandwidthSchedule(str); err == nil {
+			t.Fatal("should be error: ", str)
+		}
+	}
+}
+
+func TestBandwidthScheduleFind(t *testing.T) {
+	sl, _ := ParseBandwidthSchedule("01:00-07:00=0,09:00-18:00=2MB,22:00-01:00=512KB")
+	day := time.Date(2022, 6, 1, 0, 0, 0, 0, time.Local)
+	cases := map[string]*BandwidthSchedule{
+		"00:30": sl[2],
+		"01:00": sl[0],
+		"06:59": sl[0],
+		"07:00": nil,
+		"12:00": sl[1],
+		"18:00": nil,
+		"23:00": sl[2],
+	}
+	for clock, expected := range cases {
+		minutes, _ := parseClock(clock)
+		if s := sl.Find(day.Add(time.Duration(minutes) * time.Minute)); s != expected {
+			t.Fatal("find bandwidth schedule error: ", clock)
+		}
+	}
+
+	allDay := &BandwidthSchedule{Start: "08:00", End: "08:00"}
+	fmt.Println(allDay.Contains(day))
+}
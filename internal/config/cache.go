@@ -28,14 +28,19 @@ func (pu *PanUser) DeleteOneCache(dirPath string) {
 
 // CacheFilesDirectoriesList 缓存获取
 func (pu *PanUser) CacheFilesDirectoriesList(pathStr string) (fdl aliyunpan.FileList, apiError *apierror.ApiError) {
-	data := pu.cacheOpMap.CacheOperation(pu.ActiveDriveId, pathStr+"_OrderByName", func() expires.DataExpires {
+	return pu.CacheFilesDirectoriesListByDriveId(pu.ActiveDriveId, pathStr)
+}
+
+// CacheFilesDirectoriesListByDriveId 缓存获取指定网盘的目录
+func (pu *PanUser) CacheFilesDirectoriesListByDriveId(driveId, pathStr string) (fdl aliyunpan.FileList, apiError *apierror.ApiError) {
+	data := pu.cacheOpMap.CacheOperation(driveId, pathStr+"_OrderByName", func() expires.DataExpires {
 		var fi *aliyunpan.FileEntity
-		fi, apiError = pu.panClient.FileInfoByPath(pu.ActiveDriveId, pathStr)
+		fi, apiError = pu.panClient.FileInfoByPath(driveId, pathStr)
 		if apiError != nil {
 			return nil
 		}
 		fileListParam := &aliyunpan.FileListParam{
-			DriveId:      pu.ActiveDriveId,
+			DriveId:      driveId,
 			ParentFileId: fi.FileId,
 		}
 		fdl, apiError = pu.panClient.FileListGetAll(fileListParam, 100)
@@ -28,6 +28,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -88,6 +89,8 @@ type PanConfig struct {
 	MaxUploadRate   int64 `json:"maxUploadRate"`   // 限制最大上传速度，单位 B/s, 即字节/每秒
 	TransferUrlType int   `json:"transferUrlType"` // 上传/下载URL类别，1-默认，2-阿里云ECS
 
+	BandwidthSchedules BandwidthScheduleList `json:"bandwidthSchedules"` // 按时间段限速，时间段外使用 MaxDownloadRate / MaxUploadRate
+
 	SaveDir string `json:"saveDir"` // 下载储存路径
 
 	Proxy           string          `json:"proxy"`      // 代理
@@ -98,6 +101,10 @@ type PanConfig struct {
 	configFile     *os.File
 	fileMu         sync.Mutex
 	activeUser     *PanUser
+
+	bandwidthMu        sync.Mutex // 保护限速配置的读写, 传输过程中会重新载入限速配置
+	bandwidthCheckTime time.Time  // 上一次检查配置文件限速配置的时间
+	bandwidthModTime   time.Time  // 已载入的配置文件修改时间
 }
 
 // NewConfig 返回 PanConfig 指针对象
@@ -141,7 +148,10 @@ func (c *PanConfig) Save() error {
 	c.fileMu.Lock()
 	defer c.fileMu.Unlock()
 
+	// 限速配置可能正在被传输中的重新载入修改
+	c.bandwidthMu.Lock()
 	data, err := jsoniter.MarshalIndent(c, "", " ")
+	c.bandwidthMu.Unlock()
 	if err != nil {
 		// json数据生成失败
 		panic(err)
@@ -238,10 +248,13 @@ func (c *PanConfig) loadConfigFromFile() (err error) {
 		return err
 	}
 
+	c.bandwidthMu.Lock()
 	err = jsonhelper.UnmarshalData(c.configFile, c)
+	c.bandwidthMu.Unlock()
 	if err != nil {
 		return ErrConfigContentsParseError
 	}
+	c.bandwidthModTime = info.ModTime()
 	return nil
 }
 
@@ -431,6 +444,30 @@ func (c *PanConfig) SwitchUser(uid, username string) (*PanUser, error) {
 	return nil, fmt.Errorf("未找到指定的账号")
 }
 
+// GetLoginUser 获取已登录的用户，不切换当前登录用户
+func (c *PanConfig) GetLoginUser(uid, username string) (*PanUser, error) {
+	for _, u := range c.UserList {
+		if u.UserId != uid && (username == "" || u.AccountName != username) {
+			continue
+		}
+		if u.UserId == c.ActiveUID {
+			return c.ActiveUser(), nil
+		}
+		if u.PanClient() == nil {
+			// restore client
+			user, err := SetupUserByCookie(&u.WebToken)
+			if err != nil {
+				return nil, fmt.Errorf("账号 %s 登录失败: %s", u.Nickname, err)
+			}
+			u.panClient = user.panClient
+			u.Nickname = user.Nickname
+			u.DriveList = user.DriveList
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("未找到指定的账号")
+}
+
 // DeleteUser 删除用户，并自动切换登录用户为用户列表第一个
 func (c *PanConfig) DeleteUser(uid string) (*PanUser, error) {
 	for idx, u := range c.UserList {
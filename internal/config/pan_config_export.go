@@ -52,6 +52,8 @@ func (c *PanConfig) SetMaxDownloadRateByStr(sizeStr string) error {
 	if err != nil {
 		return err
 	}
+	c.bandwidthMu.Lock()
+	defer c.bandwidthMu.Unlock()
 	c.MaxDownloadRate = size
 	return nil
 }
@@ -62,12 +64,18 @@ func (c *PanConfig) SetMaxUploadRateByStr(sizeStr string) error {
 	if err != nil {
 		return err
 	}
+	c.bandwidthMu.Lock()
+	defer c.bandwidthMu.Unlock()
 	c.MaxUploadRate = size
 	return nil
 }
 
 // PrintTable 输出表格
 func (c *PanConfig) PrintTable() {
+	c.bandwidthMu.Lock()
+	maxDownloadRate, maxUploadRate, bandwidthSchedules := c.MaxDownloadRate, c.MaxUploadRate, c.BandwidthSchedules
+	c.bandwidthMu.Unlock()
+
 	tb := cmdtable.NewTable(os.Stdout)
 	tb.SetHeader([]string{"名称", "值", "建议值", "描述"})
 	tb.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
@@ -76,8 +84,9 @@ func (c *PanConfig) PrintTable() {
 		[]string{"cache_size", converter.ConvertFileSize(int64(c.CacheSize), 2), "1KB ~ 256KB", "下载缓存, 如果硬盘占用高或下载速度慢, 请尝试调大此值"},
 		[]string{"max_download_parallel", strconv.Itoa(c.MaxDownloadParallel), "1 ~ 20", "最大下载并发量，即同时下载文件最大数量"},
 		[]string{"max_upload_parallel", strconv.Itoa(c.MaxUploadParallel), "1 ~ 20", "最大上传并发量，即同时上传文件最大数量"},
-		[]string{"max_download_rate", showMaxRate(c.MaxDownloadRate), "", "限制单个文件最大下载速度, 0代表不限制"},
-		[]string{"max_upload_rate", showMaxRate(c.MaxUploadRate), "", "限制单个文件最大上传速度, 0代表不限制"},
+		[]string{"max_download_rate", showMaxRate(maxDownloadRate), "", "限制单个文件最大下载速度, 0代表不限制"},
+		[]string{"max_upload_rate", showMaxRate(maxUploadRate), "", "限制单个文件最大上传速度, 0代表不限制"},
+		[]string{"bandwidth_schedule", bandwidthSchedules.String(), "01:00-07:00=0,09:00-18:00=2MB:1MB", "按时间段限制单个文件最大下载:上传速度, 时间段外使用 max_download_rate 和 max_upload_rate, 修改后对正在进行的传输生效"},
 		[]string{"transfer_url_type", strconv.Itoa(c.TransferUrlType), "1-默认，2-阿里云ECS", "上传下载URL类别。除非在阿里云ECS（暂只支持经典网络）服务器中使用，不然请设置1"},
 		[]string{"savedir", c.SaveDir, "", "下载文件的储存目录"},
 		[]string{"proxy", c.Proxy, "", "设置代理, 支持 http/socks5 代理，例如：http://127.0.0.1:8888"},
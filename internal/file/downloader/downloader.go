@@ -17,7 +17,6 @@ import (
 	"context"
 	"errors"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
-	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
 	"github.com/tickstep/aliyunpan/cmder/cmdutil"
 	"github.com/tickstep/aliyunpan/internal/waitgroup"
 	"github.com/tickstep/aliyunpan/library/requester/transfer"
@@ -56,6 +55,7 @@ type (
 		driveId                 string
 		loadBalancerCompareFunc LoadBalancerCompareFunc // 负载均衡检测函数
 		durlCheckFunc           DURLCheckFunc           // 下载url检测函数
+		downloadUrlFunc         DownloadUrlFunc         // 获取下载链接函数
 		statusCodeBodyCheckFunc StatusCodeBodyCheckFunc
 		executeTime             time.Time
 		loadBalansers           []string
@@ -71,6 +71,8 @@ type (
 	DURLCheckFunc func(client *requester.HTTPClient, durl string) (contentLength int64, resp *http.Response, err error)
 	// StatusCodeBodyCheckFunc 响应状态码出错的检查函数
 	StatusCodeBodyCheckFunc func(respBody io.Reader) error
+	// DownloadUrlFunc 获取文件下载链接的函数, 返回下载链接和内网下载链接
+	DownloadUrlFunc func(driveId, fileId string) (durl, internalUrl string, err error)
 )
 
 //NewDownloader 初始化Downloader
@@ -103,6 +105,11 @@ func (der *Downloader) SetLoadBalancerCompareFunc(f LoadBalancerCompareFunc) {
 	der.loadBalancerCompareFunc = f
 }
 
+// SetDownloadUrlFunc 设置获取下载链接函数, 默认获取网盘文件的下载链接
+func (der *Downloader) SetDownloadUrlFunc(f DownloadUrlFunc) {
+	der.downloadUrlFunc = f
+}
+
 //SetStatusCodeBodyCheckFunc 设置响应状态码出错的检查函数, 当FirstCheckMethod不为HEAD时才有效
 func (der *Downloader) SetStatusCodeBodyCheckFunc(f StatusCodeBodyCheckFunc) {
 	der.statusCodeBodyCheckFunc = f
@@ -125,6 +132,9 @@ func (der *Downloader) lazyInit() {
 	if der.loadBalancerCompareFunc == nil {
 		der.loadBalancerCompareFunc = DefaultLoadBalancerCompareFunc
 	}
+	if der.downloadUrlFunc == nil {
+		der.downloadUrlFunc = NewPanDownloadUrlFunc(der.panClient)
+	}
 }
 
 // SelectParallel 获取合适的 parallel
@@ -191,6 +201,24 @@ func (der *Downloader) SelectCacheSize(confCacheSize int, blockSize int64) (cach
 	return
 }
 
+// NewPanDownloadUrlFunc 获取网盘文件下载链接的 DownloadUrlFunc
+func NewPanDownloadUrlFunc(panClient *aliyunpan.PanClient) DownloadUrlFunc {
+	return func(driveId, fileId string) (string, string, error) {
+		durl, apierr := panClient.GetFileDownloadUrl(&aliyunpan.GetFileDownloadUrlParam{
+			DriveId: driveId,
+			FileId:  fileId,
+		})
+		if apierr != nil {
+			return "", "", apierr
+		}
+		if durl == nil || durl.Url == "" || durl.Url == aliyunpan.IllegalDownloadUrl {
+			logger.Verbosef("无法获取有效的下载链接: %+v\n", durl)
+			return "", "", ErrFileDownloadForbidden
+		}
+		return durl.Url, durl.InternalUrl, nil
+	}
+}
+
 // DefaultDURLCheckFunc 默认的 DURLCheckFunc
 func DefaultDURLCheckFunc(client *requester.HTTPClient, durl string) (contentLength int64, resp *http.Response, err error) {
 	resp, err = client.Req(http.MethodGet, durl, nil, nil)
@@ -322,7 +350,11 @@ func (der *Downloader) Execute() error {
 	}
 
 	// 设置限速
-	if der.config.MaxRate > 0 {
+	if der.config.MaxRateFunc != nil {
+		rl := transfer.NewDynamicRateLimit(der.config.MaxRateFunc)
+		status.SetRateLimit(rl)
+		defer rl.Stop()
+	} else if der.config.MaxRate > 0 {
 		rl := speeds.NewRateLimit(der.config.MaxRate)
 		status.SetRateLimit(rl)
 		defer rl.Stop()
@@ -377,24 +409,19 @@ func (der *Downloader) Execute() error {
 	)
 
 	// 获取下载链接
-	var apierr *apierror.ApiError
-	durl, apierr := der.panClient.GetFileDownloadUrl(&aliyunpan.GetFileDownloadUrlParam{
-		DriveId: der.driveId,
-		FileId:  der.fileInfo.FileId,
-	})
+	durl, internalUrl, err := der.downloadUrlFunc(der.driveId, der.fileInfo.FileId)
 	time.Sleep(time.Duration(200) * time.Millisecond)
-	if apierr != nil {
-		logger.Verbosef("ERROR: get download url error: %s\n", der.fileInfo.FileId)
-		cmdutil.Trigger(der.onCancelEvent)
-		return apierr
-	}
-	if durl == nil || durl.Url == "" || durl.Url == aliyunpan.IllegalDownloadUrl {
-		logger.Verbosef("无法获取有效的下载链接: %+v\n", durl)
+	if err == ErrFileDownloadForbidden {
 		cmdutil.Trigger(der.onCancelEvent)
 		der.removeInstanceState() // 移除断点续传文件
 		cmdutil.Trigger(der.onFailedEvent)
 		return ErrFileDownloadForbidden
 	}
+	if err != nil {
+		logger.Verbosef("ERROR: get download url error: %s\n", der.fileInfo.FileId)
+		cmdutil.Trigger(der.onCancelEvent)
+		return err
+	}
 
 	// 初始化下载worker
 	for k, r := range bii.Ranges {
@@ -408,13 +435,15 @@ func (der *Downloader) Execute() error {
 		client.SetKeepAlive(true)
 		client.SetTimeout(10 * time.Minute)
 
-		realUrl := durl.Url
+		realUrl := durl
 		if der.config.UseInternalUrl {
-			realUrl = durl.InternalUrl
+			realUrl = internalUrl
 		}
 		worker := NewWorker(k, der.driveId, der.fileInfo.FileId, realUrl, writer, der.globalSpeedsStat)
 		worker.SetClient(client)
 		worker.SetPanClient(der.panClient)
+		worker.SetDownloadUrlFunc(der.downloadUrlFunc)
+		worker.SetUseInternalUrl(der.config.UseInternalUrl)
 		worker.SetWriteMutex(writeMu)
 		worker.SetTotalSize(der.fileInfo.FileSize)
 
@@ -32,20 +32,22 @@ import (
 type (
 	//Worker 工作单元
 	Worker struct {
-		totalSize    int64 // 整个文件的大小, worker请求range时会获取尝试获取该值, 如果不匹配, 则返回错误
-		wrange       *transfer.Range
-		speedsStat   *speeds.Speeds
-		globalSpeedsStat   *speeds.Speeds // 全局速度统计
-		id           int    // work id
-		fileId       string // 文件ID
-		driveId     string
-		url          string // 下载地址
-		acceptRanges string
-		panClient    *aliyunpan.PanClient
-		client       *requester.HTTPClient
-		writerAt     io.WriterAt
-		writeMu      *sync.Mutex
-		execMu       sync.Mutex
+		totalSize        int64 // 整个文件的大小, worker请求range时会获取尝试获取该值, 如果不匹配, 则返回错误
+		wrange           *transfer.Range
+		speedsStat       *speeds.Speeds
+		globalSpeedsStat *speeds.Speeds // 全局速度统计
+		id               int            // work id
+		fileId           string         // 文件ID
+		driveId          string
+		url              string // 下载地址
+		acceptRanges     string
+		panClient        *aliyunpan.PanClient
+		downloadUrlFunc  DownloadUrlFunc
+		useInternalUrl   bool // 刷新下载链接时是否使用内置链接
+		client           *requester.HTTPClient
+		writerAt         io.WriterAt
+		writeMu          *sync.Mutex
+		execMu           sync.Mutex
 
 		pauseChan              chan struct{}
 		workerCancelFunc       context.CancelFunc
@@ -118,6 +120,16 @@ func (wer *Worker) SetPanClient(p *aliyunpan.PanClient) {
 	wer.panClient = p
 }
 
+// SetDownloadUrlFunc 设置刷新下载链接的函数, 没有设置则刷新网盘文件的下载链接
+func (wer *Worker) SetDownloadUrlFunc(f DownloadUrlFunc) {
+	wer.downloadUrlFunc = f
+}
+
+// SetUseInternalUrl 设置刷新下载链接时是否使用内置链接
+func (wer *Worker) SetUseInternalUrl(useInternalUrl bool) {
+	wer.useInternalUrl = useInternalUrl
+}
+
 //SetAcceptRange 设置AcceptRange
 func (wer *Worker) SetAcceptRange(acceptRanges string) {
 	wer.acceptRanges = acceptRanges
@@ -210,6 +222,19 @@ func (wer *Worker) Reset() {
 
 // RefreshDownloadUrl 重新刷新下载链接
 func (wer *Worker) RefreshDownloadUrl() {
+	if wer.downloadUrlFunc != nil {
+		durl, internalUrl, err := wer.downloadUrlFunc(wer.driveId, wer.fileId)
+		if err != nil {
+			wer.status.statusCode = StatusCodeTooManyConnections
+			return
+		}
+		if wer.useInternalUrl && internalUrl != "" {
+			durl = internalUrl
+		}
+		wer.url = durl
+		return
+	}
+
 	var apierr *apierror.ApiError
 
 	durl, apierr := wer.panClient.GetFileDownloadUrl(&aliyunpan.GetFileDownloadUrlParam{DriveId: wer.driveId, FileId: wer.fileId})
@@ -217,6 +242,10 @@ func (wer *Worker) RefreshDownloadUrl() {
 		wer.status.statusCode = StatusCodeTooManyConnections
 		return
 	}
+	if wer.useInternalUrl && durl.InternalUrl != "" {
+		wer.url = durl.InternalUrl
+		return
+	}
 	wer.url = durl.Url
 }
 
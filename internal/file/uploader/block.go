@@ -38,7 +38,7 @@ type (
 		readerAt            io.ReaderAt
 		speedsStatRef       *speeds.Speeds
 		globalSpeedsStatRef *speeds.Speeds
-		rateLimit           *speeds.RateLimit
+		rateLimit           transfer.RateLimiter
 		mu                  sync.Mutex
 	}
 
@@ -64,7 +64,7 @@ func SplitBlock(fileSize, blockSize int64) (blockList []*BlockState) {
 }
 
 // NewBufioSplitUnit io.ReaderAt实现SplitUnit接口, 有Buffer支持
-func NewBufioSplitUnit(readerAt io.ReaderAt, readRange transfer.Range, speedsStat *speeds.Speeds, rateLimit *speeds.RateLimit, globalSpeedsStat *speeds.Speeds) SplitUnit {
+func NewBufioSplitUnit(readerAt io.ReaderAt, readRange transfer.Range, speedsStat *speeds.Speeds, rateLimit transfer.RateLimiter, globalSpeedsStat *speeds.Speeds) SplitUnit {
 	su := &fileBlock{
 		readerAt:            readerAt,
 		readRange:           readRange,
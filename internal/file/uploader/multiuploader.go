@@ -17,6 +17,7 @@ import (
 	"context"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/converter"
 	"github.com/tickstep/library-go/requester"
 	"github.com/tickstep/library-go/requester/rio"
@@ -49,7 +50,7 @@ type (
 		config           *MultiUploaderConfig
 		workers          workerList
 		speedsStat       *speeds.Speeds
-		rateLimit        *speeds.RateLimit
+		rateLimit        transfer.RateLimiter
 		globalSpeedsStat *speeds.Speeds // 全局速度统计
 
 		executeTime             time.Time
@@ -64,9 +65,10 @@ type (
 
 	// MultiUploaderConfig 多线程上传配置
 	MultiUploaderConfig struct {
-		Parallel  int   // 上传并发量
-		BlockSize int64 // 上传分块
-		MaxRate   int64 // 限制最大上传速度
+		Parallel    int                  // 上传并发量
+		BlockSize   int64                // 上传分块
+		MaxRate     int64                // 限制最大上传速度
+		MaxRateFunc transfer.MaxRateFunc // 动态获取最大上传速度, 不为nil时优先于 MaxRate 使用
 	}
 )
 
@@ -128,7 +130,10 @@ func (muer *MultiUploader) Execute() error {
 	muer.lazyInit()
 
 	// 初始化限速
-	if muer.config.MaxRate > 0 {
+	if muer.config.MaxRateFunc != nil {
+		muer.rateLimit = transfer.NewDynamicRateLimit(muer.config.MaxRateFunc)
+		defer muer.rateLimit.Stop()
+	} else if muer.config.MaxRate > 0 {
 		muer.rateLimit = speeds.NewRateLimit(muer.config.MaxRate)
 		defer muer.rateLimit.Stop()
 	}
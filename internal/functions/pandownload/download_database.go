@@ -0,0 +1,387 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"bytes"
+	"errors"
+	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/jsonhelper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// DownloadJobItem 下载任务中未完成的文件或目录
+	DownloadJobItem struct {
+		FilePanPath        string `json:"filePanPath"`        // 要下载的网盘文件路径
+		SavePath           string `json:"savePath"`           // 文件保存在本地的路径
+		OriginSaveRootPath string `json:"originSaveRootPath"` // 文件保存在本地的根目录路径
+	}
+
+	// DownloadJob 一次批量下载任务，记录所有加入下载队列但是还没有完成的文件
+	DownloadJob struct {
+		Id         string   `json:"id"`
+		UserId     string   `json:"userId"`
+		DriveId    string   `json:"driveId"`
+		Paths      []string `json:"paths"` // 下载命令指定的网盘路径
+		CreateTime string   `json:"createTime"`
+		UpdateTime string   `json:"updateTime"`
+
+		// 下载选项
+		IsExecutedPermission bool `json:"isExecutedPermission"`
+		IsOverwrite          bool `json:"isOverwrite"`
+		NoCheck              bool `json:"noCheck"`
+		Parallel             int  `json:"parallel"`
+		MaxRetry             int  `json:"maxRetry"`
+
+		Items []*DownloadJobItem `json:"items"`
+
+		itemIndex    map[string]*DownloadJobItem // key为网盘文件路径
+		removedCount int                         // 已经从索引删除但是还没有从 Items 删除的文件数量
+	}
+
+	// DownloadingDatabase 未完成下载任务的数据库。
+	// 数据库只有进程内的锁，没有文件锁。保存时会重新读取数据库文件，只使用本进程修改过的任务替换文件中的任务，
+	// 不同的下载进程修改不同的下载任务，所以不会覆盖其他下载进程的修改。
+	// 删除已经完成的文件时不会立即保存，最多间隔 downloadingSaveInterval 保存一次，进程结束前需要调用 Flush
+	DownloadingDatabase struct {
+		DownloadJobList []*DownloadJob `json:"download_jobs"`
+		Timestamp       int64          `json:"timestamp"`
+
+		dataFilePath string
+		mutex        *sync.Mutex
+		loaded       bool
+		dirtyJobIds  map[string]bool // 本进程修改过还没有保存的任务
+		lastSaveTime time.Time
+	}
+)
+
+const (
+	// DownloadingFileName 未完成下载任务的数据库文件名
+	DownloadingFileName = "aliyunpan_downloading.json"
+
+	// downloadingSaveInterval 删除已经完成的文件后保存数据库的最小间隔
+	downloadingSaveInterval = 3 * time.Second
+)
+
+var (
+	ErrDownloadJobNotFound = errors.New("下载任务不存在")
+)
+
+// NewDownloadJob 创建下载任务
+func NewDownloadJob(userId, driveId string, paths []string) *DownloadJob {
+	return &DownloadJob{
+		Id:         strings.ReplaceAll(utils.UuidStr(), "-", "")[:8],
+		UserId:     userId,
+		DriveId:    driveId,
+		Paths:      paths,
+		CreateTime: utils.NowTimeStr(),
+		UpdateTime: utils.NowTimeStr(),
+		Items:      []*DownloadJobItem{},
+	}
+}
+
+// index 获取文件索引，没有则创建
+func (job *DownloadJob) index() map[string]*DownloadJobItem {
+	if job.itemIndex == nil {
+		job.itemIndex = make(map[string]*DownloadJobItem, len(job.Items))
+		for _, item := range job.Items {
+			job.itemIndex[item.FilePanPath] = item
+		}
+		job.removedCount = 0
+	}
+	return job.itemIndex
+}
+
+// addItem 添加文件，已经存在的文件会被忽略
+func (job *DownloadJob) addItem(item *DownloadJobItem) {
+	index := job.index()
+	if _, ok := index[item.FilePanPath]; ok {
+		return
+	}
+	index[item.FilePanPath] = item
+	job.Items = append(job.Items, item)
+}
+
+// removeItem 删除文件，只从索引中删除，保存之前再从 Items 中删除
+func (job *DownloadJob) removeItem(filePanPath string) bool {
+	index := job.index()
+	if _, ok := index[filePanPath]; !ok {
+		return false
+	}
+	delete(index, filePanPath)
+	job.removedCount++
+	return true
+}
+
+// compact 从 Items 中删除已经从索引中删除的文件
+func (job *DownloadJob) compact() {
+	if job.removedCount == 0 {
+		return
+	}
+	items := make([]*DownloadJobItem, 0, len(job.itemIndex))
+	for _, item := range job.Items {
+		if job.itemIndex[item.FilePanPath] == item {
+			items = append(items, item)
+		}
+	}
+	job.Items = items
+	job.removedCount = 0
+}
+
+// NewDownloadingDatabase 初始化未完成下载任务的数据库
+func NewDownloadingDatabase() (*DownloadingDatabase, error) {
+	db := &DownloadingDatabase{
+		dataFilePath: filepath.Join(config.GetConfigDir(), DownloadingFileName),
+		mutex:        &sync.Mutex{},
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.sync(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// readJobList 从数据库文件读取所有任务
+func (db *DownloadingDatabase) readJobList() ([]*DownloadJob, error) {
+	data, err := ioutil.ReadFile(db.dataFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*DownloadJob{}, nil
+		}
+		return nil, err
+	}
+	fileDb := &DownloadingDatabase{DownloadJobList: []*DownloadJob{}}
+	if len(data) > 0 {
+		if err = jsonhelper.UnmarshalData(bytes.NewReader(data), fileDb); err != nil {
+			return nil, err
+		}
+	}
+	return fileDb.DownloadJobList, nil
+}
+
+// sync 重新读取数据库文件，合并本进程修改过的任务，有修改则保存。调用方需要持有锁
+func (db *DownloadingDatabase) sync() error {
+	fileJobs, err := db.readJobList()
+	if err != nil {
+		return err
+	}
+	if db.dirtyJobIds == nil {
+		db.dirtyJobIds = map[string]bool{}
+	}
+	localJobs := map[string]*DownloadJob{}
+	for _, job := range db.DownloadJobList {
+		localJobs[job.Id] = job
+	}
+
+	jobList := []*DownloadJob{}
+	merged := map[string]bool{}
+	for _, job := range fileJobs {
+		merged[job.Id] = true
+		if db.dirtyJobIds[job.Id] {
+			// 本进程修改过的任务，使用本进程的数据，不存在代表已经被删除
+			if localJob := localJobs[job.Id]; localJob != nil {
+				jobList = append(jobList, localJob)
+			}
+			continue
+		}
+		jobList = append(jobList, job)
+	}
+	for _, job := range db.DownloadJobList {
+		if db.dirtyJobIds[job.Id] && !merged[job.Id] {
+			jobList = append(jobList, job)
+		}
+	}
+	db.DownloadJobList = jobList
+	db.loaded = true
+
+	if len(db.dirtyJobIds) == 0 {
+		return nil
+	}
+	if err = db.save(); err != nil {
+		return err
+	}
+	db.dirtyJobIds = map[string]bool{}
+	return nil
+}
+
+// save 保存内容到数据库文件，没有未完成的任务则删除数据库文件
+func (db *DownloadingDatabase) save() error {
+	db.lastSaveTime = time.Now()
+	if len(db.DownloadJobList) == 0 {
+		if err := os.Remove(db.dataFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for _, job := range db.DownloadJobList {
+		job.compact()
+	}
+	db.Timestamp = time.Now().Unix()
+	builder := &bytes.Buffer{}
+	if err := jsonhelper.MarshalData(builder, db); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(db.dataFilePath, builder.Bytes(), 0777)
+}
+
+// update 修改指定的任务，immediate 为false时距离上次保存不足 downloadingSaveInterval 则延后保存
+func (db *DownloadingDatabase) update(jobId string, immediate bool, updateFunc func() error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if !db.loaded {
+		if err := db.sync(); err != nil {
+			return err
+		}
+	}
+	if err := updateFunc(); err != nil {
+		return err
+	}
+	db.dirtyJobIds[jobId] = true
+	if !immediate && time.Since(db.lastSaveTime) < downloadingSaveInterval {
+		return nil
+	}
+	return db.sync()
+}
+
+// Flush 保存还没有保存的修改
+func (db *DownloadingDatabase) Flush() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if len(db.dirtyJobIds) == 0 {
+		return nil
+	}
+	return db.sync()
+}
+
+// findJob 查找指定ID的任务，支持ID前缀
+func (db *DownloadingDatabase) findJob(jobId string) *DownloadJob {
+	if jobId == "" {
+		return nil
+	}
+	for _, job := range db.DownloadJobList {
+		if job.Id == jobId {
+			return job
+		}
+	}
+	var found *DownloadJob
+	for _, job := range db.DownloadJobList {
+		if strings.HasPrefix(job.Id, jobId) {
+			if found != nil {
+				// 前缀不唯一
+				return nil
+			}
+			found = job
+		}
+	}
+	return found
+}
+
+// AddJob 添加下载任务
+func (db *DownloadingDatabase) AddJob(job *DownloadJob) error {
+	return db.update(job.Id, true, func() error {
+		db.DownloadJobList = append(db.DownloadJobList, job)
+		return nil
+	})
+}
+
+// GetJob 获取指定ID的下载任务
+func (db *DownloadingDatabase) GetJob(jobId string) (*DownloadJob, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.sync(); err != nil {
+		return nil, err
+	}
+	job := db.findJob(jobId)
+	if job == nil {
+		return nil, ErrDownloadJobNotFound
+	}
+	job.compact()
+	return job, nil
+}
+
+// JobList 获取指定用户的下载任务列表
+func (db *DownloadingDatabase) JobList(userId string) ([]*DownloadJob, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.sync(); err != nil {
+		return nil, err
+	}
+	jobs := []*DownloadJob{}
+	for _, job := range db.DownloadJobList {
+		if job.UserId == userId {
+			job.compact()
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// DeleteJob 删除下载任务，不会删除已经下载的文件
+func (db *DownloadingDatabase) DeleteJob(jobId string) (*DownloadJob, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if err := db.sync(); err != nil {
+		return nil, err
+	}
+	deleted := db.findJob(jobId)
+	if deleted == nil {
+		return nil, ErrDownloadJobNotFound
+	}
+	for k, job := range db.DownloadJobList {
+		if job == deleted {
+			db.DownloadJobList = append(db.DownloadJobList[:k], db.DownloadJobList[k+1:]...)
+			break
+		}
+	}
+	db.dirtyJobIds[deleted.Id] = true
+	return deleted, db.sync()
+}
+
+// AddJobItems 添加任务中加入下载队列的文件，已经存在的文件会被忽略。
+// 文件需要在加入下载队列之前记录，所以会立即保存
+func (db *DownloadingDatabase) AddJobItems(jobId string, items ...*DownloadJobItem) error {
+	return db.update(jobId, true, func() error {
+		job := db.findJob(jobId)
+		if job == nil {
+			return ErrDownloadJobNotFound
+		}
+		for _, item := range items {
+			job.addItem(item)
+		}
+		job.UpdateTime = utils.NowTimeStr()
+		return nil
+	})
+}
+
+// DeleteJobItem 删除任务中已经完成的文件，不会立即保存
+func (db *DownloadingDatabase) DeleteJobItem(jobId, filePanPath string) error {
+	return db.update(jobId, false, func() error {
+		job := db.findJob(jobId)
+		if job == nil {
+			return ErrDownloadJobNotFound
+		}
+		if job.removeItem(filePanPath) {
+			job.UpdateTime = utils.NowTimeStr()
+		}
+		return nil
+	})
+}
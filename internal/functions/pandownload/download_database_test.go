@@ -0,0 +1,88 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadingDatabase(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_downloading")
+	defer os.RemoveAll(dir)
+	db := &DownloadingDatabase{
+		dataFilePath: filepath.Join(dir, DownloadingFileName),
+		mutex:        &sync.Mutex{},
+	}
+
+	job := NewDownloadJob("user1", "drive1", []string{"/我的资源"})
+	job.Items = append(job.Items, &DownloadJobItem{FilePanPath: "/我的资源", SavePath: "/tmp/我的资源", OriginSaveRootPath: "/tmp"})
+	if err := db.AddJob(job); err != nil {
+		t.Fatal(err)
+	}
+	db.AddJobItems(job.Id, &DownloadJobItem{FilePanPath: "/我的资源/1.mp4"}, &DownloadJobItem{FilePanPath: "/我的资源/2.mp4"})
+	db.AddJobItems(job.Id, &DownloadJobItem{FilePanPath: "/我的资源/1.mp4"})
+	db.DeleteJobItem(job.Id, "/我的资源")
+
+	// 其他进程读取到的数据，删除已经完成的文件在 Flush 之后保存
+	other := &DownloadingDatabase{
+		dataFilePath: db.dataFilePath,
+		mutex:        &sync.Mutex{},
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if otherJob, err := other.GetJob(job.Id); err != nil || len(otherJob.Items) != 2 {
+		t.Fatal("download job items should be saved after flush")
+	}
+
+	// 其他进程添加的任务不会被覆盖
+	otherJob := NewDownloadJob("user2", "drive1", []string{"/其他"})
+	if err := other.AddJob(otherJob); err != nil {
+		t.Fatal(err)
+	}
+	db.DeleteJobItem(job.Id, "/我的资源/2.mp4")
+	db.Flush()
+	if jobs, _ := other.JobList("user2"); len(jobs) != 1 {
+		t.Fatal("download job of other process should be kept")
+	}
+	if _, err := other.DeleteJob(otherJob.Id); err != nil {
+		t.Fatal(err)
+	}
+	db.AddJobItems(job.Id, &DownloadJobItem{FilePanPath: "/我的资源/2.mp4"})
+
+	saved, err := db.GetJob(job.Id[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Items) != 2 || saved.Items[0].FilePanPath != "/我的资源/1.mp4" {
+		t.Fatal("download job items error")
+	}
+	if jobs, _ := db.JobList("user2"); len(jobs) != 0 {
+		t.Fatal("download job list error")
+	}
+
+	if _, err = db.DeleteJob(job.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetJob(job.Id); err != ErrDownloadJobNotFound {
+		t.Fatal("download job should be deleted")
+	}
+	if _, err = os.Stat(db.dataFilePath); !os.IsNotExist(err) {
+		t.Fatal("database file should be removed")
+	}
+}
@@ -58,12 +58,18 @@ type (
 		IsExecutedPermission bool // 下载成功后是否加上执行权限
 		IsOverwrite          bool // 是否覆盖已存在的文件
 		NoCheck              bool // 不校验文件
+		// 获取下载链接函数, 为nil则获取网盘文件的下载链接. 设置后需要使用 SetFileInfo 指定要下载的文件, 用于下载分享链接等不在网盘内的文件
+		DownloadUrlFunc downloader.DownloadUrlFunc
 
 		FilePanPath        string // 要下载的网盘文件路径
 		SavePath           string // 文件保存在本地的路径
 		OriginSaveRootPath string // 文件保存在本地的根目录路径
 		DriveId            string
 
+		// 下载任务数据库, 记录未完成的文件, 用于中断后恢复下载
+		JobDatabase *DownloadingDatabase
+		JobId       string
+
 		fileInfo *aliyunpan.FileEntity // 文件或目录详情
 	}
 )
@@ -89,6 +95,11 @@ func (dtu *DownloadTaskUnit) SetTaskInfo(info *taskframework.TaskInfo) {
 	dtu.taskInfo = info
 }
 
+// SetFileInfo 设置要下载的文件信息, 不再从网盘获取
+func (dtu *DownloadTaskUnit) SetFileInfo(f *aliyunpan.FileEntity) {
+	dtu.fileInfo = f
+}
+
 func (dtu *DownloadTaskUnit) verboseInfof(format string, a ...interface{}) {
 	if dtu.VerbosePrinter != nil {
 		dtu.VerbosePrinter.Infof(format, a...)
@@ -129,6 +140,9 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 	der := downloader.NewDownloader(writer, dtu.Cfg, dtu.PanClient, dtu.GlobalSpeedsStat)
 	der.SetFileInfo(dtu.fileInfo)
 	der.SetDriveId(dtu.DriveId)
+	if dtu.DownloadUrlFunc != nil {
+		der.SetDownloadUrlFunc(dtu.DownloadUrlFunc)
+	}
 	der.SetStatusCodeBodyCheckFunc(func(respBody io.Reader) error {
 		// 解析错误
 		return apierror.NewFailedApiError("")
@@ -332,6 +346,7 @@ func (dtu *DownloadTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunRes
 func (dtu *DownloadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
 	// 执行插件
 	dtu.pluginCallback("success")
+	dtu.finishJobItem()
 }
 
 func (dtu *DownloadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
@@ -371,11 +386,31 @@ func (dtu *DownloadTaskUnit) pluginCallback(result string) {
 	}
 }
 
+// JobItem 任务单元对应的下载任务记录
+func (dtu *DownloadTaskUnit) JobItem() *DownloadJobItem {
+	return &DownloadJobItem{
+		FilePanPath:        dtu.FilePanPath,
+		SavePath:           dtu.SavePath,
+		OriginSaveRootPath: dtu.OriginSaveRootPath,
+	}
+}
+
+// finishJobItem 从下载任务中删除已经完成的文件, 失败的文件会保留用于恢复下载
+func (dtu *DownloadTaskUnit) finishJobItem() {
+	if dtu.JobDatabase == nil {
+		return
+	}
+	if err := dtu.JobDatabase.DeleteJobItem(dtu.JobId, dtu.FilePanPath); err != nil {
+		logger.Verbosef("[%s] delete download job item error: %s\n", dtu.taskInfo.Id(), err)
+	}
+}
+
 func (dtu *DownloadTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
 }
 
 func (dtu *DownloadTaskUnit) OnCancel(lastRunResult *taskframework.TaskUnitRunResult) {
-
+	// 插件取消下载的文件不需要恢复
+	dtu.finishJobItem()
 }
 
 func (dtu *DownloadTaskUnit) RetryWait() time.Duration {
@@ -386,10 +421,10 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 	result = &taskframework.TaskUnitRunResult{}
 	// 获取文件信息
 	var apierr *apierror.ApiError
-	if dtu.fileInfo == nil || dtu.taskInfo.Retry() > 0 {
+	if dtu.fileInfo == nil || (dtu.taskInfo.Retry() > 0 && dtu.DownloadUrlFunc == nil) {
 		// 没有获取文件信息
 		// 如果是动态添加的下载任务, 是会写入文件信息的
-		// 如果该任务重试过, 则应该再获取一次文件信息
+		// 如果该任务重试过, 则应该再获取一次文件信息. 不在网盘内的文件无法重新获取
 		dtu.fileInfo, apierr = dtu.PanClient.FileInfoByPath(dtu.DriveId, dtu.FilePanPath)
 		if apierr != nil {
 			// 如果不是未登录或文件不存在, 则不重试
@@ -481,6 +516,7 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		time.Sleep(1 * time.Second)
 
 		// 创建对应的任务进行下载
+		subUnits := make([]*DownloadTaskUnit, 0, len(fileList))
 		for k := range fileList {
 			fileList[k].Path = path.Join(dtu.FilePanPath, fileList[k].FileName)
 			if fileList[k].IsFolder() {
@@ -495,10 +531,23 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 			subUnit.fileInfo = fileList[k] // 保存文件信息
 			subUnit.FilePanPath = fileList[k].Path
 			subUnit.SavePath = filepath.Join(dtu.OriginSaveRootPath, fileList[k].Path) // 保存位置
+			subUnits = append(subUnits, &subUnit)
+		}
 
+		// 先记录到下载任务, 再加入下载队列, 保证进程中断后可以恢复
+		if dtu.JobDatabase != nil {
+			jobItems := make([]*DownloadJobItem, 0, len(subUnits))
+			for _, subUnit := range subUnits {
+				jobItems = append(jobItems, subUnit.JobItem())
+			}
+			if err := dtu.JobDatabase.AddJobItems(dtu.JobId, jobItems...); err != nil {
+				logger.Verbosef("[%s] add download job items error: %s\n", dtu.taskInfo.Id(), err)
+			}
+		}
+		for _, subUnit := range subUnits {
 			// 加入父队列，按照队列调度进行下载
-			info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
-			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), fileList[k].Path)
+			info := dtu.ParentTaskExecutor.Append(subUnit, dtu.taskInfo.MaxRetry())
+			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), subUnit.FilePanPath)
 		}
 
 		// 本下载任务执行成功
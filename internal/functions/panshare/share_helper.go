@@ -0,0 +1,420 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panshare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/tickstep/library-go/requester"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ShareHelper 访问其他用户的分享链接，支持匿名浏览分享内容、保存到自己的网盘和获取下载链接
+	ShareHelper struct {
+		apiHost     string
+		accessToken string
+		client      *requester.HTTPClient
+	}
+
+	// ShareInfo 分享链接的匿名信息
+	ShareInfo struct {
+		ShareId     string `json:"-"`
+		ShareName   string `json:"share_name"`
+		CreatorName string `json:"creator_name"`
+		Expiration  string `json:"expiration"`
+		FileCount   int    `json:"file_count"`
+	}
+
+	// ShareToken 分享令牌，访问分享内的文件需要使用
+	ShareToken struct {
+		ShareId    string `json:"-"`
+		ShareToken string `json:"share_token"`
+		ExpireTime string `json:"expire_time"`
+		ExpiresIn  int    `json:"expires_in"`
+	}
+
+	// ShareFileEntity 分享内的文件
+	ShareFileEntity struct {
+		DriveId       string `json:"drive_id"`
+		DomainId      string `json:"domain_id"`
+		ShareId       string `json:"share_id"`
+		FileId        string `json:"file_id"`
+		ParentFileId  string `json:"parent_file_id"`
+		FileName      string `json:"name"`
+		FileType      string `json:"type"`
+		FileSize      int64  `json:"size"`
+		FileExtension string `json:"file_extension"`
+		Category      string `json:"category"`
+		CreatedAt     string `json:"created_at"`
+		UpdatedAt     string `json:"updated_at"`
+		// Path 文件在分享内的路径
+		Path string `json:"-"`
+	}
+	ShareFileList []*ShareFileEntity
+
+	// SaveResult 保存一个文件到网盘的结果
+	SaveResult struct {
+		FileId      string // 分享内的文件ID
+		NewFileId   string // 保存到网盘后的文件ID
+		AsyncTaskId string // 保存文件夹时服务端异步执行的任务ID
+		Success     bool
+		Error       string
+	}
+
+	// apiError 接口返回的错误信息
+	apiError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+const (
+	// DefaultApiHost 阿里云盘接口地址
+	DefaultApiHost = "https://api.aliyundrive.com"
+
+	// ShareRootFileId 分享根目录ID
+	ShareRootFileId = "root"
+
+	// saveBatchSize 每次批量保存的文件数量
+	saveBatchSize = 100
+)
+
+var (
+	shareUrlPattern = regexp.MustCompile(`/s/([0-9a-zA-Z]+)(?:/folder/([0-9a-zA-Z]+))?`)
+)
+
+// ParseShareUrl 解析分享链接，返回分享ID和分享链接指向的文件夹ID，
+// 支持 https://www.aliyundrive.com/s/xxx 和 https://www.aliyundrive.com/s/xxx/folder/yyy 格式，也可以直接使用分享ID
+func ParseShareUrl(shareUrl string) (shareId, folderId string, err error) {
+	shareUrl = strings.TrimSpace(shareUrl)
+	if shareUrl == "" {
+		return "", "", fmt.Errorf("分享链接不能为空")
+	}
+	if !strings.Contains(shareUrl, "/") {
+		return shareUrl, ShareRootFileId, nil
+	}
+	u, err := url.Parse(shareUrl)
+	if err != nil {
+		return "", "", fmt.Errorf("分享链接格式错误: %s", shareUrl)
+	}
+	m := shareUrlPattern.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", "", fmt.Errorf("分享链接格式错误: %s", shareUrl)
+	}
+	folderId = ShareRootFileId
+	if m[2] != "" {
+		folderId = m[2]
+	}
+	return m[1], folderId, nil
+}
+
+// NewShareHelper 创建分享访问工具，accessToken 为当前登录用户的令牌，只浏览分享可以为空
+func NewShareHelper(apiHost, accessToken string) *ShareHelper {
+	if apiHost == "" {
+		apiHost = DefaultApiHost
+	}
+	return &ShareHelper{
+		apiHost:     strings.TrimSuffix(apiHost, "/"),
+		accessToken: accessToken,
+		client:      requester.NewHTTPClient(),
+	}
+}
+
+// IsFolder 是否是文件夹
+func (f *ShareFileEntity) IsFolder() bool {
+	return f.FileType == "folder"
+}
+
+// doPost 发送JSON请求并解析结果
+func (h *ShareHelper) doPost(apiPath string, shareToken string, reqBody interface{}, result interface{}) error {
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	header := map[string]string{
+		"Content-Type": "application/json;charset=UTF-8",
+		"Accept":       "application/json, text/plain, */*",
+	}
+	if h.accessToken != "" {
+		header["Authorization"] = "Bearer " + h.accessToken
+	}
+	if shareToken != "" {
+		header["X-Share-Token"] = shareToken
+	}
+
+	// 使用统一的http客户端, 和其他请求一样使用全局代理和浏览器标识
+	resp, err := h.client.Req(http.MethodPost, h.apiHost+apiPath, bytes.NewReader(data), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		e := &apiError{}
+		if json.Unmarshal(body, e) == nil && e.Code != "" {
+			return fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("请求失败: %s", resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// GetShareInfo 匿名获取分享链接的信息
+func (h *ShareHelper) GetShareInfo(shareId string) (*ShareInfo, error) {
+	info := &ShareInfo{}
+	err := h.doPost("/adrive/v3/share_link/get_share_by_anonymous?share_id="+url.QueryEscape(shareId), "", map[string]string{
+		"share_id": shareId,
+	}, info)
+	if err != nil {
+		return nil, err
+	}
+	info.ShareId = shareId
+	return info, nil
+}
+
+// GetShareToken 获取分享令牌，私密分享需要提供提取码
+func (h *ShareHelper) GetShareToken(shareId, sharePwd string) (*ShareToken, error) {
+	token := &ShareToken{}
+	err := h.doPost("/v2/share_link/get_share_token", "", map[string]string{
+		"share_id":  shareId,
+		"share_pwd": sharePwd,
+	}, token)
+	if err != nil {
+		return nil, err
+	}
+	if token.ShareToken == "" {
+		return nil, fmt.Errorf("获取分享令牌失败")
+	}
+	token.ShareId = shareId
+	return token, nil
+}
+
+// FileList 获取分享内文件夹下的所有文件
+func (h *ShareHelper) FileList(token *ShareToken, parentFileId string) (ShareFileList, error) {
+	type listResult struct {
+		Items      ShareFileList `json:"items"`
+		NextMarker string        `json:"next_marker"`
+	}
+	files := ShareFileList{}
+	marker := ""
+	for {
+		r := &listResult{}
+		err := h.doPost("/adrive/v3/file/list", token.ShareToken, map[string]interface{}{
+			"share_id":        token.ShareId,
+			"parent_file_id":  parentFileId,
+			"limit":           100,
+			"marker":          marker,
+			"order_by":        "name",
+			"order_direction": "ASC",
+		}, r)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range r.Items {
+			f.CreatedAt = formatApiTime(f.CreatedAt)
+			f.UpdatedAt = formatApiTime(f.UpdatedAt)
+		}
+		files = append(files, r.Items...)
+		if r.NextMarker == "" {
+			break
+		}
+		marker = r.NextMarker
+	}
+	return files, nil
+}
+
+// FileInfoByPath 根据分享内的路径获取文件，路径相对 rootFileId 指向的文件夹
+func (h *ShareHelper) FileInfoByPath(token *ShareToken, rootFileId, filePath string) (*ShareFileEntity, error) {
+	filePath = path.Clean("/" + strings.ReplaceAll(filePath, "\\", "/"))
+	current := &ShareFileEntity{
+		ShareId:  token.ShareId,
+		FileId:   rootFileId,
+		FileName: "/",
+		FileType: "folder",
+		Path:     "/",
+	}
+	if filePath == "/" {
+		return current, nil
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(filePath, "/"), "/") {
+		if !current.IsFolder() {
+			return nil, fmt.Errorf("文件不存在: %s", filePath)
+		}
+		files, err := h.FileList(token, current.FileId)
+		if err != nil {
+			return nil, err
+		}
+		var found *ShareFileEntity
+		for _, f := range files {
+			if f.FileName == name {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("文件不存在: %s", filePath)
+		}
+		found.Path = path.Join(current.Path, name)
+		current = found
+	}
+	return current, nil
+}
+
+// RecurseList 递归获取分享内文件夹下的所有文件和文件夹，文件路径以 folder 的路径为前缀
+func (h *ShareHelper) RecurseList(token *ShareToken, folder *ShareFileEntity) (ShareFileList, error) {
+	files, err := h.FileList(token, folder.FileId)
+	if err != nil {
+		return nil, err
+	}
+	result := ShareFileList{}
+	for _, f := range files {
+		f.Path = path.Join(folder.Path, f.FileName)
+		result = append(result, f)
+		if f.IsFolder() {
+			subFiles, err := h.RecurseList(token, f)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, subFiles...)
+		}
+	}
+	return result, nil
+}
+
+// GetDownloadUrl 获取分享内文件的下载链接。需要登录的令牌
+func (h *ShareHelper) GetDownloadUrl(token *ShareToken, fileId string) (string, error) {
+	type downloadUrlResult struct {
+		DownloadUrl string `json:"download_url"`
+		Url         string `json:"url"`
+		Expiration  string `json:"expiration"`
+	}
+	if h.accessToken == "" {
+		return "", fmt.Errorf("下载分享文件需要登录")
+	}
+	r := &downloadUrlResult{}
+	err := h.doPost("/v2/file/get_share_link_download_url", token.ShareToken, map[string]interface{}{
+		"share_id":   token.ShareId,
+		"file_id":    fileId,
+		"expire_sec": 600,
+	}, r)
+	if err != nil {
+		return "", err
+	}
+	if r.DownloadUrl != "" {
+		return r.DownloadUrl, nil
+	}
+	if r.Url != "" {
+		return r.Url, nil
+	}
+	return "", fmt.Errorf("无法获取有效的下载链接")
+}
+
+// formatApiTime 将接口返回的UTC时间转换为北京时间
+func formatApiTime(t string) string {
+	tm, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return t
+	}
+	return tm.In(time.FixedZone("CST", 8*3600)).Format("2006-01-02 15:04:05")
+}
+
+// SaveToDrive 将分享内的文件或者文件夹保存到自己网盘的指定文件夹，同名文件自动重命名。需要登录的令牌
+func (h *ShareHelper) SaveToDrive(token *ShareToken, fileIds []string, toDriveId, toParentFileId string) ([]*SaveResult, error) {
+	type batchRequest struct {
+		Body    map[string]interface{} `json:"body"`
+		Headers map[string]string      `json:"headers"`
+		Id      string                 `json:"id"`
+		Method  string                 `json:"method"`
+		Url     string                 `json:"url"`
+	}
+	type batchResponse struct {
+		Responses []struct {
+			Id     string `json:"id"`
+			Status int    `json:"status"`
+			Body   struct {
+				FileId      string `json:"file_id"`
+				AsyncTaskId string `json:"async_task_id"`
+				Code        string `json:"code"`
+				Message     string `json:"message"`
+			} `json:"body"`
+		} `json:"responses"`
+	}
+
+	if h.accessToken == "" {
+		return nil, fmt.Errorf("保存分享文件需要登录")
+	}
+	results := []*SaveResult{}
+	for start := 0; start < len(fileIds); start += saveBatchSize {
+		end := start + saveBatchSize
+		if end > len(fileIds) {
+			end = len(fileIds)
+		}
+		requests := []*batchRequest{}
+		for k, fileId := range fileIds[start:end] {
+			requests = append(requests, &batchRequest{
+				Body: map[string]interface{}{
+					"file_id":           fileId,
+					"share_id":          token.ShareId,
+					"auto_rename":       true,
+					"to_parent_file_id": toParentFileId,
+					"to_drive_id":       toDriveId,
+				},
+				Headers: map[string]string{"Content-Type": "application/json"},
+				Id:      strconv.Itoa(start + k),
+				Method:  "POST",
+				Url:     "/file/copy",
+			})
+		}
+		r := &batchResponse{}
+		err := h.doPost("/adrive/v2/batch", token.ShareToken, map[string]interface{}{
+			"requests": requests,
+			"resource": "file",
+		}, r)
+		if err != nil {
+			return results, err
+		}
+		for _, item := range r.Responses {
+			idx, e := strconv.Atoi(item.Id)
+			if e != nil || idx < start || idx >= end {
+				continue
+			}
+			result := &SaveResult{
+				FileId:      fileIds[idx],
+				NewFileId:   item.Body.FileId,
+				AsyncTaskId: item.Body.AsyncTaskId,
+				Success:     item.Status >= 200 && item.Status < 300,
+			}
+			if !result.Success {
+				result.Error = fmt.Sprintf("%s: %s", item.Body.Code, item.Body.Message)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestShareServer 模拟分享接口，分享 abc 的提取码为 1234，包含 docs/a.txt 和 b.txt
+func newTestShareServer(t *testing.T) *httptest.Server {
+	files := map[string][]map[string]interface{}{
+		"root": {
+			{"file_id": "f_docs", "name": "docs", "type": "folder", "parent_file_id": "root"},
+			{"file_id": "f_b", "name": "b.txt", "type": "file", "size": 2, "parent_file_id": "root"},
+		},
+		"f_docs": {
+			{"file_id": "f_a", "name": "a.txt", "type": "file", "size": 1, "parent_file_id": "f_docs", "updated_at": "2022-05-01T02:03:04.000Z"},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adrive/v3/share_link/get_share_by_anonymous", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"share_name": "docs", "creator_name": "tickstep", "file_count": 2})
+	})
+	mux.HandleFunc("/v2/share_link/get_share_token", func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["share_id"] != "abc" || req["share_pwd"] != "1234" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"code": "InvalidResource.SharePwd", "message": "share pwd is not valid"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"share_token": "token_abc", "expires_in": 7200})
+	})
+	mux.HandleFunc("/adrive/v3/file/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Share-Token") != "token_abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		items := files[req["parent_file_id"].(string)]
+		// 每页只返回一个文件，用于测试分页
+		marker := req["marker"].(string)
+		idx := 0
+		fmt.Sscanf(marker, "%d", &idx)
+		result := map[string]interface{}{"items": items[idx : idx+1], "next_marker": ""}
+		if idx+1 < len(items) {
+			result["next_marker"] = fmt.Sprintf("%d", idx+1)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/v2/file/get_share_link_download_url", func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("Authorization") != "Bearer access_token" || req["file_id"] != "f_a" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"code": "ForbiddenNoPermission.File", "message": "User not authorized to read file"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"download_url": "https://example.com/a.txt"})
+	})
+	mux.HandleFunc("/adrive/v2/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access_token" || r.Header.Get("X-Share-Token") != "token_abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := struct {
+			Requests []struct {
+				Id   string                 `json:"id"`
+				Url  string                 `json:"url"`
+				Body map[string]interface{} `json:"body"`
+			} `json:"requests"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		responses := []map[string]interface{}{}
+		for _, item := range req.Requests {
+			if item.Url != "/file/copy" || item.Body["to_parent_file_id"] != "my_folder" {
+				t.Errorf("unexpected batch request: %v", item)
+			}
+			if item.Body["file_id"] == "f_docs" {
+				responses = append(responses, map[string]interface{}{"id": item.Id, "status": 202,
+					"body": map[string]string{"file_id": "new_docs", "async_task_id": "task_1"}})
+			} else if item.Body["file_id"] == "f_b" {
+				responses = append(responses, map[string]interface{}{"id": item.Id, "status": 201,
+					"body": map[string]string{"file_id": "new_b"}})
+			} else {
+				responses = append(responses, map[string]interface{}{"id": item.Id, "status": 404,
+					"body": map[string]string{"code": "NotFound.File", "message": "The resource file cannot be found"}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestParseShareUrl(t *testing.T) {
+	cases := []struct {
+		url      string
+		shareId  string
+		folderId string
+	}{
+		{"https://www.aliyundrive.com/s/abcXYZ123", "abcXYZ123", "root"},
+		{"https://www.alipan.com/s/abcXYZ123/folder/6234abc", "abcXYZ123", "6234abc"},
+		{"abcXYZ123", "abcXYZ123", "root"},
+	}
+	for _, c := range cases {
+		shareId, folderId, err := ParseShareUrl(c.url)
+		if err != nil || shareId != c.shareId || folderId != c.folderId {
+			t.Errorf("ParseShareUrl(%s) = %s, %s, %v", c.url, shareId, folderId, err)
+		}
+	}
+	if _, _, err := ParseShareUrl("https://www.aliyundrive.com/drive"); err == nil {
+		t.Errorf("invalid share url should return error")
+	}
+}
+
+func TestShareHelper_FileInfoByPath(t *testing.T) {
+	server := newTestShareServer(t)
+	defer server.Close()
+	h := NewShareHelper(server.URL, "")
+
+	info, err := h.GetShareInfo("abc")
+	if err != nil || info.ShareName != "docs" || info.FileCount != 2 {
+		t.Fatalf("GetShareInfo = %v, %v", info, err)
+	}
+	if _, err := h.GetShareToken("abc", "0000"); err == nil {
+		t.Fatalf("wrong share pwd should return error")
+	}
+	token, err := h.GetShareToken("abc", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := h.FileList(token, ShareRootFileId)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("FileList = %v, %v", files, err)
+	}
+	f, err := h.FileInfoByPath(token, ShareRootFileId, "docs/a.txt")
+	if err != nil || f.FileId != "f_a" || f.Path != "/docs/a.txt" {
+		t.Fatalf("FileInfoByPath = %v, %v", f, err)
+	}
+	if f.UpdatedAt != "2022-05-01 10:03:04" {
+		t.Errorf("UpdatedAt = %s", f.UpdatedAt)
+	}
+	if _, err := h.FileInfoByPath(token, ShareRootFileId, "/b.txt/c"); err == nil {
+		t.Fatalf("path under file should return error")
+	}
+
+	all, err := h.RecurseList(token, &ShareFileEntity{FileId: ShareRootFileId, Path: "/"})
+	if err != nil || len(all) != 3 || all[1].Path != "/docs/a.txt" {
+		t.Fatalf("RecurseList = %v, %v", all, err)
+	}
+}
+
+func TestShareHelper_GetDownloadUrl(t *testing.T) {
+	server := newTestShareServer(t)
+	defer server.Close()
+
+	token := &ShareToken{ShareId: "abc", ShareToken: "token_abc"}
+	if _, err := NewShareHelper(server.URL, "").GetDownloadUrl(token, "f_a"); err == nil {
+		t.Fatalf("download without access token should return error")
+	}
+	h := NewShareHelper(server.URL, "access_token")
+	u, err := h.GetDownloadUrl(token, "f_a")
+	if err != nil || u != "https://example.com/a.txt" {
+		t.Fatalf("GetDownloadUrl = %s, %v", u, err)
+	}
+	if _, err := h.GetDownloadUrl(token, "f_b"); err == nil {
+		t.Fatalf("forbidden file should return error")
+	}
+}
+
+func TestShareHelper_SaveToDrive(t *testing.T) {
+	server := newTestShareServer(t)
+	defer server.Close()
+
+	token := &ShareToken{ShareId: "abc", ShareToken: "token_abc"}
+	if _, err := NewShareHelper(server.URL, "").SaveToDrive(token, []string{"f_b"}, "1", "my_folder"); err == nil {
+		t.Fatalf("save without access token should return error")
+	}
+
+	h := NewShareHelper(server.URL, "access_token")
+	results, err := h.SaveToDrive(token, []string{"f_docs", "f_b", "f_none"}, "1", "my_folder")
+	if err != nil || len(results) != 3 {
+		t.Fatalf("SaveToDrive = %v, %v", results, err)
+	}
+	if !results[0].Success || results[0].AsyncTaskId != "task_1" {
+		t.Errorf("save folder result: %+v", results[0])
+	}
+	if !results[1].Success || results[1].NewFileId != "new_b" {
+		t.Errorf("save file result: %+v", results[1])
+	}
+	if results[2].Success || results[2].Error == "" {
+		t.Errorf("save missing file result: %+v", results[2])
+	}
+}
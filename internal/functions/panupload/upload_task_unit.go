@@ -156,9 +156,10 @@ func (utu *UploadTaskUnit) upload() (result *taskframework.TaskUnitRunResult) {
 	muer := uploader.NewMultiUploader(
 		NewPanUpload(utu.PanClient, utu.SavePath, utu.DriveId, utu.LocalFileChecksum.UploadOpEntity, utu.UseInternalUrl),
 		rio.NewFileReaderAtLen64(utu.LocalFileChecksum.GetFile()), &uploader.MultiUploaderConfig{
-			Parallel:  utu.Parallel,
-			BlockSize: utu.BlockSize,
-			MaxRate:   config.Config.MaxUploadRate,
+			Parallel:    utu.Parallel,
+			BlockSize:   utu.BlockSize,
+			MaxRate:     config.Config.MaxUploadRate,
+			MaxRateFunc: config.Config.MaxUploadRateNow,
 		}, utu.LocalFileChecksum.UploadOpEntity, utu.GlobalSpeedsStat)
 
 	// 设置断点续传
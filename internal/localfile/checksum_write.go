@@ -42,6 +42,10 @@ type (
 	hash32ChecksumWriter struct {
 		h hash.Hash32
 	}
+
+	hash64ChecksumWriter struct {
+		h hash.Hash64
+	}
 )
 
 func (wi *ChecksumWriteUnit) handleEnd() error {
@@ -167,3 +171,17 @@ func (hc *hash32ChecksumWriter) Write(p []byte) (n int, err error) {
 func (hc *hash32ChecksumWriter) Sum() interface{} {
 	return hc.h.Sum32()
 }
+
+func NewHash64ChecksumWriter(h64 hash.Hash64) ChecksumWriter {
+	return &hash64ChecksumWriter{
+		h: h64,
+	}
+}
+
+func (hc *hash64ChecksumWriter) Write(p []byte) (n int, err error) {
+	return hc.h.Write(p)
+}
+
+func (hc *hash64ChecksumWriter) Sum() interface{} {
+	return hc.h.Sum64()
+}
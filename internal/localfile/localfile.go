@@ -19,6 +19,7 @@ import (
 	"encoding/hex"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"hash/crc32"
+	"hash/crc64"
 	"io"
 	"os"
 	"strings"
@@ -41,6 +42,9 @@ const (
 
 	// CHECKSUM_SHA1 获取文件的 sha1 值
 	CHECKSUM_SHA1
+
+	// CHECKSUM_CRC64 获取文件的 crc64 值，和阿里云盘文件的 crc64_hash 使用相同的 ECMA 多项式
+	CHECKSUM_CRC64
 )
 
 type (
@@ -51,6 +55,7 @@ type (
 		MD5     string      `json:"md5,omitempty"`    // 文件的 md5
 		CRC32   uint32      `json:"crc32,omitempty"`  // 文件的 crc32
 		SHA1    string      `json:"sha1,omitempty"`   // 文件的 sha1
+		CRC64   uint64      `json:"crc64,omitempty"`  // 文件的 crc64
 		ModTime int64       `json:"modtime"`          // 修改日期
 
 		// 网盘上传参数
@@ -260,6 +265,22 @@ func (lfc *LocalFileEntity) Sum(checkSumFlag int) (err error) {
 		defer d(err)
 	}
 
+	if (checkSumFlag & CHECKSUM_CRC64) != 0 {
+		crc64w := crc64.New(crc64.MakeTable(crc64.ECMA))
+		wu, d := lfc.createChecksumWriteUnit(
+			NewHash64ChecksumWriter(crc64w),
+			true,
+			func(sum interface{}) {
+				if sum != nil {
+					lfc.CRC64 = sum.(uint64)
+				}
+			},
+		)
+
+		wus = append(wus, wu)
+		defer d(err)
+	}
+
 	err = lfc.repeatRead(wus...)
 	return
 }
@@ -14,7 +14,6 @@ import (
 	"github.com/tickstep/library-go/logger"
 	"github.com/tickstep/library-go/requester"
 	"github.com/tickstep/library-go/requester/rio"
-	"github.com/tickstep/library-go/requester/rio/speeds"
 	"os"
 	"path"
 	"path/filepath"
@@ -33,10 +32,13 @@ type (
 		panClient *aliyunpan.PanClient
 
 		syncItem        *SyncFileItem
-		maxDownloadRate int64 // 限制最大下载速度
-		maxUploadRate   int64 // 限制最大上传速度
+		maxDownloadRate transfer.MaxRateFunc // 获取当前的最大下载速度
+		maxUploadRate   transfer.MaxRateFunc // 获取当前的最大上传速度
 
 		panFolderCreateMutex *sync.Mutex
+
+		// versioning 历史版本保留策略，不为空则被覆盖或者删除的云盘文件移动到历史版本文件夹
+		versioning *SyncVersioning
 	}
 )
 
@@ -70,6 +72,13 @@ func (f *FileActionTask) DoAction(ctx context.Context) error {
 					f.panFileDb.Add(NewPanFileItem(file))
 				}
 			}
+
+			// record the last sync sha1 of local file
+			if localFileInDb, er := f.localFileDb.Get(f.syncItem.LocalFile.Path); er == nil && localFileInDb != nil {
+				localFileInDb.Sha1Hash = f.syncItem.LocalFile.Sha1Hash
+				localFileInDb.LastSyncSha1Hash = f.syncItem.LocalFile.Sha1Hash
+				f.localFileDb.Update(localFileInDb)
+			}
 		}
 	}
 
@@ -100,14 +109,15 @@ func (f *FileActionTask) DoAction(ctx context.Context) error {
 			// save local file info into db
 			if file, er := os.Stat(f.syncItem.getLocalFileFullPath()); er == nil {
 				f.localFileDb.Add(&LocalFileItem{
-					FileName:      file.Name(),
-					FileSize:      file.Size(),
-					FileType:      "file",
-					CreatedAt:     file.ModTime().Format("2006-01-02 15:04:05"),
-					UpdatedAt:     file.ModTime().Format("2006-01-02 15:04:05"),
-					FileExtension: path.Ext(file.Name()),
-					Sha1Hash:      f.syncItem.PanFile.Sha1Hash,
-					Path:          f.syncItem.getLocalFileFullPath(),
+					FileName:         file.Name(),
+					FileSize:         file.Size(),
+					FileType:         "file",
+					CreatedAt:        file.ModTime().Format("2006-01-02 15:04:05"),
+					UpdatedAt:        file.ModTime().Format("2006-01-02 15:04:05"),
+					FileExtension:    path.Ext(file.Name()),
+					Sha1Hash:         f.syncItem.PanFile.Sha1Hash,
+					LastSyncSha1Hash: f.syncItem.PanFile.Sha1Hash,
+					Path:             f.syncItem.getLocalFileFullPath(),
 				})
 			}
 		}
@@ -135,6 +145,26 @@ func (f *FileActionTask) DoAction(ctx context.Context) error {
 		}
 	}
 
+	if f.syncItem.Action == SyncFileActionMovePan {
+		if e := f.movePanFile(ctx); e != nil {
+			return e
+		} else {
+			// update DB, remove the old local file record
+			movePanFileDb(f.panFileDb, f.syncItem.getMoveSourceFullPath(), f.syncItem.getMoveTargetFullPath())
+			f.localFileDb.Delete(f.syncItem.localPathOfPanPath(f.syncItem.getMoveSourceFullPath()))
+		}
+	}
+
+	if f.syncItem.Action == SyncFileActionMoveLocal {
+		if e := f.moveLocalFile(ctx); e != nil {
+			return e
+		} else {
+			// update DB, remove the old pan file record
+			moveLocalFileDb(f.localFileDb, f.syncItem.getMoveSourceFullPath(), f.syncItem.getMoveTargetFullPath())
+			f.panFileDb.Delete(f.syncItem.panPathOfLocalPath(f.syncItem.getMoveSourceFullPath()))
+		}
+	}
+
 	return nil
 }
 
@@ -201,8 +231,8 @@ func (f *FileActionTask) downloadFile(ctx context.Context) error {
 	worker := downloader.NewWorker(0, f.syncItem.PanFile.DriveId, f.syncItem.PanFile.FileId, downloadUrl, writer, nil)
 
 	// 限速
-	if f.maxDownloadRate > 0 {
-		rl := speeds.NewRateLimit(f.maxDownloadRate)
+	if f.maxDownloadRate != nil {
+		rl := transfer.NewDynamicRateLimit(f.maxDownloadRate)
 		defer rl.Stop()
 		status := &transfer.DownloadStatus{}
 		status.SetRateLimit(rl)
@@ -347,6 +377,13 @@ func (f *FileActionTask) uploadFile(ctx context.Context) error {
 			f.syncFileDb.Update(f.syncItem)
 			return nil
 		}
+		if panFileId != "" && f.versioning != nil {
+			// 保留将要被覆盖的云盘文件
+			if e := backupPanFileVersion(f.panClient, f.syncItem.DriveId, f.syncItem.PanFolderPath, panFileId, targetPanFilePath); e != nil {
+				logger.Verbosef("保留云盘文件历史版本失败: %s\n", e)
+				return e
+			}
+		}
 
 		// 创建文件夹
 		panDirPath := path.Dir(targetPanFilePath)
@@ -446,9 +483,9 @@ func (f *FileActionTask) uploadFile(ctx context.Context) error {
 	worker := panupload.NewPanUpload(f.panClient, f.syncItem.getPanFileFullPath(), f.syncItem.DriveId, f.syncItem.UploadEntity, f.syncItem.UseInternalUrl)
 
 	// 限速配置
-	var rateLimit *speeds.RateLimit
-	if f.maxUploadRate > 0 {
-		rateLimit = speeds.NewRateLimit(f.maxUploadRate)
+	var rateLimit transfer.RateLimiter
+	if f.maxUploadRate != nil {
+		rateLimit = transfer.NewDynamicRateLimit(f.maxUploadRate)
 	}
 
 	// 上传客户端
@@ -566,6 +603,20 @@ func (f *FileActionTask) deletePanFile(ctx context.Context) error {
 		panFileId = fi.FileId
 	}
 
+	if f.versioning != nil {
+		// 移动到历史版本文件夹，不删除
+		if e := backupPanFileVersion(f.panClient, driveId, f.syncItem.PanFolderPath, panFileId, panFilePath); e != nil {
+			f.syncItem.Status = SyncFileStatusFailed
+			f.syncItem.StatusUpdateTime = utils.NowTimeStr()
+			f.syncFileDb.Update(f.syncItem)
+			return e
+		}
+		f.syncItem.Status = SyncFileStatusSuccess
+		f.syncItem.StatusUpdateTime = utils.NowTimeStr()
+		f.syncFileDb.Update(f.syncItem)
+		return nil
+	}
+
 	// 删除
 	var fileDeleteResult []*aliyunpan.FileBatchActionResult
 	var err *apierror.ApiError
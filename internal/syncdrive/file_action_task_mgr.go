@@ -8,6 +8,7 @@ import (
 	"github.com/tickstep/aliyunpan/internal/localfile"
 	"github.com/tickstep/aliyunpan/internal/waitgroup"
 	"github.com/tickstep/aliyunpan/library/collection"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/logger"
 	"path"
 	"strings"
@@ -34,8 +35,8 @@ type (
 		fileDownloadBlockSize int64
 		fileUploadBlockSize   int64
 
-		maxDownloadRate int64 // 限制最大下载速度
-		maxUploadRate   int64 // 限制最大上传速度
+		maxDownloadRate transfer.MaxRateFunc // 获取当前的最大下载速度
+		maxUploadRate   transfer.MaxRateFunc // 获取当前的最大上传速度
 
 		useInternalUrl bool
 
@@ -43,6 +44,39 @@ type (
 		panFolderModifyCount   int // 云盘文件扫描变更记录次数，作为后续文件对比进程的参考以节省CPU资源
 		syncActionModifyCount  int // 文件对比进程检测的文件上传下载删除变更记录次数，作为后续文件上传下载处理进程的参考以节省CPU资源
 		resourceModifyMutex    *sync.Mutex
+
+		localFileDiffBusy bool // 本地文件对比进程正在进行一轮对比
+		panFileDiffBusy   bool // 云盘文件对比进程正在进行一轮对比
+		fileDiffRound     int  // 已经完成的文件对比轮数
+
+		// 大量删除保护，每轮文件对比完成后统计一次删除数量
+		deleteGuardRound     int
+		deleteGuardRecheck   bool
+		deleteGuardCheckTime time.Time
+		deleteAllowedIds     map[string]bool
+
+		conflictLogger *conflictLogger
+
+		// localChangedFolderQueue 文件监听检测到有变更的本地文件夹，优先进行文件对比
+		localChangedFolderQueue *collection.Queue
+
+		// moveDetectRequested 有文件被删除，可能合并为移动动作的上传下载需要等待本轮的文件移动检测完成
+		moveDetectRequested bool
+		// moveDetecting 正在进行文件移动检测，moveCandidateIds 为等待检测结果的新增文件动作
+		moveDetecting    bool
+		moveCandidateIds map[string]bool
+		// localSha1Cache 文件移动检测计算的本地文件SHA1，只在文件移动检测中使用
+		localSha1Cache map[string]*localSha1CacheItem
+
+		// dryRun 只收集文件动作，不存储到同步数据库，也不修改本地文件
+		dryRun        bool
+		planItems     SyncFileList
+		planItemIdMap map[string]bool
+
+		// runOnce 单次同步模式，执行失败的文件动作不再重试
+		runOnce         bool
+		failedItemIdMap map[string]bool
+		report          *SyncReport
 	}
 
 	localFileSet struct {
@@ -55,7 +89,7 @@ type (
 	}
 )
 
-func NewFileActionTaskManager(task *SyncTask, maxDownloadRate, maxUploadRate int64) *FileActionTaskManager {
+func NewFileActionTaskManager(task *SyncTask, maxDownloadRate, maxUploadRate transfer.MaxRateFunc) *FileActionTaskManager {
 	return &FileActionTaskManager{
 		mutex:             &sync.Mutex{},
 		folderCreateMutex: &sync.Mutex{},
@@ -76,9 +110,28 @@ func NewFileActionTaskManager(task *SyncTask, maxDownloadRate, maxUploadRate int
 		panFolderModifyCount:   1,
 		syncActionModifyCount:  1,
 		resourceModifyMutex:    &sync.Mutex{},
+
+		conflictLogger: newConflictLogger(task.conflictLogFullPath()),
+
+		localChangedFolderQueue: collection.NewFifoQueue(),
+		localSha1Cache:          map[string]*localSha1CacheItem{},
+
+		deleteGuardRound: -1,
+		deleteAllowedIds: map[string]bool{},
+
+		failedItemIdMap: map[string]bool{},
+		report:          &SyncReport{},
 	}
 }
 
+// AddLocalChangedFolder 添加有变更的本地文件夹，文件对比进程会优先对比该文件夹下的文件
+func (f *FileActionTaskManager) AddLocalChangedFolder(folderPath string) {
+	f.localChangedFolderQueue.PushUnique(&LocalFileItem{
+		FileType: "folder",
+		Path:     folderPath,
+	})
+}
+
 func (f *FileActionTaskManager) AddLocalFolderModifyCount() {
 	f.resourceModifyMutex.Lock()
 	defer f.resourceModifyMutex.Unlock()
@@ -142,6 +195,38 @@ func (f *FileActionTaskManager) getSyncActionModifyCount() int {
 	return f.syncActionModifyCount
 }
 
+func (f *FileActionTaskManager) setLocalFileDiffBusy(busy bool) {
+	f.resourceModifyMutex.Lock()
+	defer f.resourceModifyMutex.Unlock()
+	if f.localFileDiffBusy && !busy {
+		f.fileDiffRound += 1
+	}
+	f.localFileDiffBusy = busy
+}
+
+func (f *FileActionTaskManager) setPanFileDiffBusy(busy bool) {
+	f.resourceModifyMutex.Lock()
+	defer f.resourceModifyMutex.Unlock()
+	if f.panFileDiffBusy && !busy {
+		f.fileDiffRound += 1
+	}
+	f.panFileDiffBusy = busy
+}
+
+// getFileDiffRound 已经完成的文件对比轮数
+func (f *FileActionTaskManager) getFileDiffRound() int {
+	f.resourceModifyMutex.Lock()
+	defer f.resourceModifyMutex.Unlock()
+	return f.fileDiffRound
+}
+
+// isFileDiffBusy 文件对比进程是否正在进行一轮对比
+func (f *FileActionTaskManager) isFileDiffBusy() bool {
+	f.resourceModifyMutex.Lock()
+	defer f.resourceModifyMutex.Unlock()
+	return f.localFileDiffBusy || f.panFileDiffBusy
+}
+
 // Start 启动文件动作任务管理进程
 // 通过对本地数据库的对比，决策对文件进行下载、上传、删除等动作
 func (f *FileActionTaskManager) Start() error {
@@ -218,6 +303,22 @@ func (f *FileActionTaskManager) doLocalFileDiffRoutine(ctx context.Context) {
 					continue
 				}
 			}
+			// changed folder from local file watcher
+			if objChanged := f.localChangedFolderQueue.Pop(); objChanged != nil {
+				changedItem := objChanged.(*LocalFileItem)
+				localFiles, err := f.task.localFileDb.GetFileList(changedItem.Path)
+				if err != nil {
+					localFiles = LocalFileList{}
+				}
+				panFiles, err := f.task.panFileDb.GetFileList(f.getPanPathFromLocalPath(changedItem.Path))
+				if err != nil {
+					panFiles = PanFileList{}
+				}
+				f.doFileDiffRoutine(panFiles, localFiles, nil, nil)
+				f.detectMoveActionIfIdle()
+				continue
+			}
+
 			// check need to do the loop or to wait
 			if f.getLocalFolderModifyCount() <= 0 {
 				time.Sleep(1 * time.Second)
@@ -233,10 +334,13 @@ func (f *FileActionTaskManager) doLocalFileDiffRoutine(ctx context.Context) {
 			if objLocal == nil {
 				// restart over & begin goto next term
 				localFolderQueue.Push(localRootFolder)
+				f.setLocalFileDiffBusy(false)
 				f.MinusLocalFolderModifyCount()
+				f.detectMoveActionIfIdle()
 				time.Sleep(3 * time.Second)
 				continue
 			}
+			f.setLocalFileDiffBusy(true)
 			localItem := objLocal.(*LocalFileItem)
 			localFiles, err = f.task.localFileDb.GetFileList(localItem.Path)
 			if err != nil {
@@ -289,10 +393,13 @@ func (f *FileActionTaskManager) doPanFileDiffRoutine(ctx context.Context) {
 			if objPan == nil {
 				// restart over
 				panFolderQueue.Push(panRootFolder)
+				f.setPanFileDiffBusy(false)
 				f.MinusPanFolderModifyCount()
+				f.detectMoveActionIfIdle()
 				time.Sleep(3 * time.Second)
 				continue
 			}
+			f.setPanFileDiffBusy(true)
 			panItem := objPan.(*PanFileItem)
 			panFiles, err = f.task.panFileDb.GetFileList(panItem.Path)
 			if err != nil {
@@ -308,7 +415,56 @@ func (f *FileActionTaskManager) doPanFileDiffRoutine(ctx context.Context) {
 	}
 }
 
+// doFileDiffOnce 对比一次全部的网盘文件和本地文件信息
+func (f *FileActionTaskManager) doFileDiffOnce() {
+	localFolderQueue := collection.NewFifoQueue()
+	if localRootFolder, er := f.task.localFileDb.Get(f.task.LocalFolderPath); er == nil {
+		localFolderQueue.Push(localRootFolder)
+	}
+	for obj := localFolderQueue.Pop(); obj != nil; obj = localFolderQueue.Pop() {
+		localItem := obj.(*LocalFileItem)
+		localFiles, err := f.task.localFileDb.GetFileList(localItem.Path)
+		if err != nil {
+			localFiles = LocalFileList{}
+		}
+		panFiles, err := f.task.panFileDb.GetFileList(f.getPanPathFromLocalPath(localItem.Path))
+		if err != nil {
+			panFiles = PanFileList{}
+		}
+		f.doFileDiffRoutine(panFiles, localFiles, nil, localFolderQueue)
+	}
+
+	panFolderQueue := collection.NewFifoQueue()
+	if panRootFolder, er := f.task.panFileDb.Get(f.task.PanFolderPath); er == nil {
+		panFolderQueue.Push(panRootFolder)
+	}
+	for obj := panFolderQueue.Pop(); obj != nil; obj = panFolderQueue.Pop() {
+		panItem := obj.(*PanFileItem)
+		panFiles, err := f.task.panFileDb.GetFileList(panItem.Path)
+		if err != nil {
+			panFiles = PanFileList{}
+		}
+		localFiles, err := f.task.localFileDb.GetFileList(f.getLocalPathFromPanPath(panItem.Path))
+		if err != nil {
+			localFiles = LocalFileList{}
+		}
+		f.doFileDiffRoutine(panFiles, localFiles, panFolderQueue, nil)
+	}
+
+	// 合并文件移动动作
+	f.mutex.Lock()
+	f.moveDetectRequested = false
+	items := f.moveDetectItems()
+	f.mutex.Unlock()
+	matches := f.matchMoveActions(items)
+	f.mutex.Lock()
+	f.applyMoveMatches(matches)
+	f.mutex.Unlock()
+}
+
 func (f *FileActionTaskManager) doFileDiffRoutine(panFiles PanFileList, localFiles LocalFileList, panFolderQueue *collection.Queue, localFolderQueue *collection.Queue) {
+	panFiles, localFiles = f.task.dropIgnoredFiles(panFiles, localFiles)
+
 	// empty loop
 	if len(panFiles) == 0 && len(localFiles) == 0 {
 		time.Sleep(100 * time.Millisecond)
@@ -536,6 +692,11 @@ func (f *FileActionTaskManager) doFileDiffRoutine(panFiles PanFileList, localFil
 		if strings.ToLower(panFile.Sha1Hash) == strings.ToLower(localFile.Sha1Hash) {
 			// do nothing
 			logger.Verboseln("file is the same, no need to update file: ", localFile.Path)
+			if localFile.LastSyncSha1Hash != localFile.Sha1Hash {
+				// 记录同步基准，用于后续的冲突检测
+				localFile.LastSyncSha1Hash = localFile.Sha1Hash
+				f.task.localFileDb.Update(localFile)
+			}
 			continue
 		}
 
@@ -576,41 +737,8 @@ func (f *FileActionTaskManager) doFileDiffRoutine(panFiles PanFileList, localFil
 			}
 			f.addToSyncDb(downloadPanFile)
 		} else if f.task.Mode == SyncTwoWay {
-			if localFile.UpdateTimeUnix() > panFile.UpdateTimeUnix() { // upload file
-				uploadLocalFile := &FileActionTask{
-					syncItem: &SyncFileItem{
-						Action:            SyncFileActionUpload,
-						Status:            SyncFileStatusCreate,
-						LocalFile:         localFile,
-						PanFile:           nil,
-						StatusUpdateTime:  "",
-						PanFolderPath:     f.task.PanFolderPath,
-						LocalFolderPath:   f.task.LocalFolderPath,
-						DriveId:           f.task.DriveId,
-						DownloadBlockSize: f.fileDownloadBlockSize,
-						UploadBlockSize:   f.fileUploadBlockSize,
-						UseInternalUrl:    f.useInternalUrl,
-					},
-				}
-				f.addToSyncDb(uploadLocalFile)
-			} else if localFile.UpdateTimeUnix() < panFile.UpdateTimeUnix() { // download file
-				downloadPanFile := &FileActionTask{
-					syncItem: &SyncFileItem{
-						Action:            SyncFileActionDownload,
-						Status:            SyncFileStatusCreate,
-						LocalFile:         nil,
-						PanFile:           panFile,
-						StatusUpdateTime:  "",
-						PanFolderPath:     f.task.PanFolderPath,
-						LocalFolderPath:   f.task.LocalFolderPath,
-						DriveId:           f.task.DriveId,
-						DownloadBlockSize: f.fileDownloadBlockSize,
-						UploadBlockSize:   f.fileUploadBlockSize,
-						UseInternalUrl:    f.useInternalUrl,
-					},
-				}
-				f.addToSyncDb(downloadPanFile)
-			}
+			// 检测冲突并根据冲突策略处理
+			f.resolveSyncTwoWayFile(localFile, panFile)
 		}
 	}
 }
@@ -619,6 +747,15 @@ func (f *FileActionTaskManager) addToSyncDb(fileTask *FileActionTask) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
+	if f.dryRun {
+		// 只收集文件动作
+		if !f.planItemIdMap[fileTask.syncItem.Id()] {
+			f.planItemIdMap[fileTask.syncItem.Id()] = true
+			f.planItems = append(f.planItems, fileTask.syncItem)
+		}
+		return
+	}
+
 	// check sync db
 	if itemInDb, e := f.task.syncFileDb.Get(fileTask.syncItem.Id()); e == nil && itemInDb != nil {
 		if itemInDb.Status == SyncFileStatusCreate || itemInDb.Status == SyncFileStatusDownloading || itemInDb.Status == SyncFileStatusUploading {
@@ -663,7 +800,7 @@ func (f *FileActionTaskManager) getFromSyncDb(act SyncFileAction) *FileActionTas
 	if act == SyncFileActionDownload {
 		if files, e := f.task.syncFileDb.GetFileList(SyncFileStatusDownloading); e == nil {
 			for _, file := range files {
-				if !f.fileInProcessQueue.Contains(file) {
+				if !f.fileInProcessQueue.Contains(file) && !f.isFailedItem(file) {
 					return &FileActionTask{
 						localFileDb:          f.task.localFileDb,
 						panFileDb:            f.task.panFileDb,
@@ -673,6 +810,7 @@ func (f *FileActionTaskManager) getFromSyncDb(act SyncFileAction) *FileActionTas
 						maxDownloadRate:      f.maxDownloadRate,
 						maxUploadRate:        f.maxUploadRate,
 						panFolderCreateMutex: f.folderCreateMutex,
+						versioning:           f.task.activeVersioning(),
 					}
 				}
 			}
@@ -680,7 +818,7 @@ func (f *FileActionTaskManager) getFromSyncDb(act SyncFileAction) *FileActionTas
 	} else if act == SyncFileActionUpload {
 		if files, e := f.task.syncFileDb.GetFileList(SyncFileStatusUploading); e == nil {
 			for _, file := range files {
-				if !f.fileInProcessQueue.Contains(file) {
+				if !f.fileInProcessQueue.Contains(file) && !f.isFailedItem(file) {
 					return &FileActionTask{
 						localFileDb:          f.task.localFileDb,
 						panFileDb:            f.task.panFileDb,
@@ -690,16 +828,30 @@ func (f *FileActionTaskManager) getFromSyncDb(act SyncFileAction) *FileActionTas
 						maxDownloadRate:      f.maxDownloadRate,
 						maxUploadRate:        f.maxUploadRate,
 						panFolderCreateMutex: f.folderCreateMutex,
+						versioning:           f.task.activeVersioning(),
 					}
 				}
 			}
 		}
 	}
 
+	if act == SyncFileActionDeleteLocal || act == SyncFileActionDeletePan {
+		// 删除操作需要先通过大量删除保护检查
+		if !f.refreshDeleteGuard() {
+			return nil
+		}
+	}
+
 	if files, e := f.task.syncFileDb.GetFileList(SyncFileStatusCreate); e == nil {
 		if len(files) > 0 {
 			for _, file := range files {
-				if file.Action == act && !f.fileInProcessQueue.Contains(file) {
+				if file.Action != act || f.fileInProcessQueue.Contains(file) || f.isFailedItem(file) {
+					continue
+				}
+				if (act == SyncFileActionDeleteLocal || act == SyncFileActionDeletePan) && !f.isDeleteAllowed(file) {
+					continue
+				}
+				if !f.isWaitingForMoveAction(file, files) {
 					return &FileActionTask{
 						localFileDb:          f.task.localFileDb,
 						panFileDb:            f.task.panFileDb,
@@ -709,6 +861,7 @@ func (f *FileActionTaskManager) getFromSyncDb(act SyncFileAction) *FileActionTas
 						maxDownloadRate:      f.maxDownloadRate,
 						maxUploadRate:        f.maxUploadRate,
 						panFolderCreateMutex: f.folderCreateMutex,
+						versioning:           f.task.activeVersioning(),
 					}
 				}
 			}
@@ -731,6 +884,7 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 	uploadWaitGroup := waitgroup.NewWaitGroup(f.fileUploadParallel)
 	deleteLocalWaitGroup := waitgroup.NewWaitGroup(1)
 	deletePanWaitGroup := waitgroup.NewWaitGroup(1)
+	moveWaitGroup := waitgroup.NewWaitGroup(1)
 
 	for {
 		select {
@@ -755,7 +909,9 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 					uploadWaitGroup.AddDelta()
 					f.fileInProcessQueue.PushUnique(uploadItem.syncItem)
 					go func() {
-						if e := uploadItem.DoAction(ctx); e == nil {
+						e := uploadItem.DoAction(ctx)
+						f.recordActionResult(uploadItem.syncItem, e)
+						if e == nil {
 							// success
 							f.fileInProcessQueue.Remove(uploadItem.syncItem)
 						} else {
@@ -775,7 +931,9 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 					downloadWaitGroup.AddDelta()
 					f.fileInProcessQueue.PushUnique(downloadItem.syncItem)
 					go func() {
-						if e := downloadItem.DoAction(ctx); e == nil {
+						e := downloadItem.DoAction(ctx)
+						f.recordActionResult(downloadItem.syncItem, e)
+						if e == nil {
 							// success
 							f.fileInProcessQueue.Remove(downloadItem.syncItem)
 						} else {
@@ -795,7 +953,9 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 					deleteLocalWaitGroup.AddDelta()
 					f.fileInProcessQueue.PushUnique(deleteLocalItem.syncItem)
 					go func() {
-						if e := deleteLocalItem.DoAction(ctx); e == nil {
+						e := deleteLocalItem.DoAction(ctx)
+						f.recordActionResult(deleteLocalItem.syncItem, e)
+						if e == nil {
 							// success
 							f.fileInProcessQueue.Remove(deleteLocalItem.syncItem)
 						} else {
@@ -815,7 +975,9 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 					deletePanWaitGroup.AddDelta()
 					f.fileInProcessQueue.PushUnique(deletePanItem.syncItem)
 					go func() {
-						if e := deletePanItem.DoAction(ctx); e == nil {
+						e := deletePanItem.DoAction(ctx)
+						f.recordActionResult(deletePanItem.syncItem, e)
+						if e == nil {
 							// success
 							f.fileInProcessQueue.Remove(deletePanItem.syncItem)
 						} else {
@@ -827,6 +989,25 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 				}
 			}
 
+			// move local / pan
+			for _, act := range []SyncFileAction{SyncFileActionMovePan, SyncFileActionMoveLocal} {
+				moveItem := f.getFromSyncDb(act)
+				if moveItem == nil {
+					continue
+				}
+				actionIsEmptyOfThisTerm = false
+				if moveWaitGroup.Parallel() < 1 {
+					moveWaitGroup.AddDelta()
+					f.fileInProcessQueue.PushUnique(moveItem.syncItem)
+					go func() {
+						e := moveItem.DoAction(ctx)
+						f.recordActionResult(moveItem.syncItem, e)
+						f.fileInProcessQueue.Remove(moveItem.syncItem)
+						moveWaitGroup.Done()
+					}()
+				}
+			}
+
 			// check action list is empty or not
 			if actionIsEmptyOfThisTerm {
 				// all action queue is empty
@@ -840,12 +1021,17 @@ func (f *FileActionTaskManager) fileActionTaskExecutor(ctx context.Context) {
 	}
 }
 
+// relativeFilePath 获取文件相对于根目录的路径，不以 / 开头，根目录本身返回空字符串
+func relativeFilePath(fullPath, rootPath string) string {
+	fullPath = path.Clean(strings.ReplaceAll(fullPath, "\\", "/"))
+	rootPath = path.Clean(strings.ReplaceAll(rootPath, "\\", "/"))
+	relativePath := strings.TrimPrefix(fullPath, rootPath)
+	return strings.TrimPrefix(path.Clean("/"+relativePath), "/")
+}
+
 // getRelativePath 获取文件的相对路径
 func (l *localFileSet) getRelativePath(localPath string) string {
-	localPath = strings.ReplaceAll(localPath, "\\", "/")
-	localRootPath := strings.ReplaceAll(l.localFolderPath, "\\", "/")
-	relativePath := strings.TrimPrefix(localPath, localRootPath)
-	return path.Clean(relativePath)
+	return relativeFilePath(localPath, l.localFolderPath)
 }
 
 // Intersection 交集
@@ -890,10 +1076,7 @@ func (l *localFileSet) Difference(other *panFileSet) LocalFileList {
 
 // getRelativePath 获取文件的相对路径
 func (p *panFileSet) getRelativePath(panPath string) string {
-	panPath = strings.ReplaceAll(panPath, "\\", "/")
-	panRootPath := strings.ReplaceAll(p.panFolderPath, "\\", "/")
-	relativePath := strings.TrimPrefix(panPath, panRootPath)
-	return path.Clean(relativePath)
+	return relativeFilePath(panPath, p.panFolderPath)
 }
 
 // Intersection 交集
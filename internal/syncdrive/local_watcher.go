@@ -0,0 +1,233 @@
+package syncdrive
+
+import (
+	"context"
+	"fmt"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// LocalScanMode 本地文件扫描模式
+	LocalScanMode string
+
+	// localFileWatcher 本地文件监听器，只监听单个文件夹，不包括子文件夹
+	localFileWatcher interface {
+		// Add 添加需要监听的文件夹
+		Add(dirPath string) error
+		// Events 发生变更的文件路径，空字符串代表事件丢失，需要全量扫描
+		Events() <-chan string
+		// Close 关闭监听器
+		Close() error
+	}
+)
+
+const (
+	// LocalScanModeScan 定时全量扫描本地文件，默认模式
+	LocalScanModeScan LocalScanMode = "scan"
+	// LocalScanModeWatch 监听文件系统事件，只处理发生变更的文件，并定时进行全量扫描作为兜底
+	LocalScanModeWatch LocalScanMode = "watch"
+
+	// DefaultLocalFullScanInterval watch模式下默认的全量扫描间隔，单位分钟
+	DefaultLocalFullScanInterval = 60
+)
+
+var (
+	ErrLocalWatcherNotSupported = fmt.Errorf("当前系统不支持监听本地文件变更")
+)
+
+// setupLocalWatcher 初始化本地文件监听器，失败则退回到定时全量扫描模式
+func (t *SyncTask) setupLocalWatcher() {
+	if t.LocalScanMode != LocalScanModeWatch {
+		return
+	}
+	watcher, err := newLocalFileWatcher()
+	if err != nil {
+		logger.Verboseln("create local file watcher error, fallback to scan mode: ", err)
+		return
+	}
+	t.localWatcherMutex.Lock()
+	t.localWatcher = watcher
+	t.localWatcherMutex.Unlock()
+}
+
+// closeLocalWatcher 关闭本地文件监听器
+func (t *SyncTask) closeLocalWatcher() {
+	t.localWatcherMutex.Lock()
+	defer t.localWatcherMutex.Unlock()
+	if t.localWatcher != nil {
+		t.localWatcher.Close()
+		t.localWatcher = nil
+	}
+}
+
+// isLocalWatcherActive 本地文件监听器是否正常工作
+func (t *SyncTask) isLocalWatcherActive() bool {
+	t.localWatcherMutex.Lock()
+	defer t.localWatcherMutex.Unlock()
+	return t.localWatcher != nil
+}
+
+// addLocalWatch 监听本地文件夹，失败则关闭监听器并退回到定时全量扫描模式
+func (t *SyncTask) addLocalWatch(dirPath string) {
+	t.localWatcherMutex.Lock()
+	defer t.localWatcherMutex.Unlock()
+	if t.localWatcher == nil {
+		return
+	}
+	if err := t.localWatcher.Add(dirPath); err != nil {
+		logger.Verboseln("watch local folder error, fallback to scan mode: ", err)
+		t.localWatcher.Close()
+		t.localWatcher = nil
+	}
+}
+
+// localScanDelaySeconds 两次全量扫描之间的间隔
+func (t *SyncTask) localScanDelaySeconds() int64 {
+	if !t.isLocalWatcherActive() {
+		return TimeSecondsOf30Seconds
+	}
+	interval := t.LocalFullScanInterval
+	if interval <= 0 {
+		interval = DefaultLocalFullScanInterval
+	}
+	return int64(interval) * TimeSecondsOfOneMinute
+}
+
+// requestLocalFullScan 要求立即进行一次全量扫描
+func (t *SyncTask) requestLocalFullScan() {
+	atomic.StoreInt32(&t.localFullScanRequest, 1)
+}
+
+// watchLocalFile 本地文件变更监听进程
+func (t *SyncTask) watchLocalFile(ctx context.Context, events <-chan string) {
+	t.wg.AddDelta()
+	defer t.wg.Done()
+
+	changedPaths := map[string]bool{}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// cancel routine & done
+			logger.Verboseln("local file watch routine done")
+			return
+		case filePath, ok := <-events:
+			if !ok {
+				// 监听器已经关闭，由全量扫描进程接管
+				logger.Verboseln("local file watcher closed")
+				t.requestLocalFullScan()
+				return
+			}
+			if filePath == "" {
+				t.requestLocalFullScan()
+				continue
+			}
+			// 合并短时间内的重复事件
+			changedPaths[filePath] = true
+		case <-ticker.C:
+			if len(changedPaths) == 0 {
+				continue
+			}
+			for filePath := range changedPaths {
+				t.handleLocalFileChange(filePath)
+			}
+			changedPaths = map[string]bool{}
+		}
+	}
+}
+
+// handleLocalFileChange 处理发生变更的本地文件，更新本地数据库并通知文件对比进程
+func (t *SyncTask) handleLocalFileChange(filePath string) {
+	filePath = strings.ReplaceAll(filePath, "\\", "/")
+	if strings.HasSuffix(filePath, DownloadingFileSuffix) {
+		// 下载中文件，跳过
+		return
+	}
+	parentPath := path.Dir(filePath)
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) && t.discardLocalFile(filePath) {
+			t.fileActionTaskManager.requestMoveDetect()
+			t.fileActionTaskManager.AddLocalChangedFolder(parentPath)
+		}
+		return
+	}
+
+	if fi.Name() == IgnoreFileName {
+		// 排除规则有变更，重新加载规则并进行全量扫描
+		t.fileFilter.loadIgnoreFile(t.localIgnorePath(parentPath))
+		t.requestLocalFullScan()
+	}
+	if t.fileFilter.isPathIgnored(t.localIgnorePath(filePath), fi.IsDir()) {
+		logger.Verboseln("排除规则禁止扫描本地文件: ", filePath)
+		return
+	}
+
+	localFile := newLocalFileItem(fi, filePath)
+	if t.skipLocalFile(localFile) {
+		logger.Verboseln("插件禁止扫描本地文件: ", localFile.Path)
+		return
+	}
+
+	isNew := false
+	modified := false
+	localFileInDb, _ := t.localFileDb.Get(localFile.Path)
+	if localFileInDb == nil {
+		localFile.ScanTimeAt = utils.NowTimeStr()
+		t.localFileDb.Add(localFile)
+		logger.Verboseln("add local file to db: ", utils.ObjectToJsonStr(localFile, false))
+		isNew = true
+		modified = true
+	} else {
+		modified = localFileInDb.ScanStatus == ScanStatusDiscard
+		if t.updateLocalFileInDb(localFileInDb, localFile) {
+			modified = true
+		}
+	}
+
+	if fi.IsDir() {
+		t.addLocalWatch(filePath)
+		if isNew {
+			// 新增的文件夹，监听生效之前可能已经有文件写入
+			if files, e := ioutil.ReadDir(filePath); e == nil {
+				for _, file := range files {
+					t.handleLocalFileChange(filePath + "/" + file.Name())
+				}
+			}
+		}
+	}
+	if modified {
+		t.fileActionTaskManager.AddLocalChangedFolder(parentPath)
+	}
+}
+
+// discardLocalFile 标记已经删除的本地文件，返回数据库是否有变更
+func (t *SyncTask) discardLocalFile(filePath string) bool {
+	file, e := t.localFileDb.Get(filePath)
+	if e != nil || file == nil || file.ScanStatus == ScanStatusDiscard {
+		return false
+	}
+	if t.Mode == DownloadOnly {
+		// delete discard local file info directly
+		t.localFileDb.Delete(file.Path)
+		logger.Verboseln("label discard local file from DB: ", utils.ObjectToJsonStr(file, false))
+		return true
+	}
+	file.ScanStatus = ScanStatusDiscard
+	t.localFileDb.Update(file)
+	logger.Verboseln("label local file discard: ", utils.ObjectToJsonStr(file, false))
+	if file.IsFolder() {
+		// 文件夹下所有的文件都已经删除
+		t.discardLocalFileDb(file.Path, time.Now().Unix()+1)
+	}
+	return true
+}
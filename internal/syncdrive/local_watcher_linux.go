@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package syncdrive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+type (
+	// inotifyWatcher 基于inotify的本地文件监听器
+	inotifyWatcher struct {
+		fd        int
+		file      *os.File // 使用非阻塞的fd创建，读取事件时由Go运行时等待，关闭文件会唤醒正在等待的读取
+		closed    bool
+		mutex     *sync.Mutex
+		wdPathMap map[int32]string
+		pathWdMap map[string]int32
+
+		events chan string
+		done   chan struct{}
+		once   *sync.Once
+	}
+)
+
+const (
+	inotifyWatchMask uint32 = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+		syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+)
+
+func newLocalFileWatcher() (localFileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init error: %s", err)
+	}
+	w := &inotifyWatcher{
+		fd:        fd,
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		mutex:     &sync.Mutex{},
+		wdPathMap: map[int32]string{},
+		pathWdMap: map[string]int32{},
+		events:    make(chan string, 1024),
+		done:      make(chan struct{}),
+		once:      &sync.Once{},
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dirPath string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return fmt.Errorf("inotify watcher closed")
+	}
+	if _, ok := w.pathWdMap[dirPath]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dirPath, inotifyWatchMask)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			return fmt.Errorf("inotify watch limit reached, please increase fs.inotify.max_user_watches: %s", err)
+		}
+		return err
+	}
+	w.wdPathMap[int32(wd)] = dirPath
+	w.pathWdMap[dirPath] = int32(wd)
+	return nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		w.mutex.Lock()
+		w.closed = true
+		w.mutex.Unlock()
+		err = w.file.Close()
+	})
+	return err
+}
+
+// readEvents 读取inotify事件，并转换成发生变更的文件路径
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.events)
+
+	buf := make([]byte, syscall.SizeofInotifyEvent*4096)
+	for {
+		// 没有事件时阻塞等待，监听器关闭后返回错误
+		n, err := w.file.Read(buf)
+		if err != nil || n <= 0 {
+			return
+		}
+
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// 事件队列溢出，需要全量扫描
+				w.send("")
+				continue
+			}
+
+			w.mutex.Lock()
+			dirPath, ok := w.wdPathMap[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				// 文件夹已被删除，监听自动失效
+				delete(w.wdPathMap, raw.Wd)
+				if ok && w.pathWdMap[dirPath] == raw.Wd {
+					delete(w.pathWdMap, dirPath)
+				}
+			}
+			w.mutex.Unlock()
+			if !ok || raw.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+
+			if name == "" {
+				w.send(dirPath)
+			} else {
+				w.send(path.Join(dirPath, name))
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) send(filePath string) {
+	select {
+	case w.events <- filePath:
+	case <-w.done:
+	}
+}
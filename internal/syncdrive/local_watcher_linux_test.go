@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package syncdrive
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_watcher")
+	defer os.RemoveAll(dir)
+
+	w, err := newLocalFileWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	filePath := path.Join(dir, "a.txt")
+	ioutil.WriteFile(filePath, []byte("a"), 0644)
+
+	timeout := time.After(5 * time.Second)
+	for received := false; !received; {
+		select {
+		case p := <-w.Events():
+			received = p == filePath
+		case <-timeout:
+			t.Fatal("no event received for ", filePath)
+		}
+	}
+
+	// 关闭后事件通道需要及时关闭，不能依赖轮询
+	w.Close()
+	timeout = time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-w.Events():
+			if !ok {
+				if w.Add(dir) == nil {
+					t.Error("add watch after close should fail")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("events channel not closed after watcher closed")
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package syncdrive
+
+func newLocalFileWatcher() (localFileWatcher, error) {
+	return nil, ErrLocalWatcherNotSupported
+}
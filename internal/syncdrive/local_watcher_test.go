@@ -0,0 +1,43 @@
+package syncdrive
+
+import (
+	"sync"
+	"testing"
+)
+
+type fakeLocalFileWatcher struct {
+	events chan string
+}
+
+func (w *fakeLocalFileWatcher) Add(dirPath string) error {
+	return nil
+}
+
+func (w *fakeLocalFileWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *fakeLocalFileWatcher) Close() error {
+	return nil
+}
+
+func TestSyncTask_LocalScanDelaySeconds(t *testing.T) {
+	task := &SyncTask{localWatcherMutex: &sync.Mutex{}}
+	if d := task.localScanDelaySeconds(); d != TimeSecondsOf30Seconds {
+		t.Errorf("scan mode delay = %d, want %d", d, TimeSecondsOf30Seconds)
+	}
+
+	task.localWatcher = &fakeLocalFileWatcher{events: make(chan string)}
+	if d := task.localScanDelaySeconds(); d != int64(DefaultLocalFullScanInterval)*TimeSecondsOfOneMinute {
+		t.Errorf("watch mode default delay = %d, want %d", d, int64(DefaultLocalFullScanInterval)*TimeSecondsOfOneMinute)
+	}
+	task.LocalFullScanInterval = 5
+	if d := task.localScanDelaySeconds(); d != 5*TimeSecondsOfOneMinute {
+		t.Errorf("watch mode delay = %d, want %d", d, 5*TimeSecondsOfOneMinute)
+	}
+
+	task.closeLocalWatcher()
+	if task.isLocalWatcherActive() {
+		t.Error("watcher should be inactive after close")
+	}
+}
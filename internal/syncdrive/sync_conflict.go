@@ -0,0 +1,301 @@
+package syncdrive
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// ConflictPolicy 双向同步冲突处理策略
+	ConflictPolicy string
+
+	// ConflictResolution 冲突处理结果
+	ConflictResolution string
+
+	// ConflictRecord 冲突日志记录
+	ConflictRecord struct {
+		// Time 冲突发生时间
+		Time string `json:"time"`
+		// TaskId 同步任务ID
+		TaskId string `json:"taskId"`
+		// LocalFilePath 本地文件路径
+		LocalFilePath string `json:"localFilePath"`
+		// PanFilePath 云盘文件路径
+		PanFilePath string `json:"panFilePath"`
+		// LocalSha1Hash 本地文件SHA1
+		LocalSha1Hash string `json:"localSha1Hash"`
+		// PanSha1Hash 云盘文件SHA1
+		PanSha1Hash string `json:"panSha1Hash"`
+		// LastSyncSha1Hash 上一次同步成功时的文件SHA1，为空代表没有同步记录
+		LastSyncSha1Hash string `json:"lastSyncSha1Hash"`
+		// LocalUpdatedAt 本地文件修改时间
+		LocalUpdatedAt string `json:"localUpdatedAt"`
+		// PanUpdatedAt 云盘文件修改时间
+		PanUpdatedAt string `json:"panUpdatedAt"`
+		// Policy 采用的冲突处理策略
+		Policy ConflictPolicy `json:"policy"`
+		// Resolution 冲突处理结果
+		Resolution ConflictResolution `json:"resolution"`
+		// ConflictFilePath 保留双方时，本地冲突副本的路径
+		ConflictFilePath string `json:"conflictFilePath,omitempty"`
+	}
+	ConflictRecordList []*ConflictRecord
+
+	// conflictLogger 冲突日志，每一行是一个json格式的冲突记录
+	conflictLogger struct {
+		logFilePath string
+		mutex       *sync.Mutex
+
+		// pendingMap 已经记录过的待处理冲突，避免重复记录
+		pendingMap map[string]string
+	}
+)
+
+const (
+	// ConflictPolicyNewerWins 修改时间较新的文件覆盖另一方，默认策略
+	ConflictPolicyNewerWins ConflictPolicy = "newer-wins"
+	// ConflictPolicyKeepBoth 保留双方，本地文件重命名为冲突副本，然后下载云盘文件
+	ConflictPolicyKeepBoth ConflictPolicy = "keep-both"
+	// ConflictPolicyLocalWins 以本地文件为准
+	ConflictPolicyLocalWins ConflictPolicy = "local-wins"
+	// ConflictPolicyPanWins 以云盘文件为准
+	ConflictPolicyPanWins ConflictPolicy = "pan-wins"
+	// ConflictPolicyAsk 不做处理，只记录冲突，等待用户手动处理
+	ConflictPolicyAsk ConflictPolicy = "ask"
+
+	// ConflictResolutionUpload 上传本地文件覆盖云盘文件
+	ConflictResolutionUpload ConflictResolution = "upload"
+	// ConflictResolutionDownload 下载云盘文件覆盖本地文件
+	ConflictResolutionDownload ConflictResolution = "download"
+	// ConflictResolutionKeepBoth 保留双方
+	ConflictResolutionKeepBoth ConflictResolution = "keep-both"
+	// ConflictResolutionPending 等待用户处理
+	ConflictResolutionPending ConflictResolution = "pending"
+	// ConflictResolutionFailed 处理失败
+	ConflictResolutionFailed ConflictResolution = "failed"
+
+	// ConflictLogFileName 冲突日志文件名
+	ConflictLogFileName = "conflict.log"
+)
+
+// ParseConflictPolicy 解析冲突处理策略，为空则使用默认策略
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(strings.ToLower(strings.TrimSpace(policy))) {
+	case "", ConflictPolicyNewerWins:
+		return ConflictPolicyNewerWins, nil
+	case ConflictPolicyKeepBoth:
+		return ConflictPolicyKeepBoth, nil
+	case ConflictPolicyLocalWins:
+		return ConflictPolicyLocalWins, nil
+	case ConflictPolicyPanWins:
+		return ConflictPolicyPanWins, nil
+	case ConflictPolicyAsk:
+		return ConflictPolicyAsk, nil
+	}
+	return "", fmt.Errorf("不支持的冲突处理策略: %s", policy)
+}
+
+// ConflictFileName 生成冲突副本的文件名，格式为：name (conflict <host> <time>).ext
+func ConflictFileName(fileName, host string, t time.Time) string {
+	ext := path.Ext(fileName)
+	name := strings.TrimSuffix(fileName, ext)
+	if name == "" {
+		// 隐藏文件，例如：.bashrc
+		name = fileName
+		ext = ""
+	}
+	return fmt.Sprintf("%s (conflict %s %s)%s", name, host, t.Format("20060102-150405"), ext)
+}
+
+func newConflictLogger(logFilePath string) *conflictLogger {
+	return &conflictLogger{
+		logFilePath: logFilePath,
+		mutex:       &sync.Mutex{},
+		pendingMap:  map[string]string{},
+	}
+}
+
+// Append 追加一条冲突记录
+func (c *conflictLogger) Append(record *ConflictRecord) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if record.Resolution == ConflictResolutionPending {
+		// 同一个待处理冲突只记录一次
+		key := record.LocalSha1Hash + record.PanSha1Hash
+		if c.pendingMap[record.LocalFilePath] == key {
+			return nil
+		}
+		c.pendingMap[record.LocalFilePath] = key
+	} else {
+		delete(c.pendingMap, record.LocalFilePath)
+	}
+
+	file, err := os.OpenFile(c.logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0755)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// ReadConflictRecords 读取冲突日志文件中的所有冲突记录
+func ReadConflictRecords(logFilePath string) (ConflictRecordList, error) {
+	records := ConflictRecordList{}
+	if b, _ := utils.PathExists(logFilePath); !b {
+		return records, nil
+	}
+	file, err := os.Open(logFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := &ConflictRecord{}
+		if e := json.Unmarshal([]byte(line), record); e != nil {
+			logger.Verboseln("parse conflict record error: ", e)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// isFileConflict 检测本地文件和云盘文件是否存在冲突，即上一次同步之后两边文件都有修改
+func isFileConflict(localFile *LocalFileItem, panFile *PanFileItem) bool {
+	lastSyncSha1 := strings.ToLower(localFile.LastSyncSha1Hash)
+	if lastSyncSha1 == "" {
+		// 没有同步记录，无法判断哪一方有修改，不作为冲突处理，以修改时间较新的一方为准
+		return false
+	}
+	localModified := strings.ToLower(localFile.Sha1Hash) != lastSyncSha1
+	panModified := strings.ToLower(panFile.Sha1Hash) != lastSyncSha1
+	return localModified && panModified
+}
+
+// resolveSyncTwoWayFile 双向同步模式下，处理本地文件和云盘文件内容不一致的情况
+func (f *FileActionTaskManager) resolveSyncTwoWayFile(localFile *LocalFileItem, panFile *PanFileItem) {
+	if !isFileConflict(localFile, panFile) {
+		if localFile.LastSyncSha1Hash == "" {
+			// 没有同步记录，以修改时间较新的一方为准
+			if localFile.UpdateTimeUnix() > panFile.UpdateTimeUnix() {
+				f.addToSyncDb(f.newFileActionTask(SyncFileActionUpload, localFile, nil))
+			} else if localFile.UpdateTimeUnix() < panFile.UpdateTimeUnix() {
+				f.addToSyncDb(f.newFileActionTask(SyncFileActionDownload, nil, panFile))
+			}
+			return
+		}
+		// 只有一方有修改，以修改的一方为准
+		if strings.ToLower(localFile.Sha1Hash) != strings.ToLower(localFile.LastSyncSha1Hash) {
+			f.addToSyncDb(f.newFileActionTask(SyncFileActionUpload, localFile, nil))
+		} else {
+			f.addToSyncDb(f.newFileActionTask(SyncFileActionDownload, nil, panFile))
+		}
+		return
+	}
+
+	record := &ConflictRecord{
+		Time:             utils.NowTimeStr(),
+		TaskId:           f.task.Id,
+		LocalFilePath:    localFile.Path,
+		PanFilePath:      panFile.Path,
+		LocalSha1Hash:    localFile.Sha1Hash,
+		PanSha1Hash:      panFile.Sha1Hash,
+		LastSyncSha1Hash: localFile.LastSyncSha1Hash,
+		LocalUpdatedAt:   localFile.UpdatedAt,
+		PanUpdatedAt:     panFile.UpdatedAt,
+		Policy:           f.task.ConflictPolicy,
+	}
+	switch f.task.ConflictPolicy {
+	case ConflictPolicyLocalWins:
+		record.Resolution = ConflictResolutionUpload
+	case ConflictPolicyPanWins:
+		record.Resolution = ConflictResolutionDownload
+	case ConflictPolicyAsk:
+		record.Resolution = ConflictResolutionPending
+	case ConflictPolicyKeepBoth:
+		record.Resolution = ConflictResolutionKeepBoth
+	default:
+		record.Policy = ConflictPolicyNewerWins
+		if localFile.UpdateTimeUnix() > panFile.UpdateTimeUnix() {
+			record.Resolution = ConflictResolutionUpload
+		} else if localFile.UpdateTimeUnix() < panFile.UpdateTimeUnix() {
+			record.Resolution = ConflictResolutionDownload
+		} else {
+			// 修改时间一致，无法判断新旧
+			record.Resolution = ConflictResolutionPending
+		}
+	}
+
+	switch record.Resolution {
+	case ConflictResolutionUpload:
+		f.addToSyncDb(f.newFileActionTask(SyncFileActionUpload, localFile, nil))
+	case ConflictResolutionDownload:
+		f.addToSyncDb(f.newFileActionTask(SyncFileActionDownload, nil, panFile))
+	case ConflictResolutionKeepBoth:
+		// 本地文件重命名为冲突副本，副本会在下一次扫描时上传到云盘，然后下载云盘文件到原来的位置
+		host, _ := os.Hostname()
+		if host == "" {
+			host = "localhost"
+		}
+		conflictFilePath := path.Join(path.Dir(localFile.Path), ConflictFileName(localFile.FileName, host, time.Now()))
+		if f.dryRun {
+			// 只记录，不修改本地文件
+			record.ConflictFilePath = conflictFilePath
+			f.addToSyncDb(f.newFileActionTask(SyncFileActionDownload, nil, panFile))
+			break
+		}
+		if e := os.Rename(localFile.Path, conflictFilePath); e != nil {
+			logger.Verboseln("rename conflict local file error: ", e)
+			record.Resolution = ConflictResolutionFailed
+			break
+		}
+		record.ConflictFilePath = conflictFilePath
+		f.task.localFileDb.Delete(localFile.Path)
+		f.addToSyncDb(f.newFileActionTask(SyncFileActionDownload, nil, panFile))
+	case ConflictResolutionPending:
+		logger.Verboseln("file conflict, wait for user to resolve: ", localFile.Path)
+	}
+
+	if e := f.conflictLogger.Append(record); e != nil {
+		logger.Verboseln("write conflict log error: ", e)
+	}
+}
+
+// newFileActionTask 创建文件动作任务
+func (f *FileActionTaskManager) newFileActionTask(action SyncFileAction, localFile *LocalFileItem, panFile *PanFileItem) *FileActionTask {
+	return &FileActionTask{
+		syncItem: &SyncFileItem{
+			Action:            action,
+			Status:            SyncFileStatusCreate,
+			LocalFile:         localFile,
+			PanFile:           panFile,
+			StatusUpdateTime:  "",
+			PanFolderPath:     f.task.PanFolderPath,
+			LocalFolderPath:   f.task.LocalFolderPath,
+			DriveId:           f.task.DriveId,
+			DownloadBlockSize: f.fileDownloadBlockSize,
+			UploadBlockSize:   f.fileUploadBlockSize,
+			UseInternalUrl:    f.useInternalUrl,
+		},
+	}
+}
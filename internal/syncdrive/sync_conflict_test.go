@@ -0,0 +1,46 @@
+package syncdrive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConflictFileName(t *testing.T) {
+	tm := time.Date(2022, 6, 1, 12, 30, 45, 0, time.Local)
+	testCases := []struct {
+		fileName string
+		want     string
+	}{
+		{"a.txt", "a (conflict mypc 20220601-123045).txt"},
+		{"设计文档.docx", "设计文档 (conflict mypc 20220601-123045).docx"},
+		{".bashrc", ".bashrc (conflict mypc 20220601-123045)"},
+		{"README", "README (conflict mypc 20220601-123045)"},
+	}
+	for _, tc := range testCases {
+		if r := ConflictFileName(tc.fileName, "mypc", tm); r != tc.want {
+			t.Errorf("ConflictFileName(%q) = %q, want %q", tc.fileName, r, tc.want)
+		}
+	}
+}
+
+func TestIsFileConflict(t *testing.T) {
+	testCases := []struct {
+		localSha1    string
+		panSha1      string
+		lastSyncSha1 string
+		conflict     bool
+	}{
+		{"A", "B", "B", false}, // 只有本地修改
+		{"B", "A", "B", false}, // 只有云盘修改
+		{"A", "C", "B", true},  // 两边都有修改
+		{"a", "C", "A", false}, // sha1大小写不敏感
+		{"A", "C", "", false},  // 没有同步记录，以修改时间较新的一方为准
+	}
+	for i, tc := range testCases {
+		local := &LocalFileItem{Sha1Hash: tc.localSha1, LastSyncSha1Hash: tc.lastSyncSha1}
+		pan := &PanFileItem{Sha1Hash: tc.panSha1}
+		if r := isFileConflict(local, pan); r != tc.conflict {
+			t.Errorf("case %d: isFileConflict() = %v, want %v", i, r, tc.conflict)
+		}
+	}
+}
@@ -88,6 +88,8 @@ type (
 		FileExtension string `json:"fileExtension"`
 		// 内容Hash值，只有文件才会有
 		Sha1Hash string `json:"sha1Hash"`
+		// LastSyncSha1Hash 上一次同步成功时的内容Hash值，用于判断双向同步时哪一方有修改
+		LastSyncSha1Hash string `json:"lastSyncSha1Hash"`
 		// FilePath 文件的完整路径
 		Path string `json:"path"`
 		// ScanTimeAt 扫描时间
@@ -128,6 +130,8 @@ type (
 		// PanFolderPath 云盘目录
 		PanFolderPath    string `json:"panFolderPath"`
 		StatusUpdateTime string `json:"statusUpdateTime"`
+		// Error 上一次执行失败的错误信息
+		Error string `json:"error"`
 
 		DriveId           string                            `json:"driveId"`
 		UseInternalUrl    bool                              `json:"useInternalUrl"`
@@ -172,6 +176,10 @@ const (
 	SyncFileActionUpload      SyncFileAction = "upload"
 	SyncFileActionDeleteLocal SyncFileAction = "delete_local"
 	SyncFileActionDeletePan   SyncFileAction = "delete_pan"
+	// SyncFileActionMoveLocal 移动或者重命名本地文件，云盘文件被移动时使用
+	SyncFileActionMoveLocal SyncFileAction = "move_local"
+	// SyncFileActionMovePan 移动或者重命名云盘文件，本地文件被移动时使用
+	SyncFileActionMovePan SyncFileAction = "move_pan"
 
 	// ScanStatusNormal 正常
 	ScanStatusNormal ScanStatus = "normal"
@@ -312,6 +320,8 @@ func (item *SyncFileItem) Id() string {
 		fmt.Fprintf(sb, "%s%s", string(item.Action), item.PanFile.Id())
 	} else if item.Action == SyncFileActionUpload || item.Action == SyncFileActionDeletePan {
 		fmt.Fprintf(sb, "%s%s", string(item.Action), item.LocalFile.Id())
+	} else if item.Action == SyncFileActionMoveLocal || item.Action == SyncFileActionMovePan {
+		fmt.Fprintf(sb, "%s%s%s", string(item.Action), item.LocalFile.Id(), item.PanFile.Id())
 	}
 	return utils.Md5Str(sb.String())
 }
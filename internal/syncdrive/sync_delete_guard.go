@@ -0,0 +1,248 @@
+package syncdrive
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// DeleteGuardStatus 大量删除保护状态
+	DeleteGuardStatus string
+
+	// DeleteGuard 大量删除保护记录，超过阈值的删除操作会被阻止，直到用户确认
+	DeleteGuard struct {
+		// TaskId 同步任务ID
+		TaskId string `json:"taskId"`
+		// Status 状态
+		Status DeleteGuardStatus `json:"status"`
+		// Reason 阻止删除的原因
+		Reason string `json:"reason"`
+		// DeleteCount 将要删除的文件数量
+		DeleteCount int `json:"deleteCount"`
+		// TotalCount 文件总数量
+		TotalCount int64 `json:"totalCount"`
+		// Samples 部分将要删除的文件
+		Samples []string `json:"samples"`
+		// FileIds 被阻止的删除操作ID，确认后只允许执行这些删除操作
+		FileIds []string `json:"fileIds"`
+		// BlockedAt 阻止时间
+		BlockedAt string `json:"blockedAt"`
+		// ApprovedAt 确认时间
+		ApprovedAt string `json:"approvedAt"`
+	}
+)
+
+const (
+	// DeleteGuardStatusBlocked 删除操作已被阻止
+	DeleteGuardStatusBlocked DeleteGuardStatus = "blocked"
+	// DeleteGuardStatusApproved 用户已确认删除
+	DeleteGuardStatusApproved DeleteGuardStatus = "approved"
+
+	// DeleteGuardFileName 大量删除保护记录文件名
+	DeleteGuardFileName = "delete_guard.json"
+
+	// DefaultDeleteThresholdCount 默认一次同步最多允许删除的文件数量
+	DefaultDeleteThresholdCount = 1000
+	// DefaultDeleteThresholdPercent 默认一次同步最多允许删除的文件百分比
+	DefaultDeleteThresholdPercent = 50
+	// deleteThresholdPercentMinCount 删除数量少于该值时不检查百分比，避免文件很少的目录误触发
+	deleteThresholdPercentMinCount = 10
+	// deleteGuardSampleCount 记录的将要删除的文件数量
+	deleteGuardSampleCount = 20
+	// deleteGuardRecheckInterval 删除操作被阻止时，重新读取确认记录的间隔
+	deleteGuardRecheckInterval = 5 * time.Second
+)
+
+var (
+	ErrDeleteGuardNotBlocked = fmt.Errorf("没有被阻止的删除操作")
+)
+
+// ReadDeleteGuard 读取大量删除保护记录，没有记录则返回nil
+func ReadDeleteGuard(filePath string) (*DeleteGuard, error) {
+	if b, _ := utils.PathExists(filePath); !b {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	guard := &DeleteGuard{}
+	if err = json.Unmarshal(data, guard); err != nil {
+		return nil, err
+	}
+	return guard, nil
+}
+
+// SaveDeleteGuard 存储大量删除保护记录
+func SaveDeleteGuard(filePath string, guard *DeleteGuard) error {
+	return ioutil.WriteFile(filePath, []byte(utils.ObjectToJsonStr(guard, true)), 0755)
+}
+
+// deleteGuardFullPath 大量删除保护记录文件
+func (t *SyncTask) deleteGuardFullPath() string {
+	dir := path.Join(t.syncDbFolderPath, t.Id)
+	if b, _ := utils.PathExists(dir); !b {
+		os.MkdirAll(dir, 0755)
+	}
+	return path.Join(dir, DeleteGuardFileName)
+}
+
+// isDeleteBlocked 删除操作是否已被阻止
+func (t *SyncTask) isDeleteBlocked() bool {
+	guard, _ := ReadDeleteGuard(t.deleteGuardFullPath())
+	return guard != nil && guard.Status == DeleteGuardStatusBlocked
+}
+
+// checkDeleteThreshold 检查删除数量是否超过阈值，超过则返回原因
+func (t *SyncTask) checkDeleteThreshold(deleteCount int, totalCount int64) (bool, string) {
+	maxCount := t.DeleteThresholdCount
+	if maxCount == 0 {
+		maxCount = DefaultDeleteThresholdCount
+	}
+	maxPercent := t.DeleteThresholdPercent
+	if maxPercent == 0 {
+		maxPercent = DefaultDeleteThresholdPercent
+	}
+
+	if maxCount > 0 && deleteCount > maxCount {
+		return true, fmt.Sprintf("将要删除 %d 个文件，超过了允许的最大删除数量 %d", deleteCount, maxCount)
+	}
+	if maxPercent > 0 && totalCount > 0 && deleteCount >= deleteThresholdPercentMinCount {
+		percent := int64(deleteCount) * 100 / totalCount
+		if percent > int64(maxPercent) {
+			return true, fmt.Sprintf("将要删除 %d 个文件，占全部文件 %d 个的 %d%%，超过了允许的最大删除比例 %d%%",
+				deleteCount, totalCount, percent, maxPercent)
+		}
+	}
+	return false, ""
+}
+
+// pendingDeleteItems 等待执行的删除操作
+func (f *FileActionTaskManager) pendingDeleteItems() SyncFileList {
+	items := SyncFileList{}
+	files, e := f.task.syncFileDb.GetFileList(SyncFileStatusCreate)
+	if e != nil {
+		return items
+	}
+	for _, file := range files {
+		if file.Action == SyncFileActionDeleteLocal || file.Action == SyncFileActionDeletePan {
+			items = append(items, file)
+		}
+	}
+	return items
+}
+
+// evaluateDeleteGuard 根据删除保护记录计算允许执行的删除操作，返回允许执行的删除操作ID，
+// 需要存储的删除保护记录（nil代表删除记录）以及是否需要定时重新检查。
+// 用户确认只对被阻止时统计的删除操作有效，之后新增的删除操作作为新的一批重新检查阈值
+func (t *SyncTask) evaluateDeleteGuard(guard *DeleteGuard, items SyncFileList, totalCount int64) (map[string]bool, *DeleteGuard, bool) {
+	allowed := map[string]bool{}
+	if guard != nil && guard.Status == DeleteGuardStatusBlocked {
+		// 等待用户确认
+		return allowed, guard, true
+	}
+
+	approved := map[string]bool{}
+	if guard != nil && guard.Status == DeleteGuardStatusApproved {
+		for _, id := range guard.FileIds {
+			approved[id] = true
+		}
+	}
+	rest := SyncFileList{}
+	for _, item := range items {
+		if approved[item.Id()] {
+			allowed[item.Id()] = true
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	if len(allowed) > 0 {
+		// 先执行已经确认的删除操作，执行完成后再检查新增的删除操作
+		return allowed, guard, len(rest) > 0
+	}
+	// 确认的删除操作已经全部执行完成
+	guard = nil
+	if len(rest) == 0 {
+		return allowed, guard, false
+	}
+
+	exceeded, reason := t.checkDeleteThreshold(len(rest), totalCount)
+	if !exceeded {
+		for _, item := range rest {
+			allowed[item.Id()] = true
+		}
+		return allowed, guard, false
+	}
+	guard = &DeleteGuard{
+		TaskId:      t.Id,
+		Status:      DeleteGuardStatusBlocked,
+		Reason:      reason,
+		DeleteCount: len(rest),
+		TotalCount:  totalCount,
+		Samples:     []string{},
+		FileIds:     []string{},
+		BlockedAt:   utils.NowTimeStr(),
+	}
+	for _, item := range rest {
+		guard.FileIds = append(guard.FileIds, item.Id())
+		if len(guard.Samples) >= deleteGuardSampleCount {
+			continue
+		}
+		if item.Action == SyncFileActionDeleteLocal {
+			guard.Samples = append(guard.Samples, item.getLocalFileFullPath())
+		} else {
+			guard.Samples = append(guard.Samples, item.getPanFileFullPath())
+		}
+	}
+	return allowed, guard, true
+}
+
+// refreshDeleteGuard 检查是否允许执行删除操作，调用方需要持有锁。
+// 每轮文件对比完成后统计一次删除数量，删除数量超过阈值则阻止这些删除操作，直到用户使用 sync approve 命令确认
+func (f *FileActionTaskManager) refreshDeleteGuard() bool {
+	if f.isFileDiffBusy() {
+		// 等待本轮对比完成，统计完整的删除数量
+		return false
+	}
+	round := f.getFileDiffRound()
+	if round == f.deleteGuardRound && (!f.deleteGuardRecheck || time.Since(f.deleteGuardCheckTime) < deleteGuardRecheckInterval) {
+		return len(f.deleteAllowedIds) > 0
+	}
+	f.deleteGuardRound = round
+	f.deleteGuardCheckTime = time.Now()
+
+	guardFilePath := f.task.deleteGuardFullPath()
+	guard, _ := ReadDeleteGuard(guardFilePath)
+	totalCount := atomic.LoadInt64(&f.task.localFileCount)
+	if panCount := atomic.LoadInt64(&f.task.panFileCount); panCount > totalCount {
+		totalCount = panCount
+	}
+	allowed, newGuard, recheck := f.task.evaluateDeleteGuard(guard, f.pendingDeleteItems(), totalCount)
+	f.deleteAllowedIds = allowed
+	f.deleteGuardRecheck = recheck
+
+	if newGuard == nil {
+		if guard != nil {
+			os.Remove(guardFilePath)
+		}
+	} else if newGuard != guard {
+		if e := SaveDeleteGuard(guardFilePath, newGuard); e != nil {
+			logger.Verboseln("save delete guard error: ", e)
+		}
+		logger.Verboseln("delete action blocked: ", newGuard.Reason)
+		fmt.Printf("\n同步任务 %s 的删除操作已被阻止: %s\n请确认无误后使用以下命令允许删除: aliyunpan sync approve %s\n", f.task.NameLabel(), newGuard.Reason, f.task.Id)
+	}
+	return len(allowed) > 0
+}
+
+// isDeleteAllowed 删除操作是否允许执行，调用方需要持有锁
+func (f *FileActionTaskManager) isDeleteAllowed(item *SyncFileItem) bool {
+	return f.deleteAllowedIds[item.Id()]
+}
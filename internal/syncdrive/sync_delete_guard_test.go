@@ -0,0 +1,96 @@
+package syncdrive
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckDeleteThreshold(t *testing.T) {
+	cases := []struct {
+		thresholdCount   int
+		thresholdPercent int
+		deleteCount      int
+		totalCount       int64
+		exceeded         bool
+	}{
+		{0, 0, 5, 6, false},
+		{0, 0, 20, 30, true},
+		{0, 0, 20, 50, false},
+		{0, 0, 1001, 100000, true},
+		{-1, -1, 5000, 5000, false},
+		{10, -1, 11, 100000, true},
+	}
+	for _, c := range cases {
+		task := &SyncTask{DeleteThresholdCount: c.thresholdCount, DeleteThresholdPercent: c.thresholdPercent}
+		exceeded, reason := task.checkDeleteThreshold(c.deleteCount, c.totalCount)
+		if exceeded != c.exceeded {
+			t.Errorf("checkDeleteThreshold(%d, %d) = %v, expected %v", c.deleteCount, c.totalCount, exceeded, c.exceeded)
+		}
+		if exceeded && reason == "" {
+			t.Errorf("checkDeleteThreshold(%d, %d) should return a reason", c.deleteCount, c.totalCount)
+		}
+	}
+}
+
+func newDeleteGuardTestItems(prefix string, count int) SyncFileList {
+	items := SyncFileList{}
+	for i := 0; i < count; i++ {
+		items = append(items, &SyncFileItem{
+			Action:    SyncFileActionDeletePan,
+			LocalFile: &LocalFileItem{Path: fmt.Sprintf("D:/backup/%s%d.txt", prefix, i), UpdatedAt: "2022-06-01 12:00:00"},
+		})
+	}
+	return items
+}
+
+func TestEvaluateDeleteGuard(t *testing.T) {
+	task := &SyncTask{Id: "task1", DeleteThresholdCount: 10, DeleteThresholdPercent: -1}
+
+	// 没有超过阈值，全部允许
+	items := newDeleteGuardTestItems("a", 5)
+	allowed, guard, recheck := task.evaluateDeleteGuard(nil, items, 100)
+	if len(allowed) != 5 || guard != nil || recheck {
+		t.Errorf("under threshold: allowed %d, guard %v, recheck %v", len(allowed), guard, recheck)
+	}
+
+	// 超过阈值，全部阻止
+	items = newDeleteGuardTestItems("a", 20)
+	allowed, guard, recheck = task.evaluateDeleteGuard(nil, items, 100)
+	if len(allowed) != 0 || guard == nil || guard.Status != DeleteGuardStatusBlocked || !recheck {
+		t.Fatalf("over threshold should block: allowed %d, guard %v", len(allowed), guard)
+	}
+	if guard.DeleteCount != 20 || len(guard.FileIds) != 20 || len(guard.Samples) != 20 {
+		t.Errorf("blocked guard: count %d, ids %d, samples %d", guard.DeleteCount, len(guard.FileIds), len(guard.Samples))
+	}
+
+	// 等待确认时仍然阻止
+	allowed, blockedGuard, _ := task.evaluateDeleteGuard(guard, items, 100)
+	if len(allowed) != 0 || blockedGuard != guard {
+		t.Errorf("blocked guard should keep blocking: allowed %d", len(allowed))
+	}
+
+	// 确认后只允许被阻止时统计的删除操作，新增的删除操作等待确认的操作执行完成后重新检查
+	guard.Status = DeleteGuardStatusApproved
+	newItems := newDeleteGuardTestItems("b", 20)
+	allowed, approvedGuard, recheck := task.evaluateDeleteGuard(guard, append(items, newItems...), 100)
+	if len(allowed) != 20 || approvedGuard != guard || !recheck {
+		t.Errorf("approved guard: allowed %d, recheck %v", len(allowed), recheck)
+	}
+	for _, item := range newItems {
+		if allowed[item.Id()] {
+			t.Errorf("new delete item should not be approved: %s", item.LocalFile.Path)
+		}
+	}
+
+	// 确认的删除操作执行完成后，新增的删除操作作为新的一批重新检查阈值
+	allowed, nextGuard, _ := task.evaluateDeleteGuard(guard, newItems, 100)
+	if len(allowed) != 0 || nextGuard == nil || nextGuard == guard || nextGuard.Status != DeleteGuardStatusBlocked {
+		t.Errorf("new batch should be blocked again: allowed %d, guard %v", len(allowed), nextGuard)
+	}
+
+	// 确认的删除操作执行完成后，没有新的删除操作则删除记录
+	allowed, nextGuard, recheck = task.evaluateDeleteGuard(guard, SyncFileList{}, 100)
+	if len(allowed) != 0 || nextGuard != nil || recheck {
+		t.Errorf("approved guard should be removed: guard %v", nextGuard)
+	}
+}
@@ -0,0 +1,117 @@
+package syncdrive
+
+import (
+	"sort"
+)
+
+type (
+	// DiffStatus 本地文件和云盘文件的对比结果
+	DiffStatus string
+
+	// DiffItem 一个相对路径的对比结果
+	DiffItem struct {
+		// RelativePath 相对同步目录的路径
+		RelativePath string `json:"relativePath"`
+		// Status 对比结果
+		Status DiffStatus `json:"status"`
+		// Reason 不同的原因
+		Reason string `json:"reason,omitempty"`
+		// LocalFile 本地文件，只存在于云盘时为nil
+		LocalFile *LocalFileItem `json:"localFile,omitempty"`
+		// PanFile 云盘文件，只存在于本地时为nil
+		PanFile *PanFileItem `json:"panFile,omitempty"`
+	}
+	DiffItemList []*DiffItem
+
+	// DiffCompareFunc 对比本地和云盘都存在的文件，返回是否相同以及不同的原因
+	DiffCompareFunc func(localFile *LocalFileItem, panFile *PanFileItem) (bool, string)
+)
+
+const (
+	// DiffStatusAdded 只存在于本地
+	DiffStatusAdded DiffStatus = "added"
+	// DiffStatusRemoved 只存在于云盘
+	DiffStatusRemoved DiffStatus = "removed"
+	// DiffStatusChanged 本地和云盘都存在，但是内容不同
+	DiffStatusChanged DiffStatus = "changed"
+	// DiffStatusIdentical 本地和云盘都存在，并且内容相同
+	DiffStatusIdentical DiffStatus = "identical"
+)
+
+// DiffFileList 按照相对路径对比本地目录和云盘目录的文件列表，结果按照相对路径排序
+func DiffFileList(localFolderPath string, localFiles LocalFileList, panFolderPath string, panFiles PanFileList, compareFunc DiffCompareFunc) DiffItemList {
+	localFilesSet := &localFileSet{
+		items:           localFiles,
+		localFolderPath: localFolderPath,
+	}
+	panFilesSet := &panFileSet{
+		items:         panFiles,
+		panFolderPath: panFolderPath,
+	}
+
+	result := DiffItemList{}
+	for _, file := range localFilesSet.Difference(panFilesSet) {
+		result = append(result, &DiffItem{
+			RelativePath: localFilesSet.getRelativePath(file.Path),
+			Status:       DiffStatusAdded,
+			LocalFile:    file,
+		})
+	}
+	for _, file := range panFilesSet.Difference(localFilesSet) {
+		result = append(result, &DiffItem{
+			RelativePath: panFilesSet.getRelativePath(file.Path),
+			Status:       DiffStatusRemoved,
+			PanFile:      file,
+		})
+	}
+	localFilesNeedToCheck, panFilesNeedToCheck := localFilesSet.Intersection(panFilesSet)
+	for idx := range localFilesNeedToCheck {
+		localFile := localFilesNeedToCheck[idx]
+		panFile := panFilesNeedToCheck[idx]
+		item := &DiffItem{
+			RelativePath: localFilesSet.getRelativePath(localFile.Path),
+			Status:       DiffStatusIdentical,
+			LocalFile:    localFile,
+			PanFile:      panFile,
+		}
+		if localFile.IsFolder() != panFile.IsFolder() {
+			item.Status = DiffStatusChanged
+			item.Reason = "文件类型不同"
+		} else if !localFile.IsFolder() {
+			if localFile.FileSize != panFile.FileSize {
+				item.Status = DiffStatusChanged
+				item.Reason = "文件大小不同"
+			} else if compareFunc != nil {
+				if same, reason := compareFunc(localFile, panFile); !same {
+					item.Status = DiffStatusChanged
+					item.Reason = reason
+				}
+			}
+		}
+		result = append(result, item)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RelativePath < result[j].RelativePath
+	})
+	return result
+}
+
+// Count 统计各种对比结果的数量
+func (l DiffItemList) Count() map[DiffStatus]int {
+	count := map[DiffStatus]int{}
+	for _, item := range l {
+		count[item.Status]++
+	}
+	return count
+}
+
+// HasDifference 是否存在不同
+func (l DiffItemList) HasDifference() bool {
+	for _, item := range l {
+		if item.Status != DiffStatusIdentical {
+			return true
+		}
+	}
+	return false
+}
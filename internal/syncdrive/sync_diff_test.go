@@ -0,0 +1,76 @@
+package syncdrive
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffFileList(t *testing.T) {
+	localFiles := LocalFileList{
+		&LocalFileItem{FileName: "a.txt", FileType: "file", FileSize: 10, Path: "D:/backup/a.txt"},
+		&LocalFileItem{FileName: "b.txt", FileType: "file", FileSize: 20, Path: "D:/backup/b.txt"},
+		&LocalFileItem{FileName: "c.txt", FileType: "file", FileSize: 30, Path: "D:/backup/c.txt"},
+		&LocalFileItem{FileName: "new.txt", FileType: "file", FileSize: 40, Path: "D:/backup/new.txt"},
+	}
+	panFiles := PanFileList{
+		&PanFileItem{FileName: "a.txt", FileType: "file", FileSize: 10, Path: "/backup/a.txt"},
+		&PanFileItem{FileName: "b.txt", FileType: "file", FileSize: 21, Path: "/backup/b.txt"},
+		&PanFileItem{FileName: "c.txt", FileType: "file", FileSize: 30, Path: "/backup/c.txt"},
+		&PanFileItem{FileName: "old.txt", FileType: "file", FileSize: 50, Path: "/backup/old.txt"},
+	}
+	result := DiffFileList("D:/backup", localFiles, "/backup", panFiles, func(localFile *LocalFileItem, panFile *PanFileItem) (bool, string) {
+		if localFile.FileName == "c.txt" {
+			return false, "SHA1不同"
+		}
+		return true, ""
+	})
+	for _, item := range result {
+		fmt.Println(item.RelativePath, item.Status, item.Reason)
+	}
+
+	expected := map[string]DiffStatus{
+		"a.txt":   DiffStatusIdentical,
+		"b.txt":   DiffStatusChanged,
+		"c.txt":   DiffStatusChanged,
+		"new.txt": DiffStatusAdded,
+		"old.txt": DiffStatusRemoved,
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("unexpected result count: %d", len(result))
+	}
+	for _, item := range result {
+		if expected[item.RelativePath] != item.Status {
+			t.Errorf("%s: expected %s, got %s", item.RelativePath, expected[item.RelativePath], item.Status)
+		}
+	}
+	if !result.HasDifference() {
+		t.Fail()
+	}
+}
+
+func TestDiffFileList_RootFolder(t *testing.T) {
+	localFiles := LocalFileList{
+		&LocalFileItem{FileName: "a.txt", FileType: "file", FileSize: 10, Path: "D:\\backup\\a.txt"},
+		&LocalFileItem{FileName: "docs", FileType: "folder", Path: "D:\\backup\\docs"},
+		&LocalFileItem{FileName: "b.txt", FileType: "file", FileSize: 20, Path: "D:\\backup\\docs\\b.txt"},
+	}
+	panFiles := PanFileList{
+		&PanFileItem{FileName: "a.txt", FileType: "file", FileSize: 10, Path: "/a.txt"},
+		&PanFileItem{FileName: "docs", FileType: "folder", Path: "/docs"},
+		&PanFileItem{FileName: "b.txt", FileType: "file", FileSize: 20, Path: "/docs/b.txt"},
+	}
+	result := DiffFileList("D:\\backup", localFiles, "/", panFiles, nil)
+
+	expected := []string{"a.txt", "docs", "docs/b.txt"}
+	if len(result) != len(expected) {
+		t.Fatalf("unexpected result count: %d", len(result))
+	}
+	for i, item := range result {
+		if item.RelativePath != expected[i] || item.Status != DiffStatusIdentical {
+			t.Errorf("expected %s identical, got %s %s", expected[i], item.RelativePath, item.Status)
+		}
+	}
+	if result.HasDifference() {
+		t.Errorf("root folder diff should have no difference")
+	}
+}
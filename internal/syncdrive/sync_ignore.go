@@ -0,0 +1,320 @@
+package syncdrive
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type (
+	// ignoreRule 一条 gitignore 语法的规则
+	ignoreRule struct {
+		// pattern 原始规则
+		pattern string
+		regex   *regexp.Regexp
+		// negate 以 ! 开头的规则，重新包含之前被排除的文件
+		negate bool
+		// dirOnly 以 / 结尾的规则，只匹配文件夹
+		dirOnly bool
+	}
+
+	// ignoreRuleList 同一个来源的规则列表
+	ignoreRuleList struct {
+		// basePath 规则所在目录相对于同步目录的路径，同步目录本身为空字符串
+		basePath string
+		rules    []*ignoreRule
+	}
+
+	// syncFileFilter 同步文件过滤器，根据同步任务的 exclude / include 规则以及本地目录中的 .aliyunpanignore 文件过滤文件
+	syncFileFilter struct {
+		localFolderPath string
+		excludeRules    *ignoreRuleList
+		includeRules    *ignoreRuleList
+
+		// ignoreFileRules 本地目录中的 .aliyunpanignore 文件规则，key为文件所在目录的相对路径
+		ignoreFileRules map[string]*ignoreRuleList
+		mutex           *sync.RWMutex
+	}
+)
+
+const (
+	// IgnoreFileName 同步忽略规则文件名，语法和 .gitignore 一致
+	IgnoreFileName = ".aliyunpanignore"
+)
+
+// compileIgnorePattern 将 gitignore 语法的规则转换为正则表达式
+func compileIgnorePattern(pattern string, anchored bool) (*regexp.Regexp, error) {
+	builder := &strings.Builder{}
+	builder.WriteString("^")
+	if !anchored {
+		// 不包含 / 的规则匹配任意层级的文件
+		builder.WriteString("(?:.*/)?")
+	}
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				atStart := i == 0 || runes[i-1] == '/'
+				next := i + 2
+				atEnd := next == len(runes) || runes[next] == '/'
+				if atStart && atEnd {
+					if next == len(runes) {
+						// a/** 匹配文件夹下的所有文件
+						builder.WriteString(".*")
+					} else {
+						// **/a 以及 a/**/b 匹配零个或者多个文件夹
+						builder.WriteString("(?:.*/)?")
+					}
+					i = next
+					continue
+				}
+				// 其他位置的 ** 和 * 相同
+				i++
+			}
+			builder.WriteString("[^/]*")
+		case '?':
+			builder.WriteString("[^/]")
+		case '[':
+			end := -1
+			for j := i + 1; j < len(runes); j++ {
+				if runes[j] == ']' {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				builder.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i = end
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+			}
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+// parseIgnoreRule 解析一条规则，空行和注释返回nil
+func parseIgnoreRule(line string) *ignoreRule {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	rule := &ignoreRule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	// 规则开头或者中间包含 / 时，只匹配相对于规则所在目录的路径
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	regex, err := compileIgnorePattern(line, anchored)
+	if err != nil {
+		logger.Verboseln("invalid ignore pattern: ", rule.pattern, err)
+		return nil
+	}
+	rule.regex = regex
+	return rule
+}
+
+// newIgnoreRuleList 解析规则列表
+func newIgnoreRuleList(basePath string, lines []string) *ignoreRuleList {
+	list := &ignoreRuleList{
+		basePath: basePath,
+		rules:    []*ignoreRule{},
+	}
+	for _, line := range lines {
+		if rule := parseIgnoreRule(line); rule != nil {
+			list.rules = append(list.rules, rule)
+		}
+	}
+	return list
+}
+
+// match 匹配文件相对于同步目录的路径，返回是否有规则匹配，以及匹配的规则是否是重新包含的规则。多条规则匹配时以最后一条为准
+func (l *ignoreRuleList) match(relativePath string, isDir bool) (bool, bool) {
+	if l == nil || len(l.rules) == 0 {
+		return false, false
+	}
+	if l.basePath != "" {
+		if !strings.HasPrefix(relativePath, l.basePath+"/") {
+			return false, false
+		}
+		relativePath = strings.TrimPrefix(relativePath, l.basePath+"/")
+	}
+	for i := len(l.rules) - 1; i >= 0; i-- {
+		rule := l.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.regex.MatchString(relativePath) {
+			return true, rule.negate
+		}
+	}
+	return false, false
+}
+
+// newSyncFileFilter 创建同步文件过滤器，include规则优先级最高，可以重新包含被 exclude 规则或者 .aliyunpanignore 文件排除的文件
+func newSyncFileFilter(localFolderPath string, exclude, include []string) *syncFileFilter {
+	includeLines := []string{}
+	for _, pattern := range include {
+		includeLines = append(includeLines, "!"+strings.TrimPrefix(pattern, "!"))
+	}
+	return &syncFileFilter{
+		localFolderPath: path.Clean(strings.ReplaceAll(localFolderPath, "\\", "/")),
+		excludeRules:    newIgnoreRuleList("", exclude),
+		includeRules:    newIgnoreRuleList("", includeLines),
+		ignoreFileRules: map[string]*ignoreRuleList{},
+		mutex:           &sync.RWMutex{},
+	}
+}
+
+// loadIgnoreFile 加载本地文件夹中的 .aliyunpanignore 文件，relativeDir 为文件夹相对于同步目录的路径
+func (f *syncFileFilter) loadIgnoreFile(relativeDir string) {
+	if f == nil {
+		return
+	}
+	relativeDir = strings.Trim(path.Clean("/"+relativeDir), "/")
+	data, err := ioutil.ReadFile(path.Join(f.localFolderPath, relativeDir, IgnoreFileName))
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Verboseln("read ignore file error: ", err)
+		}
+		delete(f.ignoreFileRules, relativeDir)
+		return
+	}
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	f.ignoreFileRules[relativeDir] = newIgnoreRuleList(relativeDir, lines)
+}
+
+// isIgnored 文件是否被排除，relativePath 为文件相对于同步目录的路径。
+// 只检查文件本身，调用方需要保证文件所在的文件夹没有被排除
+func (f *syncFileFilter) isIgnored(relativePath string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	relativePath = strings.Trim(path.Clean("/"+strings.ReplaceAll(relativePath, "\\", "/")), "/")
+	if relativePath == "" {
+		return false
+	}
+
+	ignored := false
+	apply := func(list *ignoreRuleList) {
+		if matched, negate := list.match(relativePath, isDir); matched {
+			ignored = !negate
+		}
+	}
+	apply(f.excludeRules)
+	f.mutex.RLock()
+	// 从同步目录开始逐层应用 .aliyunpanignore 文件，越深的文件优先级越高
+	apply(f.ignoreFileRules[""])
+	if parentDir := path.Dir(relativePath); parentDir != "." {
+		dir := ""
+		for _, part := range strings.Split(parentDir, "/") {
+			dir = strings.TrimPrefix(dir+"/"+part, "/")
+			apply(f.ignoreFileRules[dir])
+		}
+	}
+	f.mutex.RUnlock()
+	apply(f.includeRules)
+	return ignored
+}
+
+// isPathIgnored 文件或者文件所在的任意一层文件夹是否被排除
+func (f *syncFileFilter) isPathIgnored(relativePath string, isDir bool) bool {
+	relativePath = strings.Trim(path.Clean("/"+strings.ReplaceAll(relativePath, "\\", "/")), "/")
+	parts := strings.Split(relativePath, "/")
+	for i := 1; i < len(parts); i++ {
+		if f.isIgnored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return f.isIgnored(relativePath, isDir)
+}
+
+// localIgnorePath 本地文件相对于同步目录的路径，用于匹配排除规则
+func (t *SyncTask) localIgnorePath(localPath string) string {
+	localPath = path.Clean(strings.ReplaceAll(localPath, "\\", "/"))
+	return strings.TrimPrefix(localPath, path.Clean(strings.ReplaceAll(t.LocalFolderPath, "\\", "/")))
+}
+
+// panIgnorePath 云盘文件相对于同步目录的路径，用于匹配排除规则
+func (t *SyncTask) panIgnorePath(panPath string) string {
+	return strings.TrimPrefix(path.Clean(panPath), path.Clean(t.PanFolderPath))
+}
+
+// forgetIgnoredLocalFile 从本地数据库移除被排除的文件（夹），
+// 已经同步过的文件被排除后不能被当作已删除的文件，否则会删除云盘文件
+func (t *SyncTask) forgetIgnoredLocalFile(localPath string) {
+	if item, _ := t.localFileDb.Get(localPath); item != nil {
+		t.localFileDb.Delete(localPath)
+		logger.Verboseln("remove ignored local file from db: ", localPath)
+	}
+}
+
+// forgetIgnoredPanFile 从云盘数据库移除被排除的文件（夹），避免删除对应的本地文件
+func (t *SyncTask) forgetIgnoredPanFile(panPath string) {
+	if item, _ := t.panFileDb.Get(panPath); item != nil {
+		t.panFileDb.Delete(panPath)
+		logger.Verboseln("remove ignored pan file from db: ", panPath)
+	}
+}
+
+// dropIgnoredFiles 文件对比前去掉两边数据库中被排除的文件（夹），
+// 排除规则生效之前已经记录在数据库中的文件不会产生上传、下载或者删除动作
+func (t *SyncTask) dropIgnoredFiles(panFiles PanFileList, localFiles LocalFileList) (PanFileList, LocalFileList) {
+	if t.fileFilter == nil {
+		return panFiles, localFiles
+	}
+	panResult := PanFileList{}
+	for _, file := range panFiles {
+		if !t.fileFilter.isPathIgnored(t.panIgnorePath(file.Path), file.IsFolder()) {
+			panResult = append(panResult, file)
+		}
+	}
+	localResult := LocalFileList{}
+	for _, file := range localFiles {
+		if !t.fileFilter.isPathIgnored(t.localIgnorePath(file.Path), file.IsFolder()) {
+			localResult = append(localResult, file)
+		}
+	}
+	return panResult, localResult
+}
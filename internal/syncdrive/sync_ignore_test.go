@@ -0,0 +1,68 @@
+package syncdrive
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSyncFileFilter(t *testing.T) {
+	localFolderPath := path.Join(os.TempDir(), "sync_ignore_test")
+	os.MkdirAll(path.Join(localFolderPath, "sub"), 0755)
+	defer os.RemoveAll(localFolderPath)
+	ioutil.WriteFile(path.Join(localFolderPath, "sub", IgnoreFileName), []byte("# comment\n*.log\n!a.tmp\n/only\n"), 0755)
+
+	filter := newSyncFileFilter(localFolderPath, []string{"*.tmp", "node_modules/", "/build/**", "!keep.tmp", "docs/**/draft"}, []string{"build/important"})
+	filter.loadIgnoreFile("sub")
+
+	testCases := []struct {
+		relativePath string
+		isDir        bool
+		ignored      bool
+	}{
+		{"x/y/a.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"src/node_modules", true, true},
+		{"node_modules", false, false},
+		{"build/a.o", false, true},
+		{"sub/build/a.o", false, false},
+		{"build/important", false, false},
+		{"docs/a/b/draft", true, true},
+		{"sub/q/x.log", false, true},
+		{"x.log", false, false},
+		{"sub/a.tmp", false, false},
+		{"sub/q/only", false, false},
+	}
+	for _, tc := range testCases {
+		if r := filter.isIgnored(tc.relativePath, tc.isDir); r != tc.ignored {
+			t.Errorf("isIgnored(%q) = %v, want %v", tc.relativePath, r, tc.ignored)
+		}
+	}
+	if !filter.isPathIgnored("node_modules/a/b.js", false) {
+		t.Errorf("isPathIgnored(%q) = false, want true", "node_modules/a/b.js")
+	}
+}
+
+func TestSyncTask_DropIgnoredFiles(t *testing.T) {
+	task := &SyncTask{
+		LocalFolderPath: "/home/user/sync",
+		PanFolderPath:   "/sync",
+	}
+	task.fileFilter = newSyncFileFilter(task.LocalFolderPath, []string{"*.tmp", "cache/"}, nil)
+
+	panFiles, localFiles := task.dropIgnoredFiles(PanFileList{
+		{Path: "/sync/a.txt", FileType: "file"},
+		{Path: "/sync/a.tmp", FileType: "file"},
+		{Path: "/sync/cache", FileType: "folder"},
+	}, LocalFileList{
+		{Path: "/home/user/sync/a.txt", FileType: "file"},
+		{Path: "/home/user/sync/cache/b.txt", FileType: "file"},
+	})
+	if len(panFiles) != 1 || panFiles[0].Path != "/sync/a.txt" {
+		t.Errorf("pan files = %v, want only /sync/a.txt", panFiles)
+	}
+	if len(localFiles) != 1 || localFiles[0].Path != "/home/user/sync/a.txt" {
+		t.Errorf("local files = %v, want only /home/user/sync/a.txt", localFiles)
+	}
+}
@@ -0,0 +1,692 @@
+package syncdrive
+
+import (
+	"context"
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan/internal/localfile"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/library/collection"
+	"github.com/tickstep/library-go/logger"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// moveMatch 可以合并为移动动作的删除动作和新增动作
+	moveMatch struct {
+		items    SyncFileList
+		moveItem *SyncFileItem
+	}
+
+	// localSha1CacheItem 本地文件SHA1缓存，文件大小和修改时间不变时不需要重新计算
+	localSha1CacheItem struct {
+		fileSize  int64
+		updatedAt string
+		sha1      string
+	}
+)
+
+// getMoveSourceFullPath 获取移动动作的源文件路径
+func (item *SyncFileItem) getMoveSourceFullPath() string {
+	if item.Action == SyncFileActionMovePan {
+		return item.PanFile.Path
+	}
+	return item.LocalFile.Path
+}
+
+// getMoveTargetFullPath 获取移动动作的目标文件路径
+func (item *SyncFileItem) getMoveTargetFullPath() string {
+	if item.Action == SyncFileActionMovePan {
+		return item.panPathOfLocalPath(item.LocalFile.Path)
+	}
+	return item.localPathOfPanPath(item.PanFile.Path)
+}
+
+// panPathOfLocalPath 本地文件路径对应的云盘文件路径
+func (item *SyncFileItem) panPathOfLocalPath(localPath string) string {
+	localPath = strings.ReplaceAll(localPath, "\\", "/")
+	localRootPath := strings.ReplaceAll(item.LocalFolderPath, "\\", "/")
+	return path.Join(path.Clean(item.PanFolderPath), strings.TrimPrefix(localPath, localRootPath))
+}
+
+// localPathOfPanPath 云盘文件路径对应的本地文件路径
+func (item *SyncFileItem) localPathOfPanPath(panPath string) string {
+	panPath = strings.ReplaceAll(panPath, "\\", "/")
+	panRootPath := strings.ReplaceAll(item.PanFolderPath, "\\", "/")
+	return path.Join(path.Clean(item.LocalFolderPath), strings.TrimPrefix(panPath, panRootPath))
+}
+
+// isNewFileAction 是否是新增文件的上传或者下载动作，这类动作可能和删除动作合并为移动动作
+func (item *SyncFileItem) isNewFileAction() bool {
+	return (item.Action == SyncFileActionUpload && item.PanFile == nil) ||
+		(item.Action == SyncFileActionDownload && item.LocalFile == nil)
+}
+
+// requestMoveDetect 扫描发现有文件被删除，一轮文件对比完成后需要检测文件移动
+func (f *FileActionTaskManager) requestMoveDetect() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.moveDetectRequested = true
+}
+
+// detectMoveActionIfIdle 所有的文件对比都已经完成，则进行文件移动检测。
+// 计算本地文件SHA1比较耗时，检测过程中不持有锁，只有可能合并为移动动作的上传下载需要等待检测完成
+func (f *FileActionTaskManager) detectMoveActionIfIdle() {
+	f.mutex.Lock()
+	if !f.moveDetectRequested || f.moveDetecting {
+		f.mutex.Unlock()
+		return
+	}
+	if f.isFileDiffBusy() || f.getLocalFolderModifyCount() > 0 || f.getPanFolderModifyCount() > 0 || f.localChangedFolderQueue.Length() > 0 {
+		f.mutex.Unlock()
+		return
+	}
+	f.moveDetectRequested = false
+	f.moveDetecting = true
+	items := f.moveDetectItems()
+	f.moveCandidateIds = f.moveCandidateIdsOf(items)
+	f.mutex.Unlock()
+
+	matches := f.matchMoveActions(items)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.applyMoveMatches(matches)
+	f.moveDetecting = false
+	f.moveCandidateIds = nil
+}
+
+// isWaitingForMoveAction 文件动作是否需要等待文件移动检测或者移动动作完成，调用方需要持有锁
+func (f *FileActionTaskManager) isWaitingForMoveAction(item *SyncFileItem, pendingItems SyncFileList) bool {
+	if item.Action != SyncFileActionUpload && item.Action != SyncFileActionDownload {
+		return false
+	}
+	if item.isNewFileAction() {
+		if f.moveDetecting && f.moveCandidateIds[item.Id()] {
+			return true
+		}
+		if f.moveDetectRequested && isMoveCandidate(item, pendingItems) {
+			return true
+		}
+	}
+	for _, pending := range pendingItems {
+		if item.Action == SyncFileActionUpload && pending.Action == SyncFileActionMovePan {
+			if strings.HasPrefix(item.LocalFile.Path, pending.LocalFile.Path+"/") {
+				return true
+			}
+		} else if item.Action == SyncFileActionDownload && pending.Action == SyncFileActionMoveLocal {
+			if strings.HasPrefix(item.PanFile.Path, pending.PanFile.Path+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isMoveCandidate 新增文件动作是否可能和等待执行的删除动作合并为移动动作，
+// 删除的是文件则需要文件大小一致，删除的是文件夹则无法快速判断
+func isMoveCandidate(item *SyncFileItem, pendingItems SyncFileList) bool {
+	for _, pending := range pendingItems {
+		if pending.LocalFile == nil || pending.PanFile == nil {
+			continue
+		}
+		if item.Action == SyncFileActionUpload && pending.Action == SyncFileActionDeletePan {
+			if pending.PanFile.IsFolder() || pending.PanFile.FileSize == item.LocalFile.FileSize {
+				return true
+			}
+		} else if item.Action == SyncFileActionDownload && pending.Action == SyncFileActionDeleteLocal {
+			if pending.LocalFile.IsFolder() || pending.LocalFile.FileSize == item.PanFile.FileSize {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// moveDetectItems 获取等待执行的文件动作，调用方需要持有锁
+func (f *FileActionTaskManager) moveDetectItems() SyncFileList {
+	if f.dryRun {
+		return append(SyncFileList{}, f.planItems...)
+	}
+	files, e := f.task.syncFileDb.GetFileList(SyncFileStatusCreate)
+	if e != nil {
+		return SyncFileList{}
+	}
+	return files
+}
+
+// moveCandidateIdsOf 获取文件大小和被删除的文件一致的新增文件动作，这些动作需要等待文件移动检测完成
+func (f *FileActionTaskManager) moveCandidateIdsOf(items SyncFileList) map[string]bool {
+	panDeleteSizes, localDeleteSizes := map[int64]bool{}, map[int64]bool{}
+	for _, item := range items {
+		if item.LocalFile == nil || item.PanFile == nil {
+			continue
+		}
+		if item.Action == SyncFileActionDeletePan {
+			if item.PanFile.IsFolder() {
+				for _, file := range f.panFileListRecursive(item.PanFile.Path) {
+					panDeleteSizes[file.FileSize] = true
+				}
+			} else {
+				panDeleteSizes[item.PanFile.FileSize] = true
+			}
+		} else if item.Action == SyncFileActionDeleteLocal {
+			if item.LocalFile.IsFolder() {
+				for _, file := range f.localFileListRecursive(item.LocalFile.Path) {
+					localDeleteSizes[file.FileSize] = true
+				}
+			} else {
+				localDeleteSizes[item.LocalFile.FileSize] = true
+			}
+		}
+	}
+
+	candidates := map[string]bool{}
+	for _, item := range items {
+		if !item.isNewFileAction() {
+			continue
+		}
+		if (item.Action == SyncFileActionUpload && panDeleteSizes[item.LocalFile.FileSize]) ||
+			(item.Action == SyncFileActionDownload && localDeleteSizes[item.PanFile.FileSize]) {
+			candidates[item.Id()] = true
+		}
+	}
+	return candidates
+}
+
+// matchMoveActions 查找内容相同（SHA1和大小一致）的删除动作和新增动作，这些动作可以合并为移动动作，
+// 避免重命名或者移动文件（夹）后重新上传或者下载全部文件。会计算本地文件SHA1，调用方不能持有锁
+func (f *FileActionTaskManager) matchMoveActions(items SyncFileList) []*moveMatch {
+	uploads, downloads := SyncFileList{}, SyncFileList{}
+	deletePans, deleteLocals := SyncFileList{}, SyncFileList{}
+	for _, item := range items {
+		if item.isNewFileAction() {
+			if item.Action == SyncFileActionUpload {
+				uploads = append(uploads, item)
+			} else {
+				downloads = append(downloads, item)
+			}
+		} else if item.LocalFile != nil && item.PanFile != nil {
+			// 只有云盘和本地都存在记录的删除动作才能转换为移动动作
+			if item.Action == SyncFileActionDeletePan {
+				deletePans = append(deletePans, item)
+			} else if item.Action == SyncFileActionDeleteLocal {
+				deleteLocals = append(deleteLocals, item)
+			}
+		}
+	}
+
+	matches := []*moveMatch{}
+	usedIdMap := map[string]bool{}
+	if len(uploads) > 0 {
+		for _, deleteItem := range deletePans {
+			if moveItem, matched := f.matchMovePanAction(deleteItem, uploads, usedIdMap); moveItem != nil {
+				matches = append(matches, &moveMatch{items: append(matched, deleteItem), moveItem: moveItem})
+			}
+		}
+	}
+	if len(downloads) > 0 {
+		for _, deleteItem := range deleteLocals {
+			if moveItem, matched := f.matchMoveLocalAction(deleteItem, downloads, usedIdMap); moveItem != nil {
+				matches = append(matches, &moveMatch{items: append(matched, deleteItem), moveItem: moveItem})
+			}
+		}
+	}
+	return matches
+}
+
+// applyMoveMatches 使用移动动作替换对应的删除动作和新增动作，检测期间已经开始执行的动作不再替换，调用方需要持有锁
+func (f *FileActionTaskManager) applyMoveMatches(matches []*moveMatch) {
+	for _, match := range matches {
+		pending := true
+		for _, item := range match.items {
+			if f.dryRun {
+				pending = f.planItemIdMap[item.Id()]
+			} else {
+				itemInDb, e := f.task.syncFileDb.Get(item.Id())
+				pending = e == nil && itemInDb != nil && itemInDb.Status == SyncFileStatusCreate && !f.fileInProcessQueue.Contains(itemInDb)
+			}
+			if !pending {
+				break
+			}
+		}
+		if pending {
+			f.replaceWithMoveAction(match.items, match.moveItem)
+		}
+	}
+}
+
+// matchMovePanAction 查找和删除云盘文件动作匹配的上传动作，即本地文件（夹）被移动或者重命名
+func (f *FileActionTaskManager) matchMovePanAction(deleteItem *SyncFileItem, uploads SyncFileList, usedIdMap map[string]bool) (*SyncFileItem, SyncFileList) {
+	sourcePanFile := deleteItem.PanFile
+	if !sourcePanFile.IsFolder() {
+		for _, upload := range uploads {
+			if usedIdMap[upload.Id()] || upload.LocalFile.FileSize != sourcePanFile.FileSize {
+				continue
+			}
+			if !isSameSha1(f.localFileSha1(upload.LocalFile), sourcePanFile.Sha1Hash) {
+				continue
+			}
+			usedIdMap[upload.Id()] = true
+			return f.newMoveAction(SyncFileActionMovePan, upload.LocalFile, sourcePanFile), SyncFileList{upload}
+		}
+		return nil, nil
+	}
+
+	// 文件夹需要目录结构和所有文件都一致
+	panFiles := f.panFileListRecursive(sourcePanFile.Path)
+	if len(panFiles) == 0 {
+		return nil, nil
+	}
+	uploadMap := map[string]*SyncFileItem{}
+	for _, upload := range uploads {
+		if !usedIdMap[upload.Id()] {
+			uploadMap[upload.LocalFile.Path] = upload
+		}
+	}
+	firstRelativePath := strings.TrimPrefix(panFiles[0].Path, sourcePanFile.Path)
+	for _, upload := range uploads {
+		if usedIdMap[upload.Id()] || upload.LocalFile.FileSize != panFiles[0].FileSize || !strings.HasSuffix(upload.LocalFile.Path, firstRelativePath) {
+			continue
+		}
+		targetRootPath := strings.TrimSuffix(upload.LocalFile.Path, firstRelativePath)
+		if targetRootPath == deleteItem.LocalFile.Path {
+			continue
+		}
+		targetRoot, er := f.task.localFileDb.Get(targetRootPath)
+		if er != nil || targetRoot == nil || !targetRoot.IsFolder() {
+			continue
+		}
+		if panFile, _ := f.task.panFileDb.Get(f.getPanPathFromLocalPath(targetRootPath)); panFile != nil {
+			// 云盘已经存在目标文件夹
+			continue
+		}
+
+		matched := SyncFileList{}
+		for _, panFile := range panFiles {
+			item := uploadMap[targetRootPath+strings.TrimPrefix(panFile.Path, sourcePanFile.Path)]
+			if item == nil || item.LocalFile.FileSize != panFile.FileSize {
+				matched = nil
+				break
+			}
+			matched = append(matched, item)
+		}
+		if matched == nil {
+			continue
+		}
+		for idx, panFile := range panFiles {
+			if !isSameSha1(f.localFileSha1(matched[idx].LocalFile), panFile.Sha1Hash) {
+				matched = nil
+				break
+			}
+		}
+		if matched == nil {
+			continue
+		}
+		for _, item := range matched {
+			usedIdMap[item.Id()] = true
+		}
+		return f.newMoveAction(SyncFileActionMovePan, targetRoot, sourcePanFile), matched
+	}
+	return nil, nil
+}
+
+// matchMoveLocalAction 查找和删除本地文件动作匹配的下载动作，即云盘文件（夹）被移动或者重命名
+func (f *FileActionTaskManager) matchMoveLocalAction(deleteItem *SyncFileItem, downloads SyncFileList, usedIdMap map[string]bool) (*SyncFileItem, SyncFileList) {
+	sourceLocalFile := deleteItem.LocalFile
+	if !sourceLocalFile.IsFolder() {
+		for _, download := range downloads {
+			if usedIdMap[download.Id()] || download.PanFile.FileSize != sourceLocalFile.FileSize {
+				continue
+			}
+			if !isSameSha1(f.localFileSha1(sourceLocalFile), download.PanFile.Sha1Hash) {
+				continue
+			}
+			usedIdMap[download.Id()] = true
+			return f.newMoveAction(SyncFileActionMoveLocal, sourceLocalFile, download.PanFile), SyncFileList{download}
+		}
+		return nil, nil
+	}
+
+	// 文件夹需要目录结构和所有文件都一致
+	localFiles := f.localFileListRecursive(sourceLocalFile.Path)
+	if len(localFiles) == 0 {
+		return nil, nil
+	}
+	downloadMap := map[string]*SyncFileItem{}
+	for _, download := range downloads {
+		if !usedIdMap[download.Id()] {
+			downloadMap[download.PanFile.Path] = download
+		}
+	}
+	firstRelativePath := strings.TrimPrefix(localFiles[0].Path, sourceLocalFile.Path)
+	for _, download := range downloads {
+		if usedIdMap[download.Id()] || download.PanFile.FileSize != localFiles[0].FileSize || !strings.HasSuffix(download.PanFile.Path, firstRelativePath) {
+			continue
+		}
+		targetRootPath := strings.TrimSuffix(download.PanFile.Path, firstRelativePath)
+		if targetRootPath == deleteItem.PanFile.Path {
+			continue
+		}
+		targetRoot, er := f.task.panFileDb.Get(targetRootPath)
+		if er != nil || targetRoot == nil || !targetRoot.IsFolder() {
+			continue
+		}
+		if b, _ := utils.PathExists(f.getLocalPathFromPanPath(targetRootPath)); b {
+			// 本地已经存在目标文件夹
+			continue
+		}
+
+		matched := SyncFileList{}
+		for _, localFile := range localFiles {
+			item := downloadMap[targetRootPath+strings.TrimPrefix(localFile.Path, sourceLocalFile.Path)]
+			if item == nil || item.PanFile.FileSize != localFile.FileSize {
+				matched = nil
+				break
+			}
+			matched = append(matched, item)
+		}
+		if matched == nil {
+			continue
+		}
+		for idx, localFile := range localFiles {
+			if !isSameSha1(f.localFileSha1(localFile), matched[idx].PanFile.Sha1Hash) {
+				matched = nil
+				break
+			}
+		}
+		if matched == nil {
+			continue
+		}
+		for _, item := range matched {
+			usedIdMap[item.Id()] = true
+		}
+		return f.newMoveAction(SyncFileActionMoveLocal, sourceLocalFile, targetRoot), matched
+	}
+	return nil, nil
+}
+
+// newMoveAction 创建移动动作。
+// 移动云盘文件时localFile为目标本地文件，panFile为需要移动的云盘文件；
+// 移动本地文件时localFile为需要移动的本地文件，panFile为目标云盘文件
+func (f *FileActionTaskManager) newMoveAction(action SyncFileAction, localFile *LocalFileItem, panFile *PanFileItem) *SyncFileItem {
+	return &SyncFileItem{
+		Action:            action,
+		Status:            SyncFileStatusCreate,
+		LocalFile:         localFile,
+		PanFile:           panFile,
+		StatusUpdateTime:  "",
+		PanFolderPath:     f.task.PanFolderPath,
+		LocalFolderPath:   f.task.LocalFolderPath,
+		DriveId:           f.task.DriveId,
+		DownloadBlockSize: f.fileDownloadBlockSize,
+		UploadBlockSize:   f.fileUploadBlockSize,
+		UseInternalUrl:    f.useInternalUrl,
+	}
+}
+
+// replaceWithMoveAction 使用移动动作替换对应的删除动作和新增动作，调用方需要持有锁
+func (f *FileActionTaskManager) replaceWithMoveAction(items SyncFileList, moveItem *SyncFileItem) {
+	logger.Verboseln("detect file move: ", moveItem.getMoveSourceFullPath(), " -> ", moveItem.getMoveTargetFullPath())
+	if f.dryRun {
+		removedIdMap := map[string]bool{}
+		for _, item := range items {
+			removedIdMap[item.Id()] = true
+			delete(f.planItemIdMap, item.Id())
+		}
+		planItems := SyncFileList{}
+		for _, item := range f.planItems {
+			if !removedIdMap[item.Id()] {
+				planItems = append(planItems, item)
+			}
+		}
+		f.planItems = append(planItems, moveItem)
+		f.planItemIdMap[moveItem.Id()] = true
+		return
+	}
+
+	for _, item := range items {
+		f.task.syncFileDb.Delete(item.Id())
+	}
+	f.task.syncFileDb.Add(moveItem)
+	f.AddSyncActionModifyCount()
+}
+
+// isSameSha1 文件内容Hash值是否一致，为空则认为不一致
+func isSameSha1(sha1, other string) bool {
+	return sha1 != "" && strings.ToLower(sha1) == strings.ToLower(other)
+}
+
+// localFileSha1 获取本地文件的SHA1，没有则计算并存储到数据库。
+// 只在文件移动检测中调用，同一时间只有一个检测过程，缓存不需要加锁
+func (f *FileActionTaskManager) localFileSha1(localFile *LocalFileItem) string {
+	if localFile.Sha1Hash == "" {
+		if cache := f.localSha1Cache[localFile.Path]; cache != nil && cache.fileSize == localFile.FileSize && cache.updatedAt == localFile.UpdatedAt {
+			localFile.Sha1Hash = cache.sha1
+		} else if localFile.FileSize == 0 {
+			localFile.Sha1Hash = aliyunpan.DefaultZeroSizeFileContentHash
+		} else {
+			fileSum := localfile.NewLocalFileEntity(localFile.Path)
+			if err := fileSum.OpenPath(); err != nil {
+				logger.Verbosef("文件不可读, 错误信息: %s, 跳过...\n", err)
+				return ""
+			}
+			fileSum.Sum(localfile.CHECKSUM_SHA1) // block operation
+			localFile.Sha1Hash = fileSum.SHA1
+			fileSum.Close()
+			f.localSha1Cache[localFile.Path] = &localSha1CacheItem{
+				fileSize:  localFile.FileSize,
+				updatedAt: localFile.UpdatedAt,
+				sha1:      localFile.Sha1Hash,
+			}
+		}
+		f.task.localFileDb.Update(localFile)
+	}
+	return strings.ToLower(localFile.Sha1Hash)
+}
+
+// localFileListRecursive 获取本地数据库中文件夹下所有的文件，不包括文件夹
+func (f *FileActionTaskManager) localFileListRecursive(folderPath string) LocalFileList {
+	result := LocalFileList{}
+	folderQueue := collection.NewFifoQueue()
+	folderQueue.Push(folderPath)
+	for obj := folderQueue.Pop(); obj != nil; obj = folderQueue.Pop() {
+		files, e := f.task.localFileDb.GetFileList(obj.(string))
+		if e != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsFolder() {
+				folderQueue.Push(file.Path)
+			} else {
+				result = append(result, file)
+			}
+		}
+	}
+	return result
+}
+
+// panFileListRecursive 获取云盘数据库中文件夹下所有的文件，不包括文件夹
+func (f *FileActionTaskManager) panFileListRecursive(folderPath string) PanFileList {
+	result := PanFileList{}
+	folderQueue := collection.NewFifoQueue()
+	folderQueue.Push(folderPath)
+	for obj := folderQueue.Pop(); obj != nil; obj = folderQueue.Pop() {
+		files, e := f.task.panFileDb.GetFileList(obj.(string))
+		if e != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsFolder() {
+				folderQueue.Push(file.Path)
+			} else {
+				result = append(result, file)
+			}
+		}
+	}
+	return result
+}
+
+// movePanFile 移动或者重命名云盘文件（夹）
+func (f *FileActionTask) movePanFile(ctx context.Context) error {
+	sourcePath := f.syncItem.getMoveSourceFullPath()
+	targetPath := f.syncItem.getMoveTargetFullPath()
+	driveId := f.syncItem.DriveId
+	fileId := f.syncItem.PanFile.FileId
+
+	if path.Dir(sourcePath) != path.Dir(targetPath) {
+		// 移动到目标文件夹
+		targetDirPath := path.Dir(targetPath)
+		targetDirFileId := ""
+		if targetDirItem, er := f.panFileDb.Get(targetDirPath); er == nil && targetDirItem != nil && targetDirItem.IsFolder() {
+			targetDirFileId = targetDirItem.FileId
+		} else {
+			logger.Verbosef("创建云盘文件夹: %s\n", targetDirPath)
+			f.panFolderCreateMutex.Lock()
+			rs, apierr := f.panClient.Mkdir(driveId, "root", targetDirPath)
+			f.panFolderCreateMutex.Unlock()
+			if apierr != nil {
+				return f.moveFailed(apierr)
+			}
+			if rs == nil || rs.FileId == "" {
+				return f.moveFailed(fmt.Errorf("创建云盘文件夹失败"))
+			}
+			targetDirFileId = rs.FileId
+			if targetDirFile, e := f.panClient.FileInfoById(driveId, targetDirFileId); e == nil {
+				targetDirFile.Path = targetDirPath
+				f.panFileDb.Add(NewPanFileItem(targetDirFile))
+			}
+		}
+		results, apierr := f.panClient.FileMove([]*aliyunpan.FileMoveParam{{
+			DriveId:        driveId,
+			FileId:         fileId,
+			ToDriveId:      driveId,
+			ToParentFileId: targetDirFileId,
+		}})
+		time.Sleep(1 * time.Second)
+		if apierr != nil {
+			return f.moveFailed(apierr)
+		}
+		if len(results) == 0 || !results[0].Success {
+			return f.moveFailed(fmt.Errorf("移动云盘文件失败"))
+		}
+	}
+
+	if path.Base(sourcePath) != path.Base(targetPath) {
+		b, apierr := f.panClient.FileRename(driveId, fileId, path.Base(targetPath))
+		time.Sleep(1 * time.Second)
+		if apierr != nil {
+			return f.moveFailed(apierr)
+		}
+		if !b {
+			return f.moveFailed(fmt.Errorf("重命名云盘文件失败"))
+		}
+	}
+
+	f.syncItem.Status = SyncFileStatusSuccess
+	f.syncItem.StatusUpdateTime = utils.NowTimeStr()
+	f.syncFileDb.Update(f.syncItem)
+	return nil
+}
+
+// moveLocalFile 移动或者重命名本地文件（夹）
+func (f *FileActionTask) moveLocalFile(ctx context.Context) error {
+	sourcePath := f.syncItem.getMoveSourceFullPath()
+	targetPath := f.syncItem.getMoveTargetFullPath()
+	if b, _ := utils.PathExists(targetPath); b {
+		return f.moveFailed(fmt.Errorf("本地文件已存在: %s", targetPath))
+	}
+	if b, _ := utils.PathExists(path.Dir(targetPath)); !b {
+		os.MkdirAll(path.Dir(targetPath), 0755)
+	}
+	if e := os.Rename(sourcePath, targetPath); e != nil {
+		return f.moveFailed(e)
+	}
+
+	f.syncItem.Status = SyncFileStatusSuccess
+	f.syncItem.StatusUpdateTime = utils.NowTimeStr()
+	f.syncFileDb.Update(f.syncItem)
+	return nil
+}
+
+// moveFailed 标记移动动作失败，下一轮文件对比会退回到删除和上传下载
+func (f *FileActionTask) moveFailed(err error) error {
+	f.syncItem.Status = SyncFileStatusFailed
+	f.syncItem.StatusUpdateTime = utils.NowTimeStr()
+	f.syncFileDb.Update(f.syncItem)
+	if err == nil {
+		err = fmt.Errorf("移动文件失败")
+	}
+	return err
+}
+
+// moveLocalFileDb 移动本地数据库中的文件（夹）记录
+func moveLocalFileDb(db LocalSyncDb, sourcePath, targetPath string) {
+	root, e := db.Get(sourcePath)
+	if e != nil || root == nil {
+		return
+	}
+	items := LocalFileList{root}
+	folderQueue := collection.NewFifoQueue()
+	if root.IsFolder() {
+		folderQueue.Push(root.Path)
+	}
+	for obj := folderQueue.Pop(); obj != nil; obj = folderQueue.Pop() {
+		files, er := db.GetFileList(obj.(string))
+		if er != nil {
+			continue
+		}
+		for _, file := range files {
+			items = append(items, file)
+			if file.IsFolder() {
+				folderQueue.Push(file.Path)
+			}
+		}
+	}
+	db.Delete(sourcePath)
+
+	root.FileName = path.Base(targetPath)
+	for _, item := range items {
+		item.Path = targetPath + strings.TrimPrefix(item.Path, sourcePath)
+		item.ScanTimeAt = utils.NowTimeStr()
+		item.ScanStatus = ScanStatusNormal
+	}
+	db.AddFileList(items)
+}
+
+// movePanFileDb 移动云盘数据库中的文件（夹）记录
+func movePanFileDb(db PanSyncDb, sourcePath, targetPath string) {
+	root, e := db.Get(sourcePath)
+	if e != nil || root == nil {
+		return
+	}
+	items := PanFileList{root}
+	folderQueue := collection.NewFifoQueue()
+	if root.IsFolder() {
+		folderQueue.Push(root.Path)
+	}
+	for obj := folderQueue.Pop(); obj != nil; obj = folderQueue.Pop() {
+		files, er := db.GetFileList(obj.(string))
+		if er != nil {
+			continue
+		}
+		for _, file := range files {
+			items = append(items, file)
+			if file.IsFolder() {
+				folderQueue.Push(file.Path)
+			}
+		}
+	}
+	db.Delete(sourcePath)
+
+	root.FileName = path.Base(targetPath)
+	for _, item := range items {
+		item.Path = targetPath + strings.TrimPrefix(item.Path, sourcePath)
+		item.ScanTimeAt = utils.NowTimeStr()
+		item.ScanStatus = ScanStatusNormal
+	}
+	db.AddFileList(items)
+}
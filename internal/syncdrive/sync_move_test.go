@@ -0,0 +1,82 @@
+package syncdrive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMoveTargetFullPath(t *testing.T) {
+	item := &SyncFileItem{
+		Action:          SyncFileActionMovePan,
+		LocalFile:       &LocalFileItem{Path: "D:/tickstep/Documents/设计文档/新目录"},
+		PanFile:         &PanFileItem{Path: "/sync_drive/设计文档/旧目录"},
+		LocalFolderPath: "D:/tickstep/Documents/设计文档",
+		PanFolderPath:   "/sync_drive/设计文档",
+	}
+	fmt.Println(item.getMoveSourceFullPath(), "->", item.getMoveTargetFullPath())
+	if item.getMoveTargetFullPath() != "/sync_drive/设计文档/新目录" {
+		t.Fail()
+	}
+
+	item.Action = SyncFileActionMoveLocal
+	fmt.Println(item.getMoveSourceFullPath(), "->", item.getMoveTargetFullPath())
+	if item.getMoveTargetFullPath() != "D:/tickstep/Documents/设计文档/旧目录" {
+		t.Fail()
+	}
+}
+
+func TestMoveLocalFileDb(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aliyunpan_sync_move")
+	defer os.RemoveAll(dir)
+
+	db := NewLocalSyncDb(path.Join(dir, "local.bolt"))
+	db.Open()
+	defer db.Close()
+	db.Add(&LocalFileItem{FileName: "old", FileType: "folder", Path: "/sync/old"})
+	db.Add(&LocalFileItem{FileName: "a.txt", FileType: "file", Path: "/sync/old/a.txt"})
+	db.Add(&LocalFileItem{FileName: "sub", FileType: "folder", Path: "/sync/old/sub"})
+	db.Add(&LocalFileItem{FileName: "b.txt", FileType: "file", Path: "/sync/old/sub/b.txt"})
+
+	moveLocalFileDb(db, "/sync/old", "/sync/new")
+	fmt.Println(db.Get("/sync/new/sub/b.txt"))
+	if item, _ := db.Get("/sync/old/a.txt"); item != nil {
+		t.Fail()
+	}
+	if item, _ := db.Get("/sync/new/a.txt"); item == nil {
+		t.Fail()
+	}
+}
+
+func TestIsMoveCandidate(t *testing.T) {
+	pendingItems := SyncFileList{
+		&SyncFileItem{
+			Action:    SyncFileActionDeletePan,
+			LocalFile: &LocalFileItem{Path: "D:/sync/a.txt", FileType: "file", FileSize: 10},
+			PanFile:   &PanFileItem{Path: "/sync/a.txt", FileType: "file", FileSize: 10},
+		},
+		&SyncFileItem{
+			Action:    SyncFileActionDeleteLocal,
+			LocalFile: &LocalFileItem{Path: "D:/sync/old", FileType: "folder"},
+			PanFile:   &PanFileItem{Path: "/sync/old", FileType: "folder"},
+		},
+	}
+	cases := []struct {
+		item      *SyncFileItem
+		candidate bool
+	}{
+		{&SyncFileItem{Action: SyncFileActionUpload, LocalFile: &LocalFileItem{Path: "D:/sync/b.txt", FileType: "file", FileSize: 10}}, true},
+		{&SyncFileItem{Action: SyncFileActionUpload, LocalFile: &LocalFileItem{Path: "D:/sync/c.txt", FileType: "file", FileSize: 20}}, false},
+		{&SyncFileItem{Action: SyncFileActionDownload, PanFile: &PanFileItem{Path: "/sync/new/d.txt", FileType: "file", FileSize: 30}}, true},
+	}
+	for i, c := range cases {
+		if isMoveCandidate(c.item, pendingItems) != c.candidate {
+			t.Errorf("case %d: isMoveCandidate expected %v", i, c.candidate)
+		}
+	}
+	if isMoveCandidate(cases[2].item, pendingItems[:1]) {
+		t.Errorf("download should not wait for delete_pan actions")
+	}
+}
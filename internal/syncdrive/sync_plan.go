@@ -0,0 +1,165 @@
+package syncdrive
+
+import (
+	"context"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/internal/waitgroup"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+)
+
+type (
+	// SyncPlan 同步计划，即同步任务将要执行的文件动作
+	SyncPlan struct {
+		// TaskId 同步任务ID
+		TaskId string `json:"taskId"`
+		// TaskName 同步任务名称
+		TaskName string `json:"taskName"`
+		// LocalFolderPath 本地目录
+		LocalFolderPath string `json:"localFolderPath"`
+		// PanFolderPath 云盘目录
+		PanFolderPath string `json:"panFolderPath"`
+		// Mode 同步模式
+		Mode SyncMode `json:"mode"`
+		// CreatedAt 计划生成时间
+		CreatedAt string `json:"createdAt"`
+		// Items 文件动作列表
+		Items SyncFileList `json:"items"`
+		// Conflicts 检测到的文件冲突
+		Conflicts ConflictRecordList `json:"conflicts"`
+		// DeleteBlockedReason 删除操作会被大量删除保护阻止的原因，为空代表不会被阻止
+		DeleteBlockedReason string `json:"deleteBlockedReason"`
+	}
+)
+
+// Count 指定动作的文件数量
+func (p *SyncPlan) Count(action SyncFileAction) int {
+	count := 0
+	for _, item := range p.Items {
+		if item.Action == action {
+			count += 1
+		}
+	}
+	return count
+}
+
+// TotalSize 指定动作的文件总大小
+func (p *SyncPlan) TotalSize(action SyncFileAction) int64 {
+	size := int64(0)
+	for _, item := range p.Items {
+		if item.Action != action {
+			continue
+		}
+		if item.Action == SyncFileActionDownload || item.Action == SyncFileActionDeleteLocal {
+			if item.PanFile != nil {
+				size += item.PanFile.FileSize
+			}
+		} else if item.LocalFile != nil {
+			size += item.LocalFile.FileSize
+		}
+	}
+	return size
+}
+
+// Plan 扫描本地和云盘文件并进行对比，返回同步需要执行的文件动作。
+// 使用同步数据库的副本进行计算，不会修改本地文件、云盘文件以及同步数据库
+func (t *SyncTask) Plan() (*SyncPlan, error) {
+	planDbFolderPath, err := ioutil.TempDir("", "aliyunpan_sync_plan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(planDbFolderPath)
+
+	// 复制已有的同步数据库，保证计划结果和真实同步一致
+	taskDbFolderPath := path.Join(t.syncDbFolderPath, t.Id)
+	planTaskDbFolderPath := path.Join(planDbFolderPath, t.Id)
+	if e := os.MkdirAll(planTaskDbFolderPath, 0755); e != nil {
+		return nil, e
+	}
+	for _, name := range []string{"local.bolt", "pan.bolt"} {
+		if b, _ := utils.PathExists(path.Join(taskDbFolderPath, name)); !b {
+			continue
+		}
+		if e := copyFile(path.Join(taskDbFolderPath, name), path.Join(planTaskDbFolderPath, name)); e != nil {
+			return nil, e
+		}
+	}
+
+	syncDbFolderPath := t.syncDbFolderPath
+	t.syncDbFolderPath = planDbFolderPath
+	defer func() {
+		t.syncDbFolderPath = syncDbFolderPath
+	}()
+	if e := t.setupDb(); e != nil {
+		return nil, e
+	}
+	t.setupResource()
+	t.fileActionTaskManager = NewFileActionTaskManager(t, t.maxDownloadRate, t.maxUploadRate)
+	t.fileActionTaskManager.dryRun = true
+	t.fileActionTaskManager.planItemIdMap = map[string]bool{}
+	t.scanOnce = true
+	t.wg = waitgroup.NewWaitGroup(0)
+	defer func() {
+		t.localFileDb.Close()
+		t.panFileDb.Close()
+		t.syncFileDb.Close()
+		t.fileActionTaskManager = nil
+		t.scanOnce = false
+	}()
+
+	// 扫描一次本地和云盘文件
+	ctx := context.Background()
+	scanWg := &sync.WaitGroup{}
+	scanWg.Add(2)
+	go func() {
+		defer scanWg.Done()
+		t.scanLocalFile(ctx)
+	}()
+	go func() {
+		defer scanWg.Done()
+		t.scanPanFile(ctx)
+	}()
+	scanWg.Wait()
+
+	// 对比文件
+	t.fileActionTaskManager.doFileDiffOnce()
+
+	conflicts, _ := ReadConflictRecords(t.conflictLogFullPath())
+	plan := &SyncPlan{
+		TaskId:          t.Id,
+		TaskName:        t.Name,
+		LocalFolderPath: t.LocalFolderPath,
+		PanFolderPath:   t.PanFolderPath,
+		Mode:            t.Mode,
+		CreatedAt:       utils.NowTimeStr(),
+		Items:           t.fileActionTaskManager.planItems,
+		Conflicts:       conflicts,
+	}
+	totalCount := t.localFileCount
+	if t.panFileCount > totalCount {
+		totalCount = t.panFileCount
+	}
+	deleteCount := plan.Count(SyncFileActionDeleteLocal) + plan.Count(SyncFileActionDeletePan)
+	if exceeded, reason := t.checkDeleteThreshold(deleteCount, totalCount); exceeded {
+		plan.DeleteBlockedReason = reason
+	}
+	return plan, nil
+}
+
+func copyFile(srcFilePath, dstFilePath string) error {
+	src, err := os.Open(srcFilePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dstFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,225 @@
+package syncdrive
+
+import (
+	"context"
+	"fmt"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/internal/waitgroup"
+	"github.com/tickstep/library-go/logger"
+	"sync"
+	"time"
+)
+
+type (
+	// SyncReport 单次同步的结果报告
+	SyncReport struct {
+		// TaskId 同步任务ID
+		TaskId string `json:"taskId"`
+		// TaskName 同步任务名称
+		TaskName string `json:"taskName"`
+		// StartTime 开始时间
+		StartTime string `json:"startTime"`
+		// EndTime 结束时间
+		EndTime string `json:"endTime"`
+		// Uploaded 上传成功的文件数量
+		Uploaded int `json:"uploaded"`
+		// UploadedSize 上传成功的文件总大小
+		UploadedSize int64 `json:"uploadedSize"`
+		// Downloaded 下载成功的文件数量
+		Downloaded int `json:"downloaded"`
+		// DownloadedSize 下载成功的文件总大小
+		DownloadedSize int64 `json:"downloadedSize"`
+		// DeletedLocal 删除的本地文件数量
+		DeletedLocal int `json:"deletedLocal"`
+		// DeletedPan 删除的云盘文件数量
+		DeletedPan int `json:"deletedPan"`
+		// MovedLocal 移动的本地文件（夹）数量
+		MovedLocal int `json:"movedLocal"`
+		// MovedPan 移动的云盘文件（夹）数量
+		MovedPan int `json:"movedPan"`
+		// Skipped 扫描之后文件已经不存在而跳过的文件数量
+		Skipped int `json:"skipped"`
+		// FailedItems 执行失败的文件动作
+		FailedItems []*SyncFailedItem `json:"failedItems"`
+		// DeleteBlockedReason 删除操作被大量删除保护阻止的原因，为空代表没有被阻止
+		DeleteBlockedReason string `json:"deleteBlockedReason"`
+	}
+
+	// SyncFailedItem 执行失败的文件动作
+	SyncFailedItem struct {
+		Action SyncFileAction `json:"action"`
+		Status SyncFileStatus `json:"status"`
+		Path   string         `json:"path"`
+		Error  string         `json:"error"`
+	}
+)
+
+// Failed 执行失败的文件数量
+func (r *SyncReport) Failed() int {
+	return len(r.FailedItems)
+}
+
+// recordActionResult 记录文件动作的执行结果，失败的文件动作只在单次同步模式下记录到报告
+func (f *FileActionTaskManager) recordActionResult(item *SyncFileItem, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err == nil && item.Status == SyncFileStatusSuccess {
+		if item.Error != "" {
+			item.Error = ""
+			f.task.syncFileDb.Update(item)
+		}
+		switch item.Action {
+		case SyncFileActionUpload:
+			f.report.Uploaded += 1
+			f.report.UploadedSize += item.LocalFile.FileSize
+		case SyncFileActionDownload:
+			f.report.Downloaded += 1
+			f.report.DownloadedSize += item.PanFile.FileSize
+		case SyncFileActionDeleteLocal:
+			f.report.DeletedLocal += 1
+		case SyncFileActionDeletePan:
+			f.report.DeletedPan += 1
+		case SyncFileActionMoveLocal:
+			f.report.MovedLocal += 1
+		case SyncFileActionMovePan:
+			f.report.MovedPan += 1
+		}
+		return
+	}
+	if item.Status == SyncFileStatusNotExisted {
+		// 扫描之后文件被删除了，属于正常情况，跳过该文件而不是记录为失败
+		f.report.Skipped += 1
+		return
+	}
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+		// 记录错误信息到同步数据库，供 sync status 命令查看
+		item.Error = errMsg
+		f.task.syncFileDb.Update(item)
+	}
+	if !f.runOnce {
+		// 持续同步模式下失败的文件动作会重试，不需要记录到报告中，避免报告无限增长
+		return
+	}
+	f.report.FailedItems = append(f.report.FailedItems, &SyncFailedItem{
+		Action: item.Action,
+		Status: item.Status,
+		Path:   item.actionPath(),
+		Error:  errMsg,
+	})
+	// 单次同步模式下失败的文件动作不再重试
+	f.failedItemIdMap[item.Id()] = true
+}
+
+// actionPath 文件动作对应的文件路径，移动操作显示为 源路径 -> 目标路径
+func (item *SyncFileItem) actionPath() string {
+	if item.Action == SyncFileActionMoveLocal || item.Action == SyncFileActionMovePan {
+		return item.getMoveSourceFullPath() + " -> " + item.getMoveTargetFullPath()
+	} else if item.Action == SyncFileActionDownload || item.Action == SyncFileActionDeleteLocal {
+		return item.getLocalFileFullPath()
+	}
+	return item.getPanFileFullPath()
+}
+
+// isFailedItem 是否是本次执行失败的文件动作，调用方需要持有锁
+func (f *FileActionTaskManager) isFailedItem(item *SyncFileItem) bool {
+	return f.failedItemIdMap[item.Id()]
+}
+
+// isSyncActionDrained 同步数据库中所有可执行的文件动作是否已经执行完成
+func (f *FileActionTaskManager) isSyncActionDrained() bool {
+	if f.fileInProcessQueue.Length() > 0 {
+		return false
+	}
+	deleteBlocked := f.task.isDeleteBlocked()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, status := range []SyncFileStatus{SyncFileStatusCreate, SyncFileStatusUploading, SyncFileStatusDownloading} {
+		files, e := f.task.syncFileDb.GetFileList(status)
+		if e != nil {
+			continue
+		}
+		for _, file := range files {
+			if deleteBlocked && (file.Action == SyncFileActionDeleteLocal || file.Action == SyncFileActionDeletePan) {
+				// 被阻止的删除操作等待用户确认
+				continue
+			}
+			if !f.isFailedItem(file) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// doActionOnce 执行同步数据库中所有的文件动作，全部执行完成后返回
+func (f *FileActionTaskManager) doActionOnce() {
+	f.wg = waitgroup.NewWaitGroup(0)
+	var cancel context.CancelFunc
+	f.ctx, cancel = context.WithCancel(context.Background())
+	f.cancelFunc = cancel
+
+	go f.fileActionTaskExecutor(f.ctx)
+	for {
+		time.Sleep(2 * time.Second)
+		if f.isSyncActionDrained() {
+			break
+		}
+	}
+	f.Stop()
+}
+
+// RunOnce 只进行一次全量扫描和文件对比，执行完所有的文件动作后返回同步报告
+func (t *SyncTask) RunOnce() (*SyncReport, error) {
+	if t.ctx != nil {
+		return nil, fmt.Errorf("task have starting")
+	}
+	if e := t.setupDb(); e != nil {
+		return nil, e
+	}
+	defer func() {
+		t.localFileDb.Close()
+		t.panFileDb.Close()
+		t.syncFileDb.Close()
+		t.scanOnce = false
+	}()
+	t.setupRootFolder()
+	t.setupResource()
+	t.fileActionTaskManager = NewFileActionTaskManager(t, t.maxDownloadRate, t.maxUploadRate)
+	t.fileActionTaskManager.runOnce = true
+	t.fileActionTaskManager.report.TaskId = t.Id
+	t.fileActionTaskManager.report.TaskName = t.Name
+	t.fileActionTaskManager.report.StartTime = utils.NowTimeStr()
+	t.scanOnce = true
+	t.wg = waitgroup.NewWaitGroup(0)
+
+	// 扫描一次本地和云盘文件
+	ctx := context.Background()
+	scanWg := &sync.WaitGroup{}
+	scanWg.Add(2)
+	go func() {
+		defer scanWg.Done()
+		t.scanLocalFile(ctx)
+	}()
+	go func() {
+		defer scanWg.Done()
+		t.scanPanFile(ctx)
+	}()
+	scanWg.Wait()
+	logger.Verboseln("scan once done: ", t.NameLabel())
+
+	// 对比文件并执行文件动作
+	t.fileActionTaskManager.doFileDiffOnce()
+	t.fileActionTaskManager.doActionOnce()
+
+	t.LastSyncTime = utils.NowTimeStr()
+	report := t.fileActionTaskManager.report
+	report.EndTime = utils.NowTimeStr()
+	if guard, _ := ReadDeleteGuard(t.deleteGuardFullPath()); guard != nil && guard.Status == DeleteGuardStatusBlocked {
+		report.DeleteBlockedReason = guard.Reason
+	}
+	return report, nil
+}
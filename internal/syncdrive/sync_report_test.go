@@ -0,0 +1,36 @@
+package syncdrive
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestFileActionTaskManager_RecordActionResult(t *testing.T) {
+	f := &FileActionTaskManager{
+		mutex:           &sync.Mutex{},
+		runOnce:         true,
+		failedItemIdMap: map[string]bool{},
+		report:          &SyncReport{},
+	}
+	testCases := []struct {
+		item *SyncFileItem
+		err  error
+	}{
+		{&SyncFileItem{Action: SyncFileActionUpload, Status: SyncFileStatusSuccess, LocalFile: &LocalFileItem{Path: "/local/a.txt", FileSize: 100}}, nil},
+		{&SyncFileItem{Action: SyncFileActionUpload, Status: SyncFileStatusNotExisted, LocalFile: &LocalFileItem{Path: "/local/b.txt", FileSize: 200}}, nil},
+		{&SyncFileItem{Action: SyncFileActionDownload, Status: SyncFileStatusNotExisted, PanFile: &PanFileItem{Path: "/pan/c.txt", FileSize: 300}}, fmt.Errorf("文件不存在")},
+	}
+	for _, tc := range testCases {
+		f.recordActionResult(tc.item, tc.err)
+	}
+	if f.report.Uploaded != 1 || f.report.UploadedSize != 100 {
+		t.Errorf("uploaded = %d/%d, want 1/100", f.report.Uploaded, f.report.UploadedSize)
+	}
+	if f.report.Skipped != 2 {
+		t.Errorf("skipped = %d, want 2", f.report.Skipped)
+	}
+	if f.report.Failed() != 0 {
+		t.Errorf("failed = %d, want 0", f.report.Failed())
+	}
+}
@@ -0,0 +1,264 @@
+package syncdrive
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// SyncScanStatus 文件扫描进程的状态
+	SyncScanStatus struct {
+		// LastStartTime 最近一次全量扫描的开始时间
+		LastStartTime string `json:"lastStartTime"`
+		// LastEndTime 最近一次全量扫描的完成时间
+		LastEndTime string `json:"lastEndTime"`
+		// FileCount 最近一次全量扫描的文件数量
+		FileCount int64 `json:"fileCount"`
+	}
+
+	// SyncActionItem 文件动作的状态信息
+	SyncActionItem struct {
+		Action           SyncFileAction `json:"action"`
+		Status           SyncFileStatus `json:"status"`
+		Path             string         `json:"path"`
+		FileSize         int64          `json:"fileSize"`
+		StatusUpdateTime string         `json:"statusUpdateTime"`
+		Error            string         `json:"error"`
+	}
+	SyncActionItemList []*SyncActionItem
+
+	// SyncRuntimeStatus 同步进程定时保存的运行状态，供其他进程查看正在运行的同步任务
+	SyncRuntimeStatus struct {
+		TaskId          string   `json:"taskId"`
+		TaskName        string   `json:"taskName"`
+		LocalFolderPath string   `json:"localFolderPath"`
+		PanFolderPath   string   `json:"panFolderPath"`
+		Mode            SyncMode `json:"mode"`
+		// Pid 同步进程ID
+		Pid int `json:"pid"`
+		// UpdateTime 状态保存时间
+		UpdateTime string `json:"updateTime"`
+		// LocalScan 本地文件扫描状态
+		LocalScan *SyncScanStatus `json:"localScan"`
+		// PanScan 云盘文件扫描状态
+		PanScan *SyncScanStatus `json:"panScan"`
+		// InProcessItems 正在执行的文件动作
+		InProcessItems SyncActionItemList `json:"inProcessItems"`
+	}
+
+	// SyncTaskStatus 同步任务的状态
+	SyncTaskStatus struct {
+		TaskId          string   `json:"taskId"`
+		TaskName        string   `json:"taskName"`
+		LocalFolderPath string   `json:"localFolderPath"`
+		PanFolderPath   string   `json:"panFolderPath"`
+		Mode            SyncMode `json:"mode"`
+		// Running 同步进程是否正在运行
+		Running bool `json:"running"`
+		// Pid 同步进程ID
+		Pid int `json:"pid"`
+		// UpdateTime 运行状态的保存时间
+		UpdateTime string `json:"updateTime"`
+		// StatusCount 同步数据库中各个状态的文件动作数量
+		StatusCount map[SyncFileStatus]int `json:"statusCount"`
+		// LocalScan 本地文件扫描状态
+		LocalScan *SyncScanStatus `json:"localScan"`
+		// PanScan 云盘文件扫描状态
+		PanScan *SyncScanStatus `json:"panScan"`
+		// InProcessItems 正在执行的文件动作
+		InProcessItems SyncActionItemList `json:"inProcessItems"`
+		// FailedItems 执行失败的文件动作
+		FailedItems SyncActionItemList `json:"failedItems"`
+	}
+)
+
+const (
+	// SyncStatusFileName 同步进程运行状态文件名
+	SyncStatusFileName = "sync_status.json"
+
+	// syncStatusSaveInterval 运行状态的保存间隔，单位秒
+	syncStatusSaveInterval int64 = 5
+	// syncStatusExpiredSeconds 运行状态超过该时间没有更新，则认为同步进程已经退出
+	syncStatusExpiredSeconds int64 = 30
+)
+
+var (
+	// SyncFileStatusList 同步数据库中所有的文件动作状态
+	SyncFileStatusList = []SyncFileStatus{SyncFileStatusCreate, SyncFileStatusUploading, SyncFileStatusDownloading,
+		SyncFileStatusFailed, SyncFileStatusSuccess, SyncFileStatusIllegal, SyncFileStatusNotExisted}
+)
+
+// newSyncActionItem 创建文件动作的状态信息
+func newSyncActionItem(item *SyncFileItem) *SyncActionItem {
+	actionItem := &SyncActionItem{
+		Action:           item.Action,
+		Status:           item.Status,
+		Path:             item.actionPath(),
+		StatusUpdateTime: item.StatusUpdateTime,
+		Error:            item.Error,
+	}
+	if item.Action == SyncFileActionDownload && item.PanFile != nil {
+		actionItem.FileSize = item.PanFile.FileSize
+	} else if item.LocalFile != nil {
+		actionItem.FileSize = item.LocalFile.FileSize
+	}
+	return actionItem
+}
+
+// formatUnixTime 格式化unix时间戳，为0则返回空字符串
+func formatUnixTime(ts int64) string {
+	if ts <= 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+// isRunning 同步进程是否正在运行
+func (s *SyncRuntimeStatus) isRunning(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	updateTime, e := time.ParseInLocation("2006-01-02 15:04:05", s.UpdateTime, time.Local)
+	if e != nil {
+		return false
+	}
+	return now.Unix()-updateTime.Unix() <= syncStatusExpiredSeconds
+}
+
+// ReadSyncRuntimeStatus 读取同步进程运行状态，没有记录则返回nil
+func ReadSyncRuntimeStatus(filePath string) (*SyncRuntimeStatus, error) {
+	if b, _ := utils.PathExists(filePath); !b {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	status := &SyncRuntimeStatus{}
+	if err = json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ReadSyncTaskStatus 读取同步任务数据目录中的同步数据库以及运行状态，没有同步记录则返回nil
+func ReadSyncTaskStatus(taskFolderPath string) (*SyncTaskStatus, error) {
+	runtimeStatus, err := ReadSyncRuntimeStatus(path.Join(taskFolderPath, SyncStatusFileName))
+	if err != nil {
+		return nil, err
+	}
+	syncDbFilePath := path.Join(taskFolderPath, "sync.bolt")
+	dbExisted, _ := utils.PathExists(syncDbFilePath)
+	if runtimeStatus == nil && !dbExisted {
+		return nil, nil
+	}
+
+	status := &SyncTaskStatus{
+		TaskId:         path.Base(taskFolderPath),
+		StatusCount:    map[SyncFileStatus]int{},
+		InProcessItems: SyncActionItemList{},
+		FailedItems:    SyncActionItemList{},
+	}
+	if runtimeStatus != nil {
+		status.TaskName = runtimeStatus.TaskName
+		status.LocalFolderPath = runtimeStatus.LocalFolderPath
+		status.PanFolderPath = runtimeStatus.PanFolderPath
+		status.Mode = runtimeStatus.Mode
+		status.Running = runtimeStatus.isRunning(time.Now())
+		status.Pid = runtimeStatus.Pid
+		status.UpdateTime = runtimeStatus.UpdateTime
+		status.LocalScan = runtimeStatus.LocalScan
+		status.PanScan = runtimeStatus.PanScan
+		if status.Running && runtimeStatus.InProcessItems != nil {
+			status.InProcessItems = runtimeStatus.InProcessItems
+		}
+	}
+
+	if dbExisted {
+		// 同步数据库每次操作都会重新打开，同步进程运行时也可以读取
+		syncFileDb := NewSyncFileDb(syncDbFilePath)
+		for _, fileStatus := range SyncFileStatusList {
+			files, e := syncFileDb.GetFileList(fileStatus)
+			if e != nil && e != ErrItemNotExisted {
+				return nil, e
+			}
+			status.StatusCount[fileStatus] = len(files)
+			if fileStatus == SyncFileStatusFailed || fileStatus == SyncFileStatusIllegal {
+				for _, file := range files {
+					status.FailedItems = append(status.FailedItems, newSyncActionItem(file))
+				}
+			}
+		}
+	}
+	return status, nil
+}
+
+// runtimeStatusFullPath 同步进程运行状态文件
+func (t *SyncTask) runtimeStatusFullPath() string {
+	dir := path.Join(t.syncDbFolderPath, t.Id)
+	if b, _ := utils.PathExists(dir); !b {
+		os.MkdirAll(dir, 0755)
+	}
+	return path.Join(dir, SyncStatusFileName)
+}
+
+// runtimeStatus 获取同步任务当前的运行状态
+func (t *SyncTask) runtimeStatus() *SyncRuntimeStatus {
+	status := &SyncRuntimeStatus{
+		TaskId:          t.Id,
+		TaskName:        t.Name,
+		LocalFolderPath: t.LocalFolderPath,
+		PanFolderPath:   t.PanFolderPath,
+		Mode:            t.Mode,
+		Pid:             os.Getpid(),
+		UpdateTime:      utils.NowTimeStr(),
+		LocalScan: &SyncScanStatus{
+			LastStartTime: formatUnixTime(atomic.LoadInt64(&t.localScanStartTime)),
+			LastEndTime:   formatUnixTime(atomic.LoadInt64(&t.localScanEndTime)),
+			FileCount:     atomic.LoadInt64(&t.localFileCount),
+		},
+		PanScan: &SyncScanStatus{
+			LastStartTime: formatUnixTime(atomic.LoadInt64(&t.panScanStartTime)),
+			LastEndTime:   formatUnixTime(atomic.LoadInt64(&t.panScanEndTime)),
+			FileCount:     atomic.LoadInt64(&t.panFileCount),
+		},
+		InProcessItems: SyncActionItemList{},
+	}
+	if t.fileActionTaskManager != nil {
+		for _, obj := range t.fileActionTaskManager.fileInProcessQueue.Items() {
+			if item, ok := obj.(*SyncFileItem); ok {
+				status.InProcessItems = append(status.InProcessItems, newSyncActionItem(item))
+			}
+		}
+	}
+	return status
+}
+
+// saveRuntimeStatusRoutine 定时保存同步进程的运行状态，任务停止后删除状态文件
+func (t *SyncTask) saveRuntimeStatusRoutine(ctx context.Context) {
+	t.wg.AddDelta()
+	defer t.wg.Done()
+
+	statusFilePath := t.runtimeStatusFullPath()
+	ticker := time.NewTicker(time.Duration(syncStatusSaveInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		if e := ioutil.WriteFile(statusFilePath, []byte(utils.ObjectToJsonStr(t.runtimeStatus(), true)), 0755); e != nil {
+			logger.Verboseln("save sync runtime status error: ", e)
+		}
+		select {
+		case <-ctx.Done():
+			os.Remove(statusFilePath)
+			logger.Verboseln("sync runtime status routine done")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package syncdrive
+
+import (
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestReadSyncTaskStatus(t *testing.T) {
+	taskFolderPath := path.Join(os.TempDir(), "sync_status_test", "task_id")
+	os.MkdirAll(taskFolderPath, 0755)
+	defer os.RemoveAll(path.Dir(taskFolderPath))
+
+	syncFileDb := NewSyncFileDb(path.Join(taskFolderPath, "sync.bolt"))
+	syncFileDb.Add(&SyncFileItem{
+		Action:           SyncFileActionUpload,
+		Status:           SyncFileStatusFailed,
+		LocalFile:        &LocalFileItem{FileName: "a.txt", FileSize: 100, Path: "/local/a.txt"},
+		LocalFolderPath:  "/local",
+		PanFolderPath:    "/pan",
+		StatusUpdateTime: utils.NowTimeStr(),
+		Error:            "upload error",
+	})
+	syncFileDb.Add(&SyncFileItem{
+		Action:          SyncFileActionDownload,
+		Status:          SyncFileStatusCreate,
+		PanFile:         &PanFileItem{FileName: "b.txt", FileSize: 200, Path: "/pan/b.txt"},
+		LocalFolderPath: "/local",
+		PanFolderPath:   "/pan",
+	})
+	runtimeStatus := &SyncRuntimeStatus{
+		TaskId:     "task_id",
+		TaskName:   "test",
+		Pid:        os.Getpid(),
+		UpdateTime: utils.NowTimeStr(),
+		LocalScan:  &SyncScanStatus{LastStartTime: formatUnixTime(time.Now().Unix()), FileCount: 10},
+	}
+	ioutil.WriteFile(path.Join(taskFolderPath, SyncStatusFileName), []byte(utils.ObjectToJsonStr(runtimeStatus, true)), 0755)
+
+	status, err := ReadSyncTaskStatus(taskFolderPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil {
+		t.Fatal("ReadSyncTaskStatus() = nil")
+	}
+	if status.TaskId != "task_id" || status.TaskName != "test" {
+		t.Errorf("task = %s/%s, want task_id/test", status.TaskId, status.TaskName)
+	}
+	if !status.Running || status.Pid != os.Getpid() {
+		t.Errorf("running = %v, pid = %d, want true, %d", status.Running, status.Pid, os.Getpid())
+	}
+	if status.LocalScan == nil || status.LocalScan.FileCount != 10 {
+		t.Errorf("LocalScan = %+v, want FileCount 10", status.LocalScan)
+	}
+	if c := status.StatusCount[SyncFileStatusFailed]; c != 1 {
+		t.Errorf("failed count = %d, want 1", c)
+	}
+	if c := status.StatusCount[SyncFileStatusCreate]; c != 1 {
+		t.Errorf("create count = %d, want 1", c)
+	}
+	if len(status.FailedItems) != 1 || status.FailedItems[0].Error != "upload error" {
+		t.Errorf("FailedItems = %+v, want one item with upload error", status.FailedItems)
+	}
+}
+
+func TestSyncRuntimeStatus_IsRunning(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		status  *SyncRuntimeStatus
+		running bool
+	}{
+		{nil, false},
+		{&SyncRuntimeStatus{UpdateTime: ""}, false},
+		{&SyncRuntimeStatus{UpdateTime: now.Format("2006-01-02 15:04:05")}, true},
+		{&SyncRuntimeStatus{UpdateTime: now.Add(-time.Duration(syncStatusExpiredSeconds+60) * time.Second).Format("2006-01-02 15:04:05")}, false},
+	}
+	for i, tc := range testCases {
+		if r := tc.status.isRunning(now); r != tc.running {
+			t.Errorf("case %d: isRunning() = %v, want %v", i, r, tc.running)
+		}
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"github.com/tickstep/aliyunpan/internal/utils"
 	"github.com/tickstep/aliyunpan/internal/waitgroup"
 	"github.com/tickstep/aliyunpan/library/collection"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/logger"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,6 +40,24 @@ type (
 		Mode SyncMode `json:"mode"`
 		// LastSyncTime 上一次同步时间
 		LastSyncTime string `json:"lastSyncTime"`
+		// ConflictPolicy 双向同步冲突处理策略，为空则使用默认策略 newer-wins
+		ConflictPolicy ConflictPolicy `json:"conflictPolicy"`
+		// LocalScanMode 本地文件扫描模式，支持 scan(定时全量扫描) / watch(监听文件系统事件)，为空则使用 scan
+		LocalScanMode LocalScanMode `json:"localScanMode"`
+		// LocalFullScanInterval watch模式下全量扫描的间隔，单位分钟，为0则使用默认值60分钟
+		LocalFullScanInterval int `json:"localFullScanInterval"`
+		// DeleteThresholdCount 一次同步最多允许删除的文件数量，超过则阻止删除，等待用户确认。为0则使用默认值，为-1则不限制
+		DeleteThresholdCount int `json:"deleteThresholdCount"`
+		// DeleteThresholdPercent 一次同步最多允许删除的文件百分比，超过则阻止删除，等待用户确认。为0则使用默认值，为-1则不限制
+		DeleteThresholdPercent int `json:"deleteThresholdPercent"`
+		// Versioning 历史版本保留策略，只对单向上传模式有效，为空则不保留历史版本
+		Versioning *SyncVersioning `json:"versioning"`
+		// Disabled 是否禁用该任务，禁用的任务不会被启动
+		Disabled bool `json:"disabled"`
+		// Exclude 排除文件的规则，语法和 .gitignore 一致，支持 **、! 以及以 / 结尾只匹配文件夹的规则
+		Exclude []string `json:"exclude"`
+		// Include 重新包含文件的规则，优先级高于 Exclude 规则以及 .aliyunpanignore 文件，语法和 .gitignore 一致
+		Include []string `json:"include"`
 
 		syncDbFolderPath string
 		localFileDb      LocalSyncDb
@@ -57,13 +77,33 @@ type (
 		fileUploadBlockSize   int64
 		useInternalUrl        bool
 
-		maxDownloadRate int64 // 限制最大下载速度
-		maxUploadRate   int64 // 限制最大上传速度
+		maxDownloadRate transfer.MaxRateFunc // 获取当前的最大下载速度
+		maxUploadRate   transfer.MaxRateFunc // 获取当前的最大上传速度
 
 		fileActionTaskManager *FileActionTaskManager
 
 		plugin      plugins.Plugin
 		pluginMutex *sync.Mutex
+
+		fileFilter *syncFileFilter
+
+		localWatcher         localFileWatcher
+		localWatcherMutex    *sync.Mutex
+		localFullScanRequest int32
+
+		// scanOnce 只进行一次全量扫描，扫描完成后扫描进程退出
+		scanOnce bool
+
+		// localFileCount 上一次全量扫描的本地文件数量
+		localFileCount int64
+		// panFileCount 上一次全量扫描的云盘文件数量
+		panFileCount int64
+
+		// 最近一次全量扫描的开始和结束时间，unix时间戳
+		localScanStartTime int64
+		localScanEndTime   int64
+		panScanStartTime   int64
+		panScanEndTime     int64
 	}
 )
 
@@ -91,6 +131,21 @@ func (t *SyncTask) String() string {
 		mode = "备份云盘文件（只下载）"
 	}
 	builder.WriteString("同步模式: " + mode + "\n")
+	if t.Mode == SyncTwoWay {
+		builder.WriteString("冲突策略: " + string(t.ConflictPolicy) + "\n")
+	}
+	if t.LocalScanMode == LocalScanModeWatch {
+		builder.WriteString("扫描模式: 监听本地文件变更\n")
+	}
+	if v := t.activeVersioning(); v != nil {
+		builder.WriteString(fmt.Sprintf("历史版本: 保留最新%d个版本，最近%d天每天保留一个版本\n", v.KeepLast, v.KeepDays))
+	}
+	if len(t.Exclude) > 0 {
+		builder.WriteString("排除规则: " + strings.Join(t.Exclude, " ") + "\n")
+	}
+	if len(t.Include) > 0 {
+		builder.WriteString("包含规则: " + strings.Join(t.Include, " ") + "\n")
+	}
 	builder.WriteString("本地目录: " + t.LocalFolderPath + "\n")
 	builder.WriteString("云盘目录: " + t.PanFolderPath + "\n")
 	return builder.String()
@@ -112,15 +167,8 @@ func (t *SyncTask) setupDb() error {
 	return nil
 }
 
-// Start 启动同步任务
-// 扫描本地和云盘文件信息并存储到本地数据库
-func (t *SyncTask) Start() error {
-	if t.ctx != nil {
-		return fmt.Errorf("task have starting")
-	}
-	t.setupDb()
-
-	// check root dir
+// setupRootFolder 检查本地和云盘的同步目录，不存在则创建
+func (t *SyncTask) setupRootFolder() {
 	if b, e := utils.PathExists(t.LocalFolderPath); e == nil {
 		if !b {
 			// create local root folder
@@ -132,11 +180,10 @@ func (t *SyncTask) Start() error {
 			t.panClient.MkdirByFullPath(t.DriveId, t.PanFolderPath)
 		}
 	}
+}
 
-	if t.fileActionTaskManager == nil {
-		t.fileActionTaskManager = NewFileActionTaskManager(t, t.maxDownloadRate, t.maxUploadRate)
-	}
-
+// setupResource 初始化插件等任务运行需要的资源
+func (t *SyncTask) setupResource() {
 	if t.plugin == nil {
 		pluginManger := plugins.NewPluginManager(config.GetPluginDir())
 		t.plugin, _ = pluginManger.GetPlugin()
@@ -144,6 +191,29 @@ func (t *SyncTask) Start() error {
 	if t.pluginMutex == nil {
 		t.pluginMutex = &sync.Mutex{}
 	}
+	if t.localWatcherMutex == nil {
+		t.localWatcherMutex = &sync.Mutex{}
+	}
+	if t.fileFilter == nil {
+		t.fileFilter = newSyncFileFilter(t.LocalFolderPath, t.Exclude, t.Include)
+	}
+}
+
+// Start 启动同步任务
+// 扫描本地和云盘文件信息并存储到本地数据库
+func (t *SyncTask) Start() error {
+	if t.ctx != nil {
+		return fmt.Errorf("task have starting")
+	}
+	t.setupDb()
+	t.setupRootFolder()
+
+	if t.fileActionTaskManager == nil {
+		t.fileActionTaskManager = NewFileActionTaskManager(t, t.maxDownloadRate, t.maxUploadRate)
+	}
+
+	t.setupResource()
+	t.setupLocalWatcher()
 
 	t.wg = waitgroup.NewWaitGroup(0)
 
@@ -151,8 +221,12 @@ func (t *SyncTask) Start() error {
 	t.ctx, cancel = context.WithCancel(context.Background())
 	t.cancelFunc = cancel
 
+	if t.localWatcher != nil {
+		go t.watchLocalFile(t.ctx, t.localWatcher.Events())
+	}
 	go t.scanLocalFile(t.ctx)
 	go t.scanPanFile(t.ctx)
+	go t.saveRuntimeStatusRoutine(t.ctx)
 
 	// start file sync manager
 	if e := t.fileActionTaskManager.Start(); e != nil {
@@ -168,6 +242,7 @@ func (t *SyncTask) Stop() error {
 	}
 	// cancel all sub task & process
 	t.cancelFunc()
+	t.closeLocalWatcher()
 
 	// wait for finished
 	t.wg.Wait()
@@ -221,6 +296,15 @@ func (t *SyncTask) syncFileDbFullPath() string {
 	return path.Join(dir, "sync.bolt")
 }
 
+// conflictLogFullPath 冲突日志文件
+func (t *SyncTask) conflictLogFullPath() string {
+	dir := path.Join(t.syncDbFolderPath, t.Id)
+	if b, _ := utils.PathExists(dir); !b {
+		os.MkdirAll(dir, 0755)
+	}
+	return path.Join(dir, ConflictLogFileName)
+}
+
 func newLocalFileItem(file os.FileInfo, fullPath string) *LocalFileItem {
 	ft := "file"
 	if file.IsDir() {
@@ -240,6 +324,27 @@ func newLocalFileItem(file os.FileInfo, fullPath string) *LocalFileItem {
 	}
 }
 
+// updateLocalFileInDb 更新数据库中的本地文件信息，返回文件是否有修改
+func (t *SyncTask) updateLocalFileInDb(localFileInDb, localFile *LocalFileItem) bool {
+	modified := false
+	if localFile.UpdateTimeUnix() > localFileInDb.UpdateTimeUnix() || localFile.FileSize != localFileInDb.FileSize {
+		localFileInDb.Sha1Hash = ""
+		modified = true
+	}
+
+	localFileInDb.UpdatedAt = localFile.UpdatedAt
+	localFileInDb.CreatedAt = localFile.CreatedAt
+	localFileInDb.FileSize = localFile.FileSize
+	localFileInDb.FileType = localFile.FileType
+	localFileInDb.ScanTimeAt = utils.NowTimeStr()
+	localFileInDb.ScanStatus = ScanStatusNormal
+	logger.Verboseln("update local file to db: ", utils.ObjectToJsonStr(localFileInDb, false))
+	if _, er := t.localFileDb.Update(localFileInDb); er != nil {
+		logger.Verboseln("local db update error ", er)
+	}
+	return modified
+}
+
 // discardLocalFileDb 清理本地数据库中无效的数据项
 func (t *SyncTask) discardLocalFileDb(filePath string, startTimeUnix int64) bool {
 	files, e := t.localFileDb.GetFileList(filePath)
@@ -333,6 +438,7 @@ func (t *SyncTask) scanLocalFile(ctx context.Context) {
 	startTimeOfThisLoop := time.Now().Unix()
 	delayTimeCount := int64(0)
 	isLocalFolderModify := false
+	fileCountOfThisLoop := int64(0)
 
 	t.wg.AddDelta()
 	defer t.wg.Done()
@@ -345,30 +451,46 @@ func (t *SyncTask) scanLocalFile(ctx context.Context) {
 		default:
 			// 采用广度优先遍历(BFS)进行文件遍历
 			if delayTimeCount > 0 {
-				time.Sleep(1 * time.Second)
-				delayTimeCount -= 1
-				continue
-			} else if delayTimeCount == 0 {
+				if atomic.CompareAndSwapInt32(&t.localFullScanRequest, 1, 0) {
+					// 文件监听失效，立即进行全量扫描
+					delayTimeCount = 0
+				} else {
+					time.Sleep(1 * time.Second)
+					delayTimeCount -= 1
+					continue
+				}
+			}
+			if delayTimeCount == 0 {
 				delayTimeCount -= 1
 				startTimeOfThisLoop = time.Now().Unix()
+				fileCountOfThisLoop = 0
+				atomic.StoreInt64(&t.localScanStartTime, startTimeOfThisLoop)
 				logger.Verboseln("do scan local file process at ", utils.NowTimeStr())
 			}
 			obj := folderQueue.Pop()
 			if obj == nil {
+				atomic.StoreInt64(&t.localFileCount, fileCountOfThisLoop)
+				atomic.StoreInt64(&t.localScanEndTime, time.Now().Unix())
+
 				// label discard file from DB
 				if t.discardLocalFileDb(t.LocalFolderPath, startTimeOfThisLoop) {
 					logger.Verboseln("notify local folder modify, need to do file action task")
+					t.fileActionTaskManager.requestMoveDetect()
 					t.fileActionTaskManager.AddLocalFolderModifyCount()
 					t.fileActionTaskManager.AddPanFolderModifyCount()
 					isLocalFolderModify = false // 重置标记
 				}
+				if t.scanOnce {
+					logger.Verboseln("local file scan once done")
+					return
+				}
 
 				// restart scan loop over again
 				folderQueue.Push(&folderItem{
 					fileInfo: rootFolder,
 					path:     t.LocalFolderPath,
 				})
-				delayTimeCount = TimeSecondsOf30Seconds
+				delayTimeCount = t.localScanDelaySeconds()
 				if isLocalFolderModify {
 					logger.Verboseln("notify local folder modify, need to do file action task")
 					t.fileActionTaskManager.AddLocalFolderModifyCount()
@@ -377,10 +499,12 @@ func (t *SyncTask) scanLocalFile(ctx context.Context) {
 				continue
 			}
 			item := obj.(*folderItem)
+			t.addLocalWatch(item.path)
 			files, err1 := ioutil.ReadDir(item.path)
 			if err1 != nil {
 				continue
 			}
+			t.fileFilter.loadIgnoreFile(t.localIgnorePath(item.path))
 			if len(files) == 0 {
 				continue
 			}
@@ -392,10 +516,20 @@ func (t *SyncTask) scanLocalFile(ctx context.Context) {
 				}
 
 				localFile := newLocalFileItem(file, item.path+"/"+file.Name())
+				if t.isVersionsFolder(item.path, file.Name()) {
+					// 历史版本文件夹，跳过
+					continue
+				}
+				if t.fileFilter.isIgnored(t.localIgnorePath(localFile.Path), file.IsDir()) {
+					logger.Verboseln("排除规则禁止扫描本地文件: ", localFile.Path)
+					t.forgetIgnoredLocalFile(localFile.Path)
+					continue
+				}
 				if t.skipLocalFile(localFile) {
 					logger.Verboseln("插件禁止扫描本地文件: ", localFile.Path)
 					continue
 				}
+				fileCountOfThisLoop += 1
 
 				localFileInDb, _ := t.localFileDb.Get(localFile.Path)
 				if localFileInDb == nil {
@@ -406,21 +540,9 @@ func (t *SyncTask) scanLocalFile(ctx context.Context) {
 					isLocalFolderModify = true
 				} else {
 					// update newest info into DB
-					if localFile.UpdateTimeUnix() > localFileInDb.UpdateTimeUnix() || localFile.FileSize != localFileInDb.FileSize {
-						localFileInDb.Sha1Hash = ""
+					if t.updateLocalFileInDb(localFileInDb, localFile) {
 						isLocalFolderModify = true
 					}
-
-					localFileInDb.UpdatedAt = localFile.UpdatedAt
-					localFileInDb.CreatedAt = localFile.CreatedAt
-					localFileInDb.FileSize = localFile.FileSize
-					localFileInDb.FileType = localFile.FileType
-					localFileInDb.ScanTimeAt = utils.NowTimeStr()
-					localFileInDb.ScanStatus = ScanStatusNormal
-					logger.Verboseln("update local file to db: ", utils.ObjectToJsonStr(localFileInDb, false))
-					if _, er := t.localFileDb.Update(localFileInDb); er != nil {
-						logger.Verboseln("local db update error ", er)
-					}
 				}
 
 				// for next term scan
@@ -523,6 +645,7 @@ func (t *SyncTask) scanPanFile(ctx context.Context) {
 	startTimeOfThisLoop := time.Now().Unix()
 	delayTimeCount := int64(0)
 	isPanFolderModify := false
+	fileCountOfThisLoop := int64(0)
 
 	t.wg.AddDelta()
 	defer t.wg.Done()
@@ -541,17 +664,27 @@ func (t *SyncTask) scanPanFile(ctx context.Context) {
 			} else if delayTimeCount == 0 {
 				delayTimeCount -= 1
 				startTimeOfThisLoop = time.Now().Unix()
+				fileCountOfThisLoop = 0
+				atomic.StoreInt64(&t.panScanStartTime, startTimeOfThisLoop)
 				logger.Verboseln("do scan pan file process at ", utils.NowTimeStr())
 			}
 			obj := folderQueue.Pop()
 			if obj == nil {
+				atomic.StoreInt64(&t.panFileCount, fileCountOfThisLoop)
+				atomic.StoreInt64(&t.panScanEndTime, time.Now().Unix())
+
 				// label discard file from DB
 				if t.discardPanFileDb(t.PanFolderPath, startTimeOfThisLoop) {
 					logger.Verboseln("notify pan folder modify, need to do file action task")
+					t.fileActionTaskManager.requestMoveDetect()
 					t.fileActionTaskManager.AddPanFolderModifyCount()
 					t.fileActionTaskManager.AddLocalFolderModifyCount()
 					isPanFolderModify = false
 				}
+				if t.scanOnce {
+					logger.Verboseln("pan file scan once done")
+					return
+				}
 
 				// restart scan loop over again
 				folderQueue.Push(rootPanFile)
@@ -574,14 +707,26 @@ func (t *SyncTask) scanPanFile(ctx context.Context) {
 				time.Sleep(10 * time.Second)
 				continue
 			}
+			// 云盘文件同样使用本地对应文件夹中的 .aliyunpanignore 文件
+			t.fileFilter.loadIgnoreFile(t.panIgnorePath(item.Path))
 			panFileList := PanFileList{}
 			for _, file := range files {
 				file.Path = path.Join(item.Path, file.FileName)
 				panFile := NewPanFileItem(file)
+				if t.isVersionsFolder(item.Path, file.FileName) {
+					// 历史版本文件夹，跳过
+					continue
+				}
+				if t.fileFilter.isIgnored(t.panIgnorePath(panFile.Path), file.IsFolder()) {
+					logger.Verboseln("排除规则禁止扫描云盘文件: ", panFile.Path)
+					t.forgetIgnoredPanFile(panFile.Path)
+					continue
+				}
 				if t.skipPanFile(panFile) {
 					logger.Verboseln("插件禁止扫描云盘文件: ", panFile.Path)
 					continue
 				}
+				fileCountOfThisLoop += 1
 				panFileInDb, _ := t.panFileDb.Get(file.Path)
 				if panFileInDb == nil {
 					// append
@@ -0,0 +1,284 @@
+package syncdrive
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var (
+	ErrSyncTaskNameEmpty   error = fmt.Errorf("同步任务名称不能为空")
+	ErrSyncTaskNameExisted error = fmt.Errorf("同步任务名称已存在")
+	ErrSyncTaskPathEmpty   error = fmt.Errorf("本地目录和云盘目录不能为空")
+)
+
+// ParseSyncMode 解析同步模式，为空则使用默认模式 upload
+func ParseSyncMode(mode string) (SyncMode, error) {
+	switch SyncMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case "", UploadOnly:
+		return UploadOnly, nil
+	case DownloadOnly:
+		return DownloadOnly, nil
+	case SyncTwoWay:
+		return SyncTwoWay, nil
+	}
+	return "", fmt.Errorf("不支持的同步模式: %s", mode)
+}
+
+// isPathOverlap 两个目录是否相同或者存在包含关系
+func isPathOverlap(path1, path2 string, ignoreCase bool) bool {
+	path1 = path.Clean(strings.ReplaceAll(path1, "\\", "/"))
+	path2 = path.Clean(strings.ReplaceAll(path2, "\\", "/"))
+	if ignoreCase {
+		path1 = strings.ToLower(path1)
+		path2 = strings.ToLower(path2)
+	}
+	if path1 == path2 {
+		return true
+	}
+	return strings.HasPrefix(path1, strings.TrimSuffix(path2, "/")+"/") ||
+		strings.HasPrefix(path2, strings.TrimSuffix(path1, "/")+"/")
+}
+
+// findSyncTaskIndex 查找指定ID或者名称的同步任务，不存在则返回-1
+func findSyncTaskIndex(tasks []*SyncTask, idOrName string) int {
+	for idx, task := range tasks {
+		if task.Id == idOrName {
+			return idx
+		}
+	}
+	for idx, task := range tasks {
+		if task.Name == idOrName {
+			return idx
+		}
+	}
+	return -1
+}
+
+// normalizeSyncTask 规范化同步任务的目录和名称
+func normalizeSyncTask(task *SyncTask) {
+	if task.LocalFolderPath != "" {
+		if absPath, e := filepath.Abs(task.LocalFolderPath); e == nil {
+			task.LocalFolderPath = absPath
+		}
+		task.LocalFolderPath = path.Clean(strings.ReplaceAll(task.LocalFolderPath, "\\", "/"))
+	}
+	if task.PanFolderPath != "" {
+		task.PanFolderPath = path.Clean("/" + strings.ReplaceAll(task.PanFolderPath, "\\", "/"))
+	}
+	task.Name = strings.TrimSpace(task.Name)
+	if task.Name == "" && task.LocalFolderPath != "" {
+		task.Name = path.Base(task.LocalFolderPath)
+	}
+}
+
+// checkSyncTaskOverlap 检查同步任务的名称和目录是否和其他任务重复
+func checkSyncTaskOverlap(task *SyncTask, otherTasks []*SyncTask) error {
+	for _, other := range otherTasks {
+		if other.Name == task.Name {
+			return ErrSyncTaskNameExisted
+		}
+		if isPathOverlap(task.LocalFolderPath, other.LocalFolderPath, runtime.GOOS == "windows") {
+			return fmt.Errorf("本地目录和同步任务 %s 的本地目录 %s 重叠", other.NameLabel(), other.LocalFolderPath)
+		}
+		if isPathOverlap(task.PanFolderPath, other.PanFolderPath, false) {
+			return fmt.Errorf("云盘目录和同步任务 %s 的云盘目录 %s 重叠", other.NameLabel(), other.PanFolderPath)
+		}
+	}
+	return nil
+}
+
+// validateSyncTask 校验同步任务的配置，包括本地目录和云盘目录是否有效，以及是否和其他任务的目录重叠
+func (m *SyncTaskManager) validateSyncTask(task *SyncTask, otherTasks []*SyncTask) error {
+	if task.Name == "" {
+		return ErrSyncTaskNameEmpty
+	}
+	if task.LocalFolderPath == "" || task.PanFolderPath == "" {
+		return ErrSyncTaskPathEmpty
+	}
+	mode, e := ParseSyncMode(string(task.Mode))
+	if e != nil {
+		return e
+	}
+	task.Mode = mode
+	policy, e := ParseConflictPolicy(string(task.ConflictPolicy))
+	if e != nil {
+		return e
+	}
+	task.ConflictPolicy = policy
+	if e = checkSyncTaskOverlap(task, otherTasks); e != nil {
+		return e
+	}
+
+	// 本地目录
+	if fi, er := os.Stat(task.LocalFolderPath); er == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("本地路径不是文件夹: %s", task.LocalFolderPath)
+		}
+	} else if os.IsNotExist(er) {
+		if task.Mode == UploadOnly {
+			return fmt.Errorf("本地文件夹不存在: %s", task.LocalFolderPath)
+		}
+	} else {
+		return er
+	}
+
+	// 云盘目录
+	if fi, apierr := m.PanClient.FileInfoByPath(m.DriveId, task.PanFolderPath); apierr == nil {
+		if !fi.IsFolder() {
+			return fmt.Errorf("云盘路径不是文件夹: %s", task.PanFolderPath)
+		}
+	} else if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+		if task.Mode == DownloadOnly {
+			return fmt.Errorf("云盘文件夹不存在: %s", task.PanFolderPath)
+		}
+	} else {
+		return apierr
+	}
+	return nil
+}
+
+// readConfigFileForEdit 读取配置文件用于修改，配置文件不存在则返回空的配置
+func (m *SyncTaskManager) readConfigFileForEdit() (*SyncDriveConfig, error) {
+	if b, _ := utils.PathExists(m.ConfigFilePath()); !b {
+		return &SyncDriveConfig{
+			ConfigVer:    "1.0",
+			SyncTaskList: []*SyncTask{},
+		}, nil
+	}
+	return m.readConfigFile()
+}
+
+// writeConfigFile 保存配置文件
+func (m *SyncTaskManager) writeConfigFile(cfg *SyncDriveConfig) error {
+	if b, _ := utils.PathExists(m.SyncConfigFolderPath); !b {
+		os.MkdirAll(m.SyncConfigFolderPath, 0755)
+	}
+	return ioutil.WriteFile(m.ConfigFilePath(), []byte(utils.ObjectToJsonStr(cfg, true)), 0755)
+}
+
+// saveSyncTaskState 保存正在运行的同步任务的ID和上一次同步时间到配置文件。
+// 会重新读取配置文件再保存，避免覆盖同步进程运行期间使用 sync task 命令对配置文件的修改
+func (m *SyncTaskManager) saveSyncTaskState() {
+	cfg, e := m.readConfigFile()
+	if e != nil {
+		logger.Verboseln("read sync drive config error: ", e)
+		return
+	}
+	for _, fileTask := range cfg.SyncTaskList {
+		for _, task := range m.syncDriveConfig.SyncTaskList {
+			if fileTask.Id == task.Id || (fileTask.Id == "" && fileTask.LocalFolderPath == task.LocalFolderPath &&
+				fileTask.PanFolderPath == task.PanFolderPath) {
+				fileTask.Id = task.Id
+				fileTask.LastSyncTime = task.LastSyncTime
+				break
+			}
+		}
+	}
+	if e = m.writeConfigFile(cfg); e != nil {
+		logger.Verboseln("save sync drive config error: ", e)
+	}
+}
+
+// AddSyncTask 添加同步任务到配置文件
+func (m *SyncTaskManager) AddSyncTask(task *SyncTask) (*SyncTask, error) {
+	cfg, e := m.readConfigFileForEdit()
+	if e != nil {
+		return nil, e
+	}
+	normalizeSyncTask(task)
+	task.Id = utils.UuidStr()
+	if e = m.validateSyncTask(task, cfg.SyncTaskList); e != nil {
+		return nil, e
+	}
+	cfg.SyncTaskList = append(cfg.SyncTaskList, task)
+	if e = m.writeConfigFile(cfg); e != nil {
+		return nil, e
+	}
+	return task, nil
+}
+
+// isSyncTaskFolderChanged 同步任务的本地目录或者云盘目录是否修改
+func isSyncTaskFolderChanged(oldTask, newTask *SyncTask) bool {
+	return path.Clean(oldTask.LocalFolderPath) != path.Clean(newTask.LocalFolderPath) ||
+		path.Clean(oldTask.PanFolderPath) != path.Clean(newTask.PanFolderPath)
+}
+
+// EditSyncTask 修改配置文件中指定ID或者名称的同步任务，任务ID和上一次同步时间保持不变。
+// 修改了同步目录则使用新的任务ID，即使用新的同步数据库重新扫描，避免旧目录的文件记录被当作已删除的文件
+func (m *SyncTaskManager) EditSyncTask(idOrName string, editFunc func(task *SyncTask)) (*SyncTask, error) {
+	cfg, e := m.readConfigFile()
+	if e != nil {
+		return nil, e
+	}
+	idx := findSyncTaskIndex(cfg.SyncTaskList, idOrName)
+	if idx < 0 {
+		return nil, ErrSyncTaskNotFound
+	}
+	task := *cfg.SyncTaskList[idx]
+	editFunc(&task)
+	task.Id = cfg.SyncTaskList[idx].Id
+	task.LastSyncTime = cfg.SyncTaskList[idx].LastSyncTime
+	if task.Id == "" {
+		task.Id = utils.UuidStr()
+	}
+	normalizeSyncTask(&task)
+	if isSyncTaskFolderChanged(cfg.SyncTaskList[idx], &task) {
+		task.Id = utils.UuidStr()
+		task.LastSyncTime = ""
+	}
+	otherTasks := []*SyncTask{}
+	otherTasks = append(otherTasks, cfg.SyncTaskList[:idx]...)
+	otherTasks = append(otherTasks, cfg.SyncTaskList[idx+1:]...)
+	if e = m.validateSyncTask(&task, otherTasks); e != nil {
+		return nil, e
+	}
+	cfg.SyncTaskList[idx] = &task
+	if e = m.writeConfigFile(cfg); e != nil {
+		return nil, e
+	}
+	return &task, nil
+}
+
+// RemoveSyncTask 从配置文件中删除指定ID或者名称的同步任务，同步数据库不会被删除
+func (m *SyncTaskManager) RemoveSyncTask(idOrName string) (*SyncTask, error) {
+	cfg, e := m.readConfigFile()
+	if e != nil {
+		return nil, e
+	}
+	idx := findSyncTaskIndex(cfg.SyncTaskList, idOrName)
+	if idx < 0 {
+		return nil, ErrSyncTaskNotFound
+	}
+	task := cfg.SyncTaskList[idx]
+	cfg.SyncTaskList = append(cfg.SyncTaskList[:idx], cfg.SyncTaskList[idx+1:]...)
+	if e = m.writeConfigFile(cfg); e != nil {
+		return nil, e
+	}
+	return task, nil
+}
+
+// SetSyncTaskDisabled 启用或者禁用配置文件中指定ID或者名称的同步任务
+func (m *SyncTaskManager) SetSyncTaskDisabled(idOrName string, disabled bool) (*SyncTask, error) {
+	cfg, e := m.readConfigFile()
+	if e != nil {
+		return nil, e
+	}
+	idx := findSyncTaskIndex(cfg.SyncTaskList, idOrName)
+	if idx < 0 {
+		return nil, ErrSyncTaskNotFound
+	}
+	task := cfg.SyncTaskList[idx]
+	task.Disabled = disabled
+	if e = m.writeConfigFile(cfg); e != nil {
+		return nil, e
+	}
+	return task, nil
+}
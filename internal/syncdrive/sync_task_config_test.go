@@ -0,0 +1,64 @@
+package syncdrive
+
+import (
+	"testing"
+)
+
+func TestIsPathOverlap(t *testing.T) {
+	testCases := []struct {
+		path1      string
+		path2      string
+		ignoreCase bool
+		overlap    bool
+	}{
+		{"/sync_drive/doc", "/sync_drive/doc/", false, true},
+		{"/sync_drive/doc", "/sync_drive/doc/2022", false, true},
+		{"/sync_drive/doc", "/sync_drive/document", false, false},
+		{"/", "/sync_drive", false, true},
+		{"D:\\Documents", "d:/documents/game", true, true},
+		{"D:\\Documents", "d:/documents/game", false, false},
+	}
+	for _, tc := range testCases {
+		if r := isPathOverlap(tc.path1, tc.path2, tc.ignoreCase); r != tc.overlap {
+			t.Errorf("isPathOverlap(%q, %q) = %v, want %v", tc.path1, tc.path2, r, tc.overlap)
+		}
+	}
+}
+
+func TestCheckSyncTaskOverlap(t *testing.T) {
+	tasks := []*SyncTask{
+		{Id: "1", Name: "doc", LocalFolderPath: "/home/user/doc", PanFolderPath: "/sync_drive/doc"},
+		{Id: "2", Name: "photo", LocalFolderPath: "/home/user/photo", PanFolderPath: "/sync_drive/photo"},
+	}
+	testCases := []struct {
+		task    *SyncTask
+		success bool
+	}{
+		{&SyncTask{Name: "doc", LocalFolderPath: "/home/user/music", PanFolderPath: "/sync_drive/music"}, false},
+		{&SyncTask{Name: "music", LocalFolderPath: "/home/user/doc/music", PanFolderPath: "/sync_drive/music"}, false},
+		{&SyncTask{Name: "music", LocalFolderPath: "/home/user/music", PanFolderPath: "/sync_drive"}, false},
+		{&SyncTask{Name: "music", LocalFolderPath: "/home/user/music", PanFolderPath: "/sync_drive/music"}, true},
+	}
+	for _, tc := range testCases {
+		if e := checkSyncTaskOverlap(tc.task, tasks); (e == nil) != tc.success {
+			t.Errorf("checkSyncTaskOverlap(%s) error = %v", tc.task.Name, e)
+		}
+	}
+}
+
+func TestIsSyncTaskFolderChanged(t *testing.T) {
+	task := &SyncTask{LocalFolderPath: "/home/user/doc", PanFolderPath: "/sync_drive/doc"}
+	testCases := []struct {
+		newTask *SyncTask
+		changed bool
+	}{
+		{&SyncTask{LocalFolderPath: "/home/user/doc/", PanFolderPath: "/sync_drive/doc"}, false},
+		{&SyncTask{LocalFolderPath: "/home/user/doc2", PanFolderPath: "/sync_drive/doc"}, true},
+		{&SyncTask{LocalFolderPath: "/home/user/doc", PanFolderPath: "/sync_drive/doc2"}, true},
+	}
+	for i, tc := range testCases {
+		if r := isSyncTaskFolderChanged(task, tc.newTask); r != tc.changed {
+			t.Errorf("case %d: isSyncTaskFolderChanged() = %v, want %v", i, r, tc.changed)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package syncdrive
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan/internal/config"
 	"github.com/tickstep/aliyunpan/internal/utils"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/logger"
 	"io/ioutil"
 	"path"
@@ -23,8 +25,8 @@ type (
 		fileUploadBlockSize   int64
 		useInternalUrl        bool
 
-		maxDownloadRate int64 // 限制最大下载速度
-		maxUploadRate   int64 // 限制最大上传速度
+		maxDownloadRate transfer.MaxRateFunc // 获取当前的最大下载速度
+		maxUploadRate   transfer.MaxRateFunc // 获取当前的最大上传速度
 
 		PanUser              *config.PanUser
 		DriveId              string
@@ -33,6 +35,9 @@ type (
 
 		// useConfigFile 是否使用配置文件启动
 		useConfigFile bool
+
+		ctx        context.Context
+		cancelFunc context.CancelFunc
 	}
 
 	// SyncDriveConfig 同步盘配置文件
@@ -44,11 +49,13 @@ type (
 
 var (
 	ErrSyncTaskListEmpty error = fmt.Errorf("no sync task")
+	ErrSyncTaskRunFailed error = fmt.Errorf("some sync task run failed")
+	ErrSyncTaskNotFound  error = fmt.Errorf("同步任务不存在")
 )
 
 func NewSyncTaskManager(user *config.PanUser, driveId string, panClient *aliyunpan.PanClient, syncConfigFolderPath string,
 	fileDownloadParallel, fileUploadParallel int, fileDownloadBlockSize, fileUploadBlockSize int64, useInternalUrl bool,
-	maxDownloadRate, maxUploadRate int64) *SyncTaskManager {
+	maxDownloadRate, maxUploadRate transfer.MaxRateFunc) *SyncTaskManager {
 	return &SyncTaskManager{
 		PanUser:              user,
 		DriveId:              driveId,
@@ -83,38 +90,52 @@ func (m *SyncTaskManager) parseConfigFile() error {
 	 ]
 	}
 	*/
+	r, e := m.readConfigFile()
+	m.syncDriveConfig = r
+	return e
+}
+
+// readConfigFile 读取配置文件，读取失败也会返回一个空的配置
+func (m *SyncTaskManager) readConfigFile() (*SyncDriveConfig, error) {
 	configFilePath := m.ConfigFilePath()
 	r := &SyncDriveConfig{
 		ConfigVer:    "1.0",
 		SyncTaskList: []*SyncTask{},
 	}
-	m.syncDriveConfig = r
 
 	if b, _ := utils.PathExists(configFilePath); b != true {
 		//text := utils.ObjectToJsonStr(r, true)
 		//ioutil.WriteFile(ConfigFilePath, []byte(text), 0755)
-		return fmt.Errorf("备份配置文件不存在：" + m.ConfigFilePath())
+		return r, fmt.Errorf("备份配置文件不存在：" + m.ConfigFilePath())
 	}
 	data, e := ioutil.ReadFile(configFilePath)
 	if e != nil {
-		return e
+		return r, e
 	}
 
 	if len(data) > 0 {
-		if err2 := json.Unmarshal(data, m.syncDriveConfig); err2 != nil {
+		if err2 := json.Unmarshal(data, r); err2 != nil {
 			logger.Verboseln("parse sync drive config json error ", err2)
-			return err2
+			return r, err2
 		}
 	}
-	return nil
+	return r, nil
+}
+
+// ConfigSyncTaskList 获取配置文件中的同步任务列表
+func (m *SyncTaskManager) ConfigSyncTaskList() ([]*SyncTask, error) {
+	if er := m.parseConfigFile(); er != nil {
+		return nil, er
+	}
+	return m.syncDriveConfig.SyncTaskList, nil
 }
 
 func (m *SyncTaskManager) ConfigFilePath() string {
 	return path.Join(m.SyncConfigFolderPath, "sync_drive_config.json")
 }
 
-// Start 启动同步进程
-func (m *SyncTaskManager) Start(tasks []*SyncTask) (bool, error) {
+// loadSyncTasks 加载同步任务，tasks为空则使用配置文件中的同步任务
+func (m *SyncTaskManager) loadSyncTasks(tasks []*SyncTask) error {
 	if tasks != nil && len(tasks) > 0 {
 		m.syncDriveConfig = &SyncDriveConfig{
 			ConfigVer:    "1.0",
@@ -124,30 +145,77 @@ func (m *SyncTaskManager) Start(tasks []*SyncTask) (bool, error) {
 		m.useConfigFile = false
 	} else {
 		if er := m.parseConfigFile(); er != nil {
-			return false, er
+			return er
 		}
 		m.useConfigFile = true
+
+		// 跳过已禁用的同步任务，保存配置文件时会重新读取配置，不会丢失已禁用的任务
+		enabledTasks := []*SyncTask{}
+		for _, task := range m.syncDriveConfig.SyncTaskList {
+			if task.Disabled {
+				logger.Verboseln("skip disabled sync task: ", task.NameLabel())
+				continue
+			}
+			enabledTasks = append(enabledTasks, task)
+		}
+		m.syncDriveConfig.SyncTaskList = enabledTasks
 	}
 	if m.syncDriveConfig.SyncTaskList == nil || len(m.syncDriveConfig.SyncTaskList) == 0 {
-		return false, ErrSyncTaskListEmpty
+		return ErrSyncTaskListEmpty
+	}
+
+	for _, task := range m.syncDriveConfig.SyncTaskList {
+		m.initSyncTask(task)
+	}
+	return nil
+}
+
+// initSyncTask 初始化同步任务运行需要的配置
+func (m *SyncTaskManager) initSyncTask(task *SyncTask) {
+	if len(task.Id) == 0 {
+		task.Id = utils.UuidStr()
+	}
+	task.panUser = m.PanUser
+	task.DriveId = m.DriveId
+	task.syncDbFolderPath = m.SyncConfigFolderPath
+	task.panClient = m.PanClient
+	task.fileUploadParallel = m.fileUploadParallel
+	task.fileDownloadParallel = m.fileDownloadParallel
+	task.fileUploadBlockSize = m.fileUploadBlockSize
+	task.fileDownloadBlockSize = m.fileDownloadBlockSize
+	task.useInternalUrl = m.useInternalUrl
+	task.maxDownloadRate = m.maxDownloadRate
+	task.maxUploadRate = m.maxUploadRate
+	if policy, e := ParseConflictPolicy(string(task.ConflictPolicy)); e == nil {
+		task.ConflictPolicy = policy
+	} else {
+		fmt.Println(e.Error() + "，使用默认策略: " + string(ConflictPolicyNewerWins))
+		task.ConflictPolicy = ConflictPolicyNewerWins
+	}
+}
+
+// ConfigSyncTask 获取配置文件中指定ID或者名称的同步任务
+func (m *SyncTaskManager) ConfigSyncTask(idOrName string) (*SyncTask, error) {
+	if er := m.parseConfigFile(); er != nil {
+		return nil, er
+	}
+	for _, task := range m.syncDriveConfig.SyncTaskList {
+		if task.Id == idOrName || task.Name == idOrName {
+			m.initSyncTask(task)
+			return task, nil
+		}
+	}
+	return nil, ErrSyncTaskNotFound
+}
+
+// Start 启动同步进程
+func (m *SyncTaskManager) Start(tasks []*SyncTask) (bool, error) {
+	if er := m.loadSyncTasks(tasks); er != nil {
+		return false, er
 	}
 
 	// start the sync task one by one
 	for _, task := range m.syncDriveConfig.SyncTaskList {
-		if len(task.Id) == 0 {
-			task.Id = utils.UuidStr()
-		}
-		task.panUser = m.PanUser
-		task.DriveId = m.DriveId
-		task.syncDbFolderPath = m.SyncConfigFolderPath
-		task.panClient = m.PanClient
-		task.fileUploadParallel = m.fileUploadParallel
-		task.fileDownloadParallel = m.fileDownloadParallel
-		task.fileUploadBlockSize = m.fileUploadBlockSize
-		task.fileDownloadBlockSize = m.fileDownloadBlockSize
-		task.useInternalUrl = m.useInternalUrl
-		task.maxDownloadRate = m.maxDownloadRate
-		task.maxUploadRate = m.maxUploadRate
 		if e := task.Start(); e != nil {
 			logger.Verboseln(e)
 			fmt.Println("start sync task error: {}", task.Id)
@@ -157,15 +225,111 @@ func (m *SyncTaskManager) Start(tasks []*SyncTask) (bool, error) {
 		fmt.Println(task)
 		time.Sleep(200 * time.Millisecond)
 	}
+
+	// clean expired file versions
+	var cancel context.CancelFunc
+	m.ctx, cancel = context.WithCancel(context.Background())
+	m.cancelFunc = cancel
+	go m.cleanFileVersionsRoutine(m.ctx)
 	// save config file
 	if m.useConfigFile {
-		ioutil.WriteFile(m.ConfigFilePath(), []byte(utils.ObjectToJsonStr(m.syncDriveConfig, true)), 0755)
+		m.saveSyncTaskState()
 	}
 	return true, nil
 }
 
+// Plan 生成同步计划，只扫描和对比文件，不执行任何文件动作
+func (m *SyncTaskManager) Plan(tasks []*SyncTask) ([]*SyncPlan, error) {
+	if er := m.loadSyncTasks(tasks); er != nil {
+		return nil, er
+	}
+
+	plans := []*SyncPlan{}
+	for _, task := range m.syncDriveConfig.SyncTaskList {
+		fmt.Println("正在扫描并对比文件: ", task.NameLabel())
+		plan, e := task.Plan()
+		if e != nil {
+			logger.Verboseln(e)
+			fmt.Println("生成同步计划失败: ", task.NameLabel(), e)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// RunOnce 依次对每一个同步任务进行一次完整的同步，全部完成后返回同步报告。
+// 有任务无法执行时返回 ErrSyncTaskRunFailed，以及其他任务的同步报告
+func (m *SyncTaskManager) RunOnce(tasks []*SyncTask) ([]*SyncReport, error) {
+	if er := m.loadSyncTasks(tasks); er != nil {
+		return nil, er
+	}
+
+	var err error
+	reports := []*SyncReport{}
+	for _, task := range m.syncDriveConfig.SyncTaskList {
+		fmt.Println("\n启动单次同步任务")
+		fmt.Println(task)
+		report, e := task.RunOnce()
+		if e != nil {
+			logger.Verboseln(e)
+			fmt.Println("同步任务执行失败: ", task.NameLabel(), e)
+			err = ErrSyncTaskRunFailed
+			continue
+		}
+		reports = append(reports, report)
+		m.cleanFileVersions(task)
+	}
+
+	// save config file
+	if m.useConfigFile {
+		m.saveSyncTaskState()
+	}
+	return reports, err
+}
+
+// cleanFileVersions 清理同步任务过期的历史版本
+func (m *SyncTaskManager) cleanFileVersions(task *SyncTask) {
+	if task.activeVersioning() == nil {
+		return
+	}
+	count, e := task.CleanFileVersions()
+	if e != nil {
+		logger.Verboseln("clean file versions error: ", task.NameLabel(), e)
+		return
+	}
+	if count > 0 {
+		logger.Verboseln("clean expired file versions: ", task.NameLabel(), count)
+	}
+}
+
+// cleanFileVersionsRoutine 定时清理所有同步任务过期的历史版本
+func (m *SyncTaskManager) cleanFileVersionsRoutine(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(TimeSecondsOf60Minute) * time.Second)
+	defer ticker.Stop()
+	for {
+		for _, task := range m.syncDriveConfig.SyncTaskList {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			m.cleanFileVersions(task)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // Stop 停止同步进程
 func (m *SyncTaskManager) Stop() (bool, error) {
+	if m.cancelFunc != nil {
+		m.cancelFunc()
+		m.cancelFunc = nil
+	}
 	// stop task one by one
 	for _, task := range m.syncDriveConfig.SyncTaskList {
 		if e := task.Stop(); e != nil {
@@ -178,7 +342,102 @@ func (m *SyncTaskManager) Stop() (bool, error) {
 
 	// save config file
 	if m.useConfigFile {
-		ioutil.WriteFile(m.ConfigFilePath(), []byte(utils.ObjectToJsonStr(m.syncDriveConfig, true)), 0755)
+		m.saveSyncTaskState()
 	}
 	return true, nil
 }
+
+// ApproveDelete 确认同步任务被阻止的删除操作，正在运行的同步进程会继续执行这些删除操作
+func (m *SyncTaskManager) ApproveDelete(taskId string) (*DeleteGuard, error) {
+	guardFilePath := path.Join(m.SyncConfigFolderPath, taskId, DeleteGuardFileName)
+	guard, err := ReadDeleteGuard(guardFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if guard == nil || guard.Status != DeleteGuardStatusBlocked {
+		return nil, ErrDeleteGuardNotBlocked
+	}
+	guard.Status = DeleteGuardStatusApproved
+	guard.ApprovedAt = utils.NowTimeStr()
+	if err = SaveDeleteGuard(guardFilePath, guard); err != nil {
+		return nil, err
+	}
+	return guard, nil
+}
+
+// ConflictRecords 获取同步任务的冲突记录，taskId为空则获取所有任务的冲突记录
+func (m *SyncTaskManager) ConflictRecords(taskId string) (ConflictRecordList, error) {
+	if taskId != "" {
+		return ReadConflictRecords(path.Join(m.SyncConfigFolderPath, taskId, ConflictLogFileName))
+	}
+
+	records := ConflictRecordList{}
+	files, e := ioutil.ReadDir(m.SyncConfigFolderPath)
+	if e != nil {
+		return nil, e
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		r, er := ReadConflictRecords(path.Join(m.SyncConfigFolderPath, file.Name(), ConflictLogFileName))
+		if er != nil {
+			return nil, er
+		}
+		records = append(records, r...)
+	}
+	return records, nil
+}
+
+// TaskStatusList 获取同步任务的状态，taskId为空则获取所有同步任务的状态
+func (m *SyncTaskManager) TaskStatusList(taskId string) ([]*SyncTaskStatus, error) {
+	configTasks := map[string]*SyncTask{}
+	if tasks, e := m.ConfigSyncTaskList(); e == nil {
+		for _, task := range tasks {
+			if task.Id == "" {
+				continue
+			}
+			configTasks[task.Id] = task
+			if task.Name == taskId {
+				taskId = task.Id
+			}
+		}
+	}
+
+	taskIds := []string{}
+	if taskId != "" {
+		taskIds = append(taskIds, taskId)
+	} else {
+		files, e := ioutil.ReadDir(m.SyncConfigFolderPath)
+		if e != nil {
+			return nil, e
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				taskIds = append(taskIds, file.Name())
+			}
+		}
+	}
+
+	statusList := []*SyncTaskStatus{}
+	for _, id := range taskIds {
+		status, e := ReadSyncTaskStatus(path.Join(m.SyncConfigFolderPath, id))
+		if e != nil {
+			return nil, e
+		}
+		if status == nil {
+			continue
+		}
+		if task, ok := configTasks[id]; ok && status.TaskName == "" {
+			status.TaskName = task.Name
+			status.LocalFolderPath = task.LocalFolderPath
+			status.PanFolderPath = task.PanFolderPath
+			status.Mode = task.Mode
+		}
+		statusList = append(statusList, status)
+	}
+	if taskId != "" && len(statusList) == 0 {
+		return nil, ErrSyncTaskNotFound
+	}
+	return statusList, nil
+}
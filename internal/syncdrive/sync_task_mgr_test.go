@@ -29,7 +29,7 @@ func TestStart(t *testing.T) {
 		int64(256*1024),
 		aliyunpan.DefaultChunkSize,
 		false,
-		0, 0,
+		nil, nil,
 	)
 
 	manager.Start(nil)
@@ -0,0 +1,333 @@
+package syncdrive
+
+import (
+	"fmt"
+	"github.com/tickstep/aliyunpan-api/aliyunpan"
+	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
+	"github.com/tickstep/aliyunpan/library/collection"
+	"github.com/tickstep/library-go/logger"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// SyncVersioning 历史版本保留策略，只对单向上传模式有效。
+	// 云盘文件被覆盖或者删除时，旧文件会移动到 .versions/<相对路径>/<时间戳> 而不是回收站
+	SyncVersioning struct {
+		// Enable 是否开启历史版本
+		Enable bool `json:"enable"`
+		// KeepLast 保留最新的N个版本，为0则不按数量保留
+		KeepLast int `json:"keepLast"`
+		// KeepDays 最近D天内每天保留一个版本，为0则不按天保留
+		KeepDays int `json:"keepDays"`
+	}
+
+	// FileVersion 文件的历史版本
+	FileVersion struct {
+		// Name 版本名称，即时间戳
+		Name string `json:"name"`
+		// Path 版本在云盘的完整路径
+		Path string `json:"path"`
+		// FileId 版本的云盘文件ID
+		FileId string `json:"fileId"`
+		// FileSize 文件大小
+		FileSize int64 `json:"fileSize"`
+		// IsFolder 是否是文件夹版本
+		IsFolder bool `json:"isFolder"`
+		// Time 版本时间
+		Time time.Time `json:"time"`
+	}
+	FileVersionList []*FileVersion
+)
+
+const (
+	// VersionsFolderName 历史版本文件夹名称，位于云盘同步目录下
+	VersionsFolderName = ".versions"
+	// VersionTimeFormat 历史版本的时间戳格式，精确到毫秒，避免同一秒内的多个版本重名
+	VersionTimeFormat = "20060102-150405.000"
+	// versionTimeFormatSecond 旧的精确到秒的时间戳格式，兼容已经存在的历史版本
+	versionTimeFormatSecond = "20060102-150405"
+)
+
+var (
+	ErrFileVersionNotFound = fmt.Errorf("历史版本不存在")
+)
+
+// isEnabled 历史版本是否生效
+func (v *SyncVersioning) isEnabled() bool {
+	return v != nil && v.Enable
+}
+
+// expiredVersions 根据保留策略获取需要清理的历史版本，没有配置保留策略则保留全部版本
+func (v *SyncVersioning) expiredVersions(versions FileVersionList, now time.Time) FileVersionList {
+	expired := FileVersionList{}
+	if v.KeepLast <= 0 && v.KeepDays <= 0 {
+		return expired
+	}
+
+	sorted := make(FileVersionList, len(versions))
+	copy(sorted, versions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+	dailyStartTime := now.AddDate(0, 0, -v.KeepDays)
+	keptDays := map[string]bool{}
+	for idx, version := range sorted {
+		if idx < v.KeepLast {
+			keptDays[version.Time.Format("20060102")] = true
+			continue
+		}
+		day := version.Time.Format("20060102")
+		if v.KeepDays > 0 && version.Time.After(dailyStartTime) && !keptDays[day] {
+			// 每天保留最新的一个版本
+			keptDays[day] = true
+			continue
+		}
+		expired = append(expired, version)
+	}
+	return expired
+}
+
+// activeVersioning 获取生效的历史版本策略，没有开启则返回nil
+func (t *SyncTask) activeVersioning() *SyncVersioning {
+	if t.Mode != UploadOnly || !t.Versioning.isEnabled() {
+		return nil
+	}
+	return t.Versioning
+}
+
+// versionsFolderPath 历史版本根目录
+func (t *SyncTask) versionsFolderPath() string {
+	return path.Join(path.Clean(t.PanFolderPath), VersionsFolderName)
+}
+
+// isVersionsFolder 是否是同步目录下的历史版本文件夹
+func (t *SyncTask) isVersionsFolder(parentPath, fileName string) bool {
+	if fileName != VersionsFolderName || t.activeVersioning() == nil {
+		return false
+	}
+	parentPath = path.Clean(strings.ReplaceAll(parentPath, "\\", "/"))
+	return parentPath == path.Clean(t.PanFolderPath) || parentPath == path.Clean(strings.ReplaceAll(t.LocalFolderPath, "\\", "/"))
+}
+
+// relativePanPath 获取云盘文件相对于同步目录的路径，同时支持完整路径和相对路径
+func (t *SyncTask) relativePanPath(filePath string) string {
+	filePath = path.Clean("/" + strings.ReplaceAll(filePath, "\\", "/"))
+	panRootPath := path.Clean(t.PanFolderPath)
+	if strings.HasPrefix(filePath, panRootPath+"/") {
+		return strings.TrimPrefix(filePath, panRootPath)
+	}
+	return filePath
+}
+
+// parseVersionTime 从版本名称解析版本时间，不是版本名称则返回错误
+func parseVersionTime(versionName string) (time.Time, error) {
+	tm, err := time.ParseInLocation(VersionTimeFormat, versionName, time.Local)
+	if err != nil {
+		return time.ParseInLocation(versionTimeFormatSecond, versionName, time.Local)
+	}
+	return tm, nil
+}
+
+// backupPanFileVersion 将云盘文件移动到历史版本目录 .versions/<相对路径>/<时间戳>，重命名失败则将文件移回原目录
+func backupPanFileVersion(panClient *aliyunpan.PanClient, driveId, panFolderPath, fileId, panFilePath string) error {
+	fileInfo, apierr := panClient.FileInfoById(driveId, fileId)
+	if apierr != nil {
+		return apierr
+	}
+
+	relativePath := strings.TrimPrefix(panFilePath, path.Clean(panFolderPath))
+	versionFolderPath := path.Join(path.Clean(panFolderPath), VersionsFolderName, relativePath)
+	rs, apierr := panClient.MkdirByFullPath(driveId, versionFolderPath)
+	if apierr != nil {
+		return apierr
+	}
+	if rs == nil || rs.FileId == "" {
+		return fmt.Errorf("创建历史版本文件夹失败: %s", versionFolderPath)
+	}
+
+	results, apierr := panClient.FileMove([]*aliyunpan.FileMoveParam{{
+		DriveId:        driveId,
+		FileId:         fileId,
+		ToDriveId:      driveId,
+		ToParentFileId: rs.FileId,
+	}})
+	if apierr != nil {
+		return apierr
+	}
+	if len(results) == 0 || !results[0].Success {
+		return fmt.Errorf("移动文件到历史版本文件夹失败: %s", panFilePath)
+	}
+
+	versionName := time.Now().Format(VersionTimeFormat)
+	if b, apierr := panClient.FileRename(driveId, fileId, versionName); apierr != nil || !b {
+		// 移回原目录，避免文件以原名称留在历史版本文件夹中
+		if _, e := panClient.FileMove([]*aliyunpan.FileMoveParam{{
+			DriveId:        driveId,
+			FileId:         fileId,
+			ToDriveId:      driveId,
+			ToParentFileId: fileInfo.ParentFileId,
+		}}); e != nil {
+			logger.Verboseln("move back pan file version error: ", e)
+		}
+		if apierr != nil {
+			return apierr
+		}
+		return fmt.Errorf("重命名历史版本失败: %s", panFilePath)
+	}
+	logger.Verboseln("backup pan file version: ", panFilePath, " -> ", path.Join(versionFolderPath, versionName))
+	return nil
+}
+
+// listVersionFolder 获取历史版本文件夹下的版本列表以及子文件夹
+func (t *SyncTask) listVersionFolder(folder *aliyunpan.FileEntity) (FileVersionList, aliyunpan.FileList, error) {
+	files, apierr := t.panClient.FileListGetAll(&aliyunpan.FileListParam{
+		DriveId:      t.DriveId,
+		ParentFileId: folder.FileId,
+	}, 500)
+	if apierr != nil {
+		return nil, nil, apierr
+	}
+	versions := FileVersionList{}
+	subFolders := aliyunpan.FileList{}
+	for _, file := range files {
+		file.Path = path.Join(folder.Path, file.FileName)
+		if tm, e := parseVersionTime(file.FileName); e == nil {
+			versions = append(versions, &FileVersion{
+				Name:     file.FileName,
+				Path:     file.Path,
+				FileId:   file.FileId,
+				FileSize: file.FileSize,
+				IsFolder: file.IsFolder(),
+				Time:     tm,
+			})
+		} else if file.IsFolder() {
+			subFolders = append(subFolders, file)
+		}
+	}
+	return versions, subFolders, nil
+}
+
+// ListFileVersions 获取文件（夹）的历史版本，按时间从新到旧排序。filePath可以是相对于同步目录的路径或者云盘完整路径
+func (t *SyncTask) ListFileVersions(filePath string) (FileVersionList, error) {
+	versionFolderPath := path.Join(t.versionsFolderPath(), t.relativePanPath(filePath))
+	folder, apierr := t.panClient.FileInfoByPath(t.DriveId, versionFolderPath)
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return FileVersionList{}, nil
+		}
+		return nil, apierr
+	}
+	folder.Path = versionFolderPath
+	versions, _, err := t.listVersionFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time.After(versions[j].Time)
+	})
+	return versions, nil
+}
+
+// RestoreFileVersion 恢复文件（夹）的历史版本到targetPath，targetPath为空则恢复到原路径。
+// 目标路径已经存在的文件会先作为新的历史版本保留
+func (t *SyncTask) RestoreFileVersion(filePath, versionName, targetPath string) error {
+	versions, err := t.ListFileVersions(filePath)
+	if err != nil {
+		return err
+	}
+	var version *FileVersion
+	for _, v := range versions {
+		if v.Name == versionName {
+			version = v
+			break
+		}
+	}
+	if version == nil {
+		return ErrFileVersionNotFound
+	}
+
+	if targetPath == "" {
+		targetPath = path.Join(path.Clean(t.PanFolderPath), t.relativePanPath(filePath))
+	}
+	targetPath = path.Clean(targetPath)
+	if existed, apierr := t.panClient.FileInfoByPath(t.DriveId, targetPath); apierr == nil && existed != nil {
+		if err = backupPanFileVersion(t.panClient, t.DriveId, t.PanFolderPath, existed.FileId, targetPath); err != nil {
+			return err
+		}
+	}
+
+	rs, apierr := t.panClient.MkdirByFullPath(t.DriveId, path.Dir(targetPath))
+	if apierr != nil {
+		return apierr
+	}
+	if rs == nil || rs.FileId == "" {
+		return fmt.Errorf("创建云盘文件夹失败: %s", path.Dir(targetPath))
+	}
+	results, apierr := t.panClient.FileMove([]*aliyunpan.FileMoveParam{{
+		DriveId:        t.DriveId,
+		FileId:         version.FileId,
+		ToDriveId:      t.DriveId,
+		ToParentFileId: rs.FileId,
+	}})
+	if apierr != nil {
+		return apierr
+	}
+	if len(results) == 0 || !results[0].Success {
+		return fmt.Errorf("恢复历史版本失败")
+	}
+	if b, apierr := t.panClient.FileRename(t.DriveId, version.FileId, path.Base(targetPath)); apierr != nil {
+		return apierr
+	} else if !b {
+		return fmt.Errorf("重命名文件失败: %s", targetPath)
+	}
+	return nil
+}
+
+// CleanFileVersions 根据保留策略清理过期的历史版本，返回清理的版本数量
+func (t *SyncTask) CleanFileVersions() (int, error) {
+	versioning := t.activeVersioning()
+	if versioning == nil {
+		return 0, nil
+	}
+	root, apierr := t.panClient.FileInfoByPath(t.DriveId, t.versionsFolderPath())
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return 0, nil
+		}
+		return 0, apierr
+	}
+	root.Path = t.versionsFolderPath()
+
+	count := 0
+	now := time.Now()
+	folderQueue := collection.NewFifoQueue()
+	folderQueue.Push(root)
+	for obj := folderQueue.Pop(); obj != nil; obj = folderQueue.Pop() {
+		versions, subFolders, err := t.listVersionFolder(obj.(*aliyunpan.FileEntity))
+		if err != nil {
+			return count, err
+		}
+		for _, folder := range subFolders {
+			folderQueue.Push(folder)
+		}
+
+		expired := versioning.expiredVersions(versions, now)
+		if len(expired) == 0 {
+			continue
+		}
+		params := []*aliyunpan.FileBatchActionParam{}
+		for _, version := range expired {
+			logger.Verboseln("clean expired file version: ", version.Path)
+			params = append(params, &aliyunpan.FileBatchActionParam{DriveId: t.DriveId, FileId: version.FileId})
+		}
+		if _, apierr := t.panClient.FileDelete(params); apierr != nil {
+			return count, apierr
+		}
+		count += len(expired)
+		time.Sleep(1 * time.Second)
+	}
+	return count, nil
+}
@@ -0,0 +1,61 @@
+package syncdrive
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpiredVersions(t *testing.T) {
+	now := time.Date(2022, 6, 10, 12, 0, 0, 0, time.Local)
+	versions := FileVersionList{}
+	for i := 0; i < 10; i++ {
+		// 每天两个版本
+		tm := now.Add(-time.Duration(i*12) * time.Hour)
+		versions = append(versions, &FileVersion{Name: tm.Format(VersionTimeFormat), Time: tm})
+	}
+
+	v := &SyncVersioning{Enable: true, KeepLast: 2}
+	expired := v.expiredVersions(versions, now)
+	fmt.Println(len(expired))
+	if len(expired) != 8 {
+		t.Fail()
+	}
+
+	v = &SyncVersioning{Enable: true, KeepLast: 1, KeepDays: 3}
+	expired = v.expiredVersions(versions, now)
+	for _, item := range expired {
+		fmt.Println(item.Name)
+	}
+	if len(expired) != 7 {
+		t.Fail()
+	}
+
+	v = &SyncVersioning{Enable: true}
+	if len(v.expiredVersions(versions, now)) != 0 {
+		t.Fail()
+	}
+}
+
+func TestParseVersionTime(t *testing.T) {
+	tm := time.Date(2022, 6, 10, 12, 30, 45, 123000000, time.Local)
+	testCases := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{tm.Format(VersionTimeFormat), tm, true},
+		{"20220610-123045", tm.Truncate(time.Second), true},
+		{"a.txt", time.Time{}, false},
+	}
+	for _, tc := range testCases {
+		r, err := parseVersionTime(tc.name)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseVersionTime(%q) error = %v", tc.name, err)
+			continue
+		}
+		if tc.ok && !r.Equal(tc.want) {
+			t.Errorf("parseVersionTime(%q) = %v, want %v", tc.name, r, tc.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/tickstep/aliyunpan-api/aliyunpan"
 	"github.com/tickstep/aliyunpan-api/aliyunpan/apierror"
 	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/expires"
 	"github.com/tickstep/library-go/expires/cachemap"
 	"github.com/tickstep/library-go/logger"
@@ -51,6 +52,10 @@ type PanClientProxy struct {
 
 	mutex sync.Mutex
 
+	// 下载和上传限速
+	downloadRateLimit transfer.RateLimiter
+	uploadRateLimit   transfer.RateLimiter
+
 	// 网盘文件路径到网盘文件信息实体映射缓存
 	filePathCacheMap cachemap.CacheOpMap
 
@@ -549,6 +554,9 @@ func (p *PanClientProxy) DownloadFilePart(sessionId, fileId string, offset int64
 		}
 	}
 	fds.readOffset += int64(readByteCount)
+	if p.downloadRateLimit != nil {
+		p.downloadRateLimit.Add(int64(readByteCount)) // 限速阻塞
+	}
 	return readByteCount, nil
 }
 
@@ -663,6 +671,9 @@ func (p *PanClientProxy) UploadFilePart(userId, pathStr string, offset int64, bu
 		// error
 		return 0, fmt.Errorf("file write offset position mismatch")
 	}
+	if p.uploadRateLimit != nil {
+		p.uploadRateLimit.Add(int64(len(buffer))) // 限速阻塞
+	}
 
 	// write buffer to chunk buffer
 	uploadCount := 0
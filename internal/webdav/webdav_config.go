@@ -2,6 +2,7 @@ package webdav
 
 import (
 	"github.com/tickstep/aliyunpan/internal/config"
+	"github.com/tickstep/aliyunpan/library/requester/transfer"
 	"github.com/tickstep/library-go/logger"
 	"golang.org/x/net/webdav"
 	"log"
@@ -24,6 +25,8 @@ type WebdavConfig struct {
 	PanUser     *config.PanUser `json:"-"`
 	UploadChunkSize int `json:"uploadChunkSize"` // 上传文件分片数据块大小，该数值不能太小，建议大于等于512KB
 	TransferUrlType  int `json:"transferUrlType"`   // 上传/下载URL类别，1-默认，2-阿里云ECS
+	MaxDownloadRate transfer.MaxRateFunc `json:"-"` // 获取当前的最大下载速度，为nil则不限速
+	MaxUploadRate   transfer.MaxRateFunc `json:"-"` // 获取当前的最大上传速度，为nil则不限速
 
 	Address string `json:"address"`
 	Port       int `json:"port"`
@@ -33,6 +36,14 @@ type WebdavConfig struct {
 
 func (w *WebdavConfig) StartServer() {
 	users := map[string]*User{}
+	// 所有用户共用限速
+	var downloadRateLimit, uploadRateLimit transfer.RateLimiter
+	if w.MaxDownloadRate != nil {
+		downloadRateLimit = transfer.NewDynamicRateLimit(w.MaxDownloadRate)
+	}
+	if w.MaxUploadRate != nil {
+		uploadRateLimit = transfer.NewDynamicRateLimit(w.MaxUploadRate)
+	}
 	for _,u := range w.Users {
 		fileItem,e := w.PanUser.PanClient().FileInfoByPath(w.PanDriveId, u.Scope)
 		if e != nil {
@@ -47,6 +58,8 @@ func (w *WebdavConfig) StartServer() {
 			PanUser:    w.PanUser,
 			PanDriveId: w.PanDriveId,
 			PanTransferUrlType: w.TransferUrlType,
+			downloadRateLimit:  downloadRateLimit,
+			uploadRateLimit:    uploadRateLimit,
 		}
 		users[u.Username] = &User{
 			Username: u.Username,
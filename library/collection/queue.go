@@ -99,3 +99,12 @@ func (q *Queue) Contains(item interface{}) bool {
 	}
 	return false
 }
+
+// Items 获取队列中所有数据项的副本
+func (q *Queue) Items() []interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	items := make([]interface{}, len(q.queueList))
+	copy(items, q.queueList)
+	return items
+}
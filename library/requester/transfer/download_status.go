@@ -41,7 +41,7 @@ type (
 
 		startTime time.Time // 开始下载的时间
 
-		rateLimit *speeds.RateLimit // 限速控制
+		rateLimit RateLimiter // 限速控制
 
 		gen *RangeListGen // Range生成状态
 		mu  sync.Mutex
@@ -56,7 +56,7 @@ func NewDownloadStatus() *DownloadStatus {
 }
 
 // SetRateLimit 设置限速
-func (ds *DownloadStatus) SetRateLimit(rl *speeds.RateLimit) {
+func (ds *DownloadStatus) SetRateLimit(rl RateLimiter) {
 	ds.rateLimit = rl
 }
 
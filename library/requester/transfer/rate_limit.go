@@ -0,0 +1,94 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transfer
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// RateLimiter 限速控制接口
+	RateLimiter interface {
+		// Add 增加数据量, 超过限制速度则阻塞
+		Add(size int64)
+		// Stop 停止限速
+		Stop()
+	}
+
+	// MaxRateFunc 获取当前的最大速度, 单位 B/s, 0代表不限制
+	MaxRateFunc func() int64
+
+	// DynamicRateLimit 动态限速, 每次增加数据量时都会重新获取最大速度, 修改限速配置不需要重新开始传输
+	DynamicRateLimit struct {
+		maxRateFunc MaxRateFunc
+		windowStart time.Time // 当前统计周期的开始时间
+		count       int64     // 当前统计周期的数据量
+		mu          sync.Mutex
+	}
+)
+
+// NewDynamicRateLimit 初始化动态限速
+func NewDynamicRateLimit(maxRateFunc MaxRateFunc) *DynamicRateLimit {
+	return &DynamicRateLimit{
+		maxRateFunc: maxRateFunc,
+		windowStart: time.Now(),
+	}
+}
+
+// maxRate 当前的最大速度
+func (rl *DynamicRateLimit) maxRate() int64 {
+	if rl.maxRateFunc == nil {
+		return 0
+	}
+	return rl.maxRateFunc()
+}
+
+// Add 增加数据量, 当前统计周期的数据量超过最大速度则阻塞到下一个周期
+func (rl *DynamicRateLimit) Add(size int64) {
+	for {
+		wait := rl.tryAdd(rl.maxRate(), size)
+		if wait <= 0 {
+			return
+		}
+		// 等待时不持有锁, 其他传输可以继续计算自己的等待时间
+		time.Sleep(wait)
+	}
+}
+
+// tryAdd 尝试增加数据量, 成功返回0, 否则返回需要等待的时间
+func (rl *DynamicRateLimit) tryAdd(maxRate, size int64) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if elapsed := now.Sub(rl.windowStart); elapsed >= time.Second {
+		// 超出的数据量计入之后的周期
+		if maxRate > 0 {
+			rl.count -= int64(elapsed/time.Second) * maxRate
+		}
+		if maxRate <= 0 || rl.count < 0 {
+			rl.count = 0
+		}
+		rl.windowStart = now
+	}
+	if maxRate <= 0 || rl.count < maxRate {
+		rl.count += size
+		return 0
+	}
+	return rl.windowStart.Add(time.Second).Sub(now)
+}
+
+// Stop 停止限速, 动态限速没有后台任务, 为了实现 RateLimiter 接口
+func (rl *DynamicRateLimit) Stop() {
+}
@@ -143,6 +143,7 @@ func main() {
 
 		os.Setenv(config.EnvVerbose, c.String("verbose"))
 		isCli = true
+		cmder.SetConsoleMode(true)
 		logger.Verbosef("提示: 你已经开启VERBOSE调试日志\n\n")
 
 		var (
@@ -412,14 +413,35 @@ func main() {
 		// 列出目录 ls
 		command.CmdLs(),
 
+		// 搜索文件 search
+		command.CmdSearch(),
+
+		// 树形显示目录 tree
+		command.CmdTree(),
+
+		// 统计目录占用空间 du
+		command.CmdDu(),
+
+		// 对比本地目录和云盘目录 diff
+		command.CmdDiff(),
+
+		// 校验本地目录和云盘目录的文件完整性 verify
+		command.CmdVerify(),
+
+		// 查找和清理重复文件 dedupe
+		command.CmdDedupe(),
+
 		// 创建目录 mkdir
 		command.CmdMkdir(),
 
 		// 删除文件/目录 rm
 		command.CmdRm(),
 
-		//// 拷贝文件/目录 cp
-		//command.CmdCp(),
+		// 拷贝文件/目录 cp
+		command.CmdCp(),
+
+		// 在已登录的账号之间传输文件/目录 transfer
+		command.CmdTransfer(),
 
 		// 移动文件/目录 mv
 		command.CmdMv(),
@@ -437,16 +459,16 @@ func main() {
 		command.CmdUpload(),
 
 		// 手动秒传
-		//command.CmdRapidUpload(),
+		command.CmdRapidUpload(),
 
 		// 下载文件/目录 download
 		command.CmdDownload(),
 
 		// 导出文件/目录元数据 export
-		//command.CmdExport(),
+		command.CmdExport(),
 
 		// 导入文件 import
-		//command.CmdImport(),
+		command.CmdImport(),
 
 		// webdav服务
 		command.CmdWebdav(),